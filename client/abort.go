@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// errAborted is the cause of the canceled context of the calls aborted by AbortAll.
+var errAborted = errors.New("call aborted")
+
+// RPCErrAborted is returned by the calls aborted by AbortAll.
+var RPCErrAborted = common.NewRPCError(common.ErrorTypeClientAborted, "call aborted")
+
+// abortable derives the context of an outstanding call that AbortAll cancels,
+// release must be called once the call returns.
+func (client *Client) abortable(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	client.shutdown.lock.Lock()
+	if client.shutdown.aborts == nil {
+		client.shutdown.aborts = make(map[uint64]context.CancelCauseFunc)
+	}
+	id := client.shutdown.nextID
+	client.shutdown.nextID++
+	client.shutdown.aborts[id] = cancel
+	client.shutdown.lock.Unlock()
+	return ctx, func() {
+		client.shutdown.lock.Lock()
+		delete(client.shutdown.aborts, id)
+		client.shutdown.lock.Unlock()
+		cancel(nil)
+	}
+}
+
+// AbortAll makes all the outstanding calls return RPCErrAborted at once without waiting for
+// their replies, unlike Shutdown, e.g. for an emergency failover, and returns their number.
+// The client keeps serving the new calls. If reconnect is true, the connections are closed too,
+// which fails the calls of Go, and the next calls dial new connections.
+func (client *Client) AbortAll(reconnect bool) int {
+	client.shutdown.lock.Lock()
+	aborts := client.shutdown.aborts
+	client.shutdown.aborts = nil
+	client.shutdown.lock.Unlock()
+	for _, cancel := range aborts {
+		cancel(errAborted)
+	}
+	if reconnect {
+		client.Close()
+	}
+	return len(aborts)
+}
+
+// isAborted returns whether the call of ctx is aborted by AbortAll.
+func isAborted(ctx context.Context) bool {
+	return context.Cause(ctx) == errAborted
+}
+
+// canceledError returns the error of the call whose ctx is done.
+func canceledError(ctx context.Context) *common.RPCError {
+	if isAborted(ctx) {
+		return RPCErrAborted
+	}
+	return common.NewRPCError(common.ErrorTypeClientTimeout, ctx.Err().Error())
+}
@@ -2,17 +2,24 @@ package client
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"io"
 	"net"
 	"net/http"
 	"net/rpc"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	kcp "github.com/xtaci/kcp-go"
 
 	codecGob "github.com/henrylee2cn/myrpc/codec/gob"
 	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/common/compress"
 	"github.com/henrylee2cn/myrpc/log"
 )
 
@@ -31,16 +38,77 @@ type (
 		FailMode FailMode
 		// The maximum number of attempts of the Call.
 		MaxTry int
+		//DialBackoff is the delay after the first failed dial of the Failtry mode,
+		//doubled after each failed dial up to MaxDialBackoff, 10ms if zero
+		DialBackoff time.Duration
+		//MaxDialBackoff caps the delay between the dials of the Failtry mode, 1s if zero
+		MaxDialBackoff time.Duration
 		//Timeout sets deadline for underlying net.Conns
 		Timeout time.Duration
 		//ReadTimeout sets readdeadline for underlying net.Conns
 		ReadTimeout time.Duration
 		//WriteTimeout sets writedeadline for underlying net.Conns
 		WriteTimeout time.Duration
-		selector     Selector
+		//CallTimeout sets the default deadline for every Call, zero means no limit
+		CallTimeout time.Duration
+		//OnRetry is called each time the Failover or Failtry mode retries a failed call,
+		//fromAddr is the address of the failed backend and toAddr is the address of the next one,
+		//attempt starts from 2.
+		OnRetry func(method, fromAddr, toAddr string, attempt int, err error)
+		//MaxResponseBytes limits the size of every response read from the connection,
+		//a larger response fails to decode instead of exhausting the memory. It is exact for the codecs
+		//that don't read ahead of the response or report it by a Buffered() int method, such as the codecs
+		//of the codec packages. Zero means unlimited.
+		MaxResponseBytes int64
+		//ConnRegistry shares the connections with the other clients of the registry if not nil
+		ConnRegistry *ConnRegistry
+		//RetryClassifier decides whether a failed call is retried, DefaultRetryClassifier if nil
+		RetryClassifier RetryClassifier
+		//Capabilities are the features advertised to the server once per connection
+		Capabilities []string
+		//Dial replaces the default dialing of the non-HTTP and non-KCP networks,
+		//e.g. to open a session of a multiplexed connection
+		Dial func(network, address string, timeout time.Duration) (net.Conn, error)
+		//MetadataFilter rewrites the metadata of every outgoing request, including the metadata
+		//set by the client and the plugins, e.g. to drop the internal keys of a gateway before
+		//forwarding the calls externally. The repeated keys are passed by their first value.
+		//The connections shared by a ConnRegistry filter with the client that dialed them.
+		MetadataFilter func(md map[string]string) map[string]string
+		//ForceRequestCompression compresses the connection by the compression type without negotiating,
+		//for the servers known to decompress it, see server.Server.DecompressRequests. The requests and
+		//the responses are compressed as by the compression plugin. It saves the round trip of a negotiation,
+		//e.g. for the large uploads to a trusted server. CompressNone means no compression.
+		ForceRequestCompression compress.CompressType
+		//ProtocolVersion declares the version of the framing to the server on every new connection
+		//(see common.ProtocolVersion and server.Server.ProtocolVersions), so that a server that doesn't
+		//speak it rejects the dial with a clear error. Zero declares nothing, for the servers that
+		//don't negotiate.
+		ProtocolVersion byte
+		selector        Selector
+		shutdown        *shutdown
+	}
+
+	// shutdown tracks the outstanding calls for graceful shutdown.
+	shutdown struct {
+		calls   sync.WaitGroup
+		lock    sync.Mutex // protects following
+		closing bool
+		aborts  map[uint64]context.CancelCauseFunc // the outstanding calls AbortAll cancels
+		nextID  uint64
 	}
 )
 
+//CallResult describes how a call is routed.
+type CallResult struct {
+	//Addr is the address of the backend that served the call,
+	//for Broadcast it is the backend whose reply is kept.
+	Addr string
+	//Attempts is the number of attempts, for Broadcast and Forking it is the number of backends.
+	Attempts int
+	//Latency is the total time of the call.
+	Latency time.Duration
+}
+
 //FailMode is a feature to decide client actions when clients fail to invoke services
 type FailMode int
 
@@ -73,28 +141,88 @@ func (client *Client) init() *Client {
 	if client.MaxTry <= 0 {
 		client.MaxTry = 3
 	}
+	if client.DialBackoff <= 0 {
+		client.DialBackoff = 10 * time.Millisecond
+	}
+	if client.MaxDialBackoff <= 0 {
+		client.MaxDialBackoff = time.Second
+	}
+	if client.RetryClassifier == nil {
+		client.RetryClassifier = DefaultRetryClassifier
+	}
 	if client.selector == nil {
 		log.Fatal("rpc: client do not have a 'Selector' field!")
 	}
+	client.shutdown = new(shutdown)
 	client.selector.SetNewInvokerFunc(client.newInvoker)
 	return client
 }
 
 var _ NewInvokerFunc = new(Client).newInvoker
 
-// NewInvoker connects to an RPC server at the setted network address.
+// NewInvoker connects to an RPC server at the setted network address,
+// or shares the connection of the ConnRegistry.
 func (client *Client) newInvoker(network, address string, dialTimeout time.Duration) (Invoker, error) {
+	if client.ConnRegistry != nil {
+		return client.ConnRegistry.acquire(network+"://"+address, func() (Invoker, error) {
+			return client.dialInvoker(network, address, dialTimeout)
+		})
+	}
+	return client.dialInvoker(network, address, dialTimeout)
+}
+
+//DialError is the error of dialing the server at Addr, e.g. reported by OnRetry.
+type DialError struct {
+	Addr string
+	Err  error
+}
+
+func (e *DialError) Error() string {
+	return e.Err.Error()
+}
+
+//dialAddr returns the address of the server failed to dial, or "" if unknown.
+func dialAddr(err error) string {
+	if e, ok := err.(*DialError); ok {
+		return e.Addr
+	}
+	return ""
+}
+
+//dialInvoker dials, keeping the dial in the invoker for the trace of the selection.
+func (client *Client) dialInvoker(network, address string, dialTimeout time.Duration) (Invoker, error) {
+	start := time.Now()
+	inv, err := client.dial(network, address, dialTimeout)
+	if err != nil {
+		return nil, &DialError{Addr: address, Err: err}
+	}
+	if i, ok := inv.(*invoker); ok {
+		i.dial = &TraceEvent{
+			Phase:    TraceDial,
+			Addr:     address,
+			Start:    start,
+			Duration: time.Since(start),
+		}
+	}
+	return inv, err
+}
+
+func (client *Client) dial(network, address string, dialTimeout time.Duration) (Invoker, error) {
 	var wrapper = &clientCodecWrapper{
 		pluginContainer: client.PluginContainer,
 		timeout:         client.Timeout,
 		readTimeout:     client.ReadTimeout,
 		writeTimeout:    client.WriteTimeout,
+		capabilities:    common.NewCapabilities(append([]string{common.FeatureProgress, common.FeatureStreamReply}, client.Capabilities...)...).String(),
+		metadataFilter:  client.MetadataFilter,
 	}
 	switch network {
 	case "http":
-		return client.newHTTPClient(network, address, dialTimeout, wrapper)
+		return client.newHTTPClient("tcp", address, dialTimeout, wrapper)
 	case "kcp":
 		return client.newKCPClient(address, wrapper)
+	case common.NetworkDualStack:
+		return client.newXXXClient("tcp", address, dialTimeout, wrapper)
 	default:
 		return client.newXXXClient(network, address, dialTimeout, wrapper)
 	}
@@ -107,7 +235,12 @@ func (client *Client) newXXXClient(network, address string, dialTimeout time.Dur
 		dialer  = &net.Dialer{Timeout: dialTimeout}
 		conn    net.Conn
 	)
-	if client.TLSConfig != nil {
+	if client.Dial != nil {
+		conn, err = client.Dial(network, address, dialTimeout)
+		if err == nil && client.TLSConfig != nil {
+			conn = tls.Client(conn, client.TLSConfig)
+		}
+	} else if client.TLSConfig != nil {
 		tlsConn, err = tls.DialWithDialer(dialer, network, address, client.TLSConfig)
 		conn = net.Conn(tlsConn)
 	} else {
@@ -116,6 +249,13 @@ func (client *Client) newXXXClient(network, address string, dialTimeout time.Dur
 	if err == nil {
 		wrapper.codecConn = NewClientCodecConn(conn)
 		err = client.PluginContainer.doPostConnected(wrapper.codecConn)
+		if err == nil {
+			err = client.negotiateProtocol(wrapper.codecConn.GetConn(), dialTimeout)
+		}
+		if err == nil {
+			client.limit(wrapper)
+			err = client.compressRequests(wrapper)
+		}
 		if err == nil {
 			if wrapper.codecConn.GetClientCodec() == nil {
 				wrapper.codecConn.SetClientCodec(client.ClientCodecFunc)
@@ -148,17 +288,23 @@ func (client *Client) newHTTPClient(network, address string, dialTimeout time.Du
 		wrapper.codecConn = NewClientCodecConn(conn)
 		err = client.PluginContainer.doPostConnected(wrapper.codecConn)
 		if err == nil {
-			if wrapper.codecConn.GetClientCodec() == nil {
-				wrapper.codecConn.SetClientCodec(client.ClientCodecFunc)
-			}
-			io.WriteString(wrapper.codecConn, "CONNECT "+client.HTTPPath+" HTTP/1.0\n\n")
+			client.limit(wrapper)
+			io.WriteString(wrapper.codecConn, "CONNECT "+client.HTTPPath+" HTTP/1.0\n"+client.protocolHeader()+"\n")
 			// Require successful HTTP response before switching to RPC protocol.
 			resp, err = http.ReadResponse(bufio.NewReader(wrapper.codecConn), &http.Request{Method: "CONNECT"})
 			if err == nil {
-				if resp.Status == common.Connected {
-					return newInvoker(wrapper), nil
+				if resp.Status != common.Connected {
+					err = common.NewError("unexpected HTTP response: " + resp.Status)
+				} else if err = client.checkProtocolHeader(resp.Header); err == nil {
+					// the RPC protocol starts after the CONNECT.
+					err = client.compressRequests(wrapper)
+				}
+			}
+			if err == nil {
+				if wrapper.codecConn.GetClientCodec() == nil {
+					wrapper.codecConn.SetClientCodec(client.ClientCodecFunc)
 				}
-				err = common.NewError("unexpected HTTP response: " + resp.Status)
+				return newInvoker(wrapper), nil
 			}
 		}
 		wrapper.codecConn.Close()
@@ -176,6 +322,13 @@ func (client *Client) newKCPClient(address string, wrapper *clientCodecWrapper)
 	if err == nil {
 		wrapper.codecConn = NewClientCodecConn(conn)
 		err = client.PluginContainer.doPostConnected(wrapper.codecConn)
+		if err == nil {
+			err = client.negotiateProtocol(wrapper.codecConn.GetConn(), 0)
+		}
+		if err == nil {
+			client.limit(wrapper)
+			err = client.compressRequests(wrapper)
+		}
 		if err == nil {
 			if wrapper.codecConn.GetClientCodec() == nil {
 				wrapper.codecConn.SetClientCodec(client.ClientCodecFunc)
@@ -188,53 +341,191 @@ func (client *Client) newKCPClient(address string, wrapper *clientCodecWrapper)
 }
 
 //Call invokes the named function, waits for it to complete, and returns its error status.
+//If CallTimeout is set, the call gives up after it.
 func (client *Client) Call(serviceMethod string, args interface{}, reply interface{}) *common.RPCError {
+	ctx := context.Background()
+	if client.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.CallTimeout)
+		defer cancel()
+	}
+	return client.CallContext(ctx, serviceMethod, args, reply)
+}
+
+//CallWithResult is like Call but also returns how the call is routed.
+func (client *Client) CallWithResult(serviceMethod string, args interface{}, reply interface{}) (CallResult, *common.RPCError) {
+	ctx := context.Background()
+	if client.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.CallTimeout)
+		defer cancel()
+	}
+	var res CallResult
+	start := time.Now()
+	rpcErr := client.callContext(ctx, serviceMethod, args, reply, &res)
+	res.Latency = time.Since(start)
+	return res, rpcErr
+}
+
+//CallWithPriority is like Call but sends the priority in the metadata,
+//the server with workers serves the pending calls of higher priority first.
+func (client *Client) CallWithPriority(serviceMethod string, priority common.Priority, args interface{}, reply interface{}) *common.RPCError {
+	return client.Call(withMetadata(serviceMethod, common.PriorityKey, priority.String()), args, reply)
+}
+
+//CallReusing is like Call but zeroes the reply before the call, including the nested structs,
+//so that one reply can be reused across the calls in a loop: the codecs decode into the reply
+//without resetting the fields absent from the response, e.g. the zero values with gob,
+//which would keep the values of the former reply.
+//The reply must not be used by another goroutine during the call.
+func (client *Client) CallReusing(serviceMethod string, args interface{}, reply interface{}) *common.RPCError {
+	resetReply(reply)
+	return client.Call(serviceMethod, args, reply)
+}
+
+//resetReply sets the value the reply points to to the zero value of its type.
+func resetReply(reply interface{}) {
+	if v := reflect.ValueOf(reply); v.Kind() == reflect.Ptr && !v.IsNil() {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	}
+}
+
+//withMetadata appends the metadata to the serviceMethod.
+func withMetadata(serviceMethod, key, value string) string {
+	sep := "?"
+	if strings.Contains(serviceMethod, "?") {
+		sep = "&"
+	}
+	return serviceMethod + sep + key + "=" + value
+}
+
+//filterMetadata rewrites the metadata of the serviceMethod with filter.
+func filterMetadata(serviceMethod string, filter func(map[string]string) map[string]string) string {
+	path, query := serviceMethod, ""
+	if i := strings.Index(serviceMethod, "?"); i >= 0 {
+		path, query = serviceMethod[:i], serviceMethod[i+1:]
+	}
+	values, _ := url.ParseQuery(query)
+	md := make(map[string]string, len(values))
+	for k, v := range values {
+		md[k] = v[0]
+	}
+	md = filter(md)
+	if len(md) == 0 {
+		return path
+	}
+	values = make(url.Values, len(md))
+	for k, v := range md {
+		values.Set(k, v)
+	}
+	return path + "?" + values.Encode()
+}
+
+//withHops appends the hops to the serviceMethod if ctx carries them,
+//i.e. a downstream call of a server, e.g. made with the server.Context.Context().
+func withHops(ctx context.Context, serviceMethod string) string {
+	if hops, ok := common.HopsFrom(ctx); ok {
+		return withMetadata(serviceMethod, common.HopsKey, strconv.Itoa(hops+1))
+	}
+	return serviceMethod
+}
+
+//CallContext is like Call but is bounded by ctx instead of CallTimeout.
+func (client *Client) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) *common.RPCError {
+	return client.callContext(ctx, serviceMethod, args, reply, new(CallResult))
+}
+
+func (client *Client) callContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, res *CallResult) *common.RPCError {
+	if !client.track() {
+		return common.RPCErrShutdown
+	}
+	defer client.shutdown.calls.Done()
+	ctx, release := client.abortable(ctx)
+	defer release()
+	serviceMethod = withHops(ctx, serviceMethod)
 	if client.FailMode == Broadcast {
-		return client.invokerBroadCast(serviceMethod, args, &reply)
+		return client.invokerBroadCast(ctx, serviceMethod, args, &reply, res)
 	}
 	if client.FailMode == Forking {
-		return client.invokerForking(serviceMethod, args, &reply)
+		return client.invokerForking(ctx, serviceMethod, args, &reply, res)
 	}
 	var (
 		invoker Invoker
 		rpcErr  *common.RPCError
 		err     error
 	)
+	// the failed attempt to report by OnRetry
+	var (
+		failedAddr string
+		failedErr  error
+	)
 	if client.FailMode == Failover {
-		for tries := client.MaxTry; tries > 0; tries-- {
-			invoker, err = client.selector.Select(serviceMethod, args)
+		for attempt := 1; attempt <= client.MaxTry; attempt++ {
+			res.Attempts = attempt
+			invoker, err = client.selectInvoker(ctx, serviceMethod, args)
 			if err != nil || invoker == nil {
 				log.Error("rpc: failed to select a invoker: " + err.Error())
+				failedAddr, failedErr = dialAddr(err), err
 				continue
 			}
+			client.retried(serviceMethod, failedAddr, invoker, attempt, failedErr)
+			res.Addr = invokerAddr(invoker)
 
-			rpcErr = invoker.Call(serviceMethod, args, reply)
+			rpcErr = client.invoke(ctx, invoker, serviceMethod, args, reply)
 			if rpcErr == nil {
 				return nil
 			}
+			if !connAtFault(rpcErr) {
+				break
+			}
+			failedAddr, failedErr = invokerAddr(invoker), newCallError(rpcErr)
 			client.selector.HandleFailed(invoker)
-			if rpcErr.Type == common.ErrorTypeClientShutdown || rpcErr.Type > 0 {
+			if rpcErr.Type == common.ErrorTypeServerDraining {
+				// the server rejects the new connection, try the other.
+				continue
+			}
+			if !client.RetryClassifier.Retryable(failedErr) || !retryAllowed(ctx) {
 				break
 			}
 			log.Error("rpc: failed to call: " + rpcErr.Error)
 		}
 
 	} else if client.FailMode == Failtry {
-		for tries := client.MaxTry; tries > 0; tries-- {
+		backoff := client.DialBackoff
+		for attempt := 1; attempt <= client.MaxTry; attempt++ {
+			res.Attempts = attempt
 			if invoker == nil {
-				if invoker, err = client.selector.Select(serviceMethod, args); err != nil {
+				if invoker, err = client.selectInvoker(ctx, serviceMethod, args); err != nil {
 					log.Error("rpc: failed to select a invoker: " + err.Error())
+					failedAddr, failedErr = dialAddr(err), err
+					if attempt == client.MaxTry || !sleepContext(ctx, backoff) {
+						break
+					}
+					if backoff *= 2; backoff > client.MaxDialBackoff {
+						backoff = client.MaxDialBackoff
+					}
 				}
 			}
 
 			if invoker != nil {
-				rpcErr = invoker.Call(serviceMethod, args, reply)
+				client.retried(serviceMethod, failedAddr, invoker, attempt, failedErr)
+				res.Addr = invokerAddr(invoker)
+				rpcErr = client.invoke(ctx, invoker, serviceMethod, args, reply)
 				if rpcErr == nil {
 					return nil
 				}
+				if !connAtFault(rpcErr) {
+					break
+				}
 
+				failedAddr, failedErr = invokerAddr(invoker), newCallError(rpcErr)
 				client.selector.HandleFailed(invoker)
-				if rpcErr.Type == common.ErrorTypeClientShutdown || rpcErr.Type > 0 {
+				if rpcErr.Type == common.ErrorTypeServerDraining {
+					// the server rejects the connection without running the call, select again.
+					invoker = nil
+					continue
+				}
+				if !client.RetryClassifier.Retryable(failedErr) || !retryAllowed(ctx) {
 					break
 				}
 				log.Error("rpc: failed to call: " + rpcErr.Error)
@@ -250,7 +541,73 @@ func (client *Client) Call(serviceMethod string, args interface{}, reply interfa
 	return rpcErr
 }
 
-func (client *Client) invokerBroadCast(serviceMethod string, args interface{}, reply *interface{}) *common.RPCError {
+//sleepContext waits for d, and returns false at once if ctx is done before it.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return false
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+//SelectorDebug returns the state of the backends in the view of the selector.
+func (client *Client) SelectorDebug() []BackendState {
+	return client.selector.Debug()
+}
+
+//invoke calls the invoker, telling the selector if it implements SelectorFeedback.
+func (client *Client) invoke(ctx context.Context, inv Invoker, serviceMethod string, args interface{}, reply interface{}) *common.RPCError {
+	fb, ok := client.selector.(SelectorFeedback)
+	if !ok {
+		return inv.CallContext(ctx, serviceMethod, args, reply)
+	}
+	fb.CallStarted(inv)
+	rpcErr := inv.CallContext(ctx, serviceMethod, args, reply)
+	callDone(fb, inv, rpcErr)
+	return rpcErr
+}
+
+//callDone tells the selector the completed call, with the response metadata if it implements SelectorMetadataFeedback.
+func callDone(fb SelectorFeedback, inv Invoker, rpcErr *common.RPCError) {
+	mfb, ok := fb.(SelectorMetadataFeedback)
+	if !ok {
+		fb.CallDone(inv, rpcErr)
+		return
+	}
+	var metadata map[string]string
+	if i, ok := asInvoker(inv); ok {
+		metadata = i.responseMetadata()
+	}
+	mfb.CallDoneWithMetadata(inv, rpcErr, metadata)
+}
+
+//connAtFault returns whether the failed call blames its connection, a call aborted or canceled by the client doesn't.
+func connAtFault(rpcErr *common.RPCError) bool {
+	return rpcErr.Type != common.ErrorTypeClientAborted && rpcErr.Type != common.ErrorTypeClientStreamCancelled
+}
+
+//retried calls OnRetry if the attempt is a retry.
+func (client *Client) retried(serviceMethod, fromAddr string, to Invoker, attempt int, err error) {
+	if attempt > 1 && client.OnRetry != nil {
+		client.OnRetry(serviceMethod, fromAddr, invokerAddr(to), attempt, err)
+	}
+}
+
+//invokerAddr returns the remote address of the invoker, or "" if unknown.
+func invokerAddr(inv Invoker) string {
+	if i, ok := asInvoker(inv); ok && i.codec.codecConn != nil {
+		return i.codec.codecConn.RemoteAddr().String()
+	}
+	return ""
+}
+
+func (client *Client) invokerBroadCast(ctx context.Context, serviceMethod string, args interface{}, reply *interface{}, res *CallResult) *common.RPCError {
 	invokers := client.selector.List()
 
 	if len(invokers) == 0 {
@@ -259,13 +616,20 @@ func (client *Client) invokerBroadCast(serviceMethod string, args interface{}, r
 	}
 
 	l := len(invokers)
+	res.Attempts = l
 	done := make(chan *Call, l)
+	addrs := make(map[*Call]string, l)
 	for _, invoker := range invokers {
-		invoker.Go(serviceMethod, args, reply, done)
+		addrs[invoker.Go(serviceMethod, args, reply, done)] = invokerAddr(invoker)
 	}
 
 	for l > 0 {
-		call := <-done
+		var call *Call
+		select {
+		case call = <-done:
+		case <-ctx.Done():
+			return canceledError(ctx)
+		}
 		if call == nil || call.Error != nil {
 			if call != nil {
 				log.Warnf("rpc: failed to call: %v", call.Error)
@@ -273,13 +637,14 @@ func (client *Client) invokerBroadCast(serviceMethod string, args interface{}, r
 			return common.RPCErrBroadCast
 		}
 		*reply = call.Reply
+		res.Addr = addrs[call]
 		l--
 	}
 
 	return nil
 }
 
-func (client *Client) invokerForking(serviceMethod string, args interface{}, reply *interface{}) *common.RPCError {
+func (client *Client) invokerForking(ctx context.Context, serviceMethod string, args interface{}, reply *interface{}, res *CallResult) *common.RPCError {
 	invokers := client.selector.List()
 
 	if len(invokers) == 0 {
@@ -288,15 +653,23 @@ func (client *Client) invokerForking(serviceMethod string, args interface{}, rep
 	}
 
 	l := len(invokers)
+	res.Attempts = l
 	done := make(chan *Call, l)
+	addrs := make(map[*Call]string, l)
 	for _, invoker := range invokers {
-		invoker.Go(serviceMethod, args, reply, done)
+		addrs[invoker.Go(serviceMethod, args, reply, done)] = invokerAddr(invoker)
 	}
 
 	for l > 0 {
-		call := <-done
+		var call *Call
+		select {
+		case call = <-done:
+		case <-ctx.Done():
+			return canceledError(ctx)
+		}
 		if call != nil && call.Error == nil {
 			*reply = call.Reply
+			res.Addr = addrs[call]
 			return nil
 		}
 		if call == nil {
@@ -316,32 +689,116 @@ func (client *Client) invokerForking(serviceMethod string, args interface{}, rep
 // If done is nil, Go will allocate a new channel.
 // If non-nil, done must be buffered or Go will deliberately crash.
 func (client *Client) Go(serviceMethod string, args interface{}, reply interface{}, done chan *Call) *Call {
-	invoker, err := client.selector.Select()
+	var err error
+	if client.track() {
+		var inv Invoker
+		inv, err = client.selector.Select()
+		if err == nil {
+			if i, ok := asInvoker(inv); ok {
+				fb, _ := client.selector.(SelectorFeedback)
+				if fb != nil {
+					fb.CallStarted(inv)
+				}
+				return i.goCall(context.Background(), serviceMethod, args, reply, done, func(call *Call) {
+					if fb != nil {
+						callDone(fb, inv, call.Error)
+					}
+					client.shutdown.calls.Done()
+				})
+			}
+			// the invoker is not created by this client, so the call can't be tracked.
+			client.shutdown.calls.Done()
+			return inv.Go(serviceMethod, args, reply, done)
+		}
+		client.shutdown.calls.Done()
+	}
+	call := new(Call)
+	call.ServiceMethod = serviceMethod
+	call.Args = args
+	call.Reply = reply
 	if err != nil {
-		call := new(Call)
-		call.ServiceMethod = serviceMethod
-		call.Args = args
-		call.Reply = reply
 		call.Error = &common.RPCError{
 			Type:  common.ErrorTypeClientConnect,
 			Error: err.Error(),
 		}
-		if done == nil {
-			done = make(chan *Call, 1) // buffered.
-		} else {
-			// If caller passes done != nil, it must arrange that
-			// done has enough buffer for the number of simultaneous
-			// RPCs that will be using that channel. If the channel
-			// is totally unbuffered, it's best not to run at all.
-			if cap(done) == 0 {
-				log.Panic("rpc: done channel is unbuffered")
-			}
+	} else {
+		call.Error = common.RPCErrShutdown
+	}
+	if done == nil {
+		done = make(chan *Call, 1) // buffered.
+	} else {
+		// If caller passes done != nil, it must arrange that
+		// done has enough buffer for the number of simultaneous
+		// RPCs that will be using that channel. If the channel
+		// is totally unbuffered, it's best not to run at all.
+		if cap(done) == 0 {
+			log.Panic("rpc: done channel is unbuffered")
 		}
-		call.Done = done
-		call.done()
-		return call
 	}
-	return invoker.Go(serviceMethod, args, reply, done)
+	call.Done = done
+	call.done()
+	return call
+}
+
+//Ping calls the heartbeat (see common.HeartbeatPath) of the backend at addr on a new connection,
+//bypassing the selector, and returns the round-trip time of the call excluding the dial.
+//The addr is dialed over TCP, or over the network given in the form "network://address".
+//The CallTimeout limits both the dial and the call.
+func (client *Client) Ping(addr string) (time.Duration, error) {
+	network := "tcp"
+	if i := strings.Index(addr, "://"); i >= 0 {
+		network, addr = addr[:i], addr[i+3:]
+	}
+	inv, err := client.dialInvoker(network, addr, client.CallTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer inv.Close()
+	ctx := context.Background()
+	if client.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.CallTimeout)
+		defer cancel()
+	}
+	var reply struct{}
+	start := time.Now()
+	if rpcErr := inv.CallContext(ctx, common.HeartbeatPath, struct{}{}, &reply); rpcErr != nil {
+		return 0, common.NewError(rpcErr.Error)
+	}
+	return time.Since(start), nil
+}
+
+// track registers an outstanding call, it returns false if the client is shutting down.
+func (client *Client) track() bool {
+	client.shutdown.lock.Lock()
+	defer client.shutdown.lock.Unlock()
+	if client.shutdown.closing {
+		return false
+	}
+	client.shutdown.calls.Add(1)
+	return true
+}
+
+// Shutdown stops accepting new calls, waits for the outstanding calls to complete,
+// then closes the connections.
+// If ctx is done before the outstanding calls complete, the connections are closed
+// immediately and ctx.Err() is returned.
+func (client *Client) Shutdown(ctx context.Context) error {
+	client.shutdown.lock.Lock()
+	client.shutdown.closing = true
+	client.shutdown.lock.Unlock()
+	c := make(chan struct{})
+	go func() {
+		client.shutdown.calls.Wait()
+		close(c)
+	}()
+	select {
+	case <-c:
+		return client.Close()
+	case <-ctx.Done():
+		client.Close()
+		return ctx.Err()
+	}
 }
 
 // Close closes the connection
@@ -4,6 +4,9 @@ import (
 	"io"
 	"net"
 	"net/rpc"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/common"
 )
 
 type (
@@ -13,6 +16,10 @@ type (
 		net.Conn
 		SetConn(net.Conn)
 		GetConn() net.Conn
+		// SetCapabilities stores the capabilities advertised by the server.
+		SetCapabilities(common.Capabilities)
+		// Supports returns whether the server advertised the feature.
+		Supports(feature string) bool
 
 		// ClientCodec
 
@@ -33,6 +40,8 @@ type (
 	clientCodecConn struct {
 		net.Conn
 		rpc.ClientCodec
+		caps     common.Capabilities
+		capsLock sync.RWMutex
 	}
 )
 
@@ -49,6 +58,20 @@ func (conn *clientCodecConn) GetConn() net.Conn {
 	return conn.Conn
 }
 
+// SetCapabilities stores the capabilities advertised by the server.
+func (conn *clientCodecConn) SetCapabilities(caps common.Capabilities) {
+	conn.capsLock.Lock()
+	conn.caps = caps
+	conn.capsLock.Unlock()
+}
+
+// Supports returns whether the server advertised the feature.
+func (conn *clientCodecConn) Supports(feature string) bool {
+	conn.capsLock.RLock()
+	defer conn.capsLock.RUnlock()
+	return conn.caps.Supports(feature)
+}
+
 // SetClientCodec must ensure that both Conn and ClientCodecFunc are not nil
 func (conn *clientCodecConn) SetClientCodec(fn ClientCodecFunc) {
 	if fn != nil && conn.Conn != nil {
@@ -1,10 +1,14 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/rpc"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/henrylee2cn/myrpc/common"
 	"github.com/henrylee2cn/myrpc/log"
@@ -16,6 +20,7 @@ type (
 	// Invoker provides remote call function.
 	Invoker interface {
 		Call(serviceMethod string, args interface{}, reply interface{}) *common.RPCError
+		CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) *common.RPCError
 		Go(serviceMethod string, args interface{}, reply interface{}, done chan *Call) *Call
 		Close() error
 	}
@@ -33,8 +38,10 @@ type (
 		mutex    sync.Mutex // protects following
 		seq      uint64
 		pending  map[uint64]*Call
-		closing  bool // user has called Close
-		shutdown bool // server has told us to stop
+		closing  bool              // user has called Close
+		shutdown bool              // server has told us to stop
+		dial     *TraceEvent       // the dial of the connection until a selection takes it or a call uses it
+		metadata map[string]string // of the latest response, see SelectorMetadataFeedback
 	}
 
 	// Call represents an active RPC.
@@ -44,6 +51,13 @@ type (
 		Reply         interface{}      // The reply from the function (*struct).
 		Error         *common.RPCError // After completion, the error status.
 		Done          chan *Call       // Strobes when call is complete.
+		seq           uint64
+		onDone        func(*Call) // called after the call is complete
+		onProgress    func(percent int, msg string, metadata map[string]string)
+		onChunk       func(chunk []byte)
+		onTrailers    func(trailers map[string]string)
+		trace         *CallTrace
+		written       time.Time // when the request is written, for the trace
 	}
 )
 
@@ -63,7 +77,18 @@ func newInvoker(codec *clientCodecWrapper) Invoker {
 // the same Call object. If done is nil, Go will allocate a new channel.
 // If non-nil, done must be buffered or Go will deliberately crash.
 func (invoker *invoker) Go(serviceMethod string, args interface{}, reply interface{}, done chan *Call) *Call {
+	return invoker.goCall(context.Background(), serviceMethod, args, reply, done, nil)
+}
+
+// goCall is like Go but calls onDone after the call is complete, and the callbacks
+// of ctx for every progress (see WithProgress) and chunk received before it.
+func (invoker *invoker) goCall(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, done chan *Call, onDone func(*Call)) *Call {
 	call := new(Call)
+	call.onDone = onDone
+	call.onProgress = progressFunc(ctx)
+	call.onChunk = chunkFunc(ctx)
+	call.onTrailers = trailerFunc(ctx)
+	call.trace = traceFrom(ctx)
 	call.ServiceMethod = serviceMethod
 	call.Args = args
 	call.Reply = reply
@@ -89,6 +114,53 @@ func (invoker *invoker) Call(serviceMethod string, args interface{}, reply inter
 	return call.Error
 }
 
+// CallContext is like Call but gives up waiting when ctx is done.
+// The pending call is discarded, so a late response is dropped.
+func (invoker *invoker) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) *common.RPCError {
+	call := invoker.goCall(ctx, serviceMethod, args, reply, make(chan *Call, 1), nil)
+	select {
+	case call = <-call.Done:
+		if call.Error != nil && isAborted(ctx) {
+			// e.g. the connection is closed by AbortAll.
+			return RPCErrAborted
+		}
+		return call.Error
+	case <-ctx.Done():
+		invoker.mutex.Lock()
+		pending := invoker.pending[call.seq] == call
+		if pending {
+			delete(invoker.pending, call.seq)
+		}
+		invoker.mutex.Unlock()
+		if pending && call.onChunk != nil {
+			// the server stops streaming the reply.
+			invoker.cancelStream(call.seq)
+			if ctx.Err() == context.Canceled && !isAborted(ctx) {
+				return RPCErrStreamCancelled
+			}
+		}
+		return canceledError(ctx)
+	}
+}
+
+// cancelStream sends the control frame canceling the streamed reply of the call of the seq,
+// see common.CancelStreamPath. The server doesn't reply it.
+func (invoker *invoker) cancelStream(seq uint64) {
+	invoker.reqMutex.Lock()
+	defer invoker.reqMutex.Unlock()
+	invoker.mutex.Lock()
+	closed := invoker.shutdown || invoker.closing
+	invoker.mutex.Unlock()
+	if closed {
+		return
+	}
+	invoker.request.Seq = seq
+	invoker.request.ServiceMethod = common.EncodeCancelStream(seq)
+	if err := invoker.codec.writeControl(&invoker.request); err != nil {
+		log.Debug("rpc: canceling the stream: " + err.Error())
+	}
+}
+
 // Close calls the underlying codec's Close method. If the connection is already
 // shutting down, RPCErrShutdown is returned.
 func (invoker *invoker) Close() error {
@@ -102,6 +174,13 @@ func (invoker *invoker) Close() error {
 	return invoker.codec.Close()
 }
 
+//healthy returns whether the invoker is neither closed nor shut down.
+func (invoker *invoker) healthy() bool {
+	invoker.mutex.Lock()
+	defer invoker.mutex.Unlock()
+	return !invoker.closing && !invoker.shutdown
+}
+
 func (invoker *invoker) send(call *Call) {
 	invoker.reqMutex.Lock()
 	defer invoker.reqMutex.Unlock()
@@ -116,13 +195,23 @@ func (invoker *invoker) send(call *Call) {
 	}
 	seq := invoker.seq
 	invoker.seq++
+	call.seq = seq
 	invoker.pending[seq] = call
+	// the connection is used, a later selection reuses it.
+	invoker.dial = nil
 	invoker.mutex.Unlock()
 
 	// Encode and send the request.
 	invoker.request.Seq = seq
 	invoker.request.ServiceMethod = call.ServiceMethod
+	start := time.Now()
 	rpcErr := invoker.codec.WriteRequest(&invoker.request, call.Args)
+	if call.trace != nil {
+		call.trace.record(TraceWrite, invokerAddr(invoker), start, rpcErr)
+		invoker.mutex.Lock()
+		call.written = time.Now()
+		invoker.mutex.Unlock()
+	}
 	if rpcErr != nil {
 		invoker.mutex.Lock()
 		call = invoker.pending[seq]
@@ -135,6 +224,47 @@ func (invoker *invoker) send(call *Call) {
 	}
 }
 
+// parseResponseMetadata parses the metadata that the server appended to the echoed serviceMethod
+// of the request, nil if none. The echoed query of the request comes first, so the values of
+// the request are skipped, and a key of the request doesn't shadow the metadata of the server.
+func parseResponseMetadata(serviceMethod, requested string) map[string]string {
+	query := parseQuery(serviceMethod)
+	if len(query) == 0 {
+		return nil
+	}
+	echoed := parseQuery(requested)
+	metadata := make(map[string]string, len(query))
+	for key, values := range query {
+		if n := len(echoed[key]); n < len(values) {
+			metadata[key] = values[n]
+		}
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
+
+// parseQuery parses the query of the serviceMethod, nil if none or invalid.
+func parseQuery(serviceMethod string) url.Values {
+	i := strings.Index(serviceMethod, "?")
+	if i < 0 {
+		return nil
+	}
+	query, err := url.ParseQuery(serviceMethod[i+1:])
+	if err != nil {
+		return nil
+	}
+	return query
+}
+
+// responseMetadata returns the metadata of the latest response of the invoker.
+func (invoker *invoker) responseMetadata() map[string]string {
+	invoker.mutex.Lock()
+	defer invoker.mutex.Unlock()
+	return invoker.metadata
+}
+
 func (invoker *invoker) input() {
 	var (
 		rpcErr   *common.RPCError
@@ -147,9 +277,48 @@ func (invoker *invoker) input() {
 			break
 		}
 		seq := response.Seq
+		if percent, msg, metadata, ok := common.ParseProgressMetadata(response.ServiceMethod); ok {
+			// an interim progress, the call is still pending.
+			invoker.mutex.Lock()
+			call := invoker.pending[seq]
+			invoker.mutex.Unlock()
+			rpcErr = invoker.codec.ReadResponseBody(nil)
+			if rpcErr == nil && call != nil && call.onProgress != nil {
+				call.onProgress(percent, msg, metadata)
+			}
+			continue
+		}
+		if common.IsChunk(response.ServiceMethod) {
+			// a chunk of the streamed reply, the call is still pending.
+			invoker.mutex.Lock()
+			call := invoker.pending[seq]
+			invoker.mutex.Unlock()
+			if call == nil || call.onChunk == nil {
+				rpcErr = invoker.codec.ReadResponseBody(nil)
+				continue
+			}
+			var chunk []byte
+			if rpcErr = invoker.codec.ReadResponseBody(&chunk); rpcErr == nil {
+				call.onChunk(chunk)
+			}
+			continue
+		}
 		invoker.mutex.Lock()
 		call := invoker.pending[seq]
 		delete(invoker.pending, seq)
+		var requested string
+		if call != nil {
+			requested = call.ServiceMethod
+		}
+		metadata := parseResponseMetadata(response.ServiceMethod, requested)
+		invoker.metadata = metadata
+		var written time.Time // the start of the read phase of a traced call
+		if call != nil && call.trace != nil {
+			if written = call.written; written.IsZero() {
+				// the response outran the bookkeeping of the write.
+				written = time.Now()
+			}
+		}
 		invoker.mutex.Unlock()
 
 		switch {
@@ -168,6 +337,8 @@ func (invoker *invoker) input() {
 			rpcErr = parseResponseError(response.Error)
 			call.Error = rpcErr
 			rpcErr = invoker.codec.ReadResponseBody(nil)
+			call.trace.record(TraceRead, invokerAddr(invoker), written, call.Error)
+			call.trailers(metadata)
 			call.done()
 
 		default:
@@ -175,6 +346,8 @@ func (invoker *invoker) input() {
 			if rpcErr != nil {
 				call.Error = rpcErr
 			}
+			call.trace.record(TraceRead, invokerAddr(invoker), written, call.Error)
+			call.trailers(metadata)
 			call.done()
 		}
 	}
@@ -209,6 +382,16 @@ func (call *Call) done() {
 		// sure the channel has enough buffer space. See comment in Go().
 		log.Debug("rpc: discarding Call reply due to insufficient Done chan capacity")
 	}
+	if call.onDone != nil {
+		call.onDone(call)
+	}
+}
+
+// trailers passes the trailers in the metadata of the response to the callback of the call, if any.
+func (call *Call) trailers(metadata map[string]string) {
+	if call.onTrailers != nil {
+		call.onTrailers(common.ParseTrailers(metadata[common.TrailerKey]))
+	}
 }
 
 func parseResponseError(errMsg string) *common.RPCError {
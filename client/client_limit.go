@@ -0,0 +1,75 @@
+package client
+
+import (
+	"net"
+	"net/rpc"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+//limitConn limits the bytes read for every response, so that a huge response
+//fails to decode instead of exhausting the memory. The bytes that the codec reads ahead
+//belong to the next response, the codec reports them by Buffered, see bufferedCodec.
+type limitConn struct {
+	net.Conn
+	max    int64
+	remain int64 // the bytes the current response may still read from the connection
+}
+
+//bufferedCodec is implemented by the codecs reading ahead of the current response,
+//Buffered returns the bytes read from the connection but not decoded yet.
+type bufferedCodec interface {
+	Buffered() int
+}
+
+func buffered(codec rpc.ClientCodec) int64 {
+	if b, ok := codec.(bufferedCodec); ok {
+		return int64(b.Buffered())
+	}
+	return 0
+}
+
+func newLimitConn(conn net.Conn, max int64) *limitConn {
+	return &limitConn{Conn: conn, max: max, remain: max}
+}
+
+//reset starts the budget of the next response, charging the bytes of it that the codec has read ahead.
+func (c *limitConn) reset(codec rpc.ClientCodec) {
+	atomic.StoreInt64(&c.remain, c.max-buffered(codec))
+}
+
+//exceeded returns the error if the response read to its end exceeds the budget,
+//the bytes that the codec has read ahead of its end are given back.
+func (c *limitConn) exceeded(codec rpc.ClientCodec) error {
+	if atomic.LoadInt64(&c.remain)+buffered(codec) < 0 {
+		return c.errExceeded()
+	}
+	return nil
+}
+
+func (c *limitConn) errExceeded() error {
+	return common.NewError("response exceeds MaxResponseBytes (" + strconv.FormatInt(c.max, 10) + ")")
+}
+
+func (c *limitConn) Read(b []byte) (int, error) {
+	remain := atomic.LoadInt64(&c.remain)
+	if remain <= 0 {
+		return 0, c.errExceeded()
+	}
+	if int64(len(b)) > remain {
+		b = b[:remain]
+	}
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.remain, -int64(n))
+	return n, err
+}
+
+//limit wraps the connection by MaxResponseBytes before the codec is created.
+func (client *Client) limit(wrapper *clientCodecWrapper) {
+	if client.MaxResponseBytes > 0 {
+		wrapper.limitConn = newLimitConn(wrapper.codecConn.GetConn(), client.MaxResponseBytes)
+		wrapper.codecConn.SetConn(wrapper.limitConn)
+	}
+}
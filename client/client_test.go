@@ -0,0 +1,954 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	codecGob "github.com/henrylee2cn/myrpc/codec/gob"
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/plugin/compression"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type worker struct{}
+
+func (*worker) Echo(arg string, reply *string) error {
+	*reply = arg
+	return nil
+}
+
+func (*worker) Sleep(arg time.Duration, reply *string) error {
+	time.Sleep(arg)
+	*reply = "OK"
+	return nil
+}
+
+func (*worker) Repeat(n int, reply *string) error {
+	*reply = strings.Repeat("x", n)
+	return nil
+}
+
+// Cached sets the trailer after producing the reply, as a handler learns its cache state late.
+func (*worker) Cached(ctx *server.Context, arg string, reply *string) error {
+	*reply = arg
+	ctx.SetTrailer("cache", "hit")
+	ctx.SetTrailer("rows", "1")
+	return nil
+}
+
+// serve starts a server with the worker service on a random local port.
+func serve(t *testing.T) (*server.Server, string) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", new(worker))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	return srv, lis.Addr().String()
+}
+
+func newClient(c client.Client, addr string) *client.Client {
+	return client.NewClient(c, &selector.DirectSelector{
+		Network: "tcp",
+		Address: addr,
+	})
+}
+
+func TestCallTimeout(t *testing.T) {
+	_, addr := serve(t)
+	c := newClient(client.Client{CallTimeout: 50 * time.Millisecond}, addr)
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/worker/echo", "hello", &reply); e != nil || reply != "hello" {
+		t.Fatalf("echo: reply=%q, err=%v", reply, e)
+	}
+
+	start := time.Now()
+	e := c.Call("/worker/sleep", time.Second, &reply)
+	if e == nil || e.Type != common.ErrorTypeClientTimeout {
+		t.Fatalf("expect timeout error, got: %v", e)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("call returned after %v", d)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	_, addr := serve(t)
+	c := newClient(client.Client{}, addr)
+	var reply string
+	if e := c.Call("/worker/echo", "x", &reply); e != nil {
+		t.Fatal(e)
+	}
+
+	const n = 5
+	errs := make(chan *common.RPCError, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			var reply string
+			errs <- c.Call("/worker/sleep", 200*time.Millisecond, &reply)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case e := <-errs:
+			if e != nil {
+				t.Fatalf("in-flight call failed: %v", e)
+			}
+		default:
+			t.Fatal("Shutdown returned before in-flight calls complete")
+		}
+	}
+
+	if e := c.Call("/worker/echo", "x", &reply); e != common.RPCErrShutdown {
+		t.Fatalf("expect shutdown error, got: %v", e)
+	}
+}
+
+// listSelector selects the addresses in turn.
+type listSelector struct {
+	addrs          []string
+	next           int
+	newInvokerFunc client.NewInvokerFunc
+	mu             sync.Mutex
+}
+
+func (s *listSelector) SetSelectMode(client.SelectMode) {}
+
+func (s *listSelector) SetNewInvokerFunc(fn client.NewInvokerFunc) { s.newInvokerFunc = fn }
+
+func (s *listSelector) Select(options ...interface{}) (client.Invoker, error) {
+	s.mu.Lock()
+	addr := s.addrs[s.next%len(s.addrs)]
+	s.next++
+	s.mu.Unlock()
+	return s.newInvokerFunc("tcp", addr, 0)
+}
+
+func (s *listSelector) List() []client.Invoker { return nil }
+
+func (s *listSelector) HandleFailed(inv client.Invoker) { inv.Close() }
+
+func (s *listSelector) Debug() []client.BackendState { return nil }
+
+func TestOnRetry(t *testing.T) {
+	// the primary hangs up every connection.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	primary := lis.Addr().String()
+	_, secondary := serve(t)
+
+	var retries []string
+	c := client.NewClient(client.Client{
+		OnRetry: func(method, fromAddr, toAddr string, attempt int, err error) {
+			if err == nil {
+				t.Error("OnRetry: nil error")
+			}
+			retries = append(retries, method+" "+fromAddr+" "+toAddr+" "+strconv.Itoa(attempt))
+		},
+	}, &listSelector{addrs: []string{primary, secondary, secondary}})
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/worker/echo", "hello", &reply); e != nil || reply != "hello" {
+		t.Fatalf("echo: reply=%q, err=%v", reply, e)
+	}
+	if e := c.Call("/worker/echo", "again", &reply); e != nil || reply != "again" {
+		t.Fatalf("echo: reply=%q, err=%v", reply, e)
+	}
+	expect := "/worker/echo " + primary + " " + secondary + " 2"
+	if len(retries) != 1 || retries[0] != expect {
+		t.Fatalf("expect one retry %q, got: %q", expect, retries)
+	}
+}
+
+func TestOnRetryDial(t *testing.T) {
+	// nothing listens on the primary.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := lis.Addr().String()
+	lis.Close()
+	_, secondary := serve(t)
+
+	var retries []string
+	c := client.NewClient(client.Client{
+		OnRetry: func(method, fromAddr, toAddr string, attempt int, err error) {
+			if _, ok := err.(*client.DialError); !ok {
+				t.Errorf("OnRetry: expect a DialError, got: %v", err)
+			}
+			retries = append(retries, fromAddr+" "+toAddr)
+		},
+	}, &listSelector{addrs: []string{primary, secondary}})
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/worker/echo", "hello", &reply); e != nil || reply != "hello" {
+		t.Fatalf("echo: reply=%q, err=%v", reply, e)
+	}
+	expect := primary + " " + secondary
+	if len(retries) != 1 || retries[0] != expect {
+		t.Fatalf("expect one retry %q, got: %q", expect, retries)
+	}
+}
+
+func TestCallWithResult(t *testing.T) {
+	_, addr := serve(t)
+	c := newClient(client.Client{}, addr)
+	defer c.Close()
+
+	var reply string
+	res, e := c.CallWithResult("/worker/sleep", 10*time.Millisecond, &reply)
+	if e != nil {
+		t.Fatal(e.Error)
+	}
+	if res.Addr != addr {
+		t.Fatalf("expect addr %q, got %q", addr, res.Addr)
+	}
+	if res.Attempts != 1 {
+		t.Fatalf("expect 1 attempt, got %d", res.Attempts)
+	}
+	if res.Latency < 10*time.Millisecond {
+		t.Fatalf("latency %v is less than the service time", res.Latency)
+	}
+}
+
+var errTryAgain = errors.New("try again later")
+
+// flakyWorker fails with errTryAgain until it is called the given times.
+type flakyWorker struct {
+	calls int32
+	until int32
+}
+
+func (w *flakyWorker) Get(arg string, reply *string) error {
+	if atomic.AddInt32(&w.calls, 1) < w.until {
+		return errTryAgain
+	}
+	*reply = arg
+	return nil
+}
+
+// tryAgainClassifier also retries the errTryAgain of the service.
+type tryAgainClassifier struct{}
+
+func (tryAgainClassifier) Retryable(err error) bool {
+	if e, ok := err.(*client.CallError); ok && e.Type == common.ErrorTypeServerService {
+		return e.Msg == errTryAgain.Error()
+	}
+	return client.DefaultRetryClassifier.Retryable(err)
+}
+
+func TestRetryClassifier(t *testing.T) {
+	w := &flakyWorker{until: 3}
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("flaky", w)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+
+	var reply string
+	c := newClient(client.Client{}, lis.Addr().String())
+	if e := c.Call("/flaky/get", "x", &reply); e == nil || e.Error != errTryAgain.Error() {
+		t.Fatalf("expect no retry by default, got: %v", e)
+	}
+	c.Close()
+
+	atomic.StoreInt32(&w.calls, 0)
+	c = newClient(client.Client{RetryClassifier: tryAgainClassifier{}}, lis.Addr().String())
+	defer c.Close()
+	res, e := c.CallWithResult("/flaky/get", "x", &reply)
+	if e != nil || reply != "x" {
+		t.Fatalf("expect success after retries: reply=%q, err=%v", reply, e)
+	}
+	if res.Attempts != 3 {
+		t.Fatalf("expect 3 attempts, got %d", res.Attempts)
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	_, addr := serve(t)
+	c := newClient(client.Client{MaxResponseBytes: 4096}, addr)
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/worker/repeat", 1000, &reply); e != nil || len(reply) != 1000 {
+		t.Fatalf("small reply: len=%d, err=%v", len(reply), e)
+	}
+	done := make(chan *common.RPCError, 1)
+	go func() {
+		var reply string
+		done <- c.Call("/worker/repeat", 1<<24, &reply)
+	}()
+	select {
+	case e := <-done:
+		if e == nil || !strings.Contains(e.Error, "MaxResponseBytes") {
+			t.Fatalf("expect the response size error, got: %v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("oversized response is not rejected")
+	}
+	if e := c.Call("/worker/repeat", 1000, &reply); e != nil || len(reply) != 1000 {
+		t.Fatalf("small reply after the oversized one: len=%d, err=%v", len(reply), e)
+	}
+}
+
+// rwc reads from and closes the connection, and writes to the buffer.
+type rwc struct {
+	net.Conn
+	w io.Writer
+}
+
+func (c rwc) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+func TestMaxResponseBytesReadAhead(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var out bytes.Buffer
+		codec := codecGob.NewGobServerCodec(rwc{Conn: conn, w: &out})
+		var reqs [2]rpc.Request
+		for i := range reqs {
+			if codec.ReadRequestHeader(&reqs[i]) != nil || codec.ReadRequestBody(nil) != nil {
+				return
+			}
+		}
+		// the small reply and the oversized one arrive together, which the codec reads ahead.
+		for i, n := range []int{10, 3000} {
+			codec.WriteResponse(&rpc.Response{ServiceMethod: reqs[i].ServiceMethod, Seq: reqs[i].Seq}, strings.Repeat("x", n))
+		}
+		conn.Write(out.Bytes())
+		io.Copy(io.Discard, conn)
+	}()
+	c := newClient(client.Client{MaxResponseBytes: 2048}, lis.Addr().String())
+	defer c.Close()
+
+	var small, large string
+	first := c.Go("/worker/repeat", 10, &small, make(chan *client.Call, 1))
+	second := c.Go("/worker/repeat", 3000, &large, make(chan *client.Call, 1))
+	if call := <-first.Done; call.Error != nil || small != strings.Repeat("x", 10) {
+		t.Fatalf("small reply: %q, err=%v", small, call.Error)
+	}
+	if call := <-second.Done; call.Error == nil || !strings.Contains(call.Error.Error, "MaxResponseBytes") {
+		t.Fatalf("expect the response size error, got: %v", call.Error)
+	}
+}
+
+type progressWorker struct{}
+
+func (*progressWorker) Work(ctx *server.Context, arg string, reply *string) error {
+	ctx.Progress(50, "half")
+	ctx.Progress(90, "almost")
+	*reply = "done " + arg
+	return nil
+}
+
+func TestProgress(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("progress", new(progressWorker))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	c := newClient(client.Client{}, lis.Addr().String())
+	defer c.Close()
+
+	var progresses []string
+	ctx := client.WithProgress(context.Background(), func(percent int, msg string) {
+		progresses = append(progresses, strconv.Itoa(percent)+" "+msg)
+	})
+	var reply string
+	if e := c.CallContext(ctx, "/progress/work", "x", &reply); e != nil || reply != "done x" {
+		t.Fatalf("reply=%q, err=%v", reply, e)
+	}
+	if len(progresses) != 2 || progresses[0] != "50 half" || progresses[1] != "90 almost" {
+		t.Fatalf("expect two progresses before the reply, got: %q", progresses)
+	}
+	// a call without the callback ignores the progresses.
+	if e := c.Call("/progress/work", "y", &reply); e != nil || reply != "done y" {
+		t.Fatalf("reply=%q, err=%v", reply, e)
+	}
+}
+
+func TestConnRegistry(t *testing.T) {
+	srv, addr := serve(t)
+	registry := client.NewConnRegistry()
+	c1 := newClient(client.Client{ConnRegistry: registry}, addr)
+	c2 := newClient(client.Client{ConnRegistry: registry}, addr)
+
+	var reply string
+	for _, c := range []*client.Client{c1, c2} {
+		if e := c.Call("/worker/echo", "x", &reply); e != nil || reply != "x" {
+			t.Fatalf("reply=%q, err=%v", reply, e)
+		}
+	}
+	if accepts := srv.ListenerStats()[0].Accepts; accepts != 1 {
+		t.Fatalf("expect one connection, got %d", accepts)
+	}
+
+	c1.Close()
+	if e := c2.Call("/worker/echo", "y", &reply); e != nil || reply != "y" {
+		t.Fatalf("after closing the other client: reply=%q, err=%v", reply, e)
+	}
+	if registry.Len() != 1 {
+		t.Fatalf("expect the connection still shared, got %d", registry.Len())
+	}
+	c2.Close()
+	if registry.Len() != 0 {
+		t.Fatalf("expect the connection released, got %d", registry.Len())
+	}
+}
+
+func TestDialBackoff(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	var dials []time.Time
+	dial := func(network, address string, timeout time.Duration) (net.Conn, error) {
+		dials = append(dials, time.Now())
+		return net.DialTimeout(network, address, timeout)
+	}
+	c := newClient(client.Client{
+		FailMode:       client.Failtry,
+		MaxTry:         4,
+		DialBackoff:    10 * time.Millisecond,
+		MaxDialBackoff: 20 * time.Millisecond,
+		Dial:           dial,
+	}, addr)
+	defer c.Close()
+
+	var reply string
+	e := c.Call("/worker/echo", "x", &reply)
+	if e == nil || e.Type != common.ErrorTypeClientConnect {
+		t.Fatalf("expect a connect error, got: %v", e)
+	}
+	if len(dials) != 4 {
+		t.Fatalf("expect 4 dials, got %d", len(dials))
+	}
+	// the backoff doubles up to the MaxDialBackoff, the timers never fire early.
+	for i, backoff := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond} {
+		if gap := dials[i+1].Sub(dials[i]); gap < backoff {
+			t.Fatalf("dial %d: expect a backoff of %v, got %v", i+2, backoff, gap)
+		}
+	}
+
+	// the call gives up at once if the backoff doesn't fit in its deadline.
+	dials = nil
+	c2 := newClient(client.Client{
+		FailMode:    client.Failtry,
+		MaxTry:      4,
+		DialBackoff: time.Hour,
+		CallTimeout: time.Minute,
+		Dial:        dial,
+	}, addr)
+	defer c2.Close()
+	e = c2.Call("/worker/echo", "x", &reply)
+	if e == nil || e.Type != common.ErrorTypeClientConnect {
+		t.Fatalf("expect a connect error, got: %v", e)
+	}
+	if len(dials) != 1 {
+		t.Fatalf("expect no dial beyond the deadline, got %d", len(dials))
+	}
+}
+
+func TestPing(t *testing.T) {
+	_, addr := serve(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := lis.Addr().String()
+	lis.Close()
+
+	// the selector is not used
+	c := newClient(client.Client{CallTimeout: time.Second}, closed)
+	defer c.Close()
+	for _, a := range []string{addr, "tcp://" + addr} {
+		rtt, err := c.Ping(a)
+		if err != nil {
+			t.Fatalf("ping %s: %v", a, err)
+		}
+		if rtt <= 0 || rtt > time.Second {
+			t.Fatalf("ping %s: rtt = %v", a, rtt)
+		}
+	}
+	if _, err := c.Ping(closed); err == nil {
+		t.Fatal("expect an error pinging the closed port")
+	}
+}
+
+type Inner struct {
+	Code  int
+	Notes []string
+}
+
+type Result struct {
+	Name  string
+	Count int
+	Inner Inner
+	Ptr   *Inner
+	Attrs map[string]string
+}
+
+type resultWorker struct{}
+
+func (*resultWorker) Get(full bool, reply *Result) error {
+	if full {
+		*reply = Result{
+			Name:  "full",
+			Count: 3,
+			Inner: Inner{Code: 7, Notes: []string{"a", "b"}},
+			Ptr:   &Inner{Code: 9},
+			Attrs: map[string]string{"k": "v"},
+		}
+		return nil
+	}
+	*reply = Result{Name: "sparse", Inner: Inner{Notes: []string{"c"}}}
+	return nil
+}
+
+func TestCallReusing(t *testing.T) {
+	srv, addr := serve(t)
+	srv.NamedRegister("result", new(resultWorker))
+	c := newClient(client.Client{}, addr)
+	defer c.Close()
+
+	reply := new(Result)
+	if e := c.CallReusing("/result/get", true, reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	if reply.Name != "full" || reply.Count != 3 || reply.Ptr == nil || reply.Ptr.Code != 9 || len(reply.Attrs) != 1 {
+		t.Fatalf("first reply: %+v", reply)
+	}
+	if e := c.CallReusing("/result/get", false, reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	want := &Result{Name: "sparse", Inner: Inner{Notes: []string{"c"}}}
+	if !reflect.DeepEqual(reply, want) {
+		t.Fatalf("second reply: %+v, want %+v", reply, want)
+	}
+}
+
+type blobs struct {
+	blob []byte
+}
+
+func (b *blobs) Download(name string, reply *io.Reader) error {
+	if name != "blob" {
+		return errors.New("no blob " + name)
+	}
+	*reply = bytes.NewReader(b.blob)
+	return nil
+}
+
+func TestCallStreamTo(t *testing.T) {
+	blob := make([]byte, 1<<20+123)
+	rand.New(rand.NewSource(1)).Read(blob)
+	srv, addr := serve(t)
+	srv.NamedRegister("blobs", &blobs{blob: blob})
+	c := newClient(client.Client{}, addr)
+	defer c.Close()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := c.CallStreamTo("/blobs/download", "blob", f); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Fatalf("downloaded %d bytes differ from the %d bytes of the blob", len(got), len(blob))
+	}
+
+	var buf bytes.Buffer
+	if err := c.CallStreamTo("/blobs/download", "missing", &buf); err == nil || !strings.Contains(err.Error(), "no blob missing") || buf.Len() > 0 {
+		t.Fatalf("expect the service error, got %v with %d bytes", err, buf.Len())
+	}
+	// the connection keeps serving the unary calls.
+	var reply string
+	if e := c.Call("/worker/echo", "hello", &reply); e != nil || reply != "hello" {
+		t.Fatalf("echo: reply=%q, err=%v", reply, e)
+	}
+}
+
+// ticker streams ticks until the client cancels.
+type ticker struct {
+	stopped chan error // the error of Send and whether ctx.Context() is done
+}
+
+func (tk *ticker) Ticks(ctx *server.Context, _ string, reply *io.Reader) error {
+	stream := server.NewStream()
+	values := ctx.Context()
+	go func() {
+		var err error
+		for err == nil {
+			err = stream.Send([]byte("tick\n"))
+			time.Sleep(time.Millisecond)
+		}
+		select {
+		case <-values.Done():
+			tk.stopped <- err
+		case <-time.After(time.Second):
+			tk.stopped <- errors.New("ctx.Context() is not done after " + err.Error())
+		}
+	}()
+	*reply = stream
+	return nil
+}
+
+// cancelWriter cancels the stream after n writes.
+type cancelWriter struct {
+	n      int
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	if w.n--; w.n == 0 {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
+func TestCancelStream(t *testing.T) {
+	// the cancel is a control frame, the limits of the calls held by the stream don't delay it.
+	for name, limit := range map[string]func(*server.Server){
+		"default":             func(*server.Server) {},
+		"MaxPendingResponses": func(srv *server.Server) { srv.MaxPendingResponses = 1 },
+		"Workers":             func(srv *server.Server) { srv.SetWorkers(1) },
+		"MaxGoroutines":       func(srv *server.Server) { srv.MaxGoroutines = 1 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := server.NewServer(server.Server{})
+			limit(srv)
+			srv.NamedRegister("worker", new(worker))
+			tk := &ticker{stopped: make(chan error, 1)}
+			srv.NamedRegister("ticker", tk)
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.ServeListener(lis)
+			c := newClient(client.Client{}, lis.Addr().String())
+			defer c.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err = c.CallStreamToContext(ctx, "/ticker/ticks", "", &cancelWriter{n: 5, cancel: cancel})
+			if err == nil || err.Error() != client.RPCErrStreamCancelled.Error {
+				t.Fatalf("expect the stream cancelled, got %v", err)
+			}
+			select {
+			case err := <-tk.stopped:
+				if err != server.ErrStreamCancelled {
+					t.Fatalf("expect Send to return the cancellation, got %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the server keeps streaming after the cancellation")
+			}
+			// the connection keeps serving.
+			var reply string
+			if e := c.Call("/worker/echo", "hello", &reply); e != nil || reply != "hello" {
+				t.Fatalf("echo: reply=%q, err=%v", reply, e)
+			}
+		})
+	}
+}
+
+type metadataWorker struct{}
+
+func (*metadataWorker) Get(ctx *server.Context, key string, reply *string) error {
+	if key == common.PriorityKey {
+		*reply = ctx.Priority().String()
+		return nil
+	}
+	*reply = ctx.Query().Get(key)
+	return nil
+}
+
+func TestMetadataFilter(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("metadata", new(metadataWorker))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	c := newClient(client.Client{
+		MetadataFilter: func(md map[string]string) map[string]string {
+			for k := range md {
+				if strings.HasPrefix(k, "internal-") {
+					delete(md, k)
+				}
+			}
+			md["tenant"] = strings.ToUpper(md["tenant"])
+			return md
+		},
+	}, lis.Addr().String())
+	defer c.Close()
+
+	var reply string
+	for key, want := range map[string]string{
+		"internal-trace": "",
+		"tenant":         "ACME",
+		"zone":           "eu 1",
+	} {
+		if e := c.Call("/metadata/get?internal-trace=abc&tenant=acme&zone=eu+1", key, &reply); e != nil || reply != want {
+			t.Fatalf("%s: got %q, want %q, err=%v", key, reply, want, e)
+		}
+	}
+	// the metadata of the client passes the filter too
+	if e := c.CallWithPriority("/metadata/get", common.PriorityHigh, common.PriorityKey, &reply); e != nil || reply != common.PriorityHigh.String() {
+		t.Fatalf("priority: got %q, err=%v", reply, e)
+	}
+}
+
+// countingListener counts the bytes read from the accepted connections.
+type countingListener struct {
+	net.Listener
+	read int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: c, read: &l.read}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	read *int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(c.read, int64(n))
+	return n, err
+}
+
+func TestForceRequestCompression(t *testing.T) {
+	srv := server.NewServer(server.Server{DecompressRequests: true})
+	srv.NamedRegister("worker", new(worker))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingListener{Listener: lis}
+	go srv.ServeListener(counting)
+
+	upload := strings.Repeat("compressible payload ", 50000)
+	var reply string
+	for _, compressType := range []compression.CompressType{compression.CompressFlate, compression.CompressSnappy, compression.CompressLZ4} {
+		atomic.StoreInt64(&counting.read, 0)
+		c := newClient(client.Client{ForceRequestCompression: compressType}, lis.Addr().String())
+		for i := 0; i < 2; i++ {
+			if e := c.Call("/worker/echo", upload, &reply); e != nil || reply != upload {
+				t.Fatalf("compress type %d: len=%d, err=%v", compressType, len(reply), e)
+			}
+		}
+		c.Close()
+		if n := atomic.LoadInt64(&counting.read); n >= int64(len(upload)) {
+			t.Fatalf("compress type %d: server read %d bytes for two requests of %d bytes", compressType, n, len(upload))
+		}
+	}
+
+	// the server reads the plain requests too
+	plain := newClient(client.Client{}, lis.Addr().String())
+	defer plain.Close()
+	if e := plain.Call("/worker/echo", "x", &reply); e != nil || reply != "x" {
+		t.Fatalf("plain: reply=%q, err=%v", reply, e)
+	}
+}
+
+func TestAbortAll(t *testing.T) {
+	_, addr := serve(t)
+	c := newClient(client.Client{}, addr)
+	defer c.Close()
+	var reply string
+	if e := c.Call("/worker/echo", "x", &reply); e != nil {
+		t.Fatal(e)
+	}
+
+	abort := func(reconnect bool) {
+		const n = 5
+		errs := make(chan *common.RPCError, n)
+		for i := 0; i < n; i++ {
+			go func() {
+				var reply string
+				errs <- c.Call("/worker/sleep", 5*time.Second, &reply)
+			}()
+		}
+		time.Sleep(50 * time.Millisecond)
+		start := time.Now()
+		if aborted := c.AbortAll(reconnect); aborted != n {
+			t.Fatalf("aborted %d calls, want %d", aborted, n)
+		}
+		for i := 0; i < n; i++ {
+			if e := <-errs; e != client.RPCErrAborted {
+				t.Fatalf("reconnect=%v: expect the aborted error, got: %v", reconnect, e)
+			}
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("the aborted calls returned after %v", d)
+		}
+		// the client still serves the new calls.
+		if e := c.Call("/worker/echo", "y", &reply); e != nil || reply != "y" {
+			t.Fatalf("reconnect=%v: after aborting: reply=%q, err=%v", reconnect, reply, e)
+		}
+	}
+	abort(false)
+	abort(true)
+}
+
+func TestCallWithTrace(t *testing.T) {
+	_, addr := serve(t)
+	c := newClient(client.Client{}, addr)
+	defer c.Close()
+
+	var reply string
+	trace, e := c.CallWithTrace("/worker/echo", "hi", &reply)
+	if e != nil {
+		t.Fatal(e.Error)
+	}
+	phases := make([]client.TracePhase, 0, 4)
+	for _, ev := range trace.Events() {
+		phases = append(phases, ev.Phase)
+		if ev.Addr != addr || ev.Error != "" {
+			t.Fatalf("event = %+v", ev)
+		}
+	}
+	want := []client.TracePhase{client.TraceSelect, client.TraceDial, client.TraceWrite, client.TraceRead}
+	if !reflect.DeepEqual(phases, want) {
+		t.Fatalf("phases = %v, want %v", phases, want)
+	}
+	if sel := trace.Phase(client.TraceSelect)[0]; sel.Reused {
+		t.Fatalf("the first selection dials: %+v", sel)
+	}
+	for _, phase := range []client.TracePhase{client.TraceWrite, client.TraceRead} {
+		if ev := trace.Phase(phase)[0]; ev.Duration <= 0 {
+			t.Fatalf("%s = %+v", phase, ev)
+		}
+	}
+
+	// the connection is reused
+	trace, e = c.CallWithTrace("/worker/echo", "hi", &reply)
+	if e != nil {
+		t.Fatal(e.Error)
+	}
+	if sel := trace.Phase(client.TraceSelect); len(sel) != 1 || !sel[0].Reused || len(trace.Phase(client.TraceDial)) != 0 {
+		t.Fatalf("events = %+v", trace.Events())
+	}
+
+	// the dial of an untraced call isn't attributed to a later traced one
+	untraced := newClient(client.Client{}, addr)
+	defer untraced.Close()
+	if e = untraced.Call("/worker/echo", "hi", &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	trace, e = untraced.CallWithTrace("/worker/echo", "hi", &reply)
+	if e != nil {
+		t.Fatal(e.Error)
+	}
+	if sel := trace.Phase(client.TraceSelect); len(sel) != 1 || !sel[0].Reused || len(trace.Phase(client.TraceDial)) != 0 {
+		t.Fatalf("after an untraced call: events = %+v", trace.Events())
+	}
+}
+
+func TestCallWithTrailers(t *testing.T) {
+	_, addr := serve(t)
+	c := newClient(client.Client{}, addr)
+	defer c.Close()
+
+	var reply string
+	trailers, e := c.CallWithTrailers("/worker/cached", "hello", &reply)
+	if e != nil || reply != "hello" {
+		t.Fatalf("cached: reply=%q, err=%v", reply, e)
+	}
+	if len(trailers) != 2 || trailers["cache"] != "hit" || trailers["rows"] != "1" {
+		t.Fatalf("expect the trailers set by the handler, got %v", trailers)
+	}
+
+	trailers, e = c.CallWithTrailers("/worker/echo", "hello", &reply)
+	if e != nil || trailers != nil {
+		t.Fatalf("expect no trailers, got %v, err=%v", trailers, e)
+	}
+	// the trailers don't leak into the reused context of the next call
+	if trailers, _ = c.CallWithTrailers("/worker/echo", "again", &reply); trailers != nil {
+		t.Fatalf("expect no trailers, got %v", trailers)
+	}
+}
+
+func TestFailtryDraining(t *testing.T) {
+	draining, drainingAddr := serve(t)
+	draining.SetDraining(true)
+	_, addr := serve(t)
+
+	c := client.NewClient(client.Client{FailMode: client.Failtry, MaxTry: 2},
+		&listSelector{addrs: []string{drainingAddr, addr}})
+	defer c.Close()
+	var reply string
+	if e := c.Call("/worker/echo", "hello", &reply); e != nil || reply != "hello" {
+		t.Fatalf("expect the call retried on the other server, got: reply=%q, err=%v", reply, e)
+	}
+}
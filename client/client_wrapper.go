@@ -1,7 +1,11 @@
 package client
 
 import (
+	"io"
+	"net"
 	"net/rpc"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/henrylee2cn/myrpc/common"
@@ -13,6 +17,21 @@ type clientCodecWrapper struct {
 	timeout         time.Duration
 	readTimeout     time.Duration
 	writeTimeout    time.Duration
+	capabilities    string // advertised once on the first request
+	advertised      bool
+	limitConn       *limitConn // limits the size of every response if not nil
+	metadataFilter  func(map[string]string) map[string]string
+}
+
+//writeControl writes the control frame, e.g. canceling a stream, without the plugins.
+func (w *clientCodecWrapper) writeControl(r *rpc.Request) error {
+	if w.timeout > 0 {
+		w.codecConn.SetDeadline(time.Now().Add(w.timeout))
+	}
+	if w.writeTimeout > 0 {
+		w.codecConn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	}
+	return w.codecConn.WriteRequest(r, struct{}{})
 }
 
 func (w *clientCodecWrapper) WriteRequest(r *rpc.Request, body interface{}) *common.RPCError {
@@ -32,6 +51,19 @@ func (w *clientCodecWrapper) WriteRequest(r *rpc.Request, body interface{}) *com
 		}
 	}
 
+	if w.metadataFilter != nil {
+		r.ServiceMethod = filterMetadata(r.ServiceMethod, w.metadataFilter)
+	}
+
+	if len(w.capabilities) > 0 && !w.advertised {
+		w.advertised = true
+		sep := "?"
+		if strings.Contains(r.ServiceMethod, "?") {
+			sep = "&"
+		}
+		r.ServiceMethod += sep + url.Values{common.CapabilitiesKey: {w.capabilities}}.Encode()
+	}
+
 	err = w.codecConn.WriteRequest(r, body)
 	if err != nil {
 		return &common.RPCError{
@@ -52,6 +84,9 @@ func (w *clientCodecWrapper) WriteRequest(r *rpc.Request, body interface{}) *com
 }
 
 func (w *clientCodecWrapper) ReadResponseHeader(r *rpc.Response) *common.RPCError {
+	if w.limitConn != nil {
+		w.limitConn.reset(w.codecConn.GetClientCodec())
+	}
 	if w.timeout > 0 {
 		w.codecConn.SetDeadline(time.Now().Add(w.timeout))
 	}
@@ -75,6 +110,11 @@ func (w *clientCodecWrapper) ReadResponseHeader(r *rpc.Response) *common.RPCErro
 			Error: err.Error(),
 		}
 	}
+	if i := strings.Index(r.ServiceMethod, "?"); i >= 0 && strings.Contains(r.ServiceMethod[i:], common.CapabilitiesKey+"=") {
+		if query, e := url.ParseQuery(r.ServiceMethod[i+1:]); e == nil {
+			w.codecConn.SetCapabilities(common.ParseCapabilities(query.Get(common.CapabilitiesKey)))
+		}
+	}
 
 	//post
 	err = w.pluginContainer.doPostReadResponseHeader(r)
@@ -99,11 +139,23 @@ func (w *clientCodecWrapper) ReadResponseBody(body interface{}) *common.RPCError
 
 	err = w.codecConn.ReadResponseBody(body)
 	if err != nil {
+		errorType := common.ErrorTypeClientDecodeResponse
+		if isConnError(err) {
+			errorType = common.ErrorTypeClientReadResponseBody
+		}
 		return &common.RPCError{
-			Type:  common.ErrorTypeClientReadResponseBody,
+			Type:  errorType,
 			Error: err.Error(),
 		}
 	}
+	if w.limitConn != nil {
+		if err = w.limitConn.exceeded(w.codecConn.GetClientCodec()); err != nil {
+			return &common.RPCError{
+				Type:  common.ErrorTypeClientDecodeResponse,
+				Error: err.Error(),
+			}
+		}
+	}
 
 	//post
 	err = w.pluginContainer.doPostReadResponseBody(body)
@@ -119,3 +171,12 @@ func (w *clientCodecWrapper) ReadResponseBody(body interface{}) *common.RPCError
 func (w *clientCodecWrapper) Close() error {
 	return w.codecConn.Close()
 }
+
+//isConnError returns whether err is caused by the connection rather than the content.
+func isConnError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	//ConnRegistry shares the connections among the clients by the network and address,
+	//so that the clients of the same server don't open duplicate connections.
+	//A shared connection is reference-counted and closed when all the clients close it.
+	//The clients sharing a registry must use the same codec, plugins and timeouts.
+	ConnRegistry struct {
+		lock    sync.Mutex
+		entries map[string]*registryEntry
+	}
+
+	registryEntry struct {
+		invoker *invoker
+		refs    int
+	}
+
+	//sharedInvoker is a reference of a shared invoker, which is released once by Close.
+	sharedInvoker struct {
+		*invoker
+		registry *ConnRegistry
+		key      string
+		released int32
+	}
+)
+
+//NewConnRegistry creates an empty ConnRegistry.
+func NewConnRegistry() *ConnRegistry {
+	return &ConnRegistry{entries: make(map[string]*registryEntry)}
+}
+
+//Len returns the number of the shared connections.
+func (r *ConnRegistry) Len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.entries)
+}
+
+//acquire returns a reference of the healthy invoker of the key, or of the new one created by dial.
+func (r *ConnRegistry) acquire(key string, dial func() (Invoker, error)) (Invoker, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if e, ok := r.entries[key]; ok {
+		if e.invoker.healthy() {
+			e.refs++
+			return &sharedInvoker{invoker: e.invoker, registry: r, key: key}, nil
+		}
+		delete(r.entries, key)
+	}
+	inv, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	i, ok := inv.(*invoker)
+	if !ok {
+		return inv, nil
+	}
+	r.entries[key] = &registryEntry{invoker: i, refs: 1}
+	return &sharedInvoker{invoker: i, registry: r, key: key}, nil
+}
+
+//release drops a reference of the invoker, and returns whether it is the last one.
+func (r *ConnRegistry) release(key string, inv *invoker) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	e, ok := r.entries[key]
+	if !ok || e.invoker != inv {
+		// replaced for it is unhealthy.
+		return true
+	}
+	e.refs--
+	if e.refs > 0 {
+		return false
+	}
+	delete(r.entries, key)
+	return true
+}
+
+//Close releases the reference, and closes the connection if it is the last one.
+func (s *sharedInvoker) Close() error {
+	if !atomic.CompareAndSwapInt32(&s.released, 0, 1) {
+		return nil
+	}
+	if s.registry.release(s.key, s.invoker) {
+		return s.invoker.Close()
+	}
+	return nil
+}
+
+//asInvoker returns the invoker created by the client, which may be shared.
+func asInvoker(inv Invoker) (*invoker, bool) {
+	switch i := inv.(type) {
+	case *invoker:
+		return i, true
+	case *sharedInvoker:
+		return i.invoker, true
+	}
+	return nil, false
+}
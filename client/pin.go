@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// PinnedClient makes the calls on the connection of a single backend selected by Client.Pin,
+// e.g. for the multi-step transactions that must hit the same backend.
+// It never fails over: once the connection breaks, every call fails.
+type PinnedClient struct {
+	client   *Client
+	invoker  Invoker
+	lock     sync.Mutex // protects following
+	broken   *common.RPCError
+	released bool
+}
+
+var errPinReleased = common.NewRPCError(common.ErrorTypeClientShutdown, "pinned client is released")
+
+// Pin selects a backend by the selector and returns the PinnedClient making the calls
+// on its connection until Release.
+func (client *Client) Pin() (*PinnedClient, error) {
+	invoker, err := client.selector.Select()
+	if err != nil {
+		return nil, err
+	}
+	if invoker == nil {
+		return nil, common.NewError("no invoker is selected")
+	}
+	return &PinnedClient{client: client, invoker: invoker}, nil
+}
+
+// Addr returns the address of the pinned backend.
+func (p *PinnedClient) Addr() string {
+	return invokerAddr(p.invoker)
+}
+
+// Call is like Client.Call on the pinned backend.
+func (p *PinnedClient) Call(serviceMethod string, args interface{}, reply interface{}) *common.RPCError {
+	ctx := context.Background()
+	if p.client.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.client.CallTimeout)
+		defer cancel()
+	}
+	return p.CallContext(ctx, serviceMethod, args, reply)
+}
+
+// CallContext is like Client.CallContext on the pinned backend.
+func (p *PinnedClient) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) *common.RPCError {
+	p.lock.Lock()
+	released, broken := p.released, p.broken
+	p.lock.Unlock()
+	if released {
+		return errPinReleased
+	}
+	if broken != nil {
+		return broken
+	}
+	if !p.client.track() {
+		return common.RPCErrShutdown
+	}
+	defer p.client.shutdown.calls.Done()
+	ctx, release := p.client.abortable(ctx)
+	defer release()
+	rpcErr := p.client.invoke(ctx, p.invoker, withHops(ctx, serviceMethod), args, reply)
+	if rpcErr != nil && isBrokenConn(rpcErr.Type) {
+		p.lock.Lock()
+		if p.broken == nil {
+			p.broken = common.NewRPCError(common.ErrorTypeClientConnect, "pinned backend "+p.Addr()+" failed: "+rpcErr.Error)
+			// the other calls of the client select another backend.
+			p.client.selector.HandleFailed(p.invoker)
+		}
+		p.lock.Unlock()
+	}
+	return rpcErr
+}
+
+// Release ends the pinning, after which every call fails.
+// The connection stays with the selector for the other calls of the client.
+func (p *PinnedClient) Release() {
+	p.lock.Lock()
+	p.released = true
+	p.lock.Unlock()
+}
+
+// isBrokenConn returns whether the call failed for the connection.
+func isBrokenConn(t common.ErrorType) bool {
+	switch t {
+	case common.ErrorTypeClientShutdown, common.ErrorTypeClientWriteRequest,
+		common.ErrorTypeClientReadResponseHeader, common.ErrorTypeClientReadResponseBody:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package client_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type backend struct {
+	name string
+}
+
+func (b *backend) Name(arg string, reply *string) error {
+	*reply = b.name
+	return nil
+}
+
+// connsListener records the accepted connections to break them.
+type connsListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *connsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+	}
+	return conn, err
+}
+
+func (l *connsListener) breakConns() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+}
+
+func TestPin(t *testing.T) {
+	listeners := make(map[string]*connsListener)
+	var addrs []string
+	for _, name := range []string{"a", "b"} {
+		srv := server.NewServer(server.Server{})
+		srv.NamedRegister("backend", &backend{name: name})
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := &connsListener{Listener: lis}
+		go srv.ServeListener(l)
+		listeners[name] = l
+		addrs = append(addrs, lis.Addr().String())
+	}
+	// the selector alternates the backends
+	c := client.NewClient(client.Client{}, &listSelector{addrs: addrs})
+	defer c.Close()
+
+	p, err := c.Pin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pinned string
+	for i := 0; i < 3; i++ {
+		var name string
+		if e := p.Call("/backend/name", "", &name); e != nil {
+			t.Fatal(e.Error)
+		}
+		if pinned == "" {
+			pinned = name
+		} else if name != pinned {
+			t.Fatalf("call %d hit %s, pinned %s", i, name, pinned)
+		}
+	}
+	if p.Addr() != listeners[pinned].Addr().String() {
+		t.Fatalf("Addr() = %s, pinned %s", p.Addr(), listeners[pinned].Addr())
+	}
+
+	// no failover after the pinned backend dies
+	listeners[pinned].breakConns()
+	var name string
+	if e := p.Call("/backend/name", "", &name); e == nil {
+		t.Fatalf("expect an error, hit %s", name)
+	}
+	if e := p.Call("/backend/name", "", &name); e == nil || e.Type != common.ErrorTypeClientConnect {
+		t.Fatalf("expect the broken pin error, got: %v", e)
+	}
+	if e := c.Call("/backend/name", "", &name); e != nil {
+		t.Fatalf("the client should go on: %v", e.Error)
+	}
+
+	p.Release()
+	if e := p.Call("/backend/name", "", &name); e == nil {
+		t.Fatal("expect an error after Release")
+	}
+}
@@ -0,0 +1,28 @@
+package client
+
+import (
+	"context"
+)
+
+type progressKey struct{}
+
+//WithProgress returns a copy of ctx with the callback receiving the progresses
+//sent by the service before the final reply, e.g. client.CallContext(client.WithProgress(ctx, fn), ...).
+//The callback runs on the goroutine reading the responses, it must not block.
+func WithProgress(ctx context.Context, fn func(percent int, msg string)) context.Context {
+	return WithProgressMetadata(ctx, func(percent int, msg string, _ map[string]string) {
+		fn(percent, msg)
+	})
+}
+
+//WithProgressMetadata is like WithProgress, and the callback receives the metadata
+//of the progresses too (see server.Context.ProgressWithMetadata), nil if none.
+func WithProgressMetadata(ctx context.Context, fn func(percent int, msg string, metadata map[string]string)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+//progressFunc returns the callback set by WithProgress or WithProgressMetadata, or nil.
+func progressFunc(ctx context.Context) func(int, string, map[string]string) {
+	fn, _ := ctx.Value(progressKey{}).(func(int, string, map[string]string))
+	return fn
+}
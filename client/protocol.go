@@ -0,0 +1,68 @@
+package client
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+//negotiateProtocol declares the ProtocolVersion to the server on the new connection
+//and reads the answer, an error if the server rejects the version.
+//The conn is the one wrapped by the PostConnected plugins, which the server reads it from.
+func (client *Client) negotiateProtocol(conn net.Conn, timeout time.Duration) error {
+	if client.ProtocolVersion == 0 {
+		return nil
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	preamble := common.ProtocolPreamble
+	if _, err := conn.Write([]byte(preamble + string([]byte{client.ProtocolVersion}))); err != nil {
+		return err
+	}
+	answer := make([]byte, len(preamble)+1)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return common.NewError("protocol version " + strconv.Itoa(int(client.ProtocolVersion)) +
+			" not acknowledged by the server: " + err.Error())
+	}
+	if string(answer[:len(preamble)]) != preamble {
+		return common.NewError("invalid answer of the protocol version")
+	}
+	if answer[len(preamble)] == 0 {
+		// the reason, up to the server closing the connection.
+		reason, _ := ioutil.ReadAll(io.LimitReader(conn, 512))
+		return common.NewError("protocol version rejected: " + string(reason))
+	}
+	if answer[len(preamble)] != client.ProtocolVersion {
+		return common.NewError("protocol version " + strconv.Itoa(int(answer[len(preamble)])) + " answered for " +
+			strconv.Itoa(int(client.ProtocolVersion)))
+	}
+	return nil
+}
+
+//protocolHeader returns the header line of the HTTP CONNECT request declaring the ProtocolVersion, if any.
+func (client *Client) protocolHeader() string {
+	if client.ProtocolVersion == 0 {
+		return ""
+	}
+	return common.ProtocolHeader + ": " + strconv.Itoa(int(client.ProtocolVersion)) + "\n"
+}
+
+//checkProtocolHeader checks the HTTP CONNECT response answers the declared ProtocolVersion.
+func (client *Client) checkProtocolHeader(header http.Header) error {
+	if client.ProtocolVersion == 0 {
+		return nil
+	}
+	answer := header.Get(common.ProtocolHeader)
+	if answer != strconv.Itoa(int(client.ProtocolVersion)) {
+		return common.NewError("protocol version " + strconv.Itoa(int(client.ProtocolVersion)) +
+			" not acknowledged by the server: " + strconv.Quote(answer))
+	}
+	return nil
+}
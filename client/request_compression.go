@@ -0,0 +1,21 @@
+package client
+
+import (
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/common/compress"
+)
+
+//compressRequests starts the compression of ForceRequestCompression before the codec is created:
+//it writes the preamble with the compression type, then compresses the connection both ways.
+func (client *Client) compressRequests(wrapper *clientCodecWrapper) error {
+	if client.ForceRequestCompression == compress.CompressNone {
+		return nil
+	}
+	conn := wrapper.codecConn.GetConn()
+	preamble := append([]byte(common.CompressedRequestsPreamble), byte(client.ForceRequestCompression))
+	if _, err := conn.Write(preamble); err != nil {
+		return err
+	}
+	wrapper.codecConn.SetConn(compress.NewCompressConn(conn, client.ForceRequestCompression))
+	return nil
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+type (
+	//RetryClassifier decides whether a failed call is retried by the Failover or Failtry mode.
+	RetryClassifier interface {
+		//Retryable returns whether the call failed with err is retried, err is a *CallError.
+		Retryable(err error) bool
+	}
+
+	//CallError is the error of a failed call passed to the RetryClassifier.
+	CallError struct {
+		Type common.ErrorType
+		Msg  string
+	}
+
+	defaultRetryClassifier struct{}
+)
+
+//DefaultRetryClassifier retries the connection errors, but neither the timeouts, the aborted calls,
+//the shutdown of the client, the responses failing to decode nor the errors returned by the server.
+var DefaultRetryClassifier RetryClassifier = defaultRetryClassifier{}
+
+func newCallError(rpcErr *common.RPCError) *CallError {
+	return &CallError{Type: rpcErr.Type, Msg: rpcErr.Error}
+}
+
+//Error returns the error message.
+func (e *CallError) Error() string {
+	return e.Msg
+}
+
+func (defaultRetryClassifier) Retryable(err error) bool {
+	e, ok := err.(*CallError)
+	if !ok {
+		return true
+	}
+	switch e.Type {
+	case common.ErrorTypeClientShutdown, common.ErrorTypeClientTimeout, common.ErrorTypeClientDecodeResponse, common.ErrorTypeClientAborted,
+		common.ErrorTypeClientStreamCancelled:
+		return false
+	}
+	return e.Type <= 0
+}
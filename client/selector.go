@@ -2,6 +2,8 @@ package client
 
 import (
 	"time"
+
+	"github.com/henrylee2cn/myrpc/common"
 )
 
 // Selector manage Invokers.
@@ -16,6 +18,36 @@ type Selector interface {
 	List() []Invoker
 	//HandleFailed handle failed Invoker
 	HandleFailed(Invoker)
+	//Debug returns the current state of the backends for debugging
+	Debug() []BackendState
+}
+
+// SelectorFeedback can be implemented by a Selector to be told when a call on
+// the selected Invoker starts and completes, e.g. to balance by the in-flight calls.
+type SelectorFeedback interface {
+	// CallStarted is called before the call on the Invoker.
+	CallStarted(Invoker)
+	// CallDone is called after the call on the Invoker completes, rpcErr is nil on success.
+	CallDone(inv Invoker, rpcErr *common.RPCError)
+}
+
+// SelectorMetadataFeedback can be implemented by a SelectorFeedback to be told the metadata
+// of the responses too, e.g. the load the backends report (see common.LoadKey).
+type SelectorMetadataFeedback interface {
+	SelectorFeedback
+	// CallDoneWithMetadata is called in place of CallDone with the metadata of the latest response
+	// of the Invoker, nil if none.
+	CallDoneWithMetadata(inv Invoker, rpcErr *common.RPCError, metadata map[string]string)
+}
+
+// BackendState is the state of a backend in the view of a Selector.
+type BackendState struct {
+	Address string
+	Healthy bool
+	// EjectedUntil is the time the ejection of the backend expires, zero if it is not ejected.
+	EjectedUntil time.Time
+	// Errors is the number of the recent failed calls on the backend.
+	Errors int
 }
 
 // NewInvokerFunc the function to create a new Invoker.
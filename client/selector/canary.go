@@ -0,0 +1,130 @@
+package selector
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/client"
+)
+
+// CanarySelector splits the calls of each service path between the stable and the canary
+// backends by a percentage, e.g. 5% to the canary during a rollout. A canary call falls back
+// to the stable backends if no canary is available.
+// The selections without the service method, e.g. by client.Go, go to the stable backends.
+type CanarySelector struct {
+	Stable client.Selector
+	Canary client.Selector
+	// HashKey returns the key of the call to hash instead of choosing the side at random,
+	// so that the calls of a key, e.g. of a user, stick to the same side.
+	HashKey func(path string, args interface{}) string
+	// Rand returns a number in [0, 1) choosing the side of a call without HashKey,
+	// rand.Float64 if nil.
+	Rand     func() float64
+	percents map[string]float64 // service path -> canary percentage
+	lock     sync.RWMutex
+}
+
+var _ client.Selector = new(CanarySelector)
+
+// NewCanarySelector creates a CanarySelector sending no call to the canary until SetPercent.
+func NewCanarySelector(stable, canary client.Selector) *CanarySelector {
+	return &CanarySelector{Stable: stable, Canary: canary}
+}
+
+// SetPercent sets the percentage of the calls of the service path sent to the canary, from 0 to 100.
+// The empty path sets the percentage of the paths without their own. It can be called at runtime.
+func (s *CanarySelector) SetPercent(path string, percent float64) *CanarySelector {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.percents == nil {
+		s.percents = make(map[string]float64)
+	}
+	s.percents[path] = percent
+	return s
+}
+
+// Percent returns the percentage of the calls of the service path sent to the canary.
+func (s *CanarySelector) Percent(path string) float64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if percent, ok := s.percents[path]; ok {
+		return percent
+	}
+	return s.percents[""]
+}
+
+func (s *CanarySelector) sides() router {
+	return router{s.Stable, s.Canary}
+}
+
+//SetNewInvokerFunc sets the NewInvokerFunc of the stable and the canary backends.
+func (s *CanarySelector) SetNewInvokerFunc(newInvokerFunc client.NewInvokerFunc) {
+	s.sides().SetNewInvokerFunc(newInvokerFunc)
+}
+
+//SetSelectMode sets the SelectMode of the stable and the canary backends.
+func (s *CanarySelector) SetSelectMode(selectMode client.SelectMode) {
+	s.sides().SetSelectMode(selectMode)
+}
+
+//toCanary returns whether the call of the select options (serviceMethod, args) goes to the canary.
+func (s *CanarySelector) toCanary(options []interface{}) bool {
+	if len(options) < 1 {
+		return false
+	}
+	path, ok := options[0].(string)
+	if !ok {
+		return false
+	}
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	percent := s.Percent(path)
+	if percent <= 0 {
+		return false
+	}
+	if percent >= 100 {
+		return true
+	}
+	if s.HashKey == nil {
+		random := rand.Float64
+		if s.Rand != nil {
+			random = s.Rand
+		}
+		return random()*100 < percent
+	}
+	var args interface{}
+	if len(options) > 1 {
+		args = options[1]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(s.HashKey(path, args)))
+	return float64(h.Sum32()%10000) < percent*100
+}
+
+//Select returns a canary invoker for the share of the canary if available, a stable invoker otherwise.
+func (s *CanarySelector) Select(options ...interface{}) (client.Invoker, error) {
+	if s.toCanary(options) {
+		if invoker, err := s.Canary.Select(options...); err == nil && invoker != nil {
+			return invoker, nil
+		}
+	}
+	return s.Stable.Select(options...)
+}
+
+//List returns Invokers of the stable and the canary backends.
+func (s *CanarySelector) List() []client.Invoker {
+	return s.sides().List()
+}
+
+//HandleFailed passes the failed Invoker to the selector it comes from.
+func (s *CanarySelector) HandleFailed(invoker client.Invoker) {
+	s.sides().HandleFailed(invoker)
+}
+
+//Debug returns the state of the stable and the canary backends.
+func (s *CanarySelector) Debug() []client.BackendState {
+	return s.sides().Debug()
+}
@@ -0,0 +1,82 @@
+package selector
+
+import (
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+)
+
+// Alias is a second service path of the worker.
+func (w *worker) Alias(arg string, reply *string) error {
+	return w.Name(arg, reply)
+}
+
+func TestCanarySelector(t *testing.T) {
+	stableAddr, canaryAddr := freeAddr(t), freeAddr(t)
+	serve(t, "stable", stableAddr)
+	serve(t, "canary", canaryAddr)
+
+	s := NewCanarySelector(
+		&DirectSelector{Network: "tcp", Address: stableAddr},
+		&DirectSelector{Network: "tcp", Address: canaryAddr},
+	).SetPercent("/worker/name", 10)
+	var n int
+	s.Rand = func() float64 { // 0, 0.05, ..., 0.95, 0, ...
+		n++
+		return float64(n%20) / 20
+	}
+	c := client.NewClient(client.Client{}, s)
+	defer c.Close()
+
+	count := func(path string, n int) (canary int) {
+		var reply string
+		for i := 0; i < n; i++ {
+			if e := c.Call(path, "", &reply); e != nil {
+				t.Fatal(e.Error)
+			}
+			if reply == "canary" {
+				canary++
+			}
+		}
+		return
+	}
+	if canary := count("/worker/name", 200); canary != 20 {
+		t.Fatalf("canary calls = %d of 200, want 10%%", canary)
+	}
+	if canary := count("/worker/alias", 200); canary != 0 {
+		t.Fatalf("canary calls of the other path = %d, want 0", canary)
+	}
+
+	// adjusted at runtime
+	s.SetPercent("", 100)
+	if canary := count("/worker/alias", 50); canary != 50 {
+		t.Fatalf("canary calls = %d of 50, want all", canary)
+	}
+}
+
+func TestCanarySelectorHash(t *testing.T) {
+	stableAddr, canaryAddr := freeAddr(t), freeAddr(t)
+	serve(t, "stable", stableAddr)
+	serve(t, "canary", canaryAddr)
+
+	s := NewCanarySelector(
+		&DirectSelector{Network: "tcp", Address: stableAddr},
+		&DirectSelector{Network: "tcp", Address: canaryAddr},
+	).SetPercent("", 50)
+	s.HashKey = func(path string, args interface{}) string { return args.(string) }
+	c := client.NewClient(client.Client{}, s)
+	defer c.Close()
+
+	sides := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		user := string(rune('a' + i%10))
+		var reply string
+		if e := c.Call("/worker/name", user, &reply); e != nil {
+			t.Fatal(e.Error)
+		}
+		if side, ok := sides[user]; ok && side != (reply == "canary") {
+			t.Fatalf("the calls of %s switched sides", user)
+		}
+		sides[user] = reply == "canary"
+	}
+}
@@ -1,6 +1,8 @@
 package selector
 
 import (
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/henrylee2cn/myrpc/client"
@@ -9,35 +11,82 @@ import (
 // DirectSelector is used to a direct rpc server.
 // It don't select a node from service cluster but a specific rpc server.
 type DirectSelector struct {
-	Network        string
-	Address        string
-	DialTimeout    time.Duration
-	newInvokerFunc client.NewInvokerFunc
-	invoker        client.Invoker
+	Network     string
+	Address     string
+	DialTimeout time.Duration
+	//ReconnectJitter spreads the reconnection after a failure randomly over the window,
+	//so that the clients don't stampede the recovered server, zero means reconnecting immediately.
+	ReconnectJitter time.Duration
+	newInvokerFunc  client.NewInvokerFunc
+	invoker         client.Invoker
+	reconnectAt     time.Time
+	failures        int       // since the last connection
+	dialing         *dialCall // the redial in progress, if any
+	lock            sync.Mutex
+}
+
+// dialCall is a redial in progress, whose result is shared by the callers waiting for it.
+type dialCall struct {
+	done    chan struct{}
+	invoker client.Invoker
+	err     error
 }
 
 var _ client.Selector = new(DirectSelector)
 
 //SetNewInvokerFunc sets the NewInvokerFunc.
 func (s *DirectSelector) SetNewInvokerFunc(newInvokerFunc client.NewInvokerFunc) {
+	s.lock.Lock()
 	s.newInvokerFunc = newInvokerFunc
+	s.lock.Unlock()
 }
 
 //SetSelectMode is meaningless for DirectSelector because there is only one invoker.
 func (s *DirectSelector) SetSelectMode(_ client.SelectMode) {}
 
 //Select returns a rpc invoker.
+//Only one caller redials, waiting out the reconnect jitter without the lock held,
+//the callers selecting meanwhile share its result.
 func (s *DirectSelector) Select(options ...interface{}) (client.Invoker, error) {
+	s.lock.Lock()
 	if s.invoker != nil {
-		return s.invoker, nil
+		invoker := s.invoker
+		s.lock.Unlock()
+		return invoker, nil
+	}
+	if d := s.dialing; d != nil {
+		s.lock.Unlock()
+		<-d.done
+		return d.invoker, d.err
+	}
+	d := &dialCall{done: make(chan struct{})}
+	s.dialing = d
+	wait := time.Until(s.reconnectAt)
+	newInvokerFunc := s.newInvokerFunc
+	s.lock.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	d.invoker, d.err = newInvokerFunc(s.Network, s.Address, s.DialTimeout)
+
+	s.lock.Lock()
+	s.dialing = nil
+	if d.err != nil {
+		s.failures++
+	} else {
+		s.invoker = d.invoker
+		s.failures = 0
 	}
-	c, err := s.newInvokerFunc(s.Network, s.Address, s.DialTimeout)
-	s.invoker = c
-	return c, err
+	s.lock.Unlock()
+	close(d.done)
+	return d.invoker, d.err
 }
 
 //List returns Invokers to all servers
 func (s *DirectSelector) List() []client.Invoker {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	if s.invoker == nil {
 		return []client.Invoker{}
 	}
@@ -47,5 +96,35 @@ func (s *DirectSelector) List() []client.Invoker {
 //HandleFailed handle failed Invoker
 func (s *DirectSelector) HandleFailed(invoker client.Invoker) {
 	invoker.Close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.invoker != invoker {
+		return // already replaced
+	}
 	s.invoker = nil // reset
+	s.reconnectAt = reconnectTime(s.ReconnectJitter)
+	s.failures++
+}
+
+//Debug returns the state of the server, which is unhealthy after a failure until it is reconnected.
+func (s *DirectSelector) Debug() []client.BackendState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state := client.BackendState{
+		Address: s.Address,
+		Healthy: s.failures == 0,
+		Errors:  s.failures,
+	}
+	if s.reconnectAt.After(time.Now()) {
+		state.EjectedUntil = s.reconnectAt
+	}
+	return []client.BackendState{state}
+}
+
+//reconnectTime returns a random time within the jitter window from now.
+func reconnectTime(jitter time.Duration) time.Time {
+	if jitter <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(rand.Int63n(int64(jitter))))
 }
@@ -0,0 +1,96 @@
+package selector
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+)
+
+type nopInvoker struct {
+	client.Invoker
+}
+
+func (nopInvoker) Close() error { return nil }
+
+func TestReconnectJitter(t *testing.T) {
+	const (
+		n      = 100
+		window = time.Second
+	)
+	start := time.Now()
+	min, max := window, time.Duration(0)
+	for i := 0; i < n; i++ {
+		s := &DirectSelector{ReconnectJitter: window, invoker: nopInvoker{}}
+		s.HandleFailed(s.invoker)
+		d := s.reconnectAt.Sub(start)
+		if d < 0 || d > window+100*time.Millisecond {
+			t.Fatalf("reconnect delay %v is out of the window %v", d, window)
+		}
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+	if max-min < window/2 {
+		t.Fatalf("reconnect delays are clustered in [%v, %v]", min, max)
+	}
+
+	// the reconnection waits for the delay.
+	s := &DirectSelector{
+		ReconnectJitter: 100 * time.Millisecond,
+		invoker:         nopInvoker{},
+		newInvokerFunc: func(network, address string, dialTimeout time.Duration) (client.Invoker, error) {
+			return nopInvoker{}, nil
+		},
+	}
+	s.HandleFailed(s.invoker)
+	at := s.reconnectAt
+	s.Select()
+	if time.Now().Before(at) {
+		t.Fatal("reconnected before the delay")
+	}
+}
+
+func TestSelectDialsOnce(t *testing.T) {
+	var dials int32
+	started, release := make(chan struct{}), make(chan struct{})
+	s := &DirectSelector{
+		invoker: nopInvoker{},
+		newInvokerFunc: func(network, address string, dialTimeout time.Duration) (client.Invoker, error) {
+			if atomic.AddInt32(&dials, 1) == 1 {
+				close(started)
+			}
+			<-release
+			return nopInvoker{}, nil
+		},
+	}
+	s.HandleFailed(s.invoker)
+
+	const n = 20
+	var wg, entering sync.WaitGroup
+	wg.Add(n)
+	entering.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			entering.Done()
+			if invoker, err := s.Select(); err != nil || invoker == nil {
+				t.Errorf("expect the redialed invoker, got: %v, %v", invoker, err)
+			}
+			s.Debug()
+		}()
+	}
+	// the callers selecting while the dial is held wait for it.
+	<-started
+	entering.Wait()
+	close(release)
+	wg.Wait()
+	if d := atomic.LoadInt32(&dials); d != 1 {
+		t.Fatalf("expect 1 dial for %d concurrent callers, got %d", n, d)
+	}
+}
@@ -0,0 +1,184 @@
+package selector
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// LeastRequestSelector selects the server with the fewest in-flight calls, the ties are broken randomly,
+// which balances the load better than round robin when the costs of the requests vary.
+// The in-flight calls are counted by the client through client.SelectorFeedback.
+type LeastRequestSelector struct {
+	Network     string
+	Servers     []string
+	DialTimeout time.Duration
+	// EjectTime ejects a failed server from the selection for the duration, unless all servers
+	// are ejected, zero means the failed server is reconnected on the next selection.
+	EjectTime time.Duration
+
+	newInvokerFunc client.NewInvokerFunc
+	backends       []*leastRequestBackend
+	owners         map[client.Invoker]*leastRequestBackend
+	lock           sync.Mutex
+}
+
+type leastRequestBackend struct {
+	address      string
+	invoker      client.Invoker
+	active       int
+	errors       int // since the last successful call
+	ejectedUntil time.Time
+}
+
+var (
+	_ client.Selector         = new(LeastRequestSelector)
+	_ client.SelectorFeedback = new(LeastRequestSelector)
+)
+
+// NewLeastRequestSelector creates a LeastRequestSelector of the servers.
+func NewLeastRequestSelector(network string, servers []string, dialTimeout time.Duration) *LeastRequestSelector {
+	return &LeastRequestSelector{
+		Network:     network,
+		Servers:     servers,
+		DialTimeout: dialTimeout,
+	}
+}
+
+//SetNewInvokerFunc sets the NewInvokerFunc.
+func (s *LeastRequestSelector) SetNewInvokerFunc(newInvokerFunc client.NewInvokerFunc) {
+	s.newInvokerFunc = newInvokerFunc
+}
+
+//SetSelectMode is meaningless for LeastRequestSelector because it always selects by the in-flight calls.
+func (s *LeastRequestSelector) SetSelectMode(_ client.SelectMode) {}
+
+//Select returns the invoker of the server with the fewest in-flight calls.
+func (s *LeastRequestSelector) Select(options ...interface{}) (client.Invoker, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.backends == nil {
+		s.owners = make(map[client.Invoker]*leastRequestBackend)
+		for _, address := range s.Servers {
+			s.backends = append(s.backends, &leastRequestBackend{address: address})
+		}
+	}
+	if len(s.backends) == 0 {
+		return nil, errors.New("rpc: no server to select")
+	}
+	now := time.Now()
+	candidates := make([]*leastRequestBackend, 0, len(s.backends))
+	for _, b := range s.backends {
+		if !b.ejectedUntil.After(now) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = s.backends
+	}
+	var least []*leastRequestBackend
+	for _, b := range candidates {
+		if len(least) == 0 || b.active < least[0].active {
+			least = append(least[:0], b)
+		} else if b.active == least[0].active {
+			least = append(least, b)
+		}
+	}
+	b := least[rand.Intn(len(least))]
+	if b.invoker == nil {
+		invoker, err := s.newInvokerFunc(s.Network, b.address, s.DialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		b.invoker = invoker
+		s.owners[invoker] = b
+	}
+	return b.invoker, nil
+}
+
+//CallStarted counts the in-flight call of the invoker.
+func (s *LeastRequestSelector) CallStarted(invoker client.Invoker) {
+	s.lock.Lock()
+	if b, ok := s.owners[invoker]; ok {
+		b.active++
+	}
+	s.lock.Unlock()
+}
+
+//CallDone uncounts the completed call of the invoker.
+func (s *LeastRequestSelector) CallDone(invoker client.Invoker, rpcErr *common.RPCError) {
+	s.lock.Lock()
+	if b, ok := s.owners[invoker]; ok {
+		if b.active > 0 {
+			b.active--
+		}
+		if rpcErr != nil {
+			b.errors++
+		} else {
+			b.errors = 0
+		}
+	}
+	s.lock.Unlock()
+}
+
+//List returns the connected invokers.
+func (s *LeastRequestSelector) List() []client.Invoker {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var invokers []client.Invoker
+	for _, b := range s.backends {
+		if b.invoker != nil {
+			invokers = append(invokers, b.invoker)
+		}
+	}
+	return invokers
+}
+
+//HandleFailed closes the failed invoker and ejects its server for the EjectTime,
+//the server is reconnected on the next selection of it.
+func (s *LeastRequestSelector) HandleFailed(invoker client.Invoker) {
+	invoker.Close()
+	s.lock.Lock()
+	if b, ok := s.owners[invoker]; ok {
+		delete(s.owners, invoker)
+		if b.invoker == invoker {
+			b.invoker = nil
+			b.active = 0
+			if s.EjectTime > 0 {
+				b.ejectedUntil = time.Now().Add(s.EjectTime)
+			}
+		}
+	}
+	s.lock.Unlock()
+}
+
+//Debug returns the state of the servers, an ejected server is unhealthy until the ejection expires.
+func (s *LeastRequestSelector) Debug() []client.BackendState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	states := make([]client.BackendState, 0, len(s.Servers))
+	if s.backends == nil {
+		for _, address := range s.Servers {
+			states = append(states, client.BackendState{Address: address, Healthy: true})
+		}
+		return states
+	}
+	for _, b := range s.backends {
+		state := client.BackendState{
+			Address: b.address,
+			Healthy: true,
+			Errors:  b.errors,
+		}
+		if b.ejectedUntil.After(now) {
+			state.Healthy = false
+			state.EjectedUntil = b.ejectedUntil
+		}
+		states = append(states, state)
+	}
+	return states
+}
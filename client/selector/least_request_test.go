@@ -0,0 +1,127 @@
+package selector
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// slowWorker holds the calls until released, telling when each starts.
+type slowWorker struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *slowWorker) Name(arg string, reply *string) error {
+	w.started <- struct{}{}
+	<-w.release
+	*reply = "slow"
+	return nil
+}
+
+func TestLeastRequestSelector(t *testing.T) {
+	slowAddr, fastAddr1, fastAddr2 := freeAddr(t), freeAddr(t), freeAddr(t)
+	slow := &slowWorker{started: make(chan struct{}), release: make(chan struct{})}
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", slow)
+	lis, err := net.Listen("tcp", slowAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	serve(t, "fast1", fastAddr1)
+	serve(t, "fast2", fastAddr2)
+
+	s := NewLeastRequestSelector("tcp", []string{slowAddr, fastAddr1, fastAddr2}, 0)
+	c := client.NewClient(client.Client{}, s)
+	defer c.Close()
+
+	// every call completes or is held by the slow server before the next one,
+	// so the held calls are in flight when the next one selects.
+	const n = 30
+	var (
+		wg     sync.WaitGroup
+		counts = make(map[string]int)
+		held   int
+	)
+	for i := 0; i < n; i++ {
+		done := make(chan string, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply string
+			if e := c.Call("/worker/name", "", &reply); e != nil {
+				t.Error(e.Error)
+			}
+			done <- reply
+		}()
+		select {
+		case reply := <-done:
+			counts[reply]++
+		case <-slow.started:
+			held++
+		}
+	}
+	close(slow.release)
+	wg.Wait()
+
+	if held > 1 || counts["fast1"]+counts["fast2"] != n-held {
+		t.Fatalf("expect the calls to favor the idle servers, got %d held by the slow server and %v", held, counts)
+	}
+}
+
+type failingWorker struct{}
+
+func (*failingWorker) Name(arg string, reply *string) error {
+	return errors.New("failing")
+}
+
+func TestLeastRequestEjection(t *testing.T) {
+	goodAddr, badAddr := freeAddr(t), freeAddr(t)
+	serve(t, "good", goodAddr)
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", new(failingWorker))
+	lis, err := net.Listen("tcp", badAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+
+	s := NewLeastRequestSelector("tcp", []string{goodAddr, badAddr}, 0)
+	s.EjectTime = time.Minute
+	c := client.NewClient(client.Client{}, s)
+	defer c.Close()
+
+	for _, state := range c.SelectorDebug() {
+		if !state.Healthy {
+			t.Fatalf("expect all backends healthy before the calls, got %+v", state)
+		}
+	}
+	var bad client.BackendState
+	for i := 0; i < 20 && bad.EjectedUntil.IsZero(); i++ {
+		var reply string
+		c.Call("/worker/name", "", &reply)
+		for _, state := range c.SelectorDebug() {
+			if state.Address == badAddr {
+				bad = state
+			}
+		}
+	}
+	if bad.Healthy || bad.Errors == 0 {
+		t.Fatalf("expect the failing backend ejected, got %+v", bad)
+	}
+	if d := time.Until(bad.EjectedUntil); d <= 0 || d > time.Minute {
+		t.Fatalf("expect the ejection to expire within the EjectTime, got %v", d)
+	}
+	for i := 0; i < 5; i++ {
+		var reply string
+		if e := c.Call("/worker/name", "", &reply); e != nil || reply != "good" {
+			t.Fatalf("expect the calls to avoid the ejected backend, got %q, %v", reply, e)
+		}
+	}
+}
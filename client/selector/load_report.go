@@ -0,0 +1,218 @@
+package selector
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// DefaultLoadHalfLife is the default HalfLife of LoadReportSelector.
+const DefaultLoadHalfLife = 10 * time.Second
+
+// LoadReportSelector selects the servers randomly, weighted by the load they report in the metadata
+// of the responses (see server.Server.LoadReporter), the weight of a server is 1/(1+load).
+// The reports are told by the client through client.SelectorMetadataFeedback, a server without
+// a report counts as idle.
+type LoadReportSelector struct {
+	Network     string
+	Servers     []string
+	DialTimeout time.Duration
+	// HalfLife halves the reported load every its duration since the report, so that a server
+	// reporting high load regains the traffic when it is no longer selected to report again.
+	// Zero means DefaultLoadHalfLife.
+	HalfLife time.Duration
+
+	newInvokerFunc client.NewInvokerFunc
+	backends       []*loadReportBackend
+	owners         map[client.Invoker]*loadReportBackend
+	lock           sync.Mutex
+}
+
+type loadReportBackend struct {
+	address  string
+	invoker  client.Invoker
+	load     float64
+	reported time.Time
+	errors   int // since the last successful call
+}
+
+var (
+	_ client.Selector                 = new(LoadReportSelector)
+	_ client.SelectorMetadataFeedback = new(LoadReportSelector)
+)
+
+// NewLoadReportSelector creates a LoadReportSelector of the servers.
+func NewLoadReportSelector(network string, servers []string, dialTimeout time.Duration) *LoadReportSelector {
+	return &LoadReportSelector{
+		Network:     network,
+		Servers:     servers,
+		DialTimeout: dialTimeout,
+	}
+}
+
+//SetNewInvokerFunc sets the NewInvokerFunc.
+func (s *LoadReportSelector) SetNewInvokerFunc(newInvokerFunc client.NewInvokerFunc) {
+	s.newInvokerFunc = newInvokerFunc
+}
+
+//SetSelectMode is meaningless for LoadReportSelector because it always selects by the reported load.
+func (s *LoadReportSelector) SetSelectMode(_ client.SelectMode) {}
+
+//Select returns the invoker of a server chosen randomly by the weights of the reported load.
+func (s *LoadReportSelector) Select(options ...interface{}) (client.Invoker, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.backends == nil {
+		s.owners = make(map[client.Invoker]*loadReportBackend)
+		for _, address := range s.Servers {
+			s.backends = append(s.backends, &loadReportBackend{address: address})
+		}
+	}
+	if len(s.backends) == 0 {
+		return nil, errors.New("rpc: no server to select")
+	}
+	now := time.Now()
+	weights := make([]float64, len(s.backends))
+	var total float64
+	for i, b := range s.backends {
+		weights[i] = 1 / (1 + s.decayedLoad(b, now))
+		total += weights[i]
+	}
+	b := s.backends[len(s.backends)-1]
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			b = s.backends[i]
+			break
+		}
+		r -= w
+	}
+	if b.invoker != nil {
+		return b.invoker, nil
+	}
+	return s.dial(b)
+}
+
+// dial connects the backend without the lock held, so that a slow server doesn't block
+// the selections of the others. It is called and returns with the lock held.
+func (s *LoadReportSelector) dial(b *loadReportBackend) (client.Invoker, error) {
+	s.lock.Unlock()
+	invoker, err := s.newInvokerFunc(s.Network, b.address, s.DialTimeout)
+	s.lock.Lock()
+	if err != nil {
+		b.errors++
+		return nil, err
+	}
+	if b.invoker != nil {
+		// connected by a concurrent selection meanwhile.
+		invoker.Close()
+		return b.invoker, nil
+	}
+	b.invoker = invoker
+	s.owners[invoker] = b
+	return invoker, nil
+}
+
+// decayedLoad returns the reported load of the backend halved every HalfLife since the report.
+func (s *LoadReportSelector) decayedLoad(b *loadReportBackend, now time.Time) float64 {
+	if b.load == 0 {
+		return 0
+	}
+	halfLife := s.HalfLife
+	if halfLife <= 0 {
+		halfLife = DefaultLoadHalfLife
+	}
+	return b.load * math.Pow(0.5, float64(now.Sub(b.reported))/float64(halfLife))
+}
+
+// Load returns the load the server reported, decayed since the report.
+func (s *LoadReportSelector) Load(address string) float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for _, b := range s.backends {
+		if b.address == address {
+			return s.decayedLoad(b, now)
+		}
+	}
+	return 0
+}
+
+//CallStarted is meaningless for LoadReportSelector because the servers report their load.
+func (s *LoadReportSelector) CallStarted(_ client.Invoker) {}
+
+//CallDone counts the failed calls of the invoker, a server is unhealthy since a failure until a successful call.
+func (s *LoadReportSelector) CallDone(invoker client.Invoker, rpcErr *common.RPCError) {
+	s.CallDoneWithMetadata(invoker, rpcErr, nil)
+}
+
+//CallDoneWithMetadata records the load reported in the metadata, and counts the failed calls of the invoker.
+func (s *LoadReportSelector) CallDoneWithMetadata(invoker client.Invoker, rpcErr *common.RPCError, metadata map[string]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	b, ok := s.owners[invoker]
+	if !ok {
+		return
+	}
+	if rpcErr != nil {
+		b.errors++
+	} else {
+		b.errors = 0
+	}
+	if load, ok := common.ParseLoad(metadata[common.LoadKey]); ok {
+		b.load = load
+		b.reported = time.Now()
+	}
+}
+
+//List returns the connected invokers.
+func (s *LoadReportSelector) List() []client.Invoker {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var invokers []client.Invoker
+	for _, b := range s.backends {
+		if b.invoker != nil {
+			invokers = append(invokers, b.invoker)
+		}
+	}
+	return invokers
+}
+
+//HandleFailed closes the failed invoker, the server is reconnected on the next selection of it.
+func (s *LoadReportSelector) HandleFailed(invoker client.Invoker) {
+	invoker.Close()
+	s.lock.Lock()
+	if b, ok := s.owners[invoker]; ok {
+		delete(s.owners, invoker)
+		if b.invoker == invoker {
+			b.invoker = nil
+		}
+	}
+	s.lock.Unlock()
+}
+
+//Debug returns the state of the servers.
+func (s *LoadReportSelector) Debug() []client.BackendState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	states := make([]client.BackendState, 0, len(s.Servers))
+	if s.backends == nil {
+		for _, address := range s.Servers {
+			states = append(states, client.BackendState{Address: address, Healthy: true})
+		}
+		return states
+	}
+	for _, b := range s.backends {
+		states = append(states, client.BackendState{
+			Address: b.address,
+			Healthy: b.errors == 0,
+			Errors:  b.errors,
+		})
+	}
+	return states
+}
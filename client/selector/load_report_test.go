@@ -0,0 +1,148 @@
+package selector
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+func TestLoadReportSelector(t *testing.T) {
+	busyAddr, idleAddr1, idleAddr2 := freeAddr(t), freeAddr(t), freeAddr(t)
+	srv := server.NewServer(server.Server{
+		LoadReporter: func() float64 { return 100 },
+	})
+	srv.NamedRegister("worker", &worker{name: "busy"})
+	lis, err := net.Listen("tcp", busyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	serve(t, "idle1", idleAddr1)
+	serve(t, "idle2", idleAddr2)
+
+	s := NewLoadReportSelector("tcp", []string{busyAddr, idleAddr1, idleAddr2}, 0)
+	c := client.NewClient(client.Client{}, s)
+	defer c.Close()
+
+	call := func() string {
+		var reply string
+		if e := c.Call("/worker/name", "", &reply); e != nil {
+			t.Fatal(e.Error)
+		}
+		return reply
+	}
+	// no report until the busy server is selected once
+	for i := 0; i < 100 && s.Load(busyAddr) == 0; i++ {
+		call()
+	}
+	if load := s.Load(busyAddr); load < 99 || load > 100 {
+		t.Fatalf("expect the reported load of the busy server, got %v", load)
+	}
+
+	const n = 200
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[call()]++
+	}
+	if counts["busy"] > 10 || counts["idle1"] < n/4 || counts["idle2"] < n/4 {
+		t.Fatalf("expect the calls to shift to the idle servers, got: %v", counts)
+	}
+}
+
+func TestLoadReportDecay(t *testing.T) {
+	addr := freeAddr(t)
+	srv := server.NewServer(server.Server{
+		LoadReporter: func() float64 { return 8 },
+	})
+	srv.NamedRegister("worker", &worker{name: "busy"})
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+
+	s := NewLoadReportSelector("tcp", []string{addr}, 0)
+	s.HalfLife = 50 * time.Millisecond
+	c := client.NewClient(client.Client{}, s)
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/worker/name", "", &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	if load := s.Load(addr); load < 4 {
+		t.Fatalf("expect the fresh report, got %v", load)
+	}
+	// the stale report decays without a new one
+	deadline := time.Now().Add(2 * time.Second)
+	for load := s.Load(addr); load > 0.5; load = s.Load(addr) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the stale report to decay, got %v", load)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoadReportQueryKey(t *testing.T) {
+	addr := freeAddr(t)
+	srv := server.NewServer(server.Server{
+		LoadReporter: func() float64 { return 8 },
+	})
+	srv.NamedRegister("worker", &worker{name: "busy"})
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+
+	s := NewLoadReportSelector("tcp", []string{addr}, 0)
+	c := client.NewClient(client.Client{}, s)
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/worker/name?_load=0", "", &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	if load := s.Load(addr); load < 4 {
+		t.Fatalf("expect the report of the server, not the query of the request, got %v", load)
+	}
+}
+
+func TestLoadReportDial(t *testing.T) {
+	slowAddr, deadAddr := freeAddr(t), freeAddr(t)
+	s := NewLoadReportSelector("tcp", []string{slowAddr}, 0)
+	dialing, release := make(chan struct{}), make(chan struct{})
+	s.SetNewInvokerFunc(func(network, address string, dialTimeout time.Duration) (client.Invoker, error) {
+		close(dialing)
+		<-release
+		return nil, errors.New("unreachable")
+	})
+	go s.Select("/worker/name", "")
+	<-dialing
+	loaded := make(chan struct{})
+	go func() {
+		s.Load(slowAddr)
+		close(loaded)
+	}()
+	select {
+	case <-loaded:
+	case <-time.After(time.Second):
+		t.Fatal("the dial blocks the selector")
+	}
+	close(release)
+
+	s = NewLoadReportSelector("tcp", []string{deadAddr}, time.Second)
+	c := client.NewClient(client.Client{}, s)
+	defer c.Close()
+	var reply string
+	if e := c.Call("/worker/name", "", &reply); e == nil {
+		t.Fatal("expect the call to the dead server failing")
+	}
+	if states := s.Debug(); len(states) != 1 || states[0].Healthy {
+		t.Fatalf("expect the dead server unhealthy, got %+v", states)
+	}
+}
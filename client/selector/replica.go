@@ -0,0 +1,156 @@
+package selector
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+)
+
+const (
+	// DefaultIdempotentPath is the service path of reflection.Service.IdempotentPaths
+	// registered as "reflection", listing the service paths declared idempotent.
+	DefaultIdempotentPath = "/reflection/idempotent_paths"
+	// DefaultRefreshInterval is the interval of fetching the idempotent service paths again.
+	DefaultRefreshInterval = time.Minute
+)
+
+// ReplicaSelector routes the read-only calls to the read replicas and the other calls
+// to the primary. A read-only call fails over to the primary if no replica is available,
+// a write never goes to a replica.
+//
+// By default the calls of the service paths whose receivers declare them idempotent,
+// see server.Idempotency, are read-only. The primary lists them by the reflection service:
+//
+//	srv.NamedRegister("reflection", reflection.NewService(srv))
+//
+// Until they are fetched, or if the primary doesn't list them, all the calls go to the primary.
+// The selections without the service method, e.g. by client.Go, go to the primary.
+type ReplicaSelector struct {
+	Primary client.Selector
+	Replica client.Selector
+	// ReadOnly returns whether the call of the service path with the args is read-only,
+	// if nil the call is read-only if the primary declares the service path idempotent.
+	ReadOnly func(path string, args interface{}) bool
+	// IdempotentPath is the service path listing the idempotent service paths,
+	// DefaultIdempotentPath if empty.
+	IdempotentPath string
+	// RefreshInterval is the interval of fetching the idempotent service paths again,
+	// DefaultRefreshInterval if zero.
+	RefreshInterval time.Duration
+	idempotent      map[string]bool
+	expires         time.Time
+	fetching        bool
+	lock            sync.Mutex
+}
+
+var _ client.Selector = new(ReplicaSelector)
+
+// NewReplicaSelector creates a ReplicaSelector classifying the calls by the idempotency
+// declared by the primary.
+func NewReplicaSelector(primary, replica client.Selector) *ReplicaSelector {
+	return &ReplicaSelector{Primary: primary, Replica: replica}
+}
+
+func (s *ReplicaSelector) pools() router {
+	return router{s.Primary, s.Replica}
+}
+
+//SetNewInvokerFunc sets the NewInvokerFunc of the primary and the replicas.
+func (s *ReplicaSelector) SetNewInvokerFunc(newInvokerFunc client.NewInvokerFunc) {
+	s.pools().SetNewInvokerFunc(newInvokerFunc)
+}
+
+//SetSelectMode sets the SelectMode of the primary and the replicas.
+func (s *ReplicaSelector) SetSelectMode(selectMode client.SelectMode) {
+	s.pools().SetSelectMode(selectMode)
+}
+
+//readOnly returns whether the call of the select options (serviceMethod, args) is read-only.
+func (s *ReplicaSelector) readOnly(options []interface{}) bool {
+	if len(options) < 2 {
+		return false
+	}
+	path, ok := options[0].(string)
+	if !ok {
+		return false
+	}
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	if s.ReadOnly != nil {
+		return s.ReadOnly(path, options[1])
+	}
+	return s.isIdempotent(path)
+}
+
+//isIdempotent returns whether the primary declares the service path idempotent,
+//fetching the idempotent service paths if they have expired.
+func (s *ReplicaSelector) isIdempotent(path string) bool {
+	s.lock.Lock()
+	fetch := !s.fetching && !time.Now().Before(s.expires)
+	if fetch {
+		s.fetching = true
+	}
+	idempotent := s.idempotent
+	s.lock.Unlock()
+	if fetch {
+		idempotent = s.fetchIdempotent()
+	}
+	return idempotent[path]
+}
+
+//fetchIdempotent fetches the idempotent service paths from the primary, keeping the
+//previous ones if it fails.
+func (s *ReplicaSelector) fetchIdempotent() map[string]bool {
+	listPath := s.IdempotentPath
+	if listPath == "" {
+		listPath = DefaultIdempotentPath
+	}
+	var paths []string
+	fetched := false
+	if invoker, err := s.Primary.Select(listPath, ""); err == nil && invoker != nil {
+		fetched = invoker.Call(listPath, "", &paths) == nil
+	}
+	interval := s.RefreshInterval
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fetching = false
+	s.expires = time.Now().Add(interval)
+	if fetched {
+		s.idempotent = make(map[string]bool, len(paths))
+		for _, p := range paths {
+			s.idempotent[p] = true
+		}
+	}
+	return s.idempotent
+}
+
+//Select returns a replica invoker for a read-only call if available, a primary invoker otherwise.
+func (s *ReplicaSelector) Select(options ...interface{}) (client.Invoker, error) {
+	if s.readOnly(options) {
+		if invoker, err := s.Replica.Select(options...); err == nil && invoker != nil {
+			return invoker, nil
+		}
+	}
+	return s.Primary.Select(options...)
+}
+
+//List returns Invokers of the primary and the replicas.
+func (s *ReplicaSelector) List() []client.Invoker {
+	return s.pools().List()
+}
+
+//HandleFailed passes the failed Invoker to the selector it comes from.
+func (s *ReplicaSelector) HandleFailed(invoker client.Invoker) {
+	s.pools().HandleFailed(invoker)
+}
+
+//Debug returns the state of the backends of the primary and the replicas.
+func (s *ReplicaSelector) Debug() []client.BackendState {
+	return s.pools().Debug()
+}
@@ -0,0 +1,78 @@
+package selector
+
+import (
+	"net"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/reflection"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// store declares Get idempotent, but not Set.
+type store struct {
+	name string
+}
+
+func (s *store) Get(arg string, reply *string) error {
+	*reply = s.name
+	return nil
+}
+
+func (s *store) Set(arg string, reply *string) error {
+	*reply = s.name
+	return nil
+}
+
+func (*store) Idempotent(method string) bool { return method == "Get" }
+
+func serveStore(t *testing.T, name, address string) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("store", &store{name: name})
+	srv.NamedRegister("reflection", reflection.NewService(srv))
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+}
+
+func TestReplicaSelector(t *testing.T) {
+	primaryAddr, replicaAddr := freeAddr(t), freeAddr(t)
+	serveStore(t, "primary", primaryAddr)
+
+	c := client.NewClient(client.Client{}, NewReplicaSelector(
+		&DirectSelector{Network: "tcp", Address: primaryAddr},
+		&DirectSelector{Network: "tcp", Address: replicaAddr},
+	))
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/store/get", "k", &reply); e != nil || reply != "primary" {
+		t.Fatalf("no replica, expect the read to fail over to primary, got: %q, %v", reply, e)
+	}
+
+	serveStore(t, "replica", replicaAddr)
+	if e := c.Call("/store/get?tenant=a", "k", &reply); e != nil || reply != "replica" {
+		t.Fatalf("expect the read on replica, got: %q, %v", reply, e)
+	}
+	if e := c.Call("/store/set", "k", &reply); e != nil || reply != "primary" {
+		t.Fatalf("expect the write on primary, got: %q, %v", reply, e)
+	}
+
+	// classified by the hook
+	c2 := client.NewClient(client.Client{}, &ReplicaSelector{
+		Primary: &DirectSelector{Network: "tcp", Address: primaryAddr},
+		Replica: &DirectSelector{Network: "tcp", Address: replicaAddr},
+		ReadOnly: func(path string, args interface{}) bool {
+			return path == "/store/set"
+		},
+	})
+	defer c2.Close()
+	if e := c2.Call("/store/set?tenant=a", "k", &reply); e != nil || reply != "replica" {
+		t.Fatalf("expect the read on replica, got: %q, %v", reply, e)
+	}
+	if e := c2.Call("/store/get", "k", &reply); e != nil || reply != "primary" {
+		t.Fatalf("expect the write on primary, got: %q, %v", reply, e)
+	}
+}
@@ -0,0 +1,52 @@
+package selector
+
+import (
+	"github.com/henrylee2cn/myrpc/client"
+)
+
+// router is the selectors a composite selector routes the calls to, e.g. the tiers of
+// TieredSelector. It passes a failed invoker back to the selector listing it, so that
+// the composite selectors keep no state about the invokers they return.
+type router []client.Selector
+
+func (r router) SetNewInvokerFunc(newInvokerFunc client.NewInvokerFunc) {
+	for _, s := range r {
+		s.SetNewInvokerFunc(newInvokerFunc)
+	}
+}
+
+func (r router) SetSelectMode(selectMode client.SelectMode) {
+	for _, s := range r {
+		s.SetSelectMode(selectMode)
+	}
+}
+
+func (r router) List() []client.Invoker {
+	var invokers []client.Invoker
+	for _, s := range r {
+		invokers = append(invokers, s.List()...)
+	}
+	return invokers
+}
+
+// HandleFailed passes the failed invoker to the selector listing it,
+// and closes it if no selector does, e.g. it has been replaced already.
+func (r router) HandleFailed(invoker client.Invoker) {
+	for _, s := range r {
+		for _, listed := range s.List() {
+			if listed == invoker {
+				s.HandleFailed(invoker)
+				return
+			}
+		}
+	}
+	invoker.Close()
+}
+
+func (r router) Debug() []client.BackendState {
+	var states []client.BackendState
+	for _, s := range r {
+		states = append(states, s.Debug()...)
+	}
+	return states
+}
@@ -0,0 +1,63 @@
+package selector
+
+import (
+	"errors"
+
+	"github.com/henrylee2cn/myrpc/client"
+)
+
+// TieredSelector composes an ordered list of selectors, such as primary and secondary pools.
+// It selects from the first tier that has an available invoker, and falls through to
+// the next tier otherwise. Since the tiers are always tried in order, it switches back
+// to the primary tier as soon as the primary tier recovers.
+type TieredSelector struct {
+	Tiers []client.Selector
+}
+
+var _ client.Selector = new(TieredSelector)
+
+// NewTieredSelector creates a TieredSelector, tiers are in order of preference.
+func NewTieredSelector(tiers ...client.Selector) *TieredSelector {
+	return &TieredSelector{Tiers: tiers}
+}
+
+//SetNewInvokerFunc sets the NewInvokerFunc of all tiers.
+func (s *TieredSelector) SetNewInvokerFunc(newInvokerFunc client.NewInvokerFunc) {
+	router(s.Tiers).SetNewInvokerFunc(newInvokerFunc)
+}
+
+//SetSelectMode sets the SelectMode of all tiers.
+func (s *TieredSelector) SetSelectMode(selectMode client.SelectMode) {
+	router(s.Tiers).SetSelectMode(selectMode)
+}
+
+//Select returns a rpc invoker from the first available tier.
+func (s *TieredSelector) Select(options ...interface{}) (client.Invoker, error) {
+	err := errors.New("rpc: no selector tier")
+	for _, tier := range s.Tiers {
+		var invoker client.Invoker
+		invoker, err = tier.Select(options...)
+		if err == nil && invoker != nil {
+			return invoker, nil
+		}
+	}
+	if err == nil {
+		err = errors.New("rpc: no invoker is available in any tier")
+	}
+	return nil, err
+}
+
+//List returns Invokers of all tiers.
+func (s *TieredSelector) List() []client.Invoker {
+	return router(s.Tiers).List()
+}
+
+//HandleFailed passes the failed Invoker to the tier it comes from.
+func (s *TieredSelector) HandleFailed(invoker client.Invoker) {
+	router(s.Tiers).HandleFailed(invoker)
+}
+
+//Debug returns the state of the backends of all tiers.
+func (s *TieredSelector) Debug() []client.BackendState {
+	return router(s.Tiers).Debug()
+}
@@ -0,0 +1,60 @@
+package selector
+
+import (
+	"net"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type worker struct {
+	name string
+}
+
+func (w *worker) Name(arg string, reply *string) error {
+	*reply = w.name
+	return nil
+}
+
+func serve(t *testing.T, name, address string) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", &worker{name: name})
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+}
+
+// freeAddr returns a local address on which nothing is listening.
+func freeAddr(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis.Close()
+	return lis.Addr().String()
+}
+
+func TestTieredSelector(t *testing.T) {
+	primaryAddr, secondaryAddr := freeAddr(t), freeAddr(t)
+	serve(t, "secondary", secondaryAddr)
+
+	c := client.NewClient(client.Client{}, NewTieredSelector(
+		&DirectSelector{Network: "tcp", Address: primaryAddr},
+		&DirectSelector{Network: "tcp", Address: secondaryAddr},
+	))
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/worker/name", "", &reply); e != nil || reply != "secondary" {
+		t.Fatalf("primary is dead, expect secondary, got: %q, %v", reply, e)
+	}
+
+	// the primary recovers.
+	serve(t, "primary", primaryAddr)
+	if e := c.Call("/worker/name", "", &reply); e != nil || reply != "primary" {
+		t.Fatalf("primary recovered, expect primary, got: %q, %v", reply, e)
+	}
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+//RPCErrStreamCancelled is returned by the streamed call canceled by its ctx, see CallStreamToContext.
+//The connection is kept, unlike the timeout.
+var RPCErrStreamCancelled = common.NewRPCError(common.ErrorTypeClientStreamCancelled, "stream cancelled")
+
+type (
+	chunkKey      struct{}
+	retryGuardKey struct{}
+)
+
+//withChunks returns a copy of ctx with the callback receiving the chunks of the streamed reply.
+//The callback runs on the goroutine reading the responses, it must not block for long.
+func withChunks(ctx context.Context, fn func(chunk []byte)) context.Context {
+	return context.WithValue(ctx, chunkKey{}, fn)
+}
+
+//chunkFunc returns the callback set by withChunks, or nil.
+func chunkFunc(ctx context.Context) func([]byte) {
+	fn, _ := ctx.Value(chunkKey{}).(func([]byte))
+	return fn
+}
+
+//retryAllowed returns whether the failed call of ctx may be retried,
+//e.g. not after a part of the streamed reply is written.
+func retryAllowed(ctx context.Context) bool {
+	allowed, ok := ctx.Value(retryGuardKey{}).(func() bool)
+	return !ok || allowed()
+}
+
+//CallStreamTo calls the service replying an io.Reader, i.e. declaring the reply as *io.Reader,
+//and copies the reply to w chunk by chunk as the server streams it, instead of decoding it into memory.
+//If CallTimeout is set, the call gives up after it. The call is not retried once a chunk is written to w,
+//and the error of w is returned after the call completes.
+func (client *Client) CallStreamTo(serviceMethod string, args interface{}, w io.Writer) error {
+	ctx := context.Background()
+	if client.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.CallTimeout)
+		defer cancel()
+	}
+	return client.CallStreamToContext(ctx, serviceMethod, args, w)
+}
+
+//CallStreamToContext is like CallStreamTo but is bounded by ctx instead of CallTimeout.
+//Canceling ctx cancels the stream: the server stops streaming the reply (see server.Stream),
+//and the call returns the error of RPCErrStreamCancelled.
+func (client *Client) CallStreamToContext(ctx context.Context, serviceMethod string, args interface{}, w io.Writer) error {
+	var (
+		lock    sync.Mutex // protects following
+		written bool
+		werr    error
+		done    bool // the chunks after the call returns are dropped
+	)
+	ctx = withChunks(ctx, func(chunk []byte) {
+		lock.Lock()
+		defer lock.Unlock()
+		if done || werr != nil {
+			return
+		}
+		written = true
+		_, werr = w.Write(chunk)
+	})
+	ctx = context.WithValue(ctx, retryGuardKey{}, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return !written
+	})
+	rpcErr := client.CallContext(ctx, serviceMethod, args, nil)
+	lock.Lock()
+	defer lock.Unlock()
+	done = true
+	if rpcErr != nil {
+		return errors.New(rpcErr.Error)
+	}
+	return werr
+}
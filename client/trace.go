@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+//TracePhase is a phase of a traced call.
+type TracePhase string
+
+const (
+	//TraceSelect is the selection of an invoker, including the dial by the selector if any.
+	TraceSelect TracePhase = "select"
+	//TraceDial is the dial of a new connection by the selection.
+	TraceDial TracePhase = "dial"
+	//TraceWrite is the writing of the request.
+	TraceWrite TracePhase = "write"
+	//TraceRead is the wait for the response after the request is written, and its reading.
+	TraceRead TracePhase = "read"
+)
+
+type (
+	//TraceEvent is a phase of a traced call.
+	TraceEvent struct {
+		Phase TracePhase
+		//Addr is the address of the backend, "" if unknown, e.g. of a failed selection.
+		Addr     string
+		Start    time.Time
+		Duration time.Duration
+		//Reused is whether the selection returns an existing connection, for TraceSelect.
+		Reused bool
+		//Error is the error of the phase, "" if it succeeds.
+		Error string
+	}
+
+	//CallTrace records the selector decisions, the dials and the timings of the phases of a call,
+	//e.g. to find out why a backend is chosen. The events of every attempt are in order.
+	CallTrace struct {
+		lock   sync.Mutex
+		events []TraceEvent
+	}
+
+	traceKey struct{}
+)
+
+//WithTrace returns a copy of ctx recording the phases of the call into trace,
+//e.g. client.CallContext(client.WithTrace(ctx, trace), ...).
+func WithTrace(ctx context.Context, trace *CallTrace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+//traceFrom returns the trace set by WithTrace, or nil.
+func traceFrom(ctx context.Context) *CallTrace {
+	trace, _ := ctx.Value(traceKey{}).(*CallTrace)
+	return trace
+}
+
+//Events returns the recorded events in order.
+func (t *CallTrace) Events() []TraceEvent {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]TraceEvent(nil), t.events...)
+}
+
+//Phase returns the recorded events of the phase in order.
+func (t *CallTrace) Phase(phase TracePhase) []TraceEvent {
+	var events []TraceEvent
+	for _, e := range t.Events() {
+		if e.Phase == phase {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+//record records the event of the phase started at start, a nil trace records nothing.
+func (t *CallTrace) record(phase TracePhase, addr string, start time.Time, err *common.RPCError) {
+	if t == nil {
+		return
+	}
+	e := TraceEvent{
+		Phase:    phase,
+		Addr:     addr,
+		Start:    start,
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		e.Error = err.Error
+	}
+	t.add(e)
+}
+
+func (t *CallTrace) add(e TraceEvent) {
+	t.lock.Lock()
+	t.events = append(t.events, e)
+	t.lock.Unlock()
+}
+
+//CallWithTrace is like Call but also returns the trace of the call.
+func (client *Client) CallWithTrace(serviceMethod string, args interface{}, reply interface{}) (*CallTrace, *common.RPCError) {
+	ctx := context.Background()
+	if client.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.CallTimeout)
+		defer cancel()
+	}
+	trace := new(CallTrace)
+	rpcErr := client.CallContext(WithTrace(ctx, trace), serviceMethod, args, reply)
+	return trace, rpcErr
+}
+
+//selectInvoker selects an invoker, tracing the selection and the dial it made.
+func (client *Client) selectInvoker(ctx context.Context, serviceMethod string, args interface{}) (Invoker, error) {
+	trace := traceFrom(ctx)
+	if trace == nil {
+		inv, err := client.selector.Select(serviceMethod, args)
+		if i, ok := asInvoker(inv); ok {
+			// the dial of this selection is not traced.
+			i.takeDial(time.Time{})
+		}
+		return inv, err
+	}
+	start := time.Now()
+	inv, err := client.selector.Select(serviceMethod, args)
+	e := TraceEvent{
+		Phase:    TraceSelect,
+		Start:    start,
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		e.Error = err.Error()
+		trace.add(e)
+		return inv, err
+	}
+	e.Addr = invokerAddr(inv)
+	var dial *TraceEvent
+	if i, ok := asInvoker(inv); ok {
+		dial = i.takeDial(start)
+	}
+	e.Reused = dial == nil
+	trace.add(e)
+	if dial != nil {
+		trace.add(*dial)
+	}
+	return inv, err
+}
+
+//takeDial returns the dial of the invoker once, if it started after the selection started at start,
+//so that the dial of an earlier selection isn't attributed to this one.
+func (invoker *invoker) takeDial(start time.Time) *TraceEvent {
+	invoker.mutex.Lock()
+	defer invoker.mutex.Unlock()
+	dial := invoker.dial
+	invoker.dial = nil
+	if dial != nil && dial.Start.Before(start) {
+		return nil
+	}
+	return dial
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+type trailerKey struct{}
+
+//withTrailers returns a copy of ctx with the callback receiving the trailers of the response.
+//The callback runs on the goroutine reading the responses, it must not block for long.
+func withTrailers(ctx context.Context, fn func(trailers map[string]string)) context.Context {
+	return context.WithValue(ctx, trailerKey{}, fn)
+}
+
+//trailerFunc returns the callback set by withTrailers, or nil.
+func trailerFunc(ctx context.Context) func(map[string]string) {
+	fn, _ := ctx.Value(trailerKey{}).(func(map[string]string))
+	return fn
+}
+
+//CallWithTrailers is like Call but also returns the trailers of the response, the metadata that the service
+//sets after producing the reply (see server.Context.SetTrailer), nil if none. The trailers are of the last attempt.
+func (client *Client) CallWithTrailers(serviceMethod string, args interface{}, reply interface{}) (map[string]string, *common.RPCError) {
+	ctx := context.Background()
+	if client.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.CallTimeout)
+		defer cancel()
+	}
+	var (
+		lock     sync.Mutex // a late response may outlive the call
+		trailers map[string]string
+	)
+	ctx = withTrailers(ctx, func(t map[string]string) {
+		lock.Lock()
+		trailers = t
+		lock.Unlock()
+	})
+	rpcErr := client.CallContext(ctx, serviceMethod, args, reply)
+	lock.Lock()
+	defer lock.Unlock()
+	return trailers, rpcErr
+}
@@ -90,6 +90,11 @@ func (sc *bsonServerCodec) WriteResponse(rs *rpc.Response, v interface{}) (err e
 	return
 }
 
+// ContentType returns the MIME type of bson.
+func (sc *bsonServerCodec) ContentType() string {
+	return "application/bson"
+}
+
 func (sc *bsonServerCodec) Close() (err error) {
 	err = sc.conn.Close()
 	return
@@ -0,0 +1,249 @@
+// Package checksum provides a codec wrapper that appends a checksum to every message
+// of the wrapped codec and verifies it on receipt, e.g. over an unreliable transport
+// or to catch the bugs of a codec.
+//
+// The bytes the wrapped codec writes for a request or a response are framed as their
+// 4-byte length, the bytes and their 4-byte checksum. A message is verified before its header
+// is decoded, so a mismatch fails the reading of the connection with ErrMismatch, as the call of
+// the message can't be told: the client fails its pending calls with it, and the server closes the
+// connection of a corrupted request. Both sides must use the same Algorithm:
+//
+//	srv := server.NewServer(server.Server{
+//		ServerCodecFunc: checksum.NewServerCodecFunc(codecGob.NewGobServerCodec, checksum.CRC32C),
+//	})
+//	c := client.NewClient(client.Client{
+//		ClientCodecFunc: checksum.NewClientCodecFunc(codecGob.NewGobClientCodec, checksum.CRC32C),
+//	}, s)
+//
+// The wrapped codec must read its messages exactly, without reading ahead, e.g. gob,
+// so that it doesn't read the next message with the current one.
+package checksum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/rpc"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/pierrec/xxHash/xxHash32"
+
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// Algorithm is the checksum algorithm.
+type Algorithm byte
+
+const (
+	// CRC32 is the CRC-32 of the IEEE polynomial.
+	CRC32 Algorithm = iota
+	// CRC32C is the CRC-32 of the Castagnoli polynomial, accelerated by the SSE4.2 CPUs.
+	CRC32C
+	// XXHash32 is the 32-bit xxHash, fast without the hardware acceleration.
+	XXHash32
+)
+
+// MaxFrameSize limits the size of a message, a larger length is taken as corrupted.
+// The buffer of a message grows as its bytes arrive, not by the length read.
+var MaxFrameSize = 16 << 20
+
+// ErrMismatch is the error of a message whose checksum mismatches.
+var ErrMismatch = errors.New("checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// String returns the name of the algorithm.
+func (a Algorithm) String() string {
+	switch a {
+	case CRC32:
+		return "crc32"
+	case CRC32C:
+		return "crc32c"
+	case XXHash32:
+		return "xxhash32"
+	}
+	return "Algorithm(" + strconv.Itoa(int(a)) + ")"
+}
+
+func (a Algorithm) sum(b []byte) uint32 {
+	switch a {
+	case CRC32C:
+		return crc32.Checksum(b, castagnoli)
+	case XXHash32:
+		return xxHash32.Checksum(b, 0)
+	}
+	return crc32.ChecksumIEEE(b)
+}
+
+// framer frames the messages written by the wrapped codec, and unframes the messages it reads.
+type framer struct {
+	rwc       io.ReadWriteCloser
+	algorithm Algorithm
+	head      [4]byte
+	wbuf      bytes.Buffer // the message being written
+	in        bytes.Buffer // the current message read
+	rbuf      []byte       // the unread rest of the current message
+}
+
+func newFramer(rwc io.ReadWriteCloser, algorithm Algorithm) *framer {
+	return &framer{rwc: rwc, algorithm: algorithm}
+}
+
+// Write buffers the bytes of the message being written, see flush.
+func (f *framer) Write(b []byte) (int, error) {
+	return f.wbuf.Write(b)
+}
+
+// flush writes the buffered message in a frame.
+func (f *framer) flush() error {
+	if f.wbuf.Len() == 0 {
+		return nil
+	}
+	msg := f.wbuf.Bytes()
+	frame := make([]byte, 4+len(msg)+4)
+	binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	copy(frame[4:], msg)
+	binary.BigEndian.PutUint32(frame[4+len(msg):], f.algorithm.sum(msg))
+	f.wbuf.Reset()
+	_, err := f.rwc.Write(frame)
+	return err
+}
+
+// Read reads the current message, and the next one at its end.
+func (f *framer) Read(b []byte) (int, error) {
+	if len(f.rbuf) == 0 {
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, f.rbuf)
+	f.rbuf = f.rbuf[n:]
+	return n, nil
+}
+
+// ReadByte implements io.ByteReader, so that gob doesn't buffer the reads ahead.
+func (f *framer) ReadByte() (byte, error) {
+	if len(f.rbuf) == 0 {
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+	c := f.rbuf[0]
+	f.rbuf = f.rbuf[1:]
+	return c, nil
+}
+
+// Buffered returns the unread bytes of the current message.
+func (f *framer) Buffered() int {
+	return len(f.rbuf)
+}
+
+// next reads the next message and verifies its checksum, before the wrapped codec decodes any of it.
+func (f *framer) next() error {
+	if _, err := io.ReadFull(f.rwc, f.head[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(f.head[:])
+	if int64(size) > int64(MaxFrameSize) {
+		return errors.New("checksum: message of " + strconv.FormatUint(uint64(size), 10) + " bytes exceeds MaxFrameSize")
+	}
+	f.in.Reset()
+	if _, err := io.CopyN(&f.in, f.rwc, int64(size)+4); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	frame := f.in.Bytes()
+	if binary.BigEndian.Uint32(frame[size:]) != f.algorithm.sum(frame[:size]) {
+		return ErrMismatch
+	}
+	f.rbuf = frame[:size]
+	return nil
+}
+
+func (f *framer) Close() error {
+	return f.rwc.Close()
+}
+
+// NewServerCodecFunc returns a ServerCodec creator wrapping the codec of fn with the checksums.
+func NewServerCodecFunc(fn func(io.ReadWriteCloser) rpc.ServerCodec, algorithm Algorithm) func(io.ReadWriteCloser) rpc.ServerCodec {
+	return func(conn io.ReadWriteCloser) rpc.ServerCodec {
+		f := newFramer(conn, algorithm)
+		return &serverCodec{ServerCodec: fn(f), framer: f}
+	}
+}
+
+type serverCodec struct {
+	rpc.ServerCodec
+	framer  *framer
+	sending sync.Mutex
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	err := c.ServerCodec.WriteResponse(r, body)
+	if ferr := c.framer.flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// ContentType returns the MIME type of the wrapped codec.
+func (c *serverCodec) ContentType() string {
+	return server.ContentType(c.ServerCodec)
+}
+
+// CheckType forwards server.ITypeChecker.
+func (c *serverCodec) CheckType(t reflect.Type) error {
+	if checker, ok := c.ServerCodec.(server.ITypeChecker); ok {
+		return checker.CheckType(t)
+	}
+	return nil
+}
+
+// LimitResponse forwards common.ResponseLimiter.
+func (c *serverCodec) LimitResponse(n int64) {
+	if l, ok := c.ServerCodec.(common.ResponseLimiter); ok {
+		l.LimitResponse(n)
+	}
+}
+
+// NewClientCodecFunc returns a ClientCodec creator wrapping the codec of fn with the checksums.
+func NewClientCodecFunc(fn func(io.ReadWriteCloser) rpc.ClientCodec, algorithm Algorithm) func(io.ReadWriteCloser) rpc.ClientCodec {
+	return func(conn io.ReadWriteCloser) rpc.ClientCodec {
+		f := newFramer(conn, algorithm)
+		return &clientCodec{ClientCodec: fn(f), framer: f}
+	}
+}
+
+type clientCodec struct {
+	rpc.ClientCodec
+	framer  *framer
+	sending sync.Mutex
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	err := c.ClientCodec.WriteRequest(r, body)
+	if ferr := c.framer.flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Buffered returns the bytes read ahead of the wrapped codec, see client.Client.MaxResponseBytes.
+func (c *clientCodec) Buffered() int {
+	n := c.framer.Buffered()
+	if b, ok := c.ClientCodec.(interface{ Buffered() int }); ok {
+		n += b.Buffered()
+	}
+	return n
+}
@@ -0,0 +1,130 @@
+package checksum
+
+import (
+	"io"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	codecGob "github.com/henrylee2cn/myrpc/codec/gob"
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type Echo struct {
+	calls int32
+}
+
+func (e *Echo) Say(arg string, reply *string) error {
+	atomic.AddInt32(&e.calls, 1)
+	*reply = arg
+	return nil
+}
+
+// flipConn flips the last byte of the message in the next frame written if armed.
+type flipConn struct {
+	io.ReadWriteCloser
+	armed *int32
+}
+
+func (c *flipConn) Write(b []byte) (int, error) {
+	if atomic.CompareAndSwapInt32(c.armed, 1, 0) {
+		b = append([]byte(nil), b...)
+		b[len(b)-5] ^= 0xff
+	}
+	return c.ReadWriteCloser.Write(b)
+}
+
+func serve(t *testing.T, algorithm Algorithm, flipResponse *int32) (string, *Echo) {
+	fn := NewServerCodecFunc(codecGob.NewGobServerCodec, algorithm)
+	srv := server.NewServer(server.Server{
+		ServerCodecFunc: func(conn io.ReadWriteCloser) rpc.ServerCodec {
+			return fn(&flipConn{ReadWriteCloser: conn, armed: flipResponse})
+		},
+	})
+	echo := new(Echo)
+	srv.NamedRegister("echo", echo)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	return lis.Addr().String(), echo
+}
+
+func newClient(addr string, algorithm Algorithm, flipRequest *int32) *client.Client {
+	fn := NewClientCodecFunc(codecGob.NewGobClientCodec, algorithm)
+	return client.NewClient(client.Client{
+		ClientCodecFunc: func(conn io.ReadWriteCloser) rpc.ClientCodec {
+			return fn(&flipConn{ReadWriteCloser: conn, armed: flipRequest})
+		},
+		MaxTry:      1,
+		CallTimeout: 5 * time.Second,
+	}, &selector.DirectSelector{Network: "tcp", Address: addr})
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, algorithm := range []Algorithm{CRC32, CRC32C, XXHash32} {
+		addr, _ := serve(t, algorithm, new(int32))
+		c := newClient(addr, algorithm, new(int32))
+		for i := 0; i < 3; i++ {
+			var reply string
+			if e := c.Call("/echo/say", "hello", &reply); e != nil || reply != "hello" {
+				t.Fatalf("%s: reply=%q, err=%v", algorithm, reply, e)
+			}
+		}
+		c.Close()
+	}
+}
+
+func TestMismatch(t *testing.T) {
+	flipRequest, flipResponse := new(int32), new(int32)
+	addr, echo := serve(t, CRC32C, flipResponse)
+	c := newClient(addr, CRC32C, flipRequest)
+	defer c.Close()
+
+	// the server closes the connection of the corrupted request without running it
+	var reply string
+	atomic.StoreInt32(flipRequest, 1)
+	if e := c.Call("/echo/say", "hello", &reply); e == nil {
+		t.Fatal("corrupted request: expect the connection closed")
+	}
+	if n := atomic.LoadInt32(&echo.calls); n != 0 {
+		t.Fatalf("corrupted request: calls = %d, want 0", n)
+	}
+
+	atomic.StoreInt32(flipResponse, 1)
+	e := c.Call("/echo/say", "hello", &reply)
+	if e == nil || e.Type != common.ErrorTypeClientReadResponseHeader || e.Error != ErrMismatch.Error() {
+		t.Fatalf("corrupted response: expect the checksum mismatch, got: %v", e)
+	}
+
+	if e = c.Call("/echo/say", "hello", &reply); e != nil || reply != "hello" {
+		t.Fatalf("after the mismatches: reply=%q, err=%v", reply, e)
+	}
+}
+
+func TestFrameSize(t *testing.T) {
+	local, conn := net.Pipe()
+	defer conn.Close()
+	f := newFramer(local, CRC32)
+	go conn.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	if err := f.next(); err == nil || !strings.Contains(err.Error(), "exceeds MaxFrameSize") {
+		t.Fatalf("expect the length rejected, got: %v", err)
+	}
+	go func() {
+		conn.Write([]byte{0, 0, 0x10, 0})
+		conn.Close()
+	}()
+	if err := f.next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expect the unexpected EOF, got: %v", err)
+	}
+	if f.in.Cap() >= 0x1000 {
+		t.Fatalf("the buffer grows to %d bytes before the message arrives", f.in.Cap())
+	}
+}
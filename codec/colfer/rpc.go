@@ -2,8 +2,10 @@ package colfer
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net/rpc"
+	"reflect"
 )
 
 var errBodyMismatch = errors.New("colfer/rpc: body not a Colfer type")
@@ -15,6 +17,8 @@ type colferer interface {
 	Unmarshal([]byte) (int, error)
 }
 
+var colfererType = reflect.TypeOf((*colferer)(nil)).Elem()
+
 type codec struct {
 	conn io.ReadWriteCloser
 
@@ -113,6 +117,21 @@ func (c *codec) WriteResponse(header *rpc.Response, body interface{}) error {
 	return c.encode(h, b)
 }
 
+// CheckType returns an error naming the missing method if the type t doesn't implement
+// the Colfer encoding methods, see server.ITypeChecker.
+func (c *codec) CheckType(t reflect.Type) error {
+	if t.Implements(colfererType) {
+		return nil
+	}
+	for i := 0; i < colfererType.NumMethod(); i++ {
+		name := colfererType.Method(i).Name
+		if _, ok := t.MethodByName(name); !ok {
+			return fmt.Errorf("colfer/rpc: %s is not a Colfer type: missing method %s", t, name)
+		}
+	}
+	return fmt.Errorf("colfer/rpc: %s is not a Colfer type: wrong signature of the Colfer methods", t)
+}
+
 func (c *codec) Close() error {
 	return c.conn.Close()
 }
@@ -0,0 +1,274 @@
+package colfer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// The field helpers below encode the types the Colfer schema language has no
+// generator output for in this package, so that hand-written MarshalTo, MarshalLen
+// and Unmarshal methods can carry them like the generated fields.
+// Each Put function writes the field header with index and returns the number
+// of bytes written, or 0 for a zero value, which is omitted like the generated code does.
+// Each Read function expects data[i] to be the header of the field and returns
+// the value and the index of the next header.
+//
+// timestamp: header(index, or index|0x80 for 64-bit seconds) + seconds(uint32|uint64) + nanoseconds(uint32)
+// binary:    header(index) + varint length + bytes
+// maps:      header(index) + varint count + entries sorted by key,
+//            each entry is varint key length + key + value,
+//            the string value is varint length + bytes and the int64 value is a zigzag varint.
+
+// TimeLen returns the encoded size of the timestamp field.
+func TimeLen(t time.Time) int {
+	if t.IsZero() {
+		return 0
+	}
+	if s := uint64(t.Unix()); s < 1<<32 {
+		return 9
+	}
+	return 13
+}
+
+// PutTime encodes t as the timestamp field with index into buf.
+func PutTime(buf []byte, index byte, t time.Time) int {
+	if t.IsZero() {
+		return 0
+	}
+	var i int
+	if s := uint64(t.Unix()); s < 1<<32 {
+		buf[i] = index
+		binary.BigEndian.PutUint32(buf[i+1:], uint32(s))
+		i += 5
+	} else {
+		buf[i] = index | 0x80
+		binary.BigEndian.PutUint64(buf[i+1:], s)
+		i += 9
+	}
+	binary.BigEndian.PutUint32(buf[i:], uint32(t.Nanosecond()))
+	return i + 4
+}
+
+// ReadTime decodes the timestamp field at data[i], the time is in UTC.
+func ReadTime(data []byte, i int) (time.Time, int, error) {
+	if i >= len(data) {
+		return time.Time{}, 0, io.EOF
+	}
+	var s int64
+	if data[i]&0x80 == 0 {
+		if i+9 > len(data) {
+			return time.Time{}, 0, io.EOF
+		}
+		s = int64(binary.BigEndian.Uint32(data[i+1:]))
+		i += 5
+	} else {
+		if i+13 > len(data) {
+			return time.Time{}, 0, io.EOF
+		}
+		s = int64(binary.BigEndian.Uint64(data[i+1:]))
+		i += 9
+	}
+	ns := int64(binary.BigEndian.Uint32(data[i:]))
+	if ns >= 1e9 {
+		return time.Time{}, 0, ColferError(i)
+	}
+	return time.Unix(s, ns).UTC(), i + 4, nil
+}
+
+// BytesLen returns the encoded size of the binary field.
+func BytesLen(b []byte) int {
+	if len(b) == 0 {
+		return 0
+	}
+	return 1 + uvarintLen(uint64(len(b))) + len(b)
+}
+
+// PutBytes encodes b as the binary field with index into buf.
+func PutBytes(buf []byte, index byte, b []byte) int {
+	if len(b) == 0 {
+		return 0
+	}
+	buf[0] = index
+	i := 1 + binary.PutUvarint(buf[1:], uint64(len(b)))
+	return i + copy(buf[i:], b)
+}
+
+// ReadBytes decodes the binary field at data[i], the bytes are copied.
+func ReadBytes(data []byte, i int) ([]byte, int, error) {
+	if i >= len(data) {
+		return nil, 0, io.EOF
+	}
+	b, i, err := readBytes(data, i+1)
+	if err != nil {
+		return nil, 0, err
+	}
+	return append([]byte(nil), b...), i, nil
+}
+
+// StringMapLen returns the encoded size of the map field.
+func StringMapLen(m map[string]string) int {
+	if len(m) == 0 {
+		return 0
+	}
+	l := 1 + uvarintLen(uint64(len(m)))
+	for k, v := range m {
+		l += uvarintLen(uint64(len(k))) + len(k) + uvarintLen(uint64(len(v))) + len(v)
+	}
+	return l
+}
+
+// PutStringMap encodes m as the map field with index into buf.
+func PutStringMap(buf []byte, index byte, m map[string]string) int {
+	if len(m) == 0 {
+		return 0
+	}
+	buf[0] = index
+	i := 1 + binary.PutUvarint(buf[1:], uint64(len(m)))
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		i += putString(buf[i:], k)
+		i += putString(buf[i:], m[k])
+	}
+	return i
+}
+
+// ReadStringMap decodes the map field at data[i].
+func ReadStringMap(data []byte, i int) (map[string]string, int, error) {
+	n, i, err := readCount(data, i)
+	if err != nil {
+		return nil, 0, err
+	}
+	m := make(map[string]string, n)
+	for ; n > 0; n-- {
+		var k, v []byte
+		if k, i, err = readBytes(data, i); err != nil {
+			return nil, 0, err
+		}
+		if v, i, err = readBytes(data, i); err != nil {
+			return nil, 0, err
+		}
+		m[string(k)] = string(v)
+	}
+	return m, i, nil
+}
+
+// IntMapLen returns the encoded size of the map field.
+func IntMapLen(m map[string]int64) int {
+	if len(m) == 0 {
+		return 0
+	}
+	l := 1 + uvarintLen(uint64(len(m)))
+	for k, v := range m {
+		l += uvarintLen(uint64(len(k))) + len(k) + uvarintLen(zigzag(v))
+	}
+	return l
+}
+
+// PutIntMap encodes m as the map field with index into buf.
+func PutIntMap(buf []byte, index byte, m map[string]int64) int {
+	if len(m) == 0 {
+		return 0
+	}
+	buf[0] = index
+	i := 1 + binary.PutUvarint(buf[1:], uint64(len(m)))
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		i += putString(buf[i:], k)
+		i += binary.PutUvarint(buf[i:], zigzag(m[k]))
+	}
+	return i
+}
+
+// ReadIntMap decodes the map field at data[i].
+func ReadIntMap(data []byte, i int) (map[string]int64, int, error) {
+	n, i, err := readCount(data, i)
+	if err != nil {
+		return nil, 0, err
+	}
+	m := make(map[string]int64, n)
+	for ; n > 0; n-- {
+		var k []byte
+		if k, i, err = readBytes(data, i); err != nil {
+			return nil, 0, err
+		}
+		x, l := binary.Uvarint(data[i:])
+		if l <= 0 {
+			if l == 0 {
+				return nil, 0, io.EOF
+			}
+			return nil, 0, ColferError(i)
+		}
+		i += l
+		m[string(k)] = int64(x>>1) ^ -int64(x&1)
+	}
+	return m, i, nil
+}
+
+func putString(buf []byte, s string) int {
+	i := binary.PutUvarint(buf, uint64(len(s)))
+	return i + copy(buf[i:], s)
+}
+
+// readCount reads the entry count of the map field at data[i].
+func readCount(data []byte, i int) (int, int, error) {
+	if i >= len(data) {
+		return 0, 0, io.EOF
+	}
+	i++
+	x, l := binary.Uvarint(data[i:])
+	if l <= 0 {
+		if l == 0 {
+			return 0, 0, io.EOF
+		}
+		return 0, 0, ColferError(i)
+	}
+	// every entry takes 2 bytes at least
+	if x > uint64(ColferSizeMax/2) {
+		return 0, 0, ColferMax(fmt.Sprintf("colfer: map size %d exceeds %d entries", x, ColferSizeMax/2))
+	}
+	return int(x), i + l, nil
+}
+
+// readBytes reads the length-prefixed bytes at data[i] without copying.
+func readBytes(data []byte, i int) ([]byte, int, error) {
+	x, l := binary.Uvarint(data[i:])
+	if l <= 0 {
+		if l == 0 {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, ColferError(i)
+	}
+	i += l
+	if x > uint64(len(data)-i) {
+		if x > uint64(ColferSizeMax) {
+			return nil, 0, ColferMax(fmt.Sprintf("colfer: field size %d exceeds %d bytes", x, ColferSizeMax))
+		}
+		return nil, 0, io.EOF
+	}
+	to := i + int(x)
+	return data[i:to], to, nil
+}
+
+func uvarintLen(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+func zigzag(x int64) uint64 {
+	return uint64(x<<1) ^ uint64(x>>63)
+}
@@ -0,0 +1,164 @@
+package colfer
+
+import (
+	"bytes"
+	"io"
+	"net/rpc"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+type ColfEvent struct {
+	At      time.Time
+	Payload []byte
+	Labels  map[string]string
+	Counts  map[string]int64
+}
+
+// MarshalTo encodes o as Colfer into buf and returns the number of bytes written.
+// If the buffer is too small, MarshalTo will panic.
+func (o *ColfEvent) MarshalTo(buf []byte) int {
+	var i int
+	i += PutTime(buf[i:], 0, o.At)
+	i += PutBytes(buf[i:], 1, o.Payload)
+	i += PutStringMap(buf[i:], 2, o.Labels)
+	i += PutIntMap(buf[i:], 3, o.Counts)
+	buf[i] = 0x7f
+	i++
+	return i
+}
+
+// MarshalLen returns the Colfer serial byte size.
+// The error return option is colfer.ColferMax.
+func (o *ColfEvent) MarshalLen() (int, error) {
+	l := 1 + TimeLen(o.At) + BytesLen(o.Payload) + StringMapLen(o.Labels) + IntMapLen(o.Counts)
+	if l > ColferSizeMax {
+		return l, ColferMax("colfer: struct colfer.event exceeds the size limit")
+	}
+	return l, nil
+}
+
+// Unmarshal decodes data as Colfer and returns the number of bytes read.
+// The error return options are io.EOF, colfer.ColferError and colfer.ColferMax.
+func (o *ColfEvent) Unmarshal(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, io.EOF
+	}
+	var i int
+	var err error
+	if data[i]&0x7f == 0 {
+		if o.At, i, err = ReadTime(data, i); err != nil {
+			return 0, err
+		}
+		if i >= len(data) {
+			return 0, io.EOF
+		}
+	}
+	if data[i] == 1 {
+		if o.Payload, i, err = ReadBytes(data, i); err != nil {
+			return 0, err
+		}
+		if i >= len(data) {
+			return 0, io.EOF
+		}
+	}
+	if data[i] == 2 {
+		if o.Labels, i, err = ReadStringMap(data, i); err != nil {
+			return 0, err
+		}
+		if i >= len(data) {
+			return 0, io.EOF
+		}
+	}
+	if data[i] == 3 {
+		if o.Counts, i, err = ReadIntMap(data, i); err != nil {
+			return 0, err
+		}
+		if i >= len(data) {
+			return 0, io.EOF
+		}
+	}
+	if data[i] != 0x7f {
+		return 0, ColferError(i)
+	}
+	return i + 1, nil
+}
+
+type bufferConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (bufferConn) Close() error { return nil }
+
+func TestTypesRoundTrip(t *testing.T) {
+	events := []*ColfEvent{
+		{
+			At:      time.Date(2017, 3, 4, 5, 6, 7, 123456789, time.UTC),
+			Payload: []byte{0, 1, 2, 0x7f, 0x80, 0xff},
+			Labels:  map[string]string{"zone": "eu", "": "empty key", "host": ""},
+			Counts:  map[string]int64{"min": -1 << 63, "max": 1<<63 - 1, "zero": 0},
+		},
+		// seconds beyond 32 bits and before the epoch
+		{At: time.Date(2200, 1, 1, 0, 0, 0, 1, time.UTC)},
+		{At: time.Date(1900, 12, 31, 23, 59, 59, 999999999, time.UTC)},
+		{},
+	}
+
+	var buf bytes.Buffer
+	server := NewServerCodec(bufferConn{Writer: &buf})
+	for i, e := range events {
+		if err := server.WriteResponse(&rpc.Response{ServiceMethod: "Events.Get", Seq: uint64(i)}, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// reads byte by byte to decode from every partial buffer
+	client := NewClientCodec(bufferConn{Reader: iotest.OneByteReader(&buf)})
+	for i, want := range events {
+		var resp rpc.Response
+		if err := client.ReadResponseHeader(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Seq != uint64(i) {
+			t.Fatalf("seq = %d, want %d", resp.Seq, i)
+		}
+		got := new(ColfEvent)
+		if err := client.ReadResponseBody(got); err != nil {
+			t.Fatal(err)
+		}
+		if !got.At.Equal(want.At) || got.At.Nanosecond() != want.At.Nanosecond() {
+			t.Errorf("event %d: At = %v, want %v", i, got.At, want.At)
+		}
+		if !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("event %d: Payload = %v, want %v", i, got.Payload, want.Payload)
+		}
+		if len(want.Labels) > 0 && !reflect.DeepEqual(got.Labels, want.Labels) {
+			t.Errorf("event %d: Labels = %v, want %v", i, got.Labels, want.Labels)
+		}
+		if len(want.Counts) > 0 && !reflect.DeepEqual(got.Counts, want.Counts) {
+			t.Errorf("event %d: Counts = %v, want %v", i, got.Counts, want.Counts)
+		}
+	}
+}
+
+func TestTypesDeterministic(t *testing.T) {
+	e := &ColfEvent{
+		Labels: map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"},
+		Counts: map[string]int64{"a": 1, "b": 2, "c": 3, "d": 4},
+	}
+	l, _ := e.MarshalLen()
+	first := make([]byte, l)
+	if n := e.MarshalTo(first); n != l {
+		t.Fatalf("MarshalTo wrote %d bytes, MarshalLen = %d", n, l)
+	}
+	for i := 0; i < 10; i++ {
+		b := make([]byte, l)
+		e.MarshalTo(b)
+		if !bytes.Equal(b, first) {
+			t.Fatalf("encoding %x differs from %x", b, first)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Package columnar provides a codec wrapper that compresses the list replies
+// of many near-identical elements.
+//
+// A reply whose type implements List, e.g.
+//
+//	type Users []User
+//
+//	func (Users) ColumnarList() {}
+//
+// is transposed into columns, one per exported field of the struct elements,
+// and every column stores a value once for the run of the elements sharing it.
+// The services and callers use the reply as usual; the client and the server
+// must both wrap their codecs and share the reply type. The decoded elements of a run
+// share the values of the reference types, e.g. slices and maps.
+package columnar
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"reflect"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// MaxLen limits the elements of a List decoded from the peer.
+var MaxLen = 1 << 24
+
+var errCorruptColumn = errors.New("columnar: corrupt column")
+
+// List is the marker of a slice type whose values are encoded in columns.
+type List interface {
+	ColumnarList()
+}
+
+// table is the encoded form of a List.
+type table struct {
+	Len     int
+	Columns []column
+}
+
+// column holds the distinct values of consecutive elements and the length of their runs.
+type column struct {
+	Values []byte // gob of a slice of the field type
+	Runs   []int32
+}
+
+// NewServerCodecFunc returns a ServerCodec creator that encodes the List replies in columns.
+func NewServerCodecFunc(fn func(io.ReadWriteCloser) rpc.ServerCodec) func(io.ReadWriteCloser) rpc.ServerCodec {
+	return func(conn io.ReadWriteCloser) rpc.ServerCodec {
+		return &serverCodec{ServerCodec: fn(conn)}
+	}
+}
+
+type serverCodec struct {
+	rpc.ServerCodec
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	if _, ok := body.(List); ok && r.Error == "" {
+		t, err := encodeTable(reflect.ValueOf(body))
+		if err != nil {
+			return &common.EncodeError{Err: err}
+		}
+		body = t
+	}
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// ContentType returns the MIME type of the wrapped codec.
+func (c *serverCodec) ContentType() string {
+	if ct, ok := c.ServerCodec.(interface {
+		ContentType() string
+	}); ok {
+		return ct.ContentType()
+	}
+	return "application/octet-stream"
+}
+
+// NewClientCodecFunc returns a ClientCodec creator that decodes the List replies in columns.
+func NewClientCodecFunc(fn func(io.ReadWriteCloser) rpc.ClientCodec) func(io.ReadWriteCloser) rpc.ClientCodec {
+	return func(conn io.ReadWriteCloser) rpc.ClientCodec {
+		return &clientCodec{ClientCodec: fn(conn)}
+	}
+}
+
+type clientCodec struct {
+	rpc.ClientCodec
+}
+
+// Buffered returns the bytes read ahead by the wrapped codec.
+func (c *clientCodec) Buffered() int {
+	if b, ok := c.ClientCodec.(interface{ Buffered() int }); ok {
+		return b.Buffered()
+	}
+	return 0
+}
+
+func (c *clientCodec) ReadResponseBody(body interface{}) error {
+	if _, ok := body.(List); !ok {
+		return c.ClientCodec.ReadResponseBody(body)
+	}
+	var t table
+	if err := c.ClientCodec.ReadResponseBody(&t); err != nil {
+		return err
+	}
+	return decodeTable(&t, reflect.ValueOf(body))
+}
+
+// listValue returns the slice of the List, which is a slice or a pointer to one.
+func listValue(v reflect.Value) (reflect.Value, error) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v, errors.New("columnar: nil list")
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice {
+		return v, fmt.Errorf("columnar: %s is not a slice", v.Type())
+	}
+	return v, nil
+}
+
+// fields returns the indexes of the columns of the element type,
+// nil for a non-struct element stored in a single column.
+func fields(elem reflect.Type) []int {
+	if elem.Kind() != reflect.Struct {
+		return nil
+	}
+	var indexes []int
+	for i := 0; i < elem.NumField(); i++ {
+		if elem.Field(i).PkgPath == "" {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+func encodeTable(v reflect.Value) (*table, error) {
+	list, err := listValue(v)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{Len: list.Len()}
+	if t.Len == 0 {
+		return t, nil
+	}
+	elem := list.Type().Elem()
+	indexes := fields(elem)
+	if indexes == nil {
+		col, err := encodeColumn(list, elem, func(e reflect.Value) reflect.Value { return e })
+		if err != nil {
+			return nil, err
+		}
+		t.Columns = []column{col}
+		return t, nil
+	}
+	for _, i := range indexes {
+		i := i
+		col, err := encodeColumn(list, elem.Field(i).Type, func(e reflect.Value) reflect.Value { return e.Field(i) })
+		if err != nil {
+			return nil, err
+		}
+		t.Columns = append(t.Columns, col)
+	}
+	return t, nil
+}
+
+func encodeColumn(list reflect.Value, typ reflect.Type, get func(reflect.Value) reflect.Value) (column, error) {
+	var col column
+	values := reflect.MakeSlice(reflect.SliceOf(typ), 0, 1)
+	var last reflect.Value
+	for i := 0; i < list.Len(); i++ {
+		value := get(list.Index(i))
+		if i > 0 && reflect.DeepEqual(value.Interface(), last.Interface()) {
+			col.Runs[len(col.Runs)-1]++
+			continue
+		}
+		values = reflect.Append(values, value)
+		col.Runs = append(col.Runs, 1)
+		last = value
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).EncodeValue(values); err != nil {
+		return col, err
+	}
+	col.Values = buf.Bytes()
+	return col, nil
+}
+
+// checkTable validates the lengths of the table sent by the peer before the list is allocated.
+func checkTable(t *table) error {
+	if t.Len < 0 || t.Len > MaxLen {
+		return fmt.Errorf("columnar: invalid list length %d, MaxLen is %d", t.Len, MaxLen)
+	}
+	for _, col := range t.Columns {
+		n := 0
+		for _, run := range col.Runs {
+			if run <= 0 || int(run) > t.Len-n {
+				return errCorruptColumn
+			}
+			n += int(run)
+		}
+		if n != t.Len {
+			return errCorruptColumn
+		}
+	}
+	return nil
+}
+
+func decodeTable(t *table, v reflect.Value) error {
+	list, err := listValue(v)
+	if err != nil {
+		return err
+	}
+	if !list.CanSet() {
+		return fmt.Errorf("columnar: %s can not be set", list.Type())
+	}
+	if err := checkTable(t); err != nil {
+		return err
+	}
+	list.Set(reflect.MakeSlice(list.Type(), t.Len, t.Len))
+	if t.Len == 0 {
+		return nil
+	}
+	elem := list.Type().Elem()
+	indexes := fields(elem)
+	if indexes == nil {
+		indexes = []int{-1}
+	}
+	if len(t.Columns) != len(indexes) {
+		return fmt.Errorf("columnar: %d columns for %d fields of %s", len(t.Columns), len(indexes), elem)
+	}
+	for c, i := range indexes {
+		typ := elem
+		if i >= 0 {
+			typ = elem.Field(i).Type
+		}
+		values := reflect.New(reflect.SliceOf(typ))
+		if err := gob.NewDecoder(bytes.NewReader(t.Columns[c].Values)).DecodeValue(values); err != nil {
+			return err
+		}
+		runs := t.Columns[c].Runs
+		if values.Elem().Len() != len(runs) {
+			return errCorruptColumn
+		}
+		n := 0
+		for r, run := range runs {
+			value := values.Elem().Index(r)
+			for ; run > 0; run-- {
+				if n >= t.Len {
+					return errCorruptColumn
+				}
+				if i >= 0 {
+					list.Index(n).Field(i).Set(value)
+				} else {
+					list.Index(n).Set(value)
+				}
+				n++
+			}
+		}
+		if n != t.Len {
+			return errCorruptColumn
+		}
+	}
+	return nil
+}
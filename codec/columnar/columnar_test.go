@@ -0,0 +1,118 @@
+package columnar
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/rpc"
+	"reflect"
+	"strconv"
+	"testing"
+
+	codecGob "github.com/henrylee2cn/myrpc/codec/gob"
+)
+
+type User struct {
+	ID      int
+	Country string
+	Plan    string
+	Tags    []string
+}
+
+type Users []User
+
+func (Users) ColumnarList() {}
+
+type buffer struct {
+	bytes.Buffer
+}
+
+func (*buffer) Close() error { return nil }
+
+// roundTrip writes the reply by the server codec and reads it back by the client codec,
+// and returns the wire size.
+func roundTrip(t *testing.T, server func(*buffer) rpc.ServerCodec, client func(*buffer) rpc.ClientCodec, reply, got interface{}) int {
+	var buf buffer
+	if err := server(&buf).WriteResponse(&rpc.Response{ServiceMethod: "/users/list", Seq: 1}, reply); err != nil {
+		t.Fatal(err)
+	}
+	size := buf.Len()
+	c := client(&buf)
+	var resp rpc.Response
+	if err := c.ReadResponseHeader(&resp); err != nil || resp.Seq != 1 {
+		t.Fatalf("header: %+v, err=%v", resp, err)
+	}
+	if err := c.ReadResponseBody(got); err != nil {
+		t.Fatal(err)
+	}
+	return size
+}
+
+func TestRoundTrip(t *testing.T) {
+	users := make(Users, 1000)
+	for i := range users {
+		users[i] = User{ID: i, Country: "NZ", Plan: "free", Tags: []string{"beta", "mobile"}}
+		if i%100 == 0 {
+			users[i].Plan = "pro"
+		}
+	}
+
+	var plain Users
+	plainSize := roundTrip(t,
+		func(b *buffer) rpc.ServerCodec { return codecGob.NewGobServerCodec(b) },
+		func(b *buffer) rpc.ClientCodec { return codecGob.NewGobClientCodec(b) },
+		&users, &plain)
+	var columnar Users
+	size := roundTrip(t,
+		func(b *buffer) rpc.ServerCodec { return NewServerCodecFunc(codecGob.NewGobServerCodec)(b) },
+		func(b *buffer) rpc.ClientCodec { return NewClientCodecFunc(codecGob.NewGobClientCodec)(b) },
+		&users, &columnar)
+
+	if !reflect.DeepEqual(columnar, users) || !reflect.DeepEqual(plain, users) {
+		t.Fatal("the decoded list differs")
+	}
+	if size*2 > plainSize {
+		t.Fatalf("expect the columns less than half of %d bytes, got %d", plainSize, size)
+	}
+	t.Logf("plain: %d bytes, columnar: %d bytes", plainSize, size)
+}
+
+type Names []string
+
+func (Names) ColumnarList() {}
+
+func TestScalarList(t *testing.T) {
+	names := Names{"a", "a", "b", "b", "b", "c"}
+	for i := 0; i < 10; i++ {
+		names = append(names, strconv.Itoa(i))
+	}
+	var got Names
+	roundTrip(t,
+		func(b *buffer) rpc.ServerCodec { return NewServerCodecFunc(codecGob.NewGobServerCodec)(b) },
+		func(b *buffer) rpc.ClientCodec { return NewClientCodecFunc(codecGob.NewGobClientCodec)(b) },
+		&names, &got)
+	if !reflect.DeepEqual(got, names) {
+		t.Fatalf("expect %q, got %q", names, got)
+	}
+}
+
+func TestCorruptTable(t *testing.T) {
+	var values bytes.Buffer
+	if err := gob.NewEncoder(&values).Encode([]string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, tb := range map[string]table{
+		"negative length": {Len: -1},
+		"huge length":     {Len: MaxLen + 1},
+		"short runs":      {Len: 1 << 20, Columns: []column{{Values: values.Bytes(), Runs: []int32{1}}}},
+		"negative run":    {Len: 1, Columns: []column{{Values: values.Bytes(), Runs: []int32{2, -1}}}},
+	} {
+		tb := tb
+		var got Names
+		if err := decodeTable(&tb, reflect.ValueOf(&got)); err == nil {
+			t.Fatalf("%s: expect an error", name)
+		}
+		if got != nil {
+			t.Fatalf("%s: expect the list not allocated, got %d elements", name, len(got))
+		}
+	}
+}
@@ -2,10 +2,13 @@ package gob
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/gob"
 	"io"
 	"log"
 	"net/rpc"
+
+	"github.com/henrylee2cn/myrpc/common"
 )
 
 type gobServerCodec struct {
@@ -14,16 +17,37 @@ type gobServerCodec struct {
 	enc    *gob.Encoder
 	encBuf *bufio.Writer
 	closed bool
+
+	// the encoder writes the body before the header, see WriteResponse.
+	out    messageWriter
+	header bytes.Buffer
+	body   bytes.Buffer
+	limit  int64 // of the next body, see LimitResponse
+}
+
+// maxRetainedBody limits the buffer of the body kept for the next response.
+const maxRetainedBody = 64 << 10
+
+// messageWriter writes the encoded messages to the selected buffer.
+type messageWriter struct {
+	buf  *bytes.Buffer
+	last int // the offset of the last message, the encoder writes a message at once
+}
+
+func (w *messageWriter) Write(b []byte) (int, error) {
+	w.last = w.buf.Len()
+	return w.buf.Write(b)
 }
 
 func NewGobServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
-	buf := bufio.NewWriter(conn)
-	return &gobServerCodec{
+	c := &gobServerCodec{
 		rwc:    conn,
 		dec:    gob.NewDecoder(conn),
-		enc:    gob.NewEncoder(buf),
-		encBuf: buf,
+		encBuf: bufio.NewWriter(conn),
+		limit:  -1,
 	}
+	c.enc = gob.NewEncoder(&c.out)
+	return c
 }
 
 func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
@@ -34,7 +58,31 @@ func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
 	return c.dec.Decode(body)
 }
 
+// LimitResponse limits the encoded body of the next response, see common.ResponseLimiter.
+func (c *gobServerCodec) LimitResponse(n int64) {
+	c.limit = n
+}
+
+// WriteResponse encodes the body before the header, so that a body failing to encode
+// or exceeding the limit is reported by *common.EncodeError without writing the header.
+// The type definitions sent with the body go ahead of the next message,
+// as the encoder has recorded them sent.
 func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	limit := c.limit
+	c.limit = -1
+	c.header.Reset()
+	c.body.Reset()
+	c.out.buf = &c.body
+	if err = c.enc.Encode(body); err != nil {
+		c.encBuf.Write(c.body.Bytes())
+		return &common.EncodeError{Err: err}
+	}
+	if size := int64(c.body.Len()); limit >= 0 && size > limit {
+		// the value is the last message.
+		c.encBuf.Write(c.body.Bytes()[:c.out.last])
+		return &common.EncodeError{Err: &common.TooLargeError{Size: size, Limit: limit}}
+	}
+	c.out.buf = &c.header
 	if err = c.enc.Encode(r); err != nil {
 		if c.encBuf.Flush() == nil {
 			// Gob couldn't encode the header. Should not happen, so if it does,
@@ -44,18 +92,19 @@ func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err e
 		}
 		return
 	}
-	if err = c.enc.Encode(body); err != nil {
-		if c.encBuf.Flush() == nil {
-			// Was a gob problem encoding the body but the header has been written.
-			// Shut down the connection to signal that the connection is broken.
-			log.Println("rpc: gob error encoding body:", err)
-			c.Close()
-		}
-		return
+	c.encBuf.Write(c.header.Bytes())
+	c.encBuf.Write(c.body.Bytes())
+	if c.body.Cap() > maxRetainedBody {
+		c.body = bytes.Buffer{}
 	}
 	return c.encBuf.Flush()
 }
 
+// ContentType returns the MIME type of gob.
+func (c *gobServerCodec) ContentType() string {
+	return "application/x-gob"
+}
+
 func (c *gobServerCodec) Close() error {
 	if c.closed {
 		// Only call c.rwc.Close once; otherwise the semantics are undefined.
@@ -67,6 +116,7 @@ func (c *gobServerCodec) Close() error {
 
 type gobClientCodec struct {
 	rwc    io.ReadWriteCloser
+	r      *bufio.Reader
 	dec    *gob.Decoder
 	enc    *gob.Encoder
 	encBuf *bufio.Writer
@@ -74,9 +124,11 @@ type gobClientCodec struct {
 
 func NewGobClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
 	encBuf := bufio.NewWriter(conn)
+	r := bufio.NewReader(conn) // gob reads the io.ByteReader without buffering it again
 	return &gobClientCodec{
 		rwc:    conn,
-		dec:    gob.NewDecoder(conn),
+		r:      r,
+		dec:    gob.NewDecoder(r),
 		enc:    gob.NewEncoder(encBuf),
 		encBuf: encBuf,
 	}
@@ -100,6 +152,11 @@ func (c *gobClientCodec) ReadResponseBody(body interface{}) error {
 	return c.dec.Decode(body)
 }
 
+// Buffered returns the bytes read ahead of the current response.
+func (c *gobClientCodec) Buffered() int {
+	return c.r.Buffered()
+}
+
 func (c *gobClientCodec) Close() error {
 	return c.rwc.Close()
 }
@@ -1,11 +1,52 @@
 package jsonrpc
 
 import (
+	"encoding/json"
+	"io"
+	"net/rpc"
 	"net/rpc/jsonrpc"
+
+	"github.com/henrylee2cn/myrpc/common"
 )
 
-// NewJSONRPCServerCodec creates a RPC-JSON 2.0 ServerCodec
-var NewJSONRPCServerCodec = jsonrpc.NewServerCodec
+type serverCodec struct {
+	rpc.ServerCodec
+	limit int64 // of the next body, see LimitResponse
+}
+
+// NewJSONRPCServerCodec creates a RPC-JSON 2.0 ServerCodec.
+// The arguments and the replies may be schema-less, e.g. map[string]interface{} or json.RawMessage.
+func NewJSONRPCServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	return &serverCodec{ServerCodec: jsonrpc.NewServerCodec(conn), limit: -1}
+}
+
+// LimitResponse limits the encoded body of the next response, see common.ResponseLimiter.
+func (c *serverCodec) LimitResponse(n int64) {
+	c.limit = n
+}
+
+// WriteResponse marshals the body before writing, so that a body failing to marshal
+// or exceeding the limit is reported by *common.EncodeError and the response can still be written.
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	limit := c.limit
+	c.limit = -1
+	if r.Error == "" {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return &common.EncodeError{Err: err}
+		}
+		if size := int64(len(b)); limit >= 0 && size > limit {
+			return &common.EncodeError{Err: &common.TooLargeError{Size: size, Limit: limit}}
+		}
+		body = json.RawMessage(b)
+	}
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// ContentType returns the MIME type of JSON.
+func (c *serverCodec) ContentType() string {
+	return "application/json"
+}
 
 // NewJSONRPCClientCodec creates a RPC-JSON 2.0 ClientCodec
 var NewJSONRPCClientCodec = jsonrpc.NewClientCodec
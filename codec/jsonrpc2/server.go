@@ -58,6 +58,11 @@ func NewServerCodec(conn io.ReadWriteCloser, srv *rpc.Server) rpc.ServerCodec {
 	}
 }
 
+// ContentType returns the MIME type of JSON.
+func (c *serverCodec) ContentType() string {
+	return "application/json"
+}
+
 type serverRequest struct {
 	Version string           `json:"jsonrpc"`
 	Method  string           `json:"method"`
@@ -0,0 +1,187 @@
+// Package noop provides a codec without serialization work,
+// which is a baseline for measuring the dispatch and framing overhead of the RPC machinery.
+//
+// Every body is a fixed-size Message that is copied as is.
+// Headers are framed as the sequence number followed by the length-prefixed service method
+// (and the length-prefixed error of a response).
+package noop
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/rpc"
+)
+
+// Size is the size of every message body.
+const Size = 64
+
+// Message is the body of the noop codec.
+type Message [Size]byte
+
+var (
+	errBody   = errors.New("noop: body must be *noop.Message")
+	errString = errors.New("noop: string too long")
+)
+
+type conn struct {
+	rwc  io.ReadWriteCloser
+	r    *bufio.Reader
+	w    *bufio.Writer
+	buf  [8]byte
+	body Message // scratch buffer for the bodies that are not *Message
+}
+
+func newConn(rwc io.ReadWriteCloser) conn {
+	return conn{
+		rwc: rwc,
+		r:   bufio.NewReader(rwc),
+		w:   bufio.NewWriter(rwc),
+	}
+}
+
+func (c *conn) writeUint64(v uint64) error {
+	binary.BigEndian.PutUint64(c.buf[:], v)
+	_, err := c.w.Write(c.buf[:8])
+	return err
+}
+
+func (c *conn) readUint64() (uint64, error) {
+	if _, err := io.ReadFull(c.r, c.buf[:8]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(c.buf[:8]), nil
+}
+
+func (c *conn) writeString(s string) error {
+	if len(s) > 0xffff {
+		return errString
+	}
+	binary.BigEndian.PutUint16(c.buf[:], uint16(len(s)))
+	if _, err := c.w.Write(c.buf[:2]); err != nil {
+		return err
+	}
+	_, err := c.w.WriteString(s)
+	return err
+}
+
+func (c *conn) readString() (string, error) {
+	if _, err := io.ReadFull(c.r, c.buf[:2]); err != nil {
+		return "", err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(c.buf[:2]))
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// writeBody writes the body, a body that is not *Message is written as zeros.
+func (c *conn) writeBody(body interface{}) error {
+	m, ok := body.(*Message)
+	if !ok {
+		c.body = Message{}
+		m = &c.body
+	}
+	if _, err := c.w.Write(m[:]); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// readBody reads the body, a nil body is discarded.
+func (c *conn) readBody(body interface{}) error {
+	m, ok := body.(*Message)
+	if !ok {
+		if body != nil {
+			io.ReadFull(c.r, c.body[:])
+			return errBody
+		}
+		m = &c.body
+	}
+	_, err := io.ReadFull(c.r, m[:])
+	return err
+}
+
+func (c *conn) Close() error {
+	return c.rwc.Close()
+}
+
+type serverCodec struct {
+	conn
+}
+
+// NewNoopServerCodec creates a noop ServerCodec.
+func NewNoopServerCodec(rwc io.ReadWriteCloser) rpc.ServerCodec {
+	return &serverCodec{newConn(rwc)}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) (err error) {
+	if r.Seq, err = c.readUint64(); err != nil {
+		return
+	}
+	r.ServiceMethod, err = c.readString()
+	return
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	return c.readBody(body)
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	if err := c.writeUint64(r.Seq); err != nil {
+		return err
+	}
+	if err := c.writeString(r.ServiceMethod); err != nil {
+		return err
+	}
+	if err := c.writeString(r.Error); err != nil {
+		return err
+	}
+	return c.writeBody(body)
+}
+
+// ContentType returns the MIME type of noop.
+func (c *serverCodec) ContentType() string {
+	return "application/octet-stream"
+}
+
+type clientCodec struct {
+	conn
+}
+
+// NewNoopClientCodec creates a noop ClientCodec.
+func NewNoopClientCodec(rwc io.ReadWriteCloser) rpc.ClientCodec {
+	return &clientCodec{newConn(rwc)}
+}
+
+// Buffered returns the bytes read ahead of the current response.
+func (c *clientCodec) Buffered() int {
+	return c.r.Buffered()
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	if err := c.writeUint64(r.Seq); err != nil {
+		return err
+	}
+	if err := c.writeString(r.ServiceMethod); err != nil {
+		return err
+	}
+	return c.writeBody(body)
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) (err error) {
+	if r.Seq, err = c.readUint64(); err != nil {
+		return
+	}
+	if r.ServiceMethod, err = c.readString(); err != nil {
+		return
+	}
+	r.Error, err = c.readString()
+	return
+}
+
+func (c *clientCodec) ReadResponseBody(body interface{}) error {
+	return c.readBody(body)
+}
@@ -0,0 +1,85 @@
+package noop
+
+import (
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type echo struct{}
+
+func (*echo) Echo(arg *Message, reply *Message) error {
+	*reply = *arg
+	return nil
+}
+
+func serve(tb testing.TB) *client.Client {
+	s := server.NewServer(server.Server{ServerCodecFunc: NewNoopServerCodec})
+	s.NamedRegister("echo", new(echo))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go s.ServeListener(lis)
+	return client.NewClient(
+		client.Client{ClientCodecFunc: NewNoopClientCodec},
+		&selector.DirectSelector{Network: "tcp", Address: lis.Addr().String()},
+	)
+}
+
+func TestNoopCodec(t *testing.T) {
+	c := serve(t)
+	defer c.Close()
+
+	var arg, reply Message
+	copy(arg[:], "hello")
+	if e := c.Call("/echo/echo", &arg, &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	if reply != arg {
+		t.Fatalf("reply: %q", reply[:])
+	}
+	if e := c.Call("/echo/unknown", &arg, &reply); e == nil {
+		t.Fatal("expect not found error")
+	}
+	// the connection is still usable after an error response.
+	if e := c.Call("/echo/echo", &arg, &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+}
+
+// BenchmarkDispatch measures the dispatch-only throughput of the RPC machinery.
+func BenchmarkDispatch(b *testing.B) {
+	c := serve(b)
+	defer c.Close()
+	var arg, reply Message
+	if e := c.Call("/echo/echo", &arg, &reply); e != nil {
+		b.Fatal(e.Error)
+	}
+
+	procs := runtime.GOMAXPROCS(-1)
+	n := int32(b.N)
+	var wg sync.WaitGroup
+	wg.Add(procs)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for p := 0; p < procs; p++ {
+		go func() {
+			defer wg.Done()
+			var arg, reply Message
+			for atomic.AddInt32(&n, -1) >= 0 {
+				if e := c.Call("/echo/echo", &arg, &reply); e != nil {
+					b.Error(e.Error)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,147 @@
+// Package oneof provides a reply that holds one of a set of registered concrete types,
+// so that a service method can return different types depending on its input.
+// It works with the codecs based on gob or JSON.
+package oneof
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+type (
+	// Types is the registry of the concrete types that a OneOf may hold.
+	Types struct {
+		types map[string]reflect.Type
+	}
+
+	// OneOf holds a value of one of the registered types.
+	// The server sets the Value, and the client decodes it into the matching concrete type
+	// by the registry of the OneOf, then uses a type switch to discriminate it.
+	OneOf struct {
+		Value interface{}
+		types *Types
+	}
+
+	jsonOneOf struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	}
+)
+
+// NewTypes creates a registry of the types of the given values.
+func NewTypes(values ...interface{}) *Types {
+	t := &Types{types: make(map[string]reflect.Type, len(values))}
+	for _, v := range values {
+		typ := reflect.TypeOf(v)
+		t.types[tagOf(typ)] = typ
+	}
+	return t
+}
+
+// New creates a OneOf that decodes by the registry.
+func (t *Types) New() *OneOf {
+	return &OneOf{types: t}
+}
+
+// tagOf returns the type tag of typ.
+func tagOf(typ reflect.Type) string {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.String()
+}
+
+// Set sets the value.
+func (o *OneOf) Set(value interface{}) {
+	o.Value = value
+}
+
+// newValue returns a new value of the type registered by tag.
+func (o *OneOf) newValue(tag string) (reflect.Value, error) {
+	if o.types == nil {
+		return reflect.Value{}, errors.New("oneof: no registered types")
+	}
+	typ, ok := o.types.types[tag]
+	if !ok {
+		return reflect.Value{}, errors.New("oneof: unregistered type '" + tag + "'")
+	}
+	if typ.Kind() == reflect.Ptr {
+		return reflect.New(typ.Elem()), nil
+	}
+	return reflect.New(typ), nil
+}
+
+// setValue stores the decoded value v in its registered form.
+func (o *OneOf) setValue(tag string, v reflect.Value) {
+	if o.types.types[tag].Kind() == reflect.Ptr {
+		o.Value = v.Interface()
+	} else {
+		o.Value = v.Elem().Interface()
+	}
+}
+
+// GobEncode encodes the type tag with the value.
+func (o *OneOf) GobEncode() ([]byte, error) {
+	if o.Value == nil {
+		return nil, errors.New("oneof: nil value")
+	}
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(tagOf(reflect.TypeOf(o.Value))); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(o.Value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode decodes the value into the type registered by the type tag.
+func (o *OneOf) GobDecode(b []byte) error {
+	dec := gob.NewDecoder(bytes.NewReader(b))
+	var tag string
+	if err := dec.Decode(&tag); err != nil {
+		return err
+	}
+	v, err := o.newValue(tag)
+	if err != nil {
+		return err
+	}
+	if err = dec.Decode(v.Interface()); err != nil {
+		return err
+	}
+	o.setValue(tag, v)
+	return nil
+}
+
+// MarshalJSON encodes the type tag with the value.
+func (o *OneOf) MarshalJSON() ([]byte, error) {
+	if o.Value == nil {
+		return nil, errors.New("oneof: nil value")
+	}
+	b, err := json.Marshal(o.Value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonOneOf{Type: tagOf(reflect.TypeOf(o.Value)), Value: b})
+}
+
+// UnmarshalJSON decodes the value into the type registered by the type tag.
+func (o *OneOf) UnmarshalJSON(b []byte) error {
+	var j jsonOneOf
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	v, err := o.newValue(j.Type)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(j.Value, v.Interface()); err != nil {
+		return err
+	}
+	o.setValue(j.Type, v)
+	return nil
+}
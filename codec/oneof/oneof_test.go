@@ -0,0 +1,72 @@
+package oneof
+
+import (
+	"net"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/codec/jsonrpc"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type Circle struct {
+	Radius int
+}
+
+type Square struct {
+	Side int
+}
+
+type shapes struct{}
+
+func (*shapes) Get(arg int, reply *OneOf) error {
+	if arg%2 == 0 {
+		reply.Set(&Circle{Radius: arg})
+	} else {
+		reply.Set(Square{Side: arg})
+	}
+	return nil
+}
+
+var shapeTypes = NewTypes(new(Circle), Square{})
+
+func testOneOf(t *testing.T, serverCodecFunc server.ServerCodecFunc, clientCodecFunc client.ClientCodecFunc) {
+	s := server.NewServer(server.Server{ServerCodecFunc: serverCodecFunc})
+	s.NamedRegister("shapes", new(shapes))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeListener(lis)
+
+	cli := client.NewClient(client.Client{ClientCodecFunc: clientCodecFunc}, &selector.DirectSelector{Network: "tcp", Address: lis.Addr().String()})
+	defer cli.Close()
+
+	for arg := 2; arg <= 3; arg++ {
+		reply := shapeTypes.New()
+		if e := cli.Call("/shapes/get", arg, reply); e != nil {
+			t.Fatal(e.Error)
+		}
+		switch v := reply.Value.(type) {
+		case *Circle:
+			if arg != 2 || v.Radius != 2 {
+				t.Fatalf("arg %d: unexpected %#v", arg, v)
+			}
+		case Square:
+			if arg != 3 || v.Side != 3 {
+				t.Fatalf("arg %d: unexpected %#v", arg, v)
+			}
+		default:
+			t.Fatalf("arg %d: unexpected type %T", arg, v)
+		}
+	}
+}
+
+func TestOneOfGob(t *testing.T) {
+	testOneOf(t, nil, nil)
+}
+
+func TestOneOfJSON(t *testing.T) {
+	testOneOf(t, jsonrpc.NewJSONRPCServerCodec, jsonrpc.NewJSONRPCClientCodec)
+}
@@ -0,0 +1,158 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// bootstrapLen is the capacity of a new buffer, which holds the small messages.
+	bootstrapLen = 128
+	// maxRetainedBuffer limits the buffer returned to the pool, so that a large message
+	// doesn't pin its memory.
+	maxRetainedBuffer = 64 << 10
+)
+
+// bufferPool holds the scratch buffers of marshaling and unmarshaling shared by the connections.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return &buffer{b: make([]byte, 0, bootstrapLen)} },
+}
+
+type buffer struct {
+	b  []byte
+	pb *proto.Buffer // marshals the messages without MarshalTo into b
+}
+
+func getBuffer() *buffer {
+	return bufferPool.Get().(*buffer)
+}
+
+func putBuffer(buf *buffer) {
+	if cap(buf.b) > maxRetainedBuffer {
+		return
+	}
+	buf.b = buf.b[:0]
+	bufferPool.Put(buf)
+}
+
+// sizedMarshaler is implemented by the messages generated by gogo/protobuf,
+// which marshal into the given buffer instead of a new one.
+type sizedMarshaler interface {
+	Size() int
+	MarshalTo(data []byte) (int, error)
+}
+
+// appendMessage appends the message to the buffer.
+func (buf *buffer) appendMessage(m proto.Message) error {
+	sm, ok := m.(sizedMarshaler)
+	if !ok {
+		if buf.pb == nil {
+			buf.pb = new(proto.Buffer)
+		}
+		buf.pb.SetBuf(buf.b)
+		err := buf.pb.Marshal(m)
+		buf.b = buf.pb.Bytes()
+		buf.pb.SetBuf(nil)
+		return err
+	}
+	size := sm.Size()
+	data := buf.b
+	if cap(data)-len(data) < size {
+		data = make([]byte, len(buf.b), 2*cap(buf.b)+size)
+		copy(data, buf.b)
+	}
+	n, err := sm.MarshalTo(data[len(data) : len(data)+size])
+	buf.b = data[:len(data)+n]
+	return err
+}
+
+// appendFrame appends the message prefixed by its size in uvarint,
+// the wire format of https://github.com/henrylee2cn/codec_protobuf.
+func (buf *buffer) appendFrame(m interface{}) error {
+	pb, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T does not implement proto.Message", m)
+	}
+	start := len(buf.b)
+	// reserve the room of the longest size, the message is moved next to the size then.
+	buf.b = append(buf.b, make([]byte, binary.MaxVarintLen64)...)
+	if err := buf.appendMessage(pb); err != nil {
+		buf.b = buf.b[:start]
+		return err
+	}
+	msg := buf.b[start+binary.MaxVarintLen64:]
+	n := binary.PutUvarint(buf.b[start:], uint64(len(msg)))
+	copy(buf.b[start+n:], msg)
+	buf.b = buf.b[:start+n+len(msg)]
+	return nil
+}
+
+// appendHeaderFrame appends the frame of wirepb.RequestHeader or wirepb.ResponseHeader,
+// encoded by hand instead of by the reflection of golang/protobuf.
+func (buf *buffer) appendHeaderFrame(method string, seq uint64, errmsg string) {
+	var size int
+	if method != "" {
+		size += 1 + uvarintLen(uint64(len(method))) + len(method)
+	}
+	if seq != 0 {
+		size += 1 + uvarintLen(seq)
+	}
+	if errmsg != "" {
+		size += 1 + uvarintLen(uint64(len(errmsg))) + len(errmsg)
+	}
+	b := binary.AppendUvarint(buf.b, uint64(size))
+	if method != "" {
+		b = append(b, 1<<3|proto.WireBytes)
+		b = binary.AppendUvarint(b, uint64(len(method)))
+		b = append(b, method...)
+	}
+	if seq != 0 {
+		b = append(b, 2<<3|proto.WireVarint)
+		b = binary.AppendUvarint(b, seq)
+	}
+	if errmsg != "" {
+		b = append(b, 3<<3|proto.WireBytes)
+		b = binary.AppendUvarint(b, uint64(len(errmsg)))
+		b = append(b, errmsg...)
+	}
+	buf.b = b
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for ; v >= 0x80; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// decodeReader reads the frames.
+type decodeReader interface {
+	io.ByteReader
+	io.Reader
+}
+
+// readFrame reads the message of the next frame into m, nil m discards the message.
+func readFrame(r decodeReader, m proto.Message) error {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if uint64(cap(buf.b)) < size {
+		buf.b = make([]byte, size)
+	}
+	buf.b = buf.b[:size]
+	if _, err = io.ReadFull(r, buf.b); err != nil {
+		return err
+	}
+	if m == nil {
+		return nil
+	}
+	return proto.Unmarshal(buf.b, m)
+}
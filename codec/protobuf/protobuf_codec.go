@@ -1,18 +1,181 @@
 package protobuf
 
 import (
+	"bufio"
+	"fmt"
 	"io"
 	"net/rpc"
+	"reflect"
+	"sync"
 
-	codec "github.com/henrylee2cn/codec_protobuf"
+	"github.com/golang/protobuf/proto"
+	"github.com/henrylee2cn/codec_protobuf/empty"
+	"github.com/henrylee2cn/codec_protobuf/wirepb"
+	"github.com/henrylee2cn/myrpc/common"
 )
 
-// NewProtobufServerCodec creates a protobuf ServerCodec by https://github.com/henrylee2cn/codec_protobuf
+const defaultBufferSize = 4 << 10
+
+var (
+	messageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+	emptyStruct = struct{}{}
+)
+
+type (
+	serverCodec struct {
+		rwc   io.ReadWriteCloser
+		r     *bufio.Reader
+		req   wirepb.RequestHeader
+		mu    sync.Mutex // serializes the writes
+		limit int64      // of the next body, see LimitResponse
+	}
+
+	clientCodec struct {
+		rwc  io.ReadWriteCloser
+		r    *bufio.Reader
+		resp wirepb.ResponseHeader
+		mu   sync.Mutex // serializes the writes
+	}
+)
+
+// NewProtobufServerCodec creates a protobuf ServerCodec, wire compatible with https://github.com/henrylee2cn/codec_protobuf.
+// The responses are marshaled into the buffers shared by the connections, see appendFrame.
 func NewProtobufServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
-	return codec.NewServerCodec(conn)
+	return &serverCodec{
+		rwc:   conn,
+		r:     bufio.NewReaderSize(conn, defaultBufferSize),
+		limit: -1,
+	}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	c.req.Reset()
+	if err := readFrame(c.r, &c.req); err != nil {
+		return err
+	}
+	r.ServiceMethod = c.req.Method
+	r.Seq = c.req.Seq
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	return readBody(c.r, body)
+}
+
+// LimitResponse limits the encoded body of the next response, see common.ResponseLimiter.
+func (c *serverCodec) LimitResponse(n int64) {
+	c.limit = n
+}
+
+// WriteResponse writes the header and the body frames at once, nothing is written if the body
+// fails to marshal or exceeds the limit, which is reported by *common.EncodeError.
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	limit := c.limit
+	c.limit = -1
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.appendHeaderFrame(r.ServiceMethod, r.Seq, r.Error)
+	header := len(buf.b)
+	if err := buf.appendFrame(emptyBody(body)); err != nil {
+		return &common.EncodeError{Err: err}
+	}
+	if size := int64(len(buf.b) - header); limit >= 0 && size > limit {
+		return &common.EncodeError{Err: &common.TooLargeError{Size: size, Limit: limit}}
+	}
+	c.mu.Lock()
+	_, err := c.rwc.Write(buf.b)
+	c.mu.Unlock()
+	return err
+}
+
+func (c *serverCodec) Close() error {
+	return c.rwc.Close()
+}
+
+// ContentType returns the MIME type of protobuf.
+func (c *serverCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+// CheckType returns an error naming the missing method if the type t doesn't implement
+// proto.Message, see server.ITypeChecker.
+func (c *serverCodec) CheckType(t reflect.Type) error {
+	if t.Implements(messageType) {
+		return nil
+	}
+	for i := 0; i < messageType.NumMethod(); i++ {
+		name := messageType.Method(i).Name
+		if _, ok := t.MethodByName(name); !ok {
+			return fmt.Errorf("protobuf: %s does not implement proto.Message: missing method %s", t, name)
+		}
+	}
+	return fmt.Errorf("protobuf: %s does not implement proto.Message: wrong signature of the methods", t)
 }
 
-// NewProtobufClientCodec creates a protobuf ClientCodec by https://github.com/henrylee2cn/codec_protobuf
+// NewProtobufClientCodec creates a protobuf ClientCodec, wire compatible with https://github.com/henrylee2cn/codec_protobuf.
+// The requests are marshaled into the buffers shared by the connections, see appendFrame.
 func NewProtobufClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
-	return codec.NewClientCodec(conn)
+	return &clientCodec{
+		rwc: conn,
+		r:   bufio.NewReaderSize(conn, defaultBufferSize),
+	}
+}
+
+// WriteRequest writes the header and the body frames at once.
+func (c *clientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.appendHeaderFrame(r.ServiceMethod, r.Seq, "")
+	if err := buf.appendFrame(emptyBody(body)); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	_, err := c.rwc.Write(buf.b)
+	c.mu.Unlock()
+	return err
+}
+
+// Buffered returns the bytes read ahead of the current response.
+func (c *clientCodec) Buffered() int {
+	return c.r.Buffered()
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	c.resp.Reset()
+	if err := readFrame(c.r, &c.resp); err != nil {
+		return err
+	}
+	r.ServiceMethod = c.resp.Method
+	r.Seq = c.resp.Seq
+	r.Error = c.resp.Error
+	return nil
+}
+
+func (c *clientCodec) ReadResponseBody(body interface{}) error {
+	return readBody(c.r, body)
+}
+
+func (c *clientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+// emptyBody returns the empty message in place of the missing body.
+func emptyBody(body interface{}) interface{} {
+	if body == nil || body == emptyStruct {
+		return empty.Empty
+	}
+	return body
+}
+
+// readBody reads the body frame into the body, nil body discards it.
+func readBody(r decodeReader, body interface{}) error {
+	if body == nil {
+		return readFrame(r, nil)
+	}
+	pb, ok := body.(proto.Message)
+	if !ok {
+		readFrame(r, nil)
+		return fmt.Errorf("%T does not implement proto.Message", body)
+	}
+	return readFrame(r, pb)
 }
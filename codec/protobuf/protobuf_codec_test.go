@@ -1,11 +1,18 @@
 package protobuf
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"sync"
 	"testing"
-	"time"
 
-	"github.com/henrylee2cn/myrpc"
-	"github.com/henrylee2cn/myrpc/codec"
+	codec "github.com/henrylee2cn/codec_protobuf"
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/server"
 )
 
 type ProtoArith int
@@ -19,30 +26,144 @@ func (t *ProtoArith) Error(args *ProtoArgs, reply *ProtoReply) error {
 	panic("ERROR")
 }
 
-func TestProtobufCodec(t *testing.T) {
-	// server
-	server := myrpc.NewServer(60e9, 0, 0, NewProtobufServerCodec)
-	group, _ := server.Group(codec.ServiceGroup)
-	err := group.NamedRegister(codec.ServiceName, new(ProtoArith))
+func serve(t *testing.T) string {
+	srv := server.NewServer(server.Server{ServerCodecFunc: NewProtobufServerCodec})
+	srv.NamedRegister("arith", new(ProtoArith))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatal(err)
 	}
-	go server.ListenAndServe(codec.Network, codec.ServerAddr)
-	time.Sleep(2e9)
+	go srv.ServeListener(lis)
+	return lis.Addr().String()
+}
+
+func newClient(addr string) *client.Client {
+	return client.NewClient(
+		client.Client{ClientCodecFunc: NewProtobufClientCodec},
+		&selector.DirectSelector{Network: "tcp", Address: addr},
+	)
+}
+
+func TestProtobufCodec(t *testing.T) {
+	c := newClient(serve(t))
+	defer c.Close()
 
-	// client
-	var args = &ProtoArgs{7, 8}
+	args := &ProtoArgs{A: 7, B: 8}
 	var reply ProtoReply
+	if e := c.Call("/arith/mul", args, &reply); e != nil {
+		t.Fatalf("error for Arith: %d*%d, %v", args.A, args.B, e.Error)
+	}
+	if reply.C != 56 {
+		t.Fatalf("Arith: %d*%d=%d", args.A, args.B, reply.C)
+	}
+	if e := c.Call("/arith/error", args, &reply); e == nil {
+		t.Fatal("expect the error of the panic")
+	}
+}
 
-	err = myrpc.
-		NewDialer(codec.Network, codec.ServerAddr, NewProtobufClientCodec).
-		Remote(func(client myrpc.IClient) error {
-			return client.Call(codec.ServiceMethodName, args, &reply)
-		})
+// TestProtobufCodecConcurrency checks the connections sharing the buffers don't see the messages of each other.
+func TestProtobufCodecConcurrency(t *testing.T) {
+	addr := serve(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int32) {
+			defer wg.Done()
+			c := newClient(addr)
+			defer c.Close()
+			for j := int32(0); j < 200; j++ {
+				var reply ProtoReply
+				if e := c.Call("/arith/mul", &ProtoArgs{A: i, B: j}, &reply); e != nil {
+					t.Error(e.Error)
+					return
+				}
+				if reply.C != i*j {
+					t.Errorf("%d*%d=%d", i, j, reply.C)
+					return
+				}
+			}
+		}(int32(i))
+	}
+	wg.Wait()
+}
 
-	if err != nil {
-		t.Errorf("error for Arith: %d*%d, %v \n", args.A, args.B, err)
-	} else {
-		t.Logf("Arith: %d*%d=%d \n", args.A, args.B, reply.C)
+// bufferConn is a loopback connection of a buffer.
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (*bufferConn) Close() error { return nil }
+
+// TestWireCompatibility checks the codec speaks with https://github.com/henrylee2cn/codec_protobuf.
+func TestWireCompatibility(t *testing.T) {
+	conn := new(bufferConn)
+	for _, pair := range []struct {
+		name   string
+		client rpc.ClientCodec
+		server rpc.ServerCodec
+	}{
+		{"codec_protobuf client", codec.NewClientCodec(conn), NewProtobufServerCodec(conn)},
+		{"codec_protobuf server", NewProtobufClientCodec(conn), codec.NewServerCodec(conn)},
+	} {
+		req := rpc.Request{ServiceMethod: "/arith/mul", Seq: 1 << 40}
+		if err := pair.client.WriteRequest(&req, &ProtoArgs{A: 7, B: 8}); err != nil {
+			t.Fatalf("%s: %v", pair.name, err)
+		}
+		var gotReq rpc.Request
+		var args ProtoArgs
+		if err := pair.server.ReadRequestHeader(&gotReq); err != nil || gotReq != req {
+			t.Fatalf("%s: request header %+v, %v", pair.name, gotReq, err)
+		}
+		if err := pair.server.ReadRequestBody(&args); err != nil || args.A != 7 || args.B != 8 {
+			t.Fatalf("%s: request body %+v, %v", pair.name, args, err)
+		}
+
+		resp := rpc.Response{ServiceMethod: req.ServiceMethod, Seq: req.Seq, Error: "oops"}
+		if err := pair.server.WriteResponse(&resp, &ProtoReply{C: 56}); err != nil {
+			t.Fatalf("%s: %v", pair.name, err)
+		}
+		var gotResp rpc.Response
+		var reply ProtoReply
+		if err := pair.client.ReadResponseHeader(&gotResp); err != nil || gotResp != resp {
+			t.Fatalf("%s: response header %+v, %v", pair.name, gotResp, err)
+		}
+		if err := pair.client.ReadResponseBody(&reply); err != nil || reply.C != 56 {
+			t.Fatalf("%s: response body %+v, %v", pair.name, reply, err)
+		}
+		if conn.Len() > 0 {
+			t.Fatalf("%s: %d bytes left", pair.name, conn.Len())
+		}
+	}
+}
+
+// discardConn discards the written bytes.
+type discardConn struct{}
+
+func (discardConn) Read(p []byte) (int, error)  { return 0, io.EOF }
+func (discardConn) Write(p []byte) (int, error) { return ioutil.Discard.Write(p) }
+func (discardConn) Close() error                { return nil }
+
+// BenchmarkWriteResponse compares the pooled buffers of the codec with
+// https://github.com/henrylee2cn/codec_protobuf, run with -benchmem.
+func BenchmarkWriteResponse(b *testing.B) {
+	for _, bm := range []struct {
+		name     string
+		newCodec func(io.ReadWriteCloser) rpc.ServerCodec
+	}{
+		{"pooled", NewProtobufServerCodec},
+		{"codec_protobuf", codec.NewServerCodec},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			c := bm.newCodec(discardConn{})
+			resp := &rpc.Response{ServiceMethod: "/arith/mul"}
+			reply := &ProtoReply{C: 56}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				resp.Seq = uint64(i)
+				if err := c.WriteResponse(resp, reply); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
 	}
 }
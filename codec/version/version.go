@@ -0,0 +1,200 @@
+// Package version provides a codec wrapper that tags every request with a schema version,
+// so that old and new clients can coexist during a staged rollout.
+//
+// The version is carried by the frame of every request, ahead of the bytes of the wrapped codec,
+// and the server routes each version to the services registered in the group Prefix(N):
+//
+//	srv.Group(version.Prefix(1)).NamedRegister("arith", new(ArithV1))
+//	srv.Group(version.Prefix(2)).NamedRegister("arith", new(ArithV2))
+//
+// The server wrapper routes every request by the version of its frame, so the services
+// must be registered in the groups of the versions, and the clients must wrap their codecs.
+package version
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+const frameHeaderSize = 5 // version(1) + length(4)
+
+// Prefix returns the service group prefix of the version.
+func Prefix(v uint8) string {
+	return "v" + strconv.Itoa(int(v))
+}
+
+// NewClientCodecFunc returns a ClientCodec creator that tags every request with the version v.
+func NewClientCodecFunc(v uint8, fn func(io.ReadWriteCloser) rpc.ClientCodec) func(io.ReadWriteCloser) rpc.ClientCodec {
+	return func(conn io.ReadWriteCloser) rpc.ClientCodec {
+		c := &clientCodec{rwc: conn, version: v}
+		c.ClientCodec = fn(&requestWriter{ReadWriteCloser: conn, buf: &c.buf})
+		return c
+	}
+}
+
+type clientCodec struct {
+	rpc.ClientCodec
+	rwc     io.ReadWriteCloser
+	version uint8
+	buf     bytes.Buffer // the request being written by the wrapped codec
+	sending sync.Mutex
+}
+
+// requestWriter reads the connection, and buffers the request written by the wrapped codec.
+type requestWriter struct {
+	io.ReadWriteCloser
+	buf *bytes.Buffer
+}
+
+func (w *requestWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+// Buffered returns the bytes read ahead by the wrapped codec.
+func (c *clientCodec) Buffered() int {
+	if b, ok := c.ClientCodec.(interface{ Buffered() int }); ok {
+		return b.Buffered()
+	}
+	return 0
+}
+
+// WriteRequest writes the request of the wrapped codec in a frame tagged with the version.
+func (c *clientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	c.buf.Reset()
+	c.buf.Write(make([]byte, frameHeaderSize))
+	if err := c.ClientCodec.WriteRequest(r, body); err != nil {
+		return err
+	}
+	frame := c.buf.Bytes()
+	frame[0] = c.version
+	binary.BigEndian.PutUint32(frame[1:frameHeaderSize], uint32(len(frame)-frameHeaderSize))
+	_, err := c.rwc.Write(frame)
+	return err
+}
+
+// NewServerCodecFunc returns a ServerCodec creator that accepts the given versions.
+// Requests tagged with any other version are rejected with an error response.
+func NewServerCodecFunc(fn func(io.ReadWriteCloser) rpc.ServerCodec, versions ...uint8) func(io.ReadWriteCloser) rpc.ServerCodec {
+	var accepted [256]bool
+	names := make([]string, len(versions))
+	for i, v := range versions {
+		accepted[v] = true
+		names[i] = Prefix(v)
+	}
+	supported := strings.Join(names, ", ")
+	return func(conn io.ReadWriteCloser) rpc.ServerCodec {
+		r := &frameReader{ReadWriteCloser: conn}
+		return &serverCodec{
+			ServerCodec: fn(r),
+			frames:      r,
+			accepted:    &accepted,
+			supported:   supported,
+		}
+	}
+}
+
+type serverCodec struct {
+	rpc.ServerCodec
+	frames    *frameReader
+	accepted  *[256]bool
+	supported string
+	sending   sync.Mutex
+}
+
+// frameReader hands the wrapped codec the requests without their frame headers.
+// The reads stop at the end of the frame, so the version is the one of the request being read.
+type frameReader struct {
+	io.ReadWriteCloser
+	head    [frameHeaderSize]byte
+	version uint8
+	remain  uint32 // the unread bytes of the current frame
+}
+
+// next reads the header of the next frame.
+func (f *frameReader) next() error {
+	for f.remain == 0 {
+		if _, err := io.ReadFull(f.ReadWriteCloser, f.head[:]); err != nil {
+			return err
+		}
+		f.version = f.head[0]
+		f.remain = binary.BigEndian.Uint32(f.head[1:])
+	}
+	return nil
+}
+
+func (f *frameReader) Read(b []byte) (int, error) {
+	if err := f.next(); err != nil {
+		return 0, err
+	}
+	if uint32(len(b)) > f.remain {
+		b = b[:f.remain]
+	}
+	n, err := f.ReadWriteCloser.Read(b)
+	f.remain -= uint32(n)
+	if err == io.EOF && f.remain > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// ReadByte implements io.ByteReader, so that gob doesn't buffer the reads ahead.
+func (f *frameReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(f, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// ReadRequestHeader routes the request to the group of the version of its frame.
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	for {
+		if err := c.ServerCodec.ReadRequestHeader(r); err != nil {
+			return err
+		}
+		v := c.frames.version
+		if c.accepted[v] {
+			serviceMethod := r.ServiceMethod
+			if !strings.HasPrefix(serviceMethod, "/") {
+				serviceMethod = "/" + serviceMethod
+			}
+			r.ServiceMethod = "/" + Prefix(v) + serviceMethod
+			return nil
+		}
+		// discard the body, whose schema is unknown.
+		if err := c.ServerCodec.ReadRequestBody(nil); err != nil {
+			return err
+		}
+		errMsg := fmt.Sprintf("rpc: unsupported schema version v%d (supported: %s)", v, c.supported)
+		resp := &rpc.Response{
+			ServiceMethod: r.ServiceMethod,
+			Seq:           r.Seq,
+			Error:         string(rune(common.ErrorTypeServerInvalidServiceMethod)) + errMsg,
+		}
+		if err := c.WriteResponse(resp, struct{}{}); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// ContentType returns the content type of the wrapped codec.
+func (c *serverCodec) ContentType() string {
+	return server.ContentType(c.ServerCodec)
+}
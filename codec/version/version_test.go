@@ -0,0 +1,91 @@
+package version
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	codecGob "github.com/henrylee2cn/myrpc/codec/gob"
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type UserV1 struct {
+	Name string
+}
+
+type UserV2 struct {
+	FirstName string
+	LastName  string
+}
+
+type usersV1 struct{}
+
+func (*usersV1) Greet(arg UserV1, reply *string) error {
+	*reply = "v1: hello " + arg.Name
+	return nil
+}
+
+type usersV2 struct{}
+
+func (*usersV2) Greet(arg UserV2, reply *string) error {
+	*reply = "v2: hello " + arg.FirstName + " " + arg.LastName
+	return nil
+}
+
+func TestVersion(t *testing.T) {
+	s := server.NewServer(server.Server{
+		ServerCodecFunc: NewServerCodecFunc(codecGob.NewGobServerCodec, 1, 2),
+	})
+	s.Group(Prefix(1)).NamedRegister("users", new(usersV1))
+	s.Group(Prefix(2)).NamedRegister("users", new(usersV2))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeListener(lis)
+
+	newClient := func(v uint8) *client.Client {
+		return client.NewClient(
+			client.Client{ClientCodecFunc: NewClientCodecFunc(v, codecGob.NewGobClientCodec)},
+			&selector.DirectSelector{Network: "tcp", Address: lis.Addr().String()},
+		)
+	}
+
+	c1 := newClient(1)
+	defer c1.Close()
+	var reply string
+	if e := c1.Call("/users/greet", UserV1{Name: "Henry"}, &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	if reply != "v1: hello Henry" {
+		t.Fatalf("v1 reply: %q", reply)
+	}
+
+	c2 := newClient(2)
+	defer c2.Close()
+	if e := c2.Call("/users/greet", UserV2{FirstName: "Henry", LastName: "Lee"}, &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	if reply != "v2: hello Henry Lee" {
+		t.Fatalf("v2 reply: %q", reply)
+	}
+	// the version comes from the frame only, a path that looks versioned is not rerouted.
+	e := c2.Call("/v1/users/greet", UserV1{Name: "Henry"}, &reply)
+	if e == nil || e.Type != common.ErrorTypeServerNotFoundService {
+		t.Fatalf("v2 calling /v1/users/greet: expect not found, got: %v", e)
+	}
+
+	c3 := newClient(3)
+	defer c3.Close()
+	e = c3.Call("/users/greet", UserV2{FirstName: "Henry"}, &reply)
+	if e == nil || e.Type != common.ErrorTypeServerInvalidServiceMethod || !strings.Contains(e.Error, "unsupported schema version v3") {
+		t.Fatalf("v3: expect unsupported version error, got: %v", e)
+	}
+	// the connection is still usable after the rejection.
+	if e := c3.Call("/users/greet", UserV2{FirstName: "Henry"}, &reply); e == nil {
+		t.Fatal("v3: expect unsupported version error again")
+	}
+}
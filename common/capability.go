@@ -0,0 +1,55 @@
+package common
+
+import (
+	"sort"
+	"strings"
+)
+
+// CapabilitiesKey is the metadata key that carries the capabilities,
+// which both sides advertise once per connection.
+const CapabilitiesKey = "_caps"
+
+// Common capability features.
+const (
+	FeatureStream   = "stream"
+	FeatureFlate    = "flate"
+	FeatureSnappy   = "snappy"
+	FeatureLZ4      = "lz4"
+	FeatureProgress = "progress"
+	// FeatureStreamReply means the client receives the chunks of the replies streamed as io.Reader.
+	FeatureStreamReply = "stream-reply"
+)
+
+// Capabilities is the set of features supported by a peer.
+type Capabilities map[string]bool
+
+// NewCapabilities creates Capabilities of the features.
+func NewCapabilities(features ...string) Capabilities {
+	c := make(Capabilities, len(features))
+	for _, f := range features {
+		if f = strings.TrimSpace(f); f != "" {
+			c[f] = true
+		}
+	}
+	return c
+}
+
+// ParseCapabilities parses the comma-separated features.
+func ParseCapabilities(s string) Capabilities {
+	return NewCapabilities(strings.Split(s, ",")...)
+}
+
+// Supports returns whether the feature is supported.
+func (c Capabilities) Supports(feature string) bool {
+	return c[feature]
+}
+
+// String returns the sorted comma-separated features.
+func (c Capabilities) String() string {
+	features := make([]string, 0, len(c))
+	for f := range c {
+		features = append(features, f)
+	}
+	sort.Strings(features)
+	return strings.Join(features, ",")
+}
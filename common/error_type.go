@@ -35,6 +35,15 @@ const (
 	ErrorTypeClientPreReadResponseBody
 	ErrorTypeClientReadResponseBody
 	ErrorTypeClientPostReadResponseBody
+	ErrorTypeClientTimeout
+	// ErrorTypeClientDecodeResponse means the response body is received but can't be decoded,
+	// unlike ErrorTypeClientReadResponseBody of the connection errors.
+	ErrorTypeClientDecodeResponse
+	// ErrorTypeClientAborted means the call is aborted by the client, e.g. for an emergency failover.
+	ErrorTypeClientAborted
+	// ErrorTypeClientStreamCancelled means the streamed reply is canceled by the ctx of the call,
+	// the connection is kept.
+	ErrorTypeClientStreamCancelled
 )
 
 // RPC Server error type codes.
@@ -51,6 +60,26 @@ const (
 	ErrorTypeServerService
 	ErrorTypeServerPreWriteResponse
 	ErrorTypeServerWriteResponse
+	ErrorTypeServerInterceptArg
+	ErrorTypeServerTimeout
+	ErrorTypeServerDraining
+	// ErrorTypeServerEncodeResponse means the server produced a reply it couldn't encode,
+	// unlike ErrorTypeServerWriteResponse of the connection errors.
+	ErrorTypeServerEncodeResponse
+	// ErrorTypeServerTooManyHops means the call has passed more servers than the MaxHops.
+	ErrorTypeServerTooManyHops
+	// ErrorTypeServerCallTooLarge means the request and the reply exceed the MaxCallBytes.
+	ErrorTypeServerCallTooLarge
+	// ErrorTypeServerBusy means the server runs the MaxGoroutines calls already.
+	ErrorTypeServerBusy
+	// ErrorTypeServerWrongShard means the connection is not labeled with the Shard of the server.
+	ErrorTypeServerWrongShard
+	// ErrorTypeServerProtocolVersion means the server doesn't speak the protocol version of the connection.
+	ErrorTypeServerProtocolVersion
+	// ErrorTypeServerNotEnabled means the FeatureFlag of the server disables the method for the call.
+	ErrorTypeServerNotEnabled
+	// ErrorTypeServerForbidden means the caller is not allowed to call the method, e.g. by an ACL plugin.
+	ErrorTypeServerForbidden
 )
 
 // ErrShutdown returns an error with message: 'connection is shut down'
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"errors"
 	"fmt"
 	"runtime"
 )
@@ -41,6 +42,8 @@ var (
 	ErrPreReadRequestBody = NewError("PreReadRequestBody(%s): %s")
 	// ErrPostReadRequestBody returns an error with message: 'PostReadRequestBody(+plugin name): +errMsg'
 	ErrPostReadRequestBody = NewError("PostReadRequestBody(%s): %s")
+	// ErrInterceptArg returns an error with message: 'InterceptArg(+plugin name): +errMsg'
+	ErrInterceptArg = NewError("InterceptArg(%s): %s")
 	// ErrPreWriteResponse returns an error with message: 'PreWriteResponse(+plugin name): +errMsg'
 	ErrPreWriteResponse = NewError("PreWriteResponse(%s): %s")
 	// ErrPostWriteResponse returns an error with message: 'PostWriteResponse(+plugin name): +errMsg'
@@ -98,7 +101,7 @@ func (e *Error) With(err error) error {
 
 // Return returns the actual error as it is
 func (e *Error) Return() error {
-	return fmt.Errorf(e.message)
+	return errors.New(e.message)
 }
 
 // Panic output the message and after panics
@@ -145,3 +148,32 @@ func (e *MultiError) Error() string {
 func NewMultiError(errors []error) *MultiError {
 	return &MultiError{errors: errors}
 }
+
+// EncodeError is returned by the WriteResponse of a ServerCodec if the body can't be encoded,
+// and nothing of the response is written, so that an error response can be written instead.
+type EncodeError struct {
+	Err error
+}
+
+// Error returns the message of the actual error
+func (e *EncodeError) Error() string {
+	return "encode response: " + e.Err.Error()
+}
+
+// ResponseLimiter is implemented by the ServerCodecs that encode the body of a response before writing it.
+// LimitResponse limits the encoded body of the next response to n bytes, a larger body is reported by
+// *EncodeError wrapping *TooLargeError and nothing of the response is written. n < 0 means no limit.
+type ResponseLimiter interface {
+	LimitResponse(n int64)
+}
+
+// TooLargeError reports the encoded size of a body exceeding the limit of a ResponseLimiter.
+type TooLargeError struct {
+	Size  int64
+	Limit int64
+}
+
+// Error returns the sizes.
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("body of %d bytes > %d", e.Size, e.Limit)
+}
@@ -0,0 +1,5 @@
+package common
+
+// HeartbeatPath is the reserved service path answered by every server with an empty reply,
+// unless a service is registered at it, e.g. to probe the liveness and latency of a backend.
+const HeartbeatPath = "/_heartbeat"
@@ -0,0 +1,33 @@
+package common
+
+import (
+	"context"
+	"strconv"
+)
+
+// HopsKey is the metadata key that carries the number of servers a call has passed,
+// to break the forwarding loops.
+const HopsKey = "_hops"
+
+type hopsKey struct{}
+
+// WithHops returns a copy of ctx carrying the hop count of the inbound call,
+// the calls made with it carry the count plus one.
+func WithHops(ctx context.Context, hops int) context.Context {
+	return context.WithValue(ctx, hopsKey{}, hops)
+}
+
+// HopsFrom returns the hop count carried by ctx, ok is false if ctx carries none.
+func HopsFrom(ctx context.Context) (hops int, ok bool) {
+	hops, ok = ctx.Value(hopsKey{}).(int)
+	return
+}
+
+// ParseHops parses the hop count in the metadata, 0 if invalid.
+func ParseHops(s string) int {
+	hops, err := strconv.Atoi(s)
+	if err != nil || hops < 0 {
+		return 0
+	}
+	return hops
+}
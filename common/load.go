@@ -0,0 +1,21 @@
+package common
+
+import "strconv"
+
+// LoadKey is the response metadata key that carries the load the server reports,
+// see server.Server.LoadReporter.
+const LoadKey = "_load"
+
+// FormatLoad formats the load for the response metadata.
+func FormatLoad(load float64) string {
+	return strconv.FormatFloat(load, 'g', 4, 64)
+}
+
+// ParseLoad parses the load of the response metadata, ok is false for a missing or invalid load.
+func ParseLoad(s string) (load float64, ok bool) {
+	load, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(load >= 0) {
+		return 0, false
+	}
+	return load, true
+}
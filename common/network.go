@@ -0,0 +1,6 @@
+package common
+
+// NetworkDualStack is the network of the TCP listener accepting both IPv4 and IPv6 on the
+// wildcard address, e.g. server.Serve(common.NetworkDualStack, ":8080").
+// The client dials it as "tcp".
+const NetworkDualStack = "tcp46"
@@ -0,0 +1,39 @@
+package common
+
+// PriorityKey is the metadata key that carries the priority of a call.
+const PriorityKey = "_priority"
+
+// Priority is the priority of a call, the server with workers serves
+// the pending calls of higher priority first.
+type Priority uint8
+
+// Priorities of calls.
+const (
+	PriorityLow Priority = iota + 1
+	PriorityNormal
+	PriorityHigh
+)
+
+var priorityNames = map[Priority]string{
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+}
+
+// ParsePriority parses the name of the priority, returns PriorityNormal for an unknown name.
+func ParsePriority(s string) Priority {
+	for p, name := range priorityNames {
+		if name == s {
+			return p
+		}
+	}
+	return PriorityNormal
+}
+
+// String returns the name of the priority.
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return PriorityNormal.String()
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Metadata keys of the interim progress responses sent before the final reply,
+// they are reserved, see ReservedKey.
+const (
+	ProgressKey    = "_progress"
+	ProgressMsgKey = "_progress_msg"
+)
+
+// EncodeProgress returns the serviceMethod of a progress response.
+func EncodeProgress(path string, percent int, msg string) string {
+	return EncodeProgressMetadata(path, percent, msg, nil)
+}
+
+// EncodeProgressMetadata returns the serviceMethod of a progress response carrying the metadata too,
+// e.g. the identity of the progress that the percent can't carry.
+func EncodeProgressMetadata(path string, percent int, msg string, metadata url.Values) string {
+	query := make(url.Values, len(metadata)+2)
+	for key, values := range metadata {
+		query[key] = values
+	}
+	query.Set(ProgressKey, strconv.Itoa(percent))
+	query.Set(ProgressMsgKey, msg)
+	return path + "?" + query.Encode()
+}
+
+// ParseProgress parses the serviceMethod of a response, ok is false if it is not a progress response.
+func ParseProgress(serviceMethod string) (percent int, msg string, ok bool) {
+	percent, msg, _, ok = ParseProgressMetadata(serviceMethod)
+	return
+}
+
+// ParseProgressMetadata is like ParseProgress, and returns the metadata of the progress too, nil if none.
+func ParseProgressMetadata(serviceMethod string) (percent int, msg string, metadata map[string]string, ok bool) {
+	i := strings.Index(serviceMethod, "?")
+	if i < 0 || !strings.Contains(serviceMethod[i:], ProgressKey+"=") {
+		return 0, "", nil, false
+	}
+	query, err := url.ParseQuery(serviceMethod[i+1:])
+	if err != nil {
+		return 0, "", nil, false
+	}
+	if percent, err = strconv.Atoi(query.Get(ProgressKey)); err != nil {
+		return 0, "", nil, false
+	}
+	msg = query.Get(ProgressMsgKey)
+	for key := range query {
+		if key == ProgressKey || key == ProgressMsgKey {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string, len(query)-2)
+		}
+		metadata[key] = query.Get(key)
+	}
+	return percent, msg, metadata, true
+}
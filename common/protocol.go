@@ -0,0 +1,20 @@
+package common
+
+// ProtocolPreamble starts the connection of a client declaring the version of the framing,
+// followed by the version byte. The server answers ProtocolPreamble followed by the accepted
+// version, or by 0 and the reason of the rejection before it closes the connection.
+// The client that doesn't declare it speaks ProtocolVersion1.
+const ProtocolPreamble = "\x00MYRPC-PROTOCOL\n"
+
+// ProtocolHeader is the header of the HTTP CONNECT request declaring the version of the framing,
+// and of the response answering the accepted version, instead of ProtocolPreamble.
+// The HTTP client that doesn't declare it speaks ProtocolVersion1.
+const ProtocolHeader = "Myrpc-Protocol-Version"
+
+// The versions of the framing, i.e. the header encoding and the length prefixes of the codecs.
+const (
+	// ProtocolVersion1 is the framing of the codecs of this package tree.
+	ProtocolVersion1 byte = 1
+	// ProtocolVersion is the latest version.
+	ProtocolVersion = ProtocolVersion1
+)
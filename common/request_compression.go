@@ -0,0 +1,6 @@
+package common
+
+// CompressedRequestsPreamble starts the connection of a client compressing it without negotiating
+// with the server, followed by the byte of the compress.CompressType: the server that decompresses
+// the requests recognizes the compressed connections by it and reads the others as is.
+const CompressedRequestsPreamble = "\x00MYRPC-COMPRESS\n"
@@ -0,0 +1,16 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RequestIDKey is the metadata key that carries the request ID for log correlation.
+const RequestIDKey = "_rid"
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
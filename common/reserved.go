@@ -0,0 +1,31 @@
+package common
+
+import "strings"
+
+// reservedKeys are the metadata keys marking the interim responses. The server echoes the metadata
+// of a call in its responses, so a call carrying one of them would be mistaken for an interim response.
+var reservedKeys = []string{ChunkKey, ProgressKey, ProgressMsgKey}
+
+// ReservedKey returns the reserved metadata key that the serviceMethod of a call carries, or "" if none.
+func ReservedKey(serviceMethod string) string {
+	for _, key := range reservedKeys {
+		if hasKey(serviceMethod, key) {
+			return key
+		}
+	}
+	return ""
+}
+
+// hasKey returns whether the query of the serviceMethod has the key.
+func hasKey(serviceMethod, key string) bool {
+	i := strings.Index(serviceMethod, "?")
+	if i < 0 {
+		return false
+	}
+	for _, kv := range strings.Split(serviceMethod[i+1:], "&") {
+		if kv == key || strings.HasPrefix(kv, key+"=") {
+			return true
+		}
+	}
+	return false
+}
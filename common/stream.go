@@ -0,0 +1,44 @@
+package common
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ChunkKey is the metadata key of the interim responses carrying the chunks of a streamed reply,
+// it is reserved, see ReservedKey.
+const ChunkKey = "_chunk"
+
+// EncodeChunk returns the serviceMethod of a chunk response.
+func EncodeChunk(path string) string {
+	return path + "?" + ChunkKey + "=1"
+}
+
+// IsChunk returns whether the serviceMethod is of a chunk response.
+func IsChunk(serviceMethod string) bool {
+	return hasKey(serviceMethod, ChunkKey)
+}
+
+// CancelStreamPath is the reserved service path of the control frame by which the client cancels
+// the streamed reply of its call on the same connection, the seq of the call is carried by CancelSeqKey.
+// The server handles the frame as soon as it reads it, and doesn't reply it.
+const CancelStreamPath = "/_cancel_stream"
+
+// CancelSeqKey is the metadata key of the control frame of CancelStreamPath carrying the seq of the call.
+const CancelSeqKey = "_seq"
+
+// EncodeCancelStream returns the serviceMethod of the control frame canceling the streamed reply of the seq.
+func EncodeCancelStream(seq uint64) string {
+	return CancelStreamPath + "?" + CancelSeqKey + "=" + strconv.FormatUint(seq, 10)
+}
+
+// ParseCancelStream returns the seq of the call whose streamed reply the control frame cancels,
+// ok is false if the serviceMethod is not of the control frame.
+func ParseCancelStream(serviceMethod string) (seq uint64, ok bool) {
+	prefix := CancelStreamPath + "?" + CancelSeqKey + "="
+	if !strings.HasPrefix(serviceMethod, prefix) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(serviceMethod[len(prefix):], 10, 64)
+	return seq, err == nil
+}
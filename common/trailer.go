@@ -0,0 +1,32 @@
+package common
+
+import "net/url"
+
+// TrailerKey is the response metadata key carrying the trailers of a call, the metadata that
+// the service sets after producing the reply, encoded as a query string.
+//
+// The trailers are not framed after the reply body: the codecs frame a response as a header
+// followed by the body, with no room for a trailing frame that every codec would understand.
+// Since the reply is encoded only after the service returns, the trailers are known by then and
+// ride in the metadata of the response header (the query of its ServiceMethod), in the same
+// write as the body. So a trailer can't be set while the body is being written, e.g. from the
+// size of the encoded reply, and a streamed reply carries them in its final response only.
+const TrailerKey = "_trailer"
+
+// EncodeTrailers encodes the trailers for the response metadata.
+func EncodeTrailers(trailers url.Values) string {
+	return trailers.Encode()
+}
+
+// ParseTrailers parses the trailers of the response metadata, nil if none.
+func ParseTrailers(s string) map[string]string {
+	values, err := url.ParseQuery(s)
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	trailers := make(map[string]string, len(values))
+	for key := range values {
+		trailers[key] = values.Get(key)
+	}
+	return trailers
+}
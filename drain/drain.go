@@ -0,0 +1,137 @@
+// Package drain lets the orchestrators drain a server by an RPC or HTTP call instead of a signal,
+// e.g. from the preStop hook of a Kubernetes pod.
+//
+// Draining stops accepting the connections: the new connections are rejected with the draining
+// error, and the heartbeats (see common.HeartbeatPath) report the server not serving.
+// Then it waits for the in-flight calls to complete, see server.Server.Shutdown.
+//
+// By RPC, which returns as soon as the draining starts, for the drain call is in-flight too:
+//
+//	srv.NamedRegister("drain", drain.NewService(srv, func(ctx *server.Context) error {
+//		// authorize the orchestrator
+//	}))
+//
+// By HTTP, which returns after the in-flight calls complete, e.g. GET /drain?timeout=30s:
+//
+//	http.Handle("/drain", drain.NewHandler(srv, func(r *http.Request) error {
+//		// authorize the orchestrator
+//	}))
+package drain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/log"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+var (
+	errUnauthorized = errors.New("drain: unauthorized")
+	errNoAuthorize  = errors.New("drain: no authorization is configured")
+)
+
+type (
+	// Args are the arguments of the Drain service.
+	Args struct {
+		// Timeout cancels the in-flight calls that don't complete within it, see server.CancelServerShutdown.
+		// 0 means waiting for them without limit.
+		Timeout time.Duration
+	}
+
+	// Reply is the reply of the Drain service.
+	Reply struct {
+		// Started is false if the server was already draining.
+		Started bool
+	}
+
+	// Service drains the server for the authorized clients.
+	Service struct {
+		server    *server.Server
+		authorize func(ctx *server.Context) error
+	}
+
+	// Handler drains the server for the authorized HTTP requests.
+	Handler struct {
+		server    *server.Server
+		authorize func(r *http.Request) error
+	}
+)
+
+// NewService creates a Service draining srv. Every call must pass authorize,
+// the Service rejects all the calls if authorize is nil.
+func NewService(srv *server.Server, authorize func(ctx *server.Context) error) *Service {
+	return &Service{
+		server:    srv,
+		authorize: authorize,
+	}
+}
+
+// Drain starts draining the server and returns, the in-flight calls including this one
+// complete in the background.
+func (s *Service) Drain(ctx *server.Context, args Args, reply *Reply) error {
+	if s.authorize == nil {
+		return errNoAuthorize
+	}
+	if err := s.authorize(ctx); err != nil {
+		return errUnauthorized
+	}
+	reply.Started = !s.server.ConfigSnapshot().Draining
+	// rejects the new connections before replying.
+	s.server.SetDraining(true)
+	go shutdown(s.server, args.Timeout)
+	return nil
+}
+
+// NewHandler creates a Handler draining srv. Every request must pass authorize,
+// the Handler rejects all the requests if authorize is nil.
+func NewHandler(srv *server.Server, authorize func(r *http.Request) error) *Handler {
+	return &Handler{
+		server:    srv,
+		authorize: authorize,
+	}
+}
+
+// ServeHTTP drains the server and responds after the in-flight calls complete, 200 if they do
+// within the timeout given by the "timeout" query parameter (see time.ParseDuration), 504 otherwise.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.authorize == nil {
+		http.Error(w, errNoAuthorize.Error(), http.StatusForbidden)
+		return
+	}
+	if err := h.authorize(r); err != nil {
+		http.Error(w, errUnauthorized.Error(), http.StatusForbidden)
+		return
+	}
+	var timeout time.Duration
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		var err error
+		if timeout, err = time.ParseDuration(s); err != nil {
+			http.Error(w, "drain: invalid timeout: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := shutdown(h.server, timeout); err != nil {
+		http.Error(w, "drain: "+err.Error(), http.StatusGatewayTimeout)
+		return
+	}
+	w.Write([]byte("drained\n"))
+}
+
+// shutdown shuts down srv gracefully within the timeout, or without limit if it is 0.
+func shutdown(srv *server.Server, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	log.Info("rpc: draining")
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		log.Warnf("rpc: draining: %s", err.Error())
+	}
+	return err
+}
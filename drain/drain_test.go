@@ -0,0 +1,156 @@
+package drain
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// worker closes started when its first call starts.
+type worker struct {
+	started   chan struct{}
+	startedAt time.Time
+	once      sync.Once
+}
+
+func (w *worker) Sleep(d time.Duration, reply *string) error {
+	w.once.Do(func() {
+		w.startedAt = time.Now()
+		close(w.started)
+	})
+	time.Sleep(d)
+	*reply = "OK"
+	return nil
+}
+
+func serve(t *testing.T) (*server.Server, string, *worker) {
+	w := &worker{started: make(chan struct{})}
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", w)
+	srv.NamedRegister("drain", NewService(srv, func(ctx *server.Context) error {
+		if ctx.Query().Get("token") != "secret" {
+			return errors.New("bad token")
+		}
+		return nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	return srv, lis.Addr().String(), w
+}
+
+// newClient returns a client that doesn't retry the draining error on the other connections.
+func newClient(addr string) *client.Client {
+	return client.NewClient(client.Client{MaxTry: 1}, &selector.DirectSelector{
+		Network: "tcp",
+		Address: addr,
+	})
+}
+
+// sleep starts a call of d in the background.
+func sleep(c *client.Client, d time.Duration) <-chan *common.RPCError {
+	done := make(chan *common.RPCError, 1)
+	go func() {
+		var reply string
+		done <- c.Call("/worker/sleep", d, &reply)
+	}()
+	return done
+}
+
+func TestDrainService(t *testing.T) {
+	_, addr, w := serve(t)
+	c := newClient(addr)
+	defer c.Close()
+
+	var reply Reply
+	if e := c.Call("/drain/drain?token=guess", Args{}, &reply); e == nil {
+		t.Fatal("expect unauthorized")
+	}
+
+	// a client of its own, for the selector of a client isn't safe for the concurrent dials
+	busy := newClient(addr)
+	defer busy.Close()
+	inflight := sleep(busy, 300*time.Millisecond)
+	<-w.started
+	if e := c.Call("/drain/drain?token=secret", Args{Timeout: time.Second}, &reply); e != nil || !reply.Started {
+		t.Fatalf("drain: reply=%+v, err=%v", reply, e)
+	}
+
+	// the health checks see the server not serving
+	var empty struct{}
+	if e := c.Call(common.HeartbeatPath, empty, &empty); e == nil || e.Type != common.ErrorTypeServerDraining {
+		t.Fatalf("heartbeat: expect draining error, got: %v", e)
+	}
+	c2 := newClient(addr)
+	defer c2.Close()
+	var s string
+	if e := c2.Call("/worker/sleep", time.Duration(0), &s); e == nil {
+		t.Fatal("new connection: expect rejected")
+	}
+	if e := <-inflight; e != nil {
+		t.Fatalf("in-flight call failed: %v", e)
+	}
+}
+
+func TestDrainHandler(t *testing.T) {
+	srv, addr, w := serve(t)
+	c := newClient(addr)
+	defer c.Close()
+	ts := httptest.NewServer(NewHandler(srv, func(r *http.Request) error {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			return errors.New("bad token")
+		}
+		return nil
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/drain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unauthorized: status = %d", resp.StatusCode)
+	}
+
+	const d = 300 * time.Millisecond
+	inflight := sleep(c, d)
+	<-w.started
+	req, _ := http.NewRequest("GET", ts.URL+"/drain?timeout=2s", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("drain: status = %d", resp.StatusCode)
+	}
+	// the handler returns after the in-flight call completes
+	if elapsed := time.Since(w.startedAt); elapsed < d {
+		t.Fatalf("drain returned %v after the in-flight call started, before it completed", elapsed)
+	}
+	select {
+	case e := <-inflight:
+		if e != nil {
+			t.Fatalf("in-flight call failed: %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the in-flight call didn't complete")
+	}
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("the listener still accepts")
+	}
+}
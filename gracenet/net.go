@@ -9,6 +9,7 @@
 package gracenet
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
@@ -168,6 +169,55 @@ func (n *Net) ListenUnix(nett string, laddr *net.UnixAddr) (*net.UnixListener, e
 	return l, nil
 }
 
+// ListenConfig is like Listen, but creates the fresh listener using the
+// config, e.g. whose Control sets the socket options. An inherited listener
+// is returned as is.
+func (n *Net) ListenConfig(lc *net.ListenConfig, nett, laddr string) (net.Listener, error) {
+	var addr net.Addr
+	switch nett {
+	default:
+		return nil, net.UnknownNetworkError(nett)
+	case "tcp", "tcp4", "tcp6":
+		a, err := net.ResolveTCPAddr(nett, laddr)
+		if err != nil {
+			return nil, err
+		}
+		addr = a
+	case "unix", "unixpacket":
+		a, err := net.ResolveUnixAddr(nett, laddr)
+		if err != nil {
+			return nil, err
+		}
+		addr = a
+	}
+	if err := n.inherit(); err != nil {
+		return nil, err
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	// look for an inherited listener
+	for i, l := range n.inherited {
+		if l == nil { // we nil used inherited listeners
+			continue
+		}
+		if isSameAddr(l.Addr(), addr) {
+			n.inherited[i] = nil
+			n.active = append(n.active, l)
+			return l, nil
+		}
+	}
+
+	// make a fresh listener
+	l, err := lc.Listen(context.Background(), nett, laddr)
+	if err != nil {
+		return nil, err
+	}
+	n.active = append(n.active, l)
+	return l, nil
+}
+
 // Append append listener to Net.active
 func (n *Net) Append(ln net.Listener) error {
 	if err := n.inherit(); err != nil {
@@ -93,6 +93,17 @@ func SetLogger(logger Logger) {
 	global = logger
 }
 
+// Replace sets global logger like SetLogger, and returns the function restoring the previous one,
+// e.g. for the tests capturing the logs.
+// Note: Concurrent is not safe!
+func Replace(logger Logger) (restore func()) {
+	prev := global
+	SetLogger(logger)
+	return func() {
+		global = prev
+	}
+}
+
 const __loglevel__ = "DEBUG"
 
 func newDefaultLogger() Logger {
@@ -0,0 +1,46 @@
+// Package logtest captures the logs of the global logger in tests.
+//
+//	logs, restore := logtest.Capture()
+//	defer restore()
+//	...
+//	if !strings.Contains(logs.String(), "...") {
+package logtest
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/log"
+	"github.com/henrylee2cn/myrpc/log/logging"
+)
+
+// Buffer is the log output, safe for the goroutines of the servers and the clients logging concurrently.
+type Buffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// String returns the logs written so far.
+func (b *Buffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Capture sets the global logger writing all the levels to a new Buffer,
+// and returns it and the function restoring the previous logger.
+func Capture() (logs *Buffer, restore func()) {
+	logs = new(Buffer)
+	backend := logging.AddModuleLevel(logging.NewLogBackend(logs, "", 0))
+	backend.SetLevel(logging.DEBUG, "")
+	logger := logging.NewLogger("myrpc")
+	logger.SetBackend(backend)
+	logger.ExtraCalldepth++
+	return logs, log.Replace(logger)
+}
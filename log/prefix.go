@@ -0,0 +1,106 @@
+package log
+
+import (
+	"strings"
+)
+
+// prefixLogger is a Logger that prefixes every message, e.g. with correlation fields.
+type prefixLogger struct {
+	prefix string
+}
+
+// With returns a Logger that writes the messages prefixed by prefix through the global logger.
+func With(prefix string) Logger {
+	return &prefixLogger{prefix: prefix}
+}
+
+func (l *prefixLogger) args(args []interface{}) []interface{} {
+	return append([]interface{}{l.prefix}, args...)
+}
+
+func (l *prefixLogger) format(format string) string {
+	return strings.Replace(l.prefix, "%", "%%", -1) + format
+}
+
+// AddCalldepth is a no-op, the calling path depth is the same as the package functions.
+func (l *prefixLogger) AddCalldepth(int) {}
+
+// Fatal is equivalent to l.Critica followed by a call to os.Exit(1).
+func (l *prefixLogger) Fatal(args ...interface{}) {
+	global.Fatal(l.args(args)...)
+}
+
+// Fatalf is equivalent to l.Criticalf followed by a call to os.Exit(1).
+func (l *prefixLogger) Fatalf(format string, args ...interface{}) {
+	global.Fatalf(l.format(format), args...)
+}
+
+// Panic is equivalent to l.Critical followed by a call to panic().
+func (l *prefixLogger) Panic(args ...interface{}) {
+	global.Panic(l.args(args)...)
+}
+
+// Panicf is equivalent to l.Criticalf followed by a call to panic().
+func (l *prefixLogger) Panicf(format string, args ...interface{}) {
+	global.Panicf(l.format(format), args...)
+}
+
+// Critical logs a message using CRITICAL as log level.
+func (l *prefixLogger) Critical(args ...interface{}) {
+	global.Critical(l.args(args)...)
+}
+
+// Criticalf logs a message using CRITICAL as log level.
+func (l *prefixLogger) Criticalf(format string, args ...interface{}) {
+	global.Criticalf(l.format(format), args...)
+}
+
+// Error logs a message using ERROR as log level.
+func (l *prefixLogger) Error(args ...interface{}) {
+	global.Error(l.args(args)...)
+}
+
+// Errorf logs a message using ERROR as log level.
+func (l *prefixLogger) Errorf(format string, args ...interface{}) {
+	global.Errorf(l.format(format), args...)
+}
+
+// Warn logs a message using WARNING as log level.
+func (l *prefixLogger) Warn(args ...interface{}) {
+	global.Warn(l.args(args)...)
+}
+
+// Warnf logs a message using WARNING as log level.
+func (l *prefixLogger) Warnf(format string, args ...interface{}) {
+	global.Warnf(l.format(format), args...)
+}
+
+// Notice logs a message using NOTICE as log level.
+func (l *prefixLogger) Notice(args ...interface{}) {
+	global.Notice(l.args(args)...)
+}
+
+// Noticef logs a message using NOTICE as log level.
+func (l *prefixLogger) Noticef(format string, args ...interface{}) {
+	global.Noticef(l.format(format), args...)
+}
+
+// Info logs a message using INFO as log level.
+func (l *prefixLogger) Info(args ...interface{}) {
+	global.Info(l.args(args)...)
+}
+
+// Infof logs a message using INFO as log level.
+func (l *prefixLogger) Infof(format string, args ...interface{}) {
+	global.Infof(l.format(format), args...)
+}
+
+// Debug logs a message using DEBUG as log level.
+func (l *prefixLogger) Debug(args ...interface{}) {
+	global.Debug(l.args(args)...)
+}
+
+// Debugf logs a message using DEBUG as log level.
+func (l *prefixLogger) Debugf(format string, args ...interface{}) {
+	global.Debugf(l.format(format), args...)
+}
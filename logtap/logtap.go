@@ -0,0 +1,293 @@
+// Package logtap streams the recent and new log entries of the server to the admin clients.
+//
+// The Tap is a log backend keeping the recent entries in a ring buffer for the backfill,
+// and fanning out the new entries to the subscribers without blocking the logging:
+// a subscriber that doesn't keep up loses the entries, which are counted as dropped.
+//
+// Server side:
+//
+//	tap := logtap.NewTap(1000)
+//	logger := logging.NewLogger("myrpc")
+//	logger.SetBackend(logging.MultiLogger(consoleBackend, tap))
+//	log.SetLogger(logger)
+//	srv.NamedRegister("logtap", logtap.NewService(tap, func(ctx *server.Context) error {
+//		// authorize the admin
+//	}))
+//
+// Client side:
+//
+//	reply, err := logtap.Tail(ctx, c, "/logtap/tail", logtap.TailArgs{Backfill: 100}, func(e logtap.Entry) {
+//		fmt.Println(e)
+//	})
+//
+// The entries are streamed as the progresses of the call, whose metadata IDKey carries
+// the ID of the entry, see server.Context.ProgressWithMetadata.
+package logtap
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/log/logging"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// subscriberBuffer is the number of the new entries buffered for a subscriber.
+const subscriberBuffer = 256
+
+// IDKey is the progress metadata key carrying the ID of the streamed entry.
+const IDKey = "logtap_id"
+
+var (
+	errUnauthorized = errors.New("logtap: unauthorized")
+	errNoAuthorize  = errors.New("logtap: no authorization is configured")
+)
+
+type (
+	// Entry is a log entry.
+	Entry struct {
+		// ID increases by one with each entry of the Tap.
+		ID      uint64
+		Time    time.Time
+		Level   string
+		Message string
+	}
+
+	// Tap is a log backend (see logging.Backend) that keeps the recent entries
+	// and fans out the new entries to the subscribers.
+	Tap struct {
+		lock   sync.Mutex // protects following
+		ring   []Entry
+		next   int // the index of the next entry in ring
+		full   bool
+		nextID uint64
+		subs   map[*Subscription]struct{}
+	}
+
+	// Subscription receives the entries of a Tap.
+	Subscription struct {
+		// C delivers the entries in order.
+		C       <-chan Entry
+		c       chan Entry
+		tap     *Tap
+		dropped uint64
+	}
+)
+
+var _ logging.Backend = new(Tap)
+
+// NewTap creates a Tap keeping the recent size entries for the backfill.
+func NewTap(size int) *Tap {
+	if size <= 0 {
+		size = 1
+	}
+	return &Tap{
+		ring:   make([]Entry, size),
+		nextID: 1,
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Log records the entry and sends it to the subscribers that have room for it.
+func (t *Tap) Log(calldepth int, rec *logging.Record) {
+	e := Entry{
+		Time:    rec.Time,
+		Level:   rec.Level.String(),
+		Message: rec.Message(),
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	e.ID = t.nextID
+	t.nextID++
+	t.ring[t.next] = e
+	t.next++
+	if t.next == len(t.ring) {
+		t.next = 0
+		t.full = true
+	}
+	for s := range t.subs {
+		select {
+		case s.c <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// Close closes the subscriptions.
+func (t *Tap) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for s := range t.subs {
+		delete(t.subs, s)
+		close(s.c)
+	}
+}
+
+// Subscribe subscribes the new entries, after the recent backfill entries.
+func (t *Tap) Subscribe(backfill int) *Subscription {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	recent := t.recent(backfill)
+	s := &Subscription{
+		c:   make(chan Entry, len(recent)+subscriberBuffer),
+		tap: t,
+	}
+	s.C = s.c
+	for _, e := range recent {
+		s.c <- e
+	}
+	t.subs[s] = struct{}{}
+	return s
+}
+
+// recent returns at most n recent entries in order, it must be called with t.lock held.
+func (t *Tap) recent(n int) []Entry {
+	size := t.next
+	if t.full {
+		size = len(t.ring)
+	}
+	if n > size {
+		n = size
+	}
+	if n <= 0 {
+		return nil
+	}
+	entries := make([]Entry, 0, n)
+	for i := t.next - n; i < t.next; i++ {
+		entries = append(entries, t.ring[(i+len(t.ring))%len(t.ring)])
+	}
+	return entries
+}
+
+// Dropped returns the number of the entries the subscriber lost for not keeping up.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close unsubscribes and closes C.
+func (s *Subscription) Close() {
+	s.tap.lock.Lock()
+	defer s.tap.lock.Unlock()
+	if _, ok := s.tap.subs[s]; ok {
+		delete(s.tap.subs, s)
+		close(s.c)
+	}
+}
+
+// String returns the entry as "time level message", which is the progress message of the stream.
+func (e Entry) String() string {
+	return e.Time.Format(time.RFC3339Nano) + " " + e.Level + " " + e.Message
+}
+
+// parseEntry parses the progress of the stream.
+func parseEntry(msg string, metadata map[string]string) (Entry, error) {
+	id, err := strconv.ParseUint(metadata[IDKey], 10, 64)
+	if err != nil {
+		return Entry{}, errors.New("logtap: invalid entry ID: " + metadata[IDKey])
+	}
+	a := strings.SplitN(msg, " ", 3)
+	if len(a) != 3 {
+		return Entry{}, errors.New("logtap: invalid entry: " + msg)
+	}
+	t, err := time.Parse(time.RFC3339Nano, a[0])
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{ID: id, Time: t, Level: a[1], Message: a[2]}, nil
+}
+
+type (
+	// TailArgs are the arguments of the Tail service.
+	TailArgs struct {
+		// Backfill is the number of the recent entries sent first.
+		Backfill int
+		// Max ends the call after sending Max entries if positive.
+		Max int
+		// Duration ends the call after the duration if positive.
+		Duration time.Duration
+	}
+
+	// TailReply is the reply of the Tail service.
+	TailReply struct {
+		// Sent is the number of the entries sent.
+		Sent int
+		// Dropped is the number of the entries lost for not keeping up.
+		Dropped uint64
+	}
+
+	// Service serves the entries of a Tap to the authorized clients.
+	Service struct {
+		tap       *Tap
+		authorize func(ctx *server.Context) error
+	}
+)
+
+// NewService creates a Service of the tap. Every call must pass authorize,
+// the Service rejects all the calls if authorize is nil.
+func NewService(tap *Tap, authorize func(ctx *server.Context) error) *Service {
+	return &Service{
+		tap:       tap,
+		authorize: authorize,
+	}
+}
+
+// Tail streams the entries as the progresses of the call, until Max entries are sent,
+// Duration elapses or the call is canceled, e.g. by the call timeout of the server.
+func (s *Service) Tail(ctx *server.Context, args TailArgs, reply *TailReply) error {
+	if s.authorize == nil {
+		return errNoAuthorize
+	}
+	if err := s.authorize(ctx); err != nil {
+		return errUnauthorized
+	}
+	sub := s.tap.Subscribe(args.Backfill)
+	defer sub.Close()
+	defer func() { reply.Dropped = sub.Dropped() }()
+	var deadline <-chan time.Time
+	if args.Duration > 0 {
+		timer := time.NewTimer(args.Duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for args.Max <= 0 || reply.Sent < args.Max {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			id := url.Values{IDKey: {strconv.FormatUint(e.ID, 10)}}
+			if err := ctx.ProgressWithMetadata(0, e.String(), id); err != nil {
+				return err
+			}
+			reply.Sent++
+		case <-deadline:
+			return nil
+		case <-ctx.Context().Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// Tail calls the Tail service of the path with c, and calls fn with each entry in order
+// until the call returns. fn runs on the goroutine reading the responses, it must not block.
+func Tail(ctx context.Context, c *client.Client, path string, args TailArgs, fn func(Entry)) (TailReply, error) {
+	var reply TailReply
+	ctx = client.WithProgressMetadata(ctx, func(_ int, msg string, metadata map[string]string) {
+		if e, err := parseEntry(msg, metadata); err == nil {
+			fn(e)
+		}
+	})
+	if rpcErr := c.CallContext(ctx, path, args, &reply); rpcErr != nil {
+		return reply, errors.New(rpcErr.Error)
+	}
+	return reply, nil
+}
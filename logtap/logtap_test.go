@@ -0,0 +1,113 @@
+package logtap
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/log/logging"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+func TestTail(t *testing.T) {
+	tap := NewTap(2)
+	logger := logging.NewLogger("logtap")
+	backend := logging.AddModuleLevel(tap)
+	backend.SetLevel(logging.DEBUG, "")
+	logger.SetBackend(backend)
+
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("logtap", NewService(tap, func(ctx *server.Context) error {
+		if ctx.Query().Get("token") != "secret" {
+			return errUnauthorized
+		}
+		return nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	c := client.NewClient(client.Client{CallTimeout: 5 * time.Second}, &selector.DirectSelector{
+		Network: "tcp",
+		Address: lis.Addr().String(),
+	})
+	defer c.Close()
+
+	if _, err := Tail(context.Background(), c, "/logtap/tail?token=guess", TailArgs{Max: 1}, func(Entry) {}); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Fatalf("expect unauthorized, got: %v", err)
+	}
+
+	// the ring keeps the last two for the backfill
+	logger.Info("one")
+	logger.Info("two")
+	logger.Warning("three")
+
+	entries := make(chan Entry, 10)
+	done := make(chan TailReply, 1)
+	go func() {
+		reply, err := Tail(context.Background(), c, "/logtap/tail?token=secret", TailArgs{Backfill: 5, Max: 4}, func(e Entry) {
+			entries <- e
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- reply
+	}()
+	var got []Entry
+	next := func() {
+		select {
+		case e := <-entries:
+			got = append(got, e)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout, got: %v", got)
+		}
+	}
+	// the backfill arrives after subscribing
+	next()
+	next()
+	logger.Info("four")
+	logger.Debugf("five %d", 5)
+	next()
+	next()
+
+	want := []string{"INFO two", "WARNING three", "INFO four", "DEBUG five 5"}
+	for i, e := range got {
+		if e.Level+" "+e.Message != want[i] {
+			t.Fatalf("entry %d: %v, want %q", i, e, want[i])
+		}
+		if e.ID != uint64(i+2) {
+			t.Fatalf("entry %d: ID = %d", i, e.ID)
+		}
+		if e.Time.IsZero() {
+			t.Fatalf("entry %d: no time", i)
+		}
+	}
+	if reply := <-done; reply.Sent != 4 || reply.Dropped != 0 {
+		t.Fatalf("reply: %+v", reply)
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	tap := NewTap(10)
+	sub := tap.Subscribe(0)
+	defer sub.Close()
+	rec := &logging.Record{Time: time.Now(), Level: logging.INFO, Args: []interface{}{"x"}}
+	for i := 0; i < subscriberBuffer+10; i++ {
+		r := *rec
+		tap.Log(0, &r)
+	}
+	if d := sub.Dropped(); d != 10 {
+		t.Fatalf("dropped %d", d)
+	}
+	if len(sub.C) != subscriberBuffer {
+		t.Fatalf("buffered %d", len(sub.C))
+	}
+	if e := <-sub.C; e.ID != 1 {
+		t.Fatalf("first entry: %v", e)
+	}
+}
@@ -0,0 +1,453 @@
+// Package mux multiplexes independent logical sessions over a single connection.
+//
+// Every frame is tagged with its session ID, so each session has its own RPC codec,
+// sequence space and server Context, and carries its own metadata (such as the identity)
+// given when it is opened.
+//
+// Client side:
+//
+//	m := mux.NewMux(conn)
+//	c := client.NewClient(client.Client{
+//		Dial: func(network, address string, timeout time.Duration) (net.Conn, error) {
+//			return m.Open("user=alice")
+//		},
+//	}, selector)
+//
+// Server side:
+//
+//	srv.ServeListener(mux.NewListener(lis))
+//
+// and the service reads the metadata by mux.Metadata(ctx.Conn().GetConn()).
+//
+// Every session sends at most a window of bytes that the peer hasn't read yet,
+// so a slow session neither stalls the others nor makes the peer buffer without bound.
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	frameOpen byte = iota
+	frameData
+	frameClose
+	frameWindow // grants the peer more bytes to send on the session
+)
+
+const headerSize = 9 // session id(4) + type(1) + length(4)
+
+// maxFrameSize limits the payload of a frame.
+const maxFrameSize = 32 << 10
+
+// window is the bytes a session sends before the peer reads them.
+const window = 256 << 10
+
+var (
+	// ErrClosed is returned when using a closed session or Mux.
+	ErrClosed  = errors.New("mux: closed")
+	errTimeout = &timeoutError{}
+)
+
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "mux: i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
+
+type (
+	// Mux multiplexes sessions over a connection.
+	Mux struct {
+		conn     net.Conn
+		wlock    sync.Mutex // protects writing frames
+		lock     sync.Mutex // protects following
+		sessions map[uint32]*Session
+		nextID   uint32
+		closed   bool
+		accept   func(*Session) // called when the peer opens a session
+	}
+
+	// Session is a logical connection of a Mux, which implements net.Conn.
+	Session struct {
+		mux      *Mux
+		id       uint32
+		metadata string
+
+		lock         sync.Mutex
+		cond         *sync.Cond // signals the readers and the writers
+		buf          bytes.Buffer
+		sendWindow   uint32 // the bytes the peer can take
+		unacked      uint32 // the bytes read but not granted back to the peer yet
+		eof          bool   // the peer closed the session
+		closed       bool   // the session is closed locally
+		readDeadline time.Time
+		timer        *time.Timer
+	}
+)
+
+// NewMux creates a Mux over the connection to open sessions.
+func NewMux(conn net.Conn) *Mux {
+	m := newMux(conn, nil)
+	go m.readLoop()
+	return m
+}
+
+func newMux(conn net.Conn, accept func(*Session)) *Mux {
+	return &Mux{
+		conn:     conn,
+		sessions: make(map[uint32]*Session),
+		nextID:   1,
+		accept:   accept,
+	}
+}
+
+// Open opens a session with the metadata.
+func (m *Mux) Open(metadata string) (*Session, error) {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return nil, ErrClosed
+	}
+	s := m.newSession(m.nextID, metadata)
+	m.nextID++
+	m.lock.Unlock()
+	if err := m.writeFrame(s.id, frameOpen, []byte(metadata)); err != nil {
+		m.remove(s.id)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the connection and all the sessions.
+func (m *Mux) Close() error {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return nil
+	}
+	m.closed = true
+	sessions := m.sessions
+	m.sessions = make(map[uint32]*Session)
+	m.lock.Unlock()
+	for _, s := range sessions {
+		s.setEOF()
+	}
+	return m.conn.Close()
+}
+
+// newSession must be called with m.lock held.
+func (m *Mux) newSession(id uint32, metadata string) *Session {
+	s := &Session{
+		mux:        m,
+		id:         id,
+		metadata:   metadata,
+		sendWindow: window,
+	}
+	s.cond = sync.NewCond(&s.lock)
+	m.sessions[id] = s
+	return s
+}
+
+func (m *Mux) remove(id uint32) {
+	m.lock.Lock()
+	delete(m.sessions, id)
+	m.lock.Unlock()
+}
+
+func (m *Mux) writeFrame(id uint32, typ byte, payload []byte) error {
+	var header [headerSize]byte
+	binary.BigEndian.PutUint32(header[:4], id)
+	header[4] = typ
+	binary.BigEndian.PutUint32(header[5:], uint32(len(payload)))
+	m.wlock.Lock()
+	defer m.wlock.Unlock()
+	if _, err := m.conn.Write(header[:]); err != nil {
+		return err
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	_, err := m.conn.Write(payload)
+	return err
+}
+
+func (m *Mux) readLoop() {
+	defer m.Close()
+	var header [headerSize]byte
+	for {
+		if _, err := io.ReadFull(m.conn, header[:]); err != nil {
+			return
+		}
+		id := binary.BigEndian.Uint32(header[:4])
+		typ := header[4]
+		size := binary.BigEndian.Uint32(header[5:])
+		if size > maxFrameSize {
+			return
+		}
+		var payload []byte
+		if size > 0 {
+			payload = make([]byte, size)
+			if _, err := io.ReadFull(m.conn, payload); err != nil {
+				return
+			}
+		}
+		m.lock.Lock()
+		s := m.sessions[id]
+		if typ == frameOpen && s == nil && !m.closed {
+			s = m.newSession(id, string(payload))
+			m.lock.Unlock()
+			if m.accept != nil {
+				// a slow accept must not block the frames of the other sessions.
+				go m.accept(s)
+			}
+			continue
+		}
+		if typ == frameClose {
+			delete(m.sessions, id)
+		}
+		m.lock.Unlock()
+		if s == nil {
+			continue
+		}
+		switch typ {
+		case frameData:
+			if !s.push(payload) {
+				// the peer ignores the window.
+				return
+			}
+		case frameWindow:
+			if len(payload) == 4 {
+				s.grant(binary.BigEndian.Uint32(payload))
+			}
+		case frameClose:
+			s.setEOF()
+		}
+	}
+}
+
+// Metadata returns the metadata of the session, or "" if conn is not a session.
+func Metadata(conn net.Conn) string {
+	if s, ok := conn.(*Session); ok {
+		return s.metadata
+	}
+	return ""
+}
+
+// Metadata returns the metadata given when the session is opened.
+func (s *Session) Metadata() string {
+	return s.metadata
+}
+
+// push buffers the data for Read, ok is false if it overflows the window.
+func (s *Session) push(b []byte) (ok bool) {
+	s.lock.Lock()
+	if s.buf.Len()+len(b) > window {
+		s.lock.Unlock()
+		return false
+	}
+	s.buf.Write(b)
+	s.lock.Unlock()
+	s.cond.Broadcast()
+	return true
+}
+
+// grant lets the session send n more bytes.
+func (s *Session) grant(n uint32) {
+	s.lock.Lock()
+	s.sendWindow += n
+	s.lock.Unlock()
+	s.cond.Broadcast()
+}
+
+func (s *Session) setEOF() {
+	s.lock.Lock()
+	s.eof = true
+	s.lock.Unlock()
+	s.cond.Broadcast()
+}
+
+// Read reads data from the session.
+func (s *Session) Read(b []byte) (int, error) {
+	s.lock.Lock()
+	for s.buf.Len() == 0 {
+		var err error
+		if s.closed {
+			err = ErrClosed
+		} else if s.eof {
+			err = io.EOF
+		} else if !s.readDeadline.IsZero() && !time.Now().Before(s.readDeadline) {
+			err = errTimeout
+		}
+		if err != nil {
+			s.lock.Unlock()
+			return 0, err
+		}
+		s.cond.Wait()
+	}
+	n, _ := s.buf.Read(b)
+	// grant the read bytes back to the peer in batches.
+	var grant uint32
+	s.unacked += uint32(n)
+	if s.unacked >= window/2 {
+		grant, s.unacked = s.unacked, 0
+	}
+	s.lock.Unlock()
+	if grant > 0 {
+		var payload [4]byte
+		binary.BigEndian.PutUint32(payload[:], grant)
+		s.mux.writeFrame(s.id, frameWindow, payload[:])
+	}
+	return n, nil
+}
+
+// Write writes data to the session, blocking while the peer has not read the window.
+func (s *Session) Write(b []byte) (int, error) {
+	s.lock.Lock()
+	closed := s.closed || s.eof
+	s.lock.Unlock()
+	if closed {
+		return 0, ErrClosed
+	}
+	for n := 0; n < len(b); {
+		s.lock.Lock()
+		for s.sendWindow == 0 && !s.closed && !s.eof {
+			s.cond.Wait()
+		}
+		if s.closed || s.eof {
+			s.lock.Unlock()
+			return n, ErrClosed
+		}
+		size := len(b) - n
+		if size > maxFrameSize {
+			size = maxFrameSize
+		}
+		if uint32(size) > s.sendWindow {
+			size = int(s.sendWindow)
+		}
+		s.sendWindow -= uint32(size)
+		s.lock.Unlock()
+		if err := s.mux.writeFrame(s.id, frameData, b[n:n+size]); err != nil {
+			return n, err
+		}
+		n += size
+	}
+	return len(b), nil
+}
+
+// Close closes the session.
+func (s *Session) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	eof := s.eof
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.lock.Unlock()
+	s.cond.Broadcast()
+	s.mux.remove(s.id)
+	if eof {
+		return nil
+	}
+	return s.mux.writeFrame(s.id, frameClose, nil)
+}
+
+// LocalAddr returns the local address of the connection.
+func (s *Session) LocalAddr() net.Addr {
+	return s.mux.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the connection.
+func (s *Session) RemoteAddr() net.Addr {
+	return s.mux.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read deadline, the writes don't time out.
+func (s *Session) SetDeadline(t time.Time) error {
+	return s.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for the future Read calls.
+func (s *Session) SetReadDeadline(t time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.readDeadline = t
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if !t.IsZero() {
+		s.timer = time.AfterFunc(time.Until(t), s.cond.Broadcast)
+	}
+	return nil
+}
+
+// SetWriteDeadline is a no-op, the writes of a session don't time out.
+func (s *Session) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// Listener accepts the sessions multiplexed over the connections of a listener.
+type Listener struct {
+	net.Listener
+	sessions chan *Session
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewListener creates a Listener accepting the sessions over the connections of lis.
+func NewListener(lis net.Listener) *Listener {
+	l := &Listener{
+		Listener: lis,
+		sessions: make(chan *Session),
+		done:     make(chan struct{}),
+	}
+	go l.serve()
+	return l
+}
+
+func (l *Listener) serve() {
+	defer l.Close()
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return
+		}
+		m := newMux(conn, func(s *Session) {
+			select {
+			case l.sessions <- s:
+			case <-l.done:
+				s.Close()
+			}
+		})
+		go m.readLoop()
+	}
+}
+
+// Accept waits for and returns the next session.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case s := <-l.sessions:
+		return s, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener.
+func (l *Listener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		err = l.Listener.Close()
+	})
+	return err
+}
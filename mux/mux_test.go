@@ -0,0 +1,142 @@
+package mux_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/mux"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type worker struct{}
+
+func (*worker) Whoami(ctx *server.Context, _ string, reply *string) error {
+	*reply = mux.Metadata(ctx.Conn().GetConn())
+	return nil
+}
+
+func TestSessions(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", new(worker))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(mux.NewListener(lis))
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mux.NewMux(conn)
+	defer m.Close()
+
+	newClient := func(metadata string) *client.Client {
+		return client.NewClient(client.Client{
+			Dial: func(network, address string, timeout time.Duration) (net.Conn, error) {
+				return m.Open(metadata)
+			},
+		}, &selector.DirectSelector{
+			Network: "tcp",
+			Address: lis.Addr().String(),
+		})
+	}
+	alice := newClient("user=alice")
+	defer alice.Close()
+	bob := newClient("user=bob")
+	defer bob.Close()
+
+	for i := 0; i < 3; i++ {
+		for _, c := range []struct {
+			client *client.Client
+			expect string
+		}{{alice, "user=alice"}, {bob, "user=bob"}} {
+			var reply string
+			if e := c.client.Call("/worker/whoami", "", &reply); e != nil {
+				t.Fatal(e.Error)
+			}
+			if reply != c.expect {
+				t.Fatalf("expect %q, got %q", c.expect, reply)
+			}
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml := mux.NewListener(lis)
+	defer ml.Close()
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mux.NewMux(conn)
+	defer m.Close()
+
+	slow, err := m.Open("slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := m.Open("fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(map[string]net.Conn)
+	for i := 0; i < 2; i++ {
+		s, err := ml.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted[mux.Metadata(s)] = s
+	}
+
+	// the slow session writes beyond the window that the peer doesn't read.
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	written := make(chan error, 1)
+	go func() {
+		_, err := slow.Write(data)
+		written <- err
+	}()
+	// the other session isn't stalled.
+	if _, err := fast.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(accepted["fast"], buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, err=%v", buf, err)
+	}
+	select {
+	case err := <-written:
+		t.Fatalf("expect the write blocked by the window, returned %v", err)
+	default:
+	}
+	// reading lets the slow session finish.
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(accepted["slow"], got); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes differ, err=%v", len(got), err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListenerClosed(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml := mux.NewListener(lis)
+	ml.Close()
+	if _, err := ml.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expect net.ErrClosed, got %v", err)
+	}
+}
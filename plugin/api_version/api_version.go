@@ -0,0 +1,108 @@
+package api_version
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/plugin"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// VersionKey is the metadata key that carries the API version of the client,
+// e.g. "/arith/mul?api-version=2". A call without it is of version 0.
+const VersionKey = "api-version"
+
+// UnsupportedPrefix prefixes the message of the error that rejects a call.
+const UnsupportedPrefix = "unsupported version: "
+
+// Range is the supported API versions of a service path, Max is unlimited if 0.
+type Range struct {
+	Min int
+	Max int
+}
+
+// APIVersionPlugin rejects the calls whose API version is out of the supported range of the path,
+// so that the old clients are retired gracefully with a clear error.
+type APIVersionPlugin struct {
+	ranges map[string]Range // service path -> supported versions
+	sync.RWMutex
+}
+
+// NewAPIVersionPlugin creates an APIVersionPlugin, the paths without a range accept all versions.
+func NewAPIVersionPlugin() *APIVersionPlugin {
+	return &APIVersionPlugin{
+		ranges: make(map[string]Range),
+	}
+}
+
+var _ plugin.IPlugin = new(APIVersionPlugin)
+
+// Name returns plugin name.
+func (p *APIVersionPlugin) Name() string {
+	return "APIVersionPlugin"
+}
+
+// Require sets the versions from min to max that the service path supports, max is unlimited if 0.
+// It replaces the former range of the path, and can be called at runtime.
+func (p *APIVersionPlugin) Require(servicePath string, min, max int) *APIVersionPlugin {
+	p.Lock()
+	defer p.Unlock()
+	p.ranges[servicePath] = Range{Min: min, Max: max}
+	return p
+}
+
+// Remove removes the range of the service path, which then accepts all versions.
+func (p *APIVersionPlugin) Remove(servicePath string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.ranges, servicePath)
+}
+
+// Supports returns whether the service path supports the version.
+func (p *APIVersionPlugin) Supports(servicePath string, version int) bool {
+	p.RLock()
+	r, ok := p.ranges[servicePath]
+	p.RUnlock()
+	return !ok || r.contains(version)
+}
+
+func (r Range) contains(version int) bool {
+	return version >= r.Min && (r.Max == 0 || version <= r.Max)
+}
+
+var _ server.IPostReadRequestHeaderPlugin = new(APIVersionPlugin)
+
+// PostReadRequestHeader rejects the call of an unsupported version before dispatch.
+func (p *APIVersionPlugin) PostReadRequestHeader(ctx *server.Context) error {
+	p.RLock()
+	r, ok := p.ranges[ctx.Path()]
+	p.RUnlock()
+	if !ok {
+		return nil
+	}
+	var version int
+	if v := ctx.Query().Get(VersionKey); v != "" {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf(UnsupportedPrefix+"invalid version %q", v)
+		}
+	}
+	if r.contains(version) {
+		return nil
+	}
+	if r.Max == 0 {
+		return fmt.Errorf(UnsupportedPrefix+"%s requires version %d or later, got %d", ctx.Path(), r.Min, version)
+	}
+	return fmt.Errorf(UnsupportedPrefix+"%s requires version %d to %d, got %d", ctx.Path(), r.Min, r.Max, version)
+}
+
+// WithVersion appends the API version to the serviceMethod of a call.
+func WithVersion(serviceMethod string, version int) string {
+	sep := "?"
+	if strings.Contains(serviceMethod, "?") {
+		sep = "&"
+	}
+	return serviceMethod + sep + VersionKey + "=" + strconv.Itoa(version)
+}
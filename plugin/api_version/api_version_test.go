@@ -0,0 +1,57 @@
+package api_version
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type worker struct{}
+
+func (*worker) Mul(arg int, reply *int) error {
+	*reply = arg * arg
+	return nil
+}
+
+func TestAPIVersionPlugin(t *testing.T) {
+	versions := NewAPIVersionPlugin().Require("/arith/mul", 2, 0)
+	srv := server.NewServer(server.Server{})
+	srv.PluginContainer.Add(versions)
+	srv.NamedRegister("arith", new(worker))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+
+	c := client.NewClient(client.Client{}, &selector.DirectSelector{Network: "tcp", Address: lis.Addr().String()})
+	defer c.Close()
+
+	var reply int
+	for _, path := range []string{WithVersion("/arith/mul", 1), "/arith/mul", "/arith/mul?api-version=x"} {
+		e := c.Call(path, 3, &reply)
+		if e == nil || !strings.Contains(e.Error, UnsupportedPrefix) {
+			t.Fatalf("%s: expect unsupported version error, got: %v", path, e)
+		}
+	}
+	if e := c.Call(WithVersion("/arith/mul", 2), 3, &reply); e != nil || reply != 9 {
+		t.Fatalf("v2: reply=%d, err=%v", reply, e)
+	}
+
+	// update the range at runtime.
+	versions.Require("/arith/mul", 1, 1)
+	if e := c.Call(WithVersion("/arith/mul", 1), 3, &reply); e != nil {
+		t.Fatalf("v1: expect supported, got: %v", e)
+	}
+	if e := c.Call(WithVersion("/arith/mul", 2), 3, &reply); e == nil || !strings.Contains(e.Error, "version 1 to 1") {
+		t.Fatalf("v2: expect unsupported version error, got: %v", e)
+	}
+	versions.Remove("/arith/mul")
+	if e := c.Call("/arith/mul", 3, &reply); e != nil {
+		t.Fatalf("expect all versions supported, got: %v", e)
+	}
+}
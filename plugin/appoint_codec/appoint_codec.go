@@ -80,17 +80,17 @@ func (appointCodec *AppointCodecPlugin) PostConnAccept(codecConn server.ServerCo
 	}
 	switch CodecType(b[0]) {
 	case CODEC_TYPE_BSON:
-		codecConn.SetServerCodec(bson.NewBsonServerCodec)
+		return codecConn.SetServerCodec(bson.NewBsonServerCodec)
 	case CODEC_TYPE_COLFER:
-		codecConn.SetServerCodec(colfer.NewServerCodec)
+		return codecConn.SetServerCodec(colfer.NewServerCodec)
 	case CODEC_TYPE_GENCODE:
-		codecConn.SetServerCodec(gencode.NewGencodeServerCodec)
+		return codecConn.SetServerCodec(gencode.NewGencodeServerCodec)
 	case CODEC_TYPE_GOB:
-		codecConn.SetServerCodec(gob.NewGobServerCodec)
+		return codecConn.SetServerCodec(gob.NewGobServerCodec)
 	case CODEC_TYPE_JSON:
-		codecConn.SetServerCodec(jsonrpc.NewJSONRPCServerCodec)
+		return codecConn.SetServerCodec(jsonrpc.NewJSONRPCServerCodec)
 	case CODEC_TYPE_PROTOBUF:
-		codecConn.SetServerCodec(protobuf.NewProtobufServerCodec)
+		return codecConn.SetServerCodec(protobuf.NewProtobufServerCodec)
 	}
 	return nil
 }
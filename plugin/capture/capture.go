@@ -0,0 +1,209 @@
+// Package capture records the requests of the server as frames to be replayed offline
+// by server.Server.Replay, e.g. to reproduce deterministically a request triggering a bug:
+//
+//	p := capture.NewCapturePlugin(func(path string, frame []byte) {
+//		ioutil.WriteFile(dir+"/"+strconv.FormatInt(time.Now().UnixNano(), 10), frame, 0600)
+//	})
+//	p.Match = func(ctx *server.Context) bool { return ctx.Path() == "/arith/div" }
+//	srv := server.NewServer(server.Server{ServerCodecFunc: p.ServerCodecFunc(codecGob.NewGobServerCodec)})
+//	srv.PluginContainer.Add(p)
+//
+// and later, against a server with the same services:
+//
+//	response, err := srv.Replay(frame)
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/rpc"
+	"reflect"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/plugin"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+const gobContentType = "application/x-gob"
+
+// CapturePlugin records the request frames of the calls.
+// A frame is the raw bytes of the request as read by the codec, so the codec must be wrapped
+// by ServerCodecFunc. The frames are exact with the codecs reading no further than a request
+// from an io.ByteReader, as gob does. The gob type definitions sent once per connection
+// go ahead of the frames, so that a frame is self-contained.
+type CapturePlugin struct {
+	sink func(path string, frame []byte)
+	// Match selects the captured calls, all by default.
+	Match func(ctx *server.Context) bool
+}
+
+// NewCapturePlugin creates a CapturePlugin that passes the frames to sink with the service paths.
+// sink is called on the goroutine reading the connection, it must be fast and safe for concurrent use.
+func NewCapturePlugin(sink func(path string, frame []byte)) *CapturePlugin {
+	return &CapturePlugin{sink: sink}
+}
+
+var _ plugin.IPlugin = new(CapturePlugin)
+
+// Name returns plugin name.
+func (p *CapturePlugin) Name() string {
+	return "CapturePlugin"
+}
+
+// ServerCodecFunc returns a ServerCodecFunc recording the bytes of the requests read by the codecs of fn.
+func (p *CapturePlugin) ServerCodecFunc(fn server.ServerCodecFunc) server.ServerCodecFunc {
+	return func(conn io.ReadWriteCloser) rpc.ServerCodec {
+		tee := &teeConn{ReadWriteCloser: conn, r: bufio.NewReader(conn)}
+		codec := fn(tee)
+		if codec == nil {
+			return nil
+		}
+		return &teeCodec{
+			ServerCodec: codec,
+			tee:         tee,
+			gob:         server.ContentType(codec) == gobContentType,
+		}
+	}
+}
+
+var _ server.IPostReadRequestBodyPlugin = new(CapturePlugin)
+
+// PostReadRequestBody records the frame of the request as read by the codec.
+func (p *CapturePlugin) PostReadRequestBody(ctx *server.Context, body interface{}) error {
+	c, ok := ctx.Conn().GetServerCodec().(*teeCodec)
+	if !ok || (p.Match != nil && !p.Match(ctx)) {
+		return nil
+	}
+	p.sink(ctx.Path(), c.frame())
+	return nil
+}
+
+// teeConn keeps the bytes read since the request began. It reads through a bufio.Reader
+// exposed as an io.ByteReader, so that e.g. gob doesn't read ahead of the request.
+type teeConn struct {
+	io.ReadWriteCloser
+	r   *bufio.Reader
+	buf bytes.Buffer
+}
+
+func (c *teeConn) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.buf.Write(b[:n])
+	return n, err
+}
+
+// ReadByte implements io.ByteReader.
+func (c *teeConn) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.buf.WriteByte(b)
+	}
+	return b, err
+}
+
+// teeCodec starts recording the bytes of every request, it is used by the read loop only.
+type teeCodec struct {
+	rpc.ServerCodec
+	tee  *teeConn
+	gob  bool
+	defs []byte // the gob type definitions read by the previous requests
+}
+
+func (c *teeCodec) ReadRequestHeader(r *rpc.Request) error {
+	if c.gob {
+		c.defs = gobDefinitions(c.defs, c.tee.buf.Bytes())
+	}
+	c.tee.buf.Reset()
+	return c.ServerCodec.ReadRequestHeader(r)
+}
+
+// frame returns the bytes of the request read so far, after the type definitions it depends on.
+func (c *teeCodec) frame() []byte {
+	frame := make([]byte, 0, len(c.defs)+c.tee.buf.Len())
+	frame = append(frame, c.defs...)
+	return append(frame, c.tee.buf.Bytes()...)
+}
+
+// ContentType returns the content type of the wrapped codec.
+func (c *teeCodec) ContentType() string {
+	return server.ContentType(c.ServerCodec)
+}
+
+// CheckType forwards server.ITypeChecker.
+func (c *teeCodec) CheckType(t reflect.Type) error {
+	if checker, ok := c.ServerCodec.(server.ITypeChecker); ok {
+		return checker.CheckType(t)
+	}
+	return nil
+}
+
+// InitError forwards server.IInitError.
+func (c *teeCodec) InitError() error {
+	if e, ok := c.ServerCodec.(server.IInitError); ok {
+		return e.InitError()
+	}
+	return nil
+}
+
+// LimitResponse forwards common.ResponseLimiter.
+func (c *teeCodec) LimitResponse(n int64) {
+	if l, ok := c.ServerCodec.(common.ResponseLimiter); ok {
+		l.LimitResponse(n)
+	}
+}
+
+// gobDefinitions appends the messages of b defining types to defs,
+// the messages of gob start with their length and their type ID, negative for the definitions.
+func gobDefinitions(defs, b []byte) []byte {
+	for len(b) > 0 {
+		n, w := gobUint(b)
+		if w == 0 || uint64(len(b)-w) < n {
+			return defs
+		}
+		end := w + int(n)
+		if id, idw := gobUint(b[w:end]); idw > 0 && id&1 == 1 {
+			defs = append(defs, b[:end]...)
+		}
+		b = b[end:]
+	}
+	return defs
+}
+
+// gobUint decodes the unsigned integer of gob at the start of b and returns its width, 0 if b is short.
+func gobUint(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	if b[0] < 0x80 {
+		return uint64(b[0]), 1
+	}
+	n := -int(int8(b[0]))
+	if n > 8 || len(b) <= n {
+		return 0, 0
+	}
+	var x uint64
+	for _, c := range b[1 : 1+n] {
+		x = x<<8 | uint64(c)
+	}
+	return x, 1 + n
+}
+
+// frameConn is the connection of the codec encoding a frame.
+type frameConn struct {
+	bytes.Buffer
+}
+
+func (*frameConn) Close() error { return nil }
+
+// Encode returns the frame of the request encoded by a new client codec of codecFunc,
+// e.g. to craft a frame for server.Server.Replay.
+func Encode(codecFunc client.ClientCodecFunc, serviceMethod string, seq uint64, body interface{}) ([]byte, error) {
+	conn := new(frameConn)
+	err := codecFunc(conn).WriteRequest(&rpc.Request{ServiceMethod: serviceMethod, Seq: seq}, body)
+	if err != nil {
+		return nil, err
+	}
+	return conn.Bytes(), nil
+}
@@ -0,0 +1,111 @@
+package capture
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	codecGob "github.com/henrylee2cn/myrpc/codec/gob"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type Args struct {
+	A, B int
+}
+
+type arith struct{}
+
+func (*arith) Div(args *Args, reply *int) error {
+	*reply = args.A / args.B
+	return nil
+}
+
+// readResponse decodes the response frame.
+func readResponse(t *testing.T, frame []byte) (resp rpc.Response, reply int) {
+	conn := new(frameConn)
+	conn.Write(frame)
+	codec := codecGob.NewGobClientCodec(conn)
+	if err := codec.ReadResponseHeader(&resp); err != nil {
+		t.Fatal(err)
+	}
+	body := interface{}(&reply)
+	if resp.Error != "" {
+		body = nil
+	}
+	if err := codec.ReadResponseBody(body); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestCaptureAndReplay(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		frames [][]byte
+	)
+	p := NewCapturePlugin(func(path string, frame []byte) {
+		mu.Lock()
+		frames = append(frames, frame)
+		mu.Unlock()
+	})
+	p.Match = func(ctx *server.Context) bool { return ctx.Path() == "/arith/div" }
+	srv := server.NewServer(server.Server{ServerCodecFunc: p.ServerCodecFunc(codecGob.NewGobServerCodec)})
+	srv.PluginContainer.Add(p)
+	srv.NamedRegister("arith", new(arith))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	c := client.NewClient(client.Client{}, &selector.DirectSelector{Network: "tcp", Address: lis.Addr().String()})
+	defer c.Close()
+
+	var reply int
+	if e := c.Call("/arith/div", &Args{A: 9, B: 3}, &reply); e != nil || reply != 3 {
+		t.Fatalf("reply=%d, err=%v", reply, e)
+	}
+	// the second request relies on the type definitions sent by the first one
+	if e := c.Call("/arith/div", &Args{A: 7, B: 2}, &reply); e != nil || reply != 3 {
+		t.Fatalf("reply=%d, err=%v", reply, e)
+	}
+	mu.Lock()
+	captured := frames
+	mu.Unlock()
+	if len(captured) != 2 {
+		t.Fatalf("frames = %d, want 2", len(captured))
+	}
+
+	// replayed offline by a server with the same services
+	offline := server.NewServer(server.Server{})
+	offline.NamedRegister("arith", new(arith))
+	response, err := offline.Replay(captured[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, replayed := readResponse(t, response)
+	if resp.Error != "" || replayed != reply {
+		t.Fatalf("replayed: reply=%d, err=%q, want %d", replayed, resp.Error, reply)
+	}
+	again, _ := offline.Replay(captured[1])
+	if string(again) != string(response) {
+		t.Fatal("the replays differ")
+	}
+
+	// a crafted frame triggering the bug
+	frame, err := Encode(codecGob.NewGobClientCodec, "/arith/div", 1, &Args{A: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response, err = offline.Replay(frame); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := readResponse(t, response); resp.Error == "" {
+		t.Fatal("expect the panic of dividing by zero")
+	}
+	if _, err = offline.Replay([]byte("garbage")); err == nil {
+		t.Fatal("expect the error of the unreadable frame")
+	}
+}
@@ -0,0 +1,142 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/plugin"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// maxDictFrameSize limits the compressed size of a frame.
+const maxDictFrameSize = 1 << 24
+
+var errDictFrameTooLarge = errors.New("compression: frame is too large")
+
+// DictCompressionPlugin compresses every frame written to the connection independently
+// with a preset dictionary, which improves the ratio of small and repetitive payloads,
+// e.g. JSON with the same keys. The dictionary must be the same on the client and server.
+type DictCompressionPlugin struct {
+	Dict []byte
+}
+
+// NewDictCompressionPlugin creates a new DictCompressionPlugin
+func NewDictCompressionPlugin(dict []byte) *DictCompressionPlugin {
+	return &DictCompressionPlugin{Dict: dict}
+}
+
+var _ plugin.IPlugin = new(DictCompressionPlugin)
+
+// Name return name of this plugin.
+func (p *DictCompressionPlugin) Name() string {
+	return "DictCompressionPlugin"
+}
+
+var _ server.IPostConnAcceptPlugin = new(DictCompressionPlugin)
+
+// PostConnAccept can create a conn that support compression with the dictionary.
+// Used by servers.
+func (p *DictCompressionPlugin) PostConnAccept(codecConn server.ServerCodecConn) error {
+	codecConn.SetConn(NewDictCompressConn(codecConn.GetConn(), p.Dict))
+	return nil
+}
+
+var _ client.IPostConnectedPlugin = new(DictCompressionPlugin)
+
+// PostConnected can create a conn that support compression with the dictionary.
+// Used by clients.
+func (p *DictCompressionPlugin) PostConnected(codecConn client.ClientCodecConn) error {
+	codecConn.SetConn(NewDictCompressConn(codecConn.GetConn(), p.Dict))
+	return nil
+}
+
+// DictCompressConn wraps a net.Conn and compresses every write as a frame with the dictionary.
+type DictCompressConn struct {
+	net.Conn
+	dict []byte
+
+	rlock   sync.Mutex // protects following
+	r       io.ReadCloser
+	pending bytes.Buffer
+
+	wlock sync.Mutex // protects following
+	w     *flate.Writer
+	frame bytes.Buffer
+}
+
+// NewDictCompressConn creates a wrapped net.Conn compressing the frames with the dictionary,
+// no dictionary if dict is nil.
+func NewDictCompressConn(conn net.Conn, dict []byte) net.Conn {
+	w, err := flate.NewWriterDict(ioutil.Discard, flate.BestCompression, dict)
+	if err != nil {
+		panic(fmt.Sprintf("BUG: flate.NewWriterDict(%d) returned non-nil err: %s", flate.BestCompression, err))
+	}
+	return &DictCompressConn{
+		Conn: conn,
+		dict: dict,
+		r:    flate.NewReaderDict(bytes.NewReader(nil), dict),
+		w:    w,
+	}
+}
+
+func (c *DictCompressConn) Read(b []byte) (n int, err error) {
+	c.rlock.Lock()
+	defer c.rlock.Unlock()
+	for c.pending.Len() == 0 {
+		if err = c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	return c.pending.Read(b)
+}
+
+func (c *DictCompressConn) readFrame() error {
+	var header [4]byte
+	if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxDictFrameSize {
+		return errDictFrameTooLarge
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(c.Conn, frame); err != nil {
+		return err
+	}
+	if err := c.r.(flate.Resetter).Reset(bytes.NewReader(frame), c.dict); err != nil {
+		return err
+	}
+	_, err := c.pending.ReadFrom(c.r)
+	return err
+}
+
+func (c *DictCompressConn) Write(b []byte) (n int, err error) {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	c.frame.Reset()
+	c.frame.Write([]byte{0, 0, 0, 0})
+	c.w.Reset(&c.frame)
+	if _, err = c.w.Write(b); err != nil {
+		return 0, err
+	}
+	if err = c.w.Close(); err != nil {
+		return 0, err
+	}
+	frame := c.frame.Bytes()
+	if len(frame)-4 > maxDictFrameSize {
+		return 0, errDictFrameTooLarge
+	}
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(frame)-4))
+	if _, err = c.Conn.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,67 @@
+package compression_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/plugin/compression"
+)
+
+// bufferConn is a net.Conn reading and writing a buffer.
+type bufferConn struct {
+	net.Conn
+	bytes.Buffer
+}
+
+func (c *bufferConn) Read(b []byte) (int, error)  { return c.Buffer.Read(b) }
+func (c *bufferConn) Write(b []byte) (int, error) { return c.Buffer.Write(b) }
+
+var dict = []byte(`{"user_id":,"user_name":"","email":"@example.com","status":"active","created_at":"2017-`)
+
+func messages() [][]byte {
+	var msgs [][]byte
+	for i := 0; i < 50; i++ {
+		msgs = append(msgs, []byte(fmt.Sprintf(
+			`{"user_id":%d,"user_name":"user%d","email":"user%d@example.com","status":"active","created_at":"2017-03-%02d"}`,
+			i, i, i, i%28+1)))
+	}
+	return msgs
+}
+
+// compressedSize writes the messages through a DictCompressConn, checks reading them back,
+// and returns the size written to the underlying connection.
+func compressedSize(t *testing.T, dict []byte) int {
+	raw := new(bufferConn)
+	w := compression.NewDictCompressConn(raw, dict)
+	msgs := messages()
+	for _, msg := range msgs {
+		if _, err := w.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size := raw.Len()
+
+	r := compression.NewDictCompressConn(raw, dict)
+	for _, msg := range msgs {
+		b := make([]byte, len(msg))
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, msg) {
+			t.Fatalf("expect %s, got %s", msg, b)
+		}
+	}
+	return size
+}
+
+func TestDictCompression(t *testing.T) {
+	without := compressedSize(t, nil)
+	with := compressedSize(t, dict)
+	t.Logf("compressed size: %d without dictionary, %d with dictionary", without, with)
+	if with*10 > without*7 {
+		t.Fatalf("expect at least 30%% reduction by the dictionary, got %d -> %d", without, with)
+	}
+}
@@ -0,0 +1,79 @@
+package method_acl
+
+import (
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/plugin"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// MethodACLPlugin restricts the service paths that each connection identity may call.
+// The identity is a string stored with IdentityKey in the context data or in the connection,
+// e.g. by an authentication plugin after the TLS handshake.
+type MethodACLPlugin struct {
+	identityKey interface{}
+	policy      map[string][]string // identity -> allowed path globs
+	sync.RWMutex
+}
+
+// NewMethodACLPlugin creates a MethodACLPlugin that reads the identity with the key.
+// The identity without a policy may call nothing.
+func NewMethodACLPlugin(identityKey interface{}) *MethodACLPlugin {
+	return &MethodACLPlugin{
+		identityKey: identityKey,
+		policy:      make(map[string][]string),
+	}
+}
+
+var _ plugin.IPlugin = new(MethodACLPlugin)
+
+// Name returns plugin name.
+func (acl *MethodACLPlugin) Name() string {
+	return "MethodACLPlugin"
+}
+
+// Allow sets the service path globs the identity may call, such as "/arith/*" (see path.Match).
+// It replaces the former policy of the identity, and can be called at runtime.
+func (acl *MethodACLPlugin) Allow(identity string, globs ...string) *MethodACLPlugin {
+	acl.Lock()
+	defer acl.Unlock()
+	acl.policy[identity] = append([]string(nil), globs...)
+	return acl
+}
+
+// Revoke removes the policy of the identity, which then may call nothing.
+func (acl *MethodACLPlugin) Revoke(identity string) {
+	acl.Lock()
+	defer acl.Unlock()
+	delete(acl.policy, identity)
+}
+
+// IsAllowed returns whether the identity may call the service path.
+func (acl *MethodACLPlugin) IsAllowed(identity, servicePath string) bool {
+	acl.RLock()
+	defer acl.RUnlock()
+	for _, glob := range acl.policy[identity] {
+		if ok, _ := path.Match(glob, servicePath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+var _ server.IPostReadRequestHeaderPlugin = new(MethodACLPlugin)
+
+// PostReadRequestHeader rejects the disallowed call before dispatch with an ErrorTypeServerForbidden error.
+func (acl *MethodACLPlugin) PostReadRequestHeader(ctx *server.Context) error {
+	identity, _ := ctx.Data().Get(acl.identityKey).(string)
+	if identity == "" {
+		identity, _ = ctx.Conn().GetValue(acl.identityKey).(string)
+	}
+	if !acl.IsAllowed(identity, ctx.Path()) {
+		ctx.SetErrorType(common.ErrorTypeServerForbidden)
+		return fmt.Errorf("identity %q may not call %s", identity, ctx.Path())
+	}
+	return nil
+}
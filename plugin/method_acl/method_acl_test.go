@@ -0,0 +1,64 @@
+package method_acl
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type identityKey struct{}
+
+// identityPlugin identifies every connection as "A".
+type identityPlugin struct{}
+
+func (*identityPlugin) Name() string {
+	return "identity_plugin"
+}
+
+func (*identityPlugin) PostConnAccept(conn server.ServerCodecConn) error {
+	conn.SetValue(identityKey{}, "A")
+	return nil
+}
+
+type worker struct{}
+
+func (*worker) Mul(arg int, reply *int) error {
+	*reply = arg * arg
+	return nil
+}
+
+func TestMethodACLPlugin(t *testing.T) {
+	acl := NewMethodACLPlugin(identityKey{}).Allow("A", "/arith/*")
+	srv := server.NewServer(server.Server{})
+	srv.PluginContainer.Add(new(identityPlugin), acl)
+	srv.NamedRegister("arith", new(worker))
+	srv.NamedRegister("admin", new(worker))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+
+	c := client.NewClient(client.Client{}, &selector.DirectSelector{Network: "tcp", Address: lis.Addr().String()})
+	defer c.Close()
+
+	var reply int
+	if e := c.Call("/arith/mul", 3, &reply); e != nil || reply != 9 {
+		t.Fatalf("arith: reply=%d, err=%v", reply, e)
+	}
+	e := c.Call("/admin/mul", 3, &reply)
+	if e == nil || e.Type != common.ErrorTypeServerForbidden || !strings.Contains(e.Error, "may not call /admin/mul") {
+		t.Fatalf("admin: expect forbidden error, got: %v", e)
+	}
+
+	// update the policy at runtime.
+	acl.Allow("A", "/arith/*", "/admin/*")
+	if e = c.Call("/admin/mul", 3, &reply); e != nil {
+		t.Fatalf("admin after update: %v", e)
+	}
+}
@@ -0,0 +1,175 @@
+package sample_log
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/henrylee2cn/myrpc/plugin"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// RedactTag is the struct tag of the fields whose values are not logged, e.g.
+//
+//	type LoginArgs struct {
+//		User     string
+//		Password string `log:"redact"`
+//	}
+const RedactTag = "log"
+
+// Redacted replaces the values of the redacted fields.
+const Redacted = "[REDACTED]"
+
+// maxDepth limits the nesting of the logged values, e.g. of the cyclic pointers.
+const maxDepth = 16
+
+// SampleLogPlugin logs the decoded argument and reply of a sample of the calls of each path,
+// as JSON with the sensitive fields redacted, for the diagnostics too expensive for all the calls.
+// The reply is logged before it is encoded, it is {} for the error responses.
+type SampleLogPlugin struct {
+	rates  map[string]float64 // path -> sample rate
+	redact map[string]bool    // the lower-cased names of the redacted fields
+	sync.RWMutex
+}
+
+type (
+	sampleKey struct{}
+
+	// sample is the call being logged.
+	sample struct {
+		arg    string
+		logged int32
+	}
+)
+
+// NewSampleLogPlugin creates a SampleLogPlugin that logs no call until the rates are set.
+// The fields named one of redact, case-insensitively, are redacted as the fields tagged `log:"redact"`.
+func NewSampleLogPlugin(redact ...string) *SampleLogPlugin {
+	p := &SampleLogPlugin{
+		rates:  make(map[string]float64),
+		redact: make(map[string]bool, len(redact)),
+	}
+	for _, name := range redact {
+		p.redact[strings.ToLower(name)] = true
+	}
+	return p
+}
+
+var _ plugin.IPlugin = new(SampleLogPlugin)
+
+// Name returns plugin name.
+func (p *SampleLogPlugin) Name() string {
+	return "SampleLogPlugin"
+}
+
+// SetRate sets the rate of the logged calls of the service path, from 0 (none) to 1 (all).
+// It can be called at runtime.
+func (p *SampleLogPlugin) SetRate(path string, rate float64) *SampleLogPlugin {
+	p.Lock()
+	defer p.Unlock()
+	if rate <= 0 {
+		delete(p.rates, path)
+	} else {
+		p.rates[path] = rate
+	}
+	return p
+}
+
+func (p *SampleLogPlugin) sampled(path string) bool {
+	p.RLock()
+	rate := p.rates[path]
+	p.RUnlock()
+	return rate >= 1 || (rate > 0 && rand.Float64() < rate)
+}
+
+var _ server.IPostReadRequestBodyPlugin = new(SampleLogPlugin)
+
+// PostReadRequestBody samples the call and keeps its argument as decoded,
+// before the service may change it.
+func (p *SampleLogPlugin) PostReadRequestBody(ctx *server.Context, body interface{}) error {
+	if !p.sampled(ctx.Path()) {
+		return nil
+	}
+	ctx.WithValue(sampleKey{}, &sample{arg: p.format(body)})
+	return nil
+}
+
+var _ server.IPreWriteResponsePlugin = new(SampleLogPlugin)
+
+// PreWriteResponse logs the argument and the reply of the sampled call.
+func (p *SampleLogPlugin) PreWriteResponse(ctx *server.Context, body interface{}) error {
+	s, ok := ctx.Value(sampleKey{}).(*sample)
+	if !ok || !atomic.CompareAndSwapInt32(&s.logged, 0, 1) {
+		return nil
+	}
+	ctx.Logger().Infof("sampled call: arg=%s reply=%s", s.arg, p.format(body))
+	return nil
+}
+
+// format returns the JSON of v with the sensitive fields redacted.
+func (p *SampleLogPlugin) format(v interface{}) string {
+	b, err := json.Marshal(p.redacted(reflect.ValueOf(v), 0))
+	if err != nil {
+		return "!" + err.Error()
+	}
+	return string(b)
+}
+
+// redacted returns a copy of v made of maps and slices, without the values of the redacted fields.
+func (p *SampleLogPlugin) redacted(v reflect.Value, depth int) interface{} {
+	if depth > maxDepth {
+		return "..."
+	}
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return p.redacted(v.Elem(), depth+1)
+	case reflect.Struct:
+		t := v.Type()
+		m := make(map[string]interface{}, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if f.Tag.Get(RedactTag) == "redact" || p.redact[strings.ToLower(f.Name)] {
+				m[f.Name] = Redacted
+				continue
+			}
+			m[f.Name] = p.redacted(v.Field(i), depth+1)
+		}
+		return m
+	case reflect.Map:
+		m := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			key, _ := json.Marshal(k.Interface())
+			name := strings.Trim(string(key), `"`)
+			if p.redact[strings.ToLower(name)] {
+				m[name] = Redacted
+				continue
+			}
+			m[name] = p.redacted(v.MapIndex(k), depth+1)
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface() // []byte as base64
+		}
+		a := make([]interface{}, v.Len())
+		for i := range a {
+			a[i] = p.redacted(v.Index(i), depth+1)
+		}
+		return a
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return v.Type().String()
+	default:
+		return v.Interface()
+	}
+}
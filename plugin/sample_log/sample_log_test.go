@@ -0,0 +1,82 @@
+package sample_log
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/log/logtest"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type (
+	LoginArgs struct {
+		User     string
+		Password string `log:"redact"`
+		Token    string
+	}
+	LoginReply struct {
+		Session string
+		Roles   []string
+	}
+)
+
+type worker struct{}
+
+func (*worker) Login(args *LoginArgs, reply *LoginReply) error {
+	reply.Session = "s-" + args.User
+	reply.Roles = []string{"admin"}
+	return nil
+}
+
+func (*worker) Other(args *LoginArgs, reply *LoginReply) error {
+	return nil
+}
+
+func TestSampleLogPlugin(t *testing.T) {
+	logs, restore := logtest.Capture()
+	defer restore()
+
+	p := NewSampleLogPlugin("token").SetRate("/worker/login", 1).SetRate("/worker/other", 0)
+	srv := server.NewServer(server.Server{})
+	srv.PluginContainer.Add(p)
+	srv.NamedRegister("worker", new(worker))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	c := client.NewClient(client.Client{}, &selector.DirectSelector{Network: "tcp", Address: lis.Addr().String()})
+	defer c.Close()
+
+	var reply LoginReply
+	args := &LoginArgs{User: "henry", Password: "p4ss", Token: "t0ken"}
+	for _, path := range []string{"/worker/other", "/worker/login"} {
+		if e := c.Call(path, args, &reply); e != nil {
+			t.Fatal(e.Error)
+		}
+	}
+
+	var sampled []string
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "sampled call") {
+			sampled = append(sampled, line)
+		}
+	}
+	if len(sampled) != 1 || !strings.Contains(sampled[0], "path=/worker/login") {
+		t.Fatalf("expect the login call logged only, got:\n%s", logs.String())
+	}
+	for _, want := range []string{
+		`arg={"Password":"[REDACTED]","Token":"[REDACTED]","User":"henry"}`,
+		`reply={"Roles":["admin"],"Session":"s-henry"}`,
+	} {
+		if !strings.Contains(sampled[0], want) {
+			t.Errorf("expect %s in: %s", want, sampled[0])
+		}
+	}
+	if strings.Contains(logs.String(), "p4ss") || strings.Contains(logs.String(), "t0ken") {
+		t.Fatalf("the secrets are logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,161 @@
+package reflection
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/protoc-gen-gogo/descriptor"
+
+	"github.com/henrylee2cn/myrpc/client"
+)
+
+const (
+	// DefaultDescribePath is the service path of the Describe service registered as "reflection".
+	DefaultDescribePath = "/reflection/describe"
+	// DefaultIdempotentPath is the service path of the IdempotentPaths service registered as "reflection".
+	DefaultIdempotentPath = "/reflection/idempotent_paths"
+)
+
+type (
+	// ServiceDescriptor describes the method of a service path.
+	ServiceDescriptor struct {
+		// Path is the service path, e.g. "/arith/mul".
+		Path string
+		// Method is the descriptor of the method, whose input and output types are
+		// the full names of the Arg and the Reply messages.
+		Method *descriptor.MethodDescriptorProto
+		Arg    *descriptor.DescriptorProto
+		Reply  *descriptor.DescriptorProto
+		// Files are the files describing the method and its messages.
+		Files []*descriptor.FileDescriptorProto
+		// Idempotent is whether the receiver declares the method idempotent.
+		Idempotent bool
+	}
+
+	// Client fetches the descriptors of the service paths from the Describe service of a server
+	// and caches them, for the generic clients validating the arguments before sending.
+	Client struct {
+		// DescribePath is the service path of the Describe service, DefaultDescribePath by default.
+		DescribePath string
+		client       *client.Client
+		ttl          time.Duration
+		cache        map[string]*cachedDescriptor // service path -> descriptor
+		lock         sync.Mutex
+	}
+
+	cachedDescriptor struct {
+		desc    *ServiceDescriptor
+		expires time.Time
+	}
+)
+
+// NewClient creates a Client calling the Describe service by c.
+// The descriptors are fetched again after the ttl, never if the ttl is 0.
+func NewClient(c *client.Client, ttl time.Duration) *Client {
+	return &Client{
+		DescribePath: DefaultDescribePath,
+		client:       c,
+		ttl:          ttl,
+		cache:        make(map[string]*cachedDescriptor),
+	}
+}
+
+// DescribeService returns the descriptor of the service path, from the cache if not expired.
+func (c *Client) DescribeService(path string) (*ServiceDescriptor, error) {
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	now := time.Now()
+	c.lock.Lock()
+	cached, ok := c.cache[path]
+	c.lock.Unlock()
+	if ok && (c.ttl <= 0 || now.Before(cached.expires)) {
+		return cached.desc, nil
+	}
+
+	var reply DescribeReply
+	if rpcErr := c.client.Call(c.DescribePath, path, &reply); rpcErr != nil {
+		return nil, errors.New("reflection: describe " + path + ": " + rpcErr.Error)
+	}
+	files, err := reply.Descriptors()
+	if err != nil {
+		return nil, err
+	}
+	desc, err := newServiceDescriptor(path, files)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range reply.Idempotent {
+		if p == path {
+			desc.Idempotent = true
+		}
+	}
+	c.lock.Lock()
+	c.cache[path] = &cachedDescriptor{desc: desc, expires: now.Add(c.ttl)}
+	c.lock.Unlock()
+	return desc, nil
+}
+
+// IsIdempotent returns whether the service of the path is declared idempotent,
+// so that the calls can be retried or hedged.
+func (c *Client) IsIdempotent(path string) (bool, error) {
+	desc, err := c.DescribeService(path)
+	if err != nil {
+		return false, err
+	}
+	return desc.Idempotent, nil
+}
+
+// Forget drops the cached descriptor of the service path, e.g. after the service is replaced.
+func (c *Client) Forget(path string) {
+	c.lock.Lock()
+	delete(c.cache, path)
+	c.lock.Unlock()
+}
+
+// newServiceDescriptor finds the method of the service path and its messages in the files.
+func newServiceDescriptor(path string, files []*descriptor.FileDescriptorProto) (*ServiceDescriptor, error) {
+	if len(files) == 0 {
+		return nil, errors.New("reflection: no descriptor of " + path)
+	}
+	serviceName, methodName := splitPath(path)
+	desc := &ServiceDescriptor{Path: path, Files: files}
+	for _, sd := range files[len(files)-1].Service {
+		if sd.GetName() != serviceName {
+			continue
+		}
+		for _, md := range sd.Method {
+			if md.GetName() == methodName {
+				desc.Method = md
+			}
+		}
+	}
+	if desc.Method == nil {
+		return nil, errors.New("reflection: can't find service " + path)
+	}
+	messages := make(map[string]*descriptor.DescriptorProto)
+	for _, f := range files {
+		prefix := ""
+		if f.GetPackage() != "" {
+			prefix = "." + f.GetPackage()
+		}
+		addMessages(messages, prefix, f.MessageType)
+	}
+	desc.Arg = messages[desc.Method.GetInputType()]
+	desc.Reply = messages[desc.Method.GetOutputType()]
+	if desc.Arg == nil || desc.Reply == nil {
+		return nil, errors.New("reflection: incomplete descriptor of " + path)
+	}
+	return desc, nil
+}
+
+// addMessages indexes the messages and their nested ones by the full names.
+func addMessages(messages map[string]*descriptor.DescriptorProto, prefix string, mds []*descriptor.DescriptorProto) {
+	for _, md := range mds {
+		name := prefix + "." + md.GetName()
+		messages[name] = md
+		addMessages(messages, name, md.NestedType)
+	}
+}
@@ -0,0 +1,89 @@
+package reflection
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/protoc-gen-gogo/descriptor"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// countingService counts the calls of the Describe service.
+type countingService struct {
+	*Service
+	calls int32
+}
+
+func (s *countingService) Describe(prefix string, reply *DescribeReply) error {
+	atomic.AddInt32(&s.calls, 1)
+	return s.Service.Describe(prefix, reply)
+}
+
+func TestDescribeService(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("arith", new(Arith))
+	service := &countingService{Service: NewService(srv)}
+	srv.NamedRegister("reflection", service)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	c := client.NewClient(client.Client{CallTimeout: 5 * time.Second}, &selector.DirectSelector{
+		Network: "tcp",
+		Address: lis.Addr().String(),
+	})
+	defer c.Close()
+
+	rc := NewClient(c, 200*time.Millisecond)
+	desc, err := rc.DescribeService("/arith/mul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Method.GetName() != "mul" || desc.Method.GetInputType() != ".arith.ArithArgs" {
+		t.Fatalf("method = %v", desc.Method)
+	}
+	if desc.Arg.GetName() != "ArithArgs" || len(desc.Arg.Field) != 2 ||
+		desc.Arg.Field[0].GetName() != "a" || desc.Arg.Field[0].GetType() != descriptor.FieldDescriptorProto_TYPE_INT32 {
+		t.Fatalf("arg = %v", desc.Arg)
+	}
+	if desc.Reply.GetName() != "ArithReply" || len(desc.Reply.Field) != 1 || desc.Reply.Field[0].GetName() != "c" {
+		t.Fatalf("reply = %v", desc.Reply)
+	}
+
+	if !desc.Idempotent {
+		t.Fatal("expect /arith/mul idempotent")
+	}
+
+	// cached
+	if again, err := rc.DescribeService("/arith/mul"); err != nil || again != desc {
+		t.Fatalf("cached: desc=%v, err=%v", again, err)
+	}
+	if n := atomic.LoadInt32(&service.calls); n != 1 {
+		t.Fatalf("describe calls = %d, want 1", n)
+	}
+
+	// served from the cache until refreshed after the ttl
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&service.calls) == 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the descriptor isn't refreshed after the ttl")
+		}
+		if _, err := rc.DescribeService("/arith/mul"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&service.calls); n != 2 {
+		t.Fatalf("describe calls after the ttl = %d, want 2", n)
+	}
+
+	if _, err := rc.DescribeService("/arith/div"); err == nil {
+		t.Fatal("expect the unknown path failing")
+	}
+}
@@ -0,0 +1,430 @@
+// Package reflection describes the registered services of a server as protobuf descriptors,
+// for the tools calling a server without its Go types, like the gRPC server reflection.
+//
+// Server side:
+//
+//	srv.NamedRegister("reflection", reflection.NewService(srv))
+//
+// Client side:
+//
+//	var reply reflection.DescribeReply
+//	c.Call("/reflection/describe", "", &reply)
+//	files, err := reply.Descriptors()
+//
+// or, caching the descriptor of a service path:
+//
+//	desc, err := reflection.NewClient(c, time.Minute).DescribeService("/arith/mul")
+//
+// The reply lists the described service paths whose receivers declare them idempotent
+// by implementing server.Idempotency, for the clients deciding whether to retry or hedge.
+//
+// The methods are described in a synthesized file of package ServicePackage: the service
+// path "/arith/mul" is the method "mul" of the service "arith". The arguments and replies
+// that are protobuf messages refer to the descriptors of their own files, which are described
+// too. The other types are described best-effort, as messages of the synthesized file
+// whose fields are numbered in the order of the exported struct fields.
+package reflection
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/protoc-gen-gogo/descriptor"
+
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+const (
+	// ServicePackage is the protobuf package of the synthesized file describing the methods.
+	ServicePackage = "myrpc"
+	// ServiceFile is the name of the synthesized file describing the methods.
+	ServiceFile = "myrpc/reflection.proto"
+)
+
+type (
+	// DescribeReply is the reply of the Describe service.
+	DescribeReply struct {
+		// Files are the serialized FileDescriptorProtos, the files of the protobuf messages
+		// first and the synthesized file last.
+		Files [][]byte
+		// Idempotent are the described service paths declared idempotent, see server.Idempotency.
+		Idempotent []string
+	}
+
+	// Service describes the registered services of a server.
+	Service struct {
+		server *server.Server
+	}
+
+	// protoMessage is a generated protobuf message knowing its descriptor.
+	protoMessage interface {
+		proto.Message
+		Descriptor() ([]byte, []int)
+	}
+
+	// describer builds the descriptors of the methods.
+	describer struct {
+		files    []*descriptor.FileDescriptorProto
+		fileSet  map[string]bool
+		service  *descriptor.FileDescriptorProto
+		services map[string]*descriptor.ServiceDescriptorProto
+		names    map[reflect.Type]string // the full names of the described types
+		taken    map[string]bool         // the message names of the synthesized file
+	}
+)
+
+var protoMessageType = reflect.TypeOf((*protoMessage)(nil)).Elem()
+
+// NewService creates a Service describing the registered services of srv.
+func NewService(srv *server.Server) *Service {
+	return &Service{server: srv}
+}
+
+// Describe describes the methods whose service path has the prefix, all of them if prefix is "".
+func (s *Service) Describe(prefix string, reply *DescribeReply) error {
+	files, err := Describe(s.server, prefix)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		b, err := proto.Marshal(f)
+		if err != nil {
+			return err
+		}
+		reply.Files = append(reply.Files, b)
+	}
+	reply.Idempotent = idempotentPaths(s.server, prefix)
+	return nil
+}
+
+// IdempotentPaths lists the service paths with the prefix declared idempotent,
+// for the clients routing the calls by it, e.g. selector.ReplicaSelector.
+func (s *Service) IdempotentPaths(prefix string, reply *[]string) error {
+	*reply = idempotentPaths(s.server, prefix)
+	return nil
+}
+
+// Descriptors parses the files of the reply.
+func (r *DescribeReply) Descriptors() ([]*descriptor.FileDescriptorProto, error) {
+	files := make([]*descriptor.FileDescriptorProto, 0, len(r.Files))
+	for _, b := range r.Files {
+		f := new(descriptor.FileDescriptorProto)
+		if err := proto.Unmarshal(b, f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// idempotentPaths returns the service paths of srv with the prefix declared idempotent.
+func idempotentPaths(srv *server.Server, prefix string) []string {
+	var paths []string
+	for _, m := range srv.Methods() {
+		if m.Idempotent && strings.HasPrefix(m.Path, prefix) {
+			paths = append(paths, m.Path)
+		}
+	}
+	return paths
+}
+
+// Describe returns the descriptors of the methods of srv whose service path has the prefix:
+// the files of their protobuf messages, followed by the synthesized file ServiceFile.
+func Describe(srv *server.Server, prefix string) ([]*descriptor.FileDescriptorProto, error) {
+	d := &describer{
+		fileSet: make(map[string]bool),
+		service: &descriptor.FileDescriptorProto{
+			Name:    proto.String(ServiceFile),
+			Package: proto.String(ServicePackage),
+			Syntax:  proto.String("proto3"),
+		},
+		services: make(map[string]*descriptor.ServiceDescriptorProto),
+		names:    make(map[reflect.Type]string),
+		taken:    make(map[string]bool),
+	}
+	for _, m := range srv.Methods() {
+		if !strings.HasPrefix(m.Path, prefix) {
+			continue
+		}
+		if err := d.addMethod(m); err != nil {
+			return nil, err
+		}
+	}
+	return append(d.files, d.service), nil
+}
+
+// addMethod adds the method of the service path m.Path, "/a/b/c" being the method "c"
+// of the service "a.b".
+func (d *describer) addMethod(m server.MethodType) error {
+	serviceName, methodName := splitPath(m.Path)
+	sd, ok := d.services[serviceName]
+	if !ok {
+		sd = &descriptor.ServiceDescriptorProto{Name: proto.String(serviceName)}
+		d.services[serviceName] = sd
+		d.service.Service = append(d.service.Service, sd)
+	}
+	input, err := d.message(m.ArgType)
+	if err != nil {
+		return err
+	}
+	output, err := d.message(m.ReplyType)
+	if err != nil {
+		return err
+	}
+	sd.Method = append(sd.Method, &descriptor.MethodDescriptorProto{
+		Name:       proto.String(methodName),
+		InputType:  proto.String(input),
+		OutputType: proto.String(output),
+	})
+	return nil
+}
+
+// splitPath returns the service and the method names of the service path,
+// e.g. "a.b" and "c" of "/a/b/c", and "root" and "c" of "/c".
+func splitPath(servicePath string) (serviceName, methodName string) {
+	path := strings.Trim(servicePath, "/")
+	i := strings.LastIndex(path, "/")
+	serviceName = "root"
+	if i >= 0 {
+		elems := strings.Split(path[:i], "/")
+		for j := range elems {
+			elems[j] = identifier(elems[j])
+		}
+		serviceName = strings.Join(elems, ".")
+	}
+	return serviceName, identifier(path[i+1:])
+}
+
+// message returns the full name of the message describing t, adding its descriptor.
+func (d *describer) message(t reflect.Type) (string, error) {
+	if t == nil {
+		return d.synthesize(reflect.TypeOf(struct{}{}), "Empty"), nil
+	}
+	if name, ok := d.names[t]; ok {
+		return name, nil
+	}
+	if t.Implements(protoMessageType) || reflect.PtrTo(t).Implements(protoMessageType) {
+		name, err := d.protoMessage(t)
+		if err != nil {
+			return "", err
+		}
+		d.names[t] = name
+		return name, nil
+	}
+	if t.Kind() == reflect.Ptr {
+		name, err := d.message(t.Elem())
+		d.names[t] = name
+		return name, err
+	}
+	return d.synthesize(t, ""), nil
+}
+
+// protoMessage adds the file of the protobuf message type t and returns its full name.
+func (d *describer) protoMessage(t reflect.Type) (string, error) {
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PtrTo(t)
+	}
+	gz, path := reflect.New(t.Elem()).Interface().(protoMessage).Descriptor()
+	if len(path) == 0 {
+		return "", errors.New("reflection: no descriptor path of " + t.String())
+	}
+	fd, err := extractFile(gz)
+	if err != nil {
+		return "", err
+	}
+	name := ""
+	if fd.GetPackage() != "" {
+		name = "." + fd.GetPackage()
+	}
+	if path[0] >= len(fd.MessageType) {
+		return "", errors.New("reflection: invalid descriptor path of " + t.String())
+	}
+	md := fd.MessageType[path[0]]
+	name += "." + md.GetName()
+	for _, i := range path[1:] {
+		if i >= len(md.NestedType) {
+			return "", errors.New("reflection: invalid descriptor path of " + t.String())
+		}
+		md = md.NestedType[i]
+		name += "." + md.GetName()
+	}
+	if !d.fileSet[fd.GetName()] {
+		d.fileSet[fd.GetName()] = true
+		d.files = append(d.files, fd)
+		d.service.Dependency = append(d.service.Dependency, fd.GetName())
+	}
+	return name, nil
+}
+
+// synthesize adds the best-effort descriptor of t to the synthesized file and returns
+// its full name. A type other than a struct is described as a message of a single field "value".
+func (d *describer) synthesize(t reflect.Type, name string) string {
+	if full, ok := d.names[t]; ok {
+		return full
+	}
+	if name == "" {
+		name = typeName(t)
+	}
+	for i, base := 2, name; d.taken[name]; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	d.taken[name] = true
+	full := "." + ServicePackage + "." + name
+	d.names[t] = full
+
+	md := &descriptor.DescriptorProto{Name: proto.String(name)}
+	d.service.MessageType = append(d.service.MessageType, md)
+	if t.Kind() != reflect.Struct || t == timeType {
+		md.Field = append(md.Field, d.field(md, full, "value", 1, t))
+		return full
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		md.Field = append(md.Field, d.field(md, full, f.Name, int32(i+1), f.Type))
+	}
+	return full
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// field returns the descriptor of the field of type t in md, whose full name is mdName.
+func (d *describer) field(md *descriptor.DescriptorProto, mdName, name string, number int32, t reflect.Type) *descriptor.FieldDescriptorProto {
+	fd := &descriptor.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Label:  descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		fd.Label = descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		t = t.Elem()
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	case reflect.Map:
+		// a map is a repeated entry message, as protoc generates for the map fields
+		entryName := mdName + "." + name + "Entry"
+		entry := &descriptor.DescriptorProto{
+			Name:    proto.String(name + "Entry"),
+			Options: &descriptor.MessageOptions{MapEntry: proto.Bool(true)},
+		}
+		entry.Field = []*descriptor.FieldDescriptorProto{
+			d.field(entry, entryName, "key", 1, t.Key()),
+			d.field(entry, entryName, "value", 2, t.Elem()),
+		}
+		md.NestedType = append(md.NestedType, entry)
+		fd.Label = descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		fd.Type = descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		fd.TypeName = proto.String(entryName)
+		return fd
+	}
+	fd.Type = scalarType(t).Enum()
+	if fd.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+		// a protobuf message has a descriptor, else it can't fail
+		name, err := d.message(t)
+		if err != nil {
+			name = d.synthesize(t, "")
+		}
+		fd.TypeName = proto.String(name)
+	}
+	return fd
+}
+
+// scalarType returns the protobuf type of t, TYPE_MESSAGE for a struct,
+// TYPE_BYTES if there is no equivalent.
+func scalarType(t reflect.Type) descriptor.FieldDescriptorProto_Type {
+	switch t.Kind() {
+	case reflect.Bool:
+		return descriptor.FieldDescriptorProto_TYPE_BOOL
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return descriptor.FieldDescriptorProto_TYPE_INT32
+	case reflect.Int, reflect.Int64:
+		return descriptor.FieldDescriptorProto_TYPE_INT64
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return descriptor.FieldDescriptorProto_TYPE_UINT32
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return descriptor.FieldDescriptorProto_TYPE_UINT64
+	case reflect.Float32:
+		return descriptor.FieldDescriptorProto_TYPE_FLOAT
+	case reflect.Float64:
+		return descriptor.FieldDescriptorProto_TYPE_DOUBLE
+	case reflect.String:
+		return descriptor.FieldDescriptorProto_TYPE_STRING
+	case reflect.Struct:
+		return descriptor.FieldDescriptorProto_TYPE_MESSAGE
+	}
+	return descriptor.FieldDescriptorProto_TYPE_BYTES
+}
+
+// typeName returns the message name of the Go type t, e.g. "Args" for "*server.Args",
+// "StringValue" for "string" and "RepeatedStringValue" for "[]string".
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() != "" && t.Kind() == reflect.Struct {
+		return identifier(t.Name())
+	}
+	s := t.String()
+	s = strings.Replace(s, "[]", "Repeated ", -1)
+	s = strings.Replace(s, "map[", "Map ", -1)
+	return identifier(strings.Title(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return ' '
+	}, s)) + "Value")
+}
+
+// identifier returns s with the characters invalid in a protobuf identifier removed,
+// and "_" prepended if it starts with a digit.
+func identifier(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)), r == '_':
+			return r
+		case r == '-' || r == '.':
+			return '_'
+		}
+		return -1
+	}, s)
+	if s == "" || unicode.IsDigit(rune(s[0])) {
+		s = "_" + s
+	}
+	return s
+}
+
+// extractFile extracts a FileDescriptorProto from the gzipped descriptor of a protobuf message.
+func extractFile(gz []byte) (*descriptor.FileDescriptorProto, error) {
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	fd := new(descriptor.FileDescriptorProto)
+	if err := proto.Unmarshal(b, fd); err != nil {
+		return nil, err
+	}
+	return fd, nil
+}
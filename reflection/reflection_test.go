@@ -0,0 +1,180 @@
+package reflection
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/protoc-gen-gogo/descriptor"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// ArithArgs and ArithReply are the protobuf messages of arith.proto, as generated by protoc-gen-gogo.
+type ArithArgs struct {
+	A int32 `protobuf:"varint,1,opt,name=a,proto3" json:"a,omitempty"`
+	B int32 `protobuf:"varint,2,opt,name=b,proto3" json:"b,omitempty"`
+}
+
+func (m *ArithArgs) Reset()                    { *m = ArithArgs{} }
+func (m *ArithArgs) String() string            { return proto.CompactTextString(m) }
+func (*ArithArgs) ProtoMessage()               {}
+func (*ArithArgs) Descriptor() ([]byte, []int) { return fileDescriptorArith, []int{0} }
+
+type ArithReply struct {
+	C int32 `protobuf:"varint,1,opt,name=c,proto3" json:"c,omitempty"`
+}
+
+func (m *ArithReply) Reset()                    { *m = ArithReply{} }
+func (m *ArithReply) String() string            { return proto.CompactTextString(m) }
+func (*ArithReply) ProtoMessage()               {}
+func (*ArithReply) Descriptor() ([]byte, []int) { return fileDescriptorArith, []int{1} }
+
+var fileDescriptorArith = func() []byte {
+	int32Field := func(name string, number int32) *descriptor.FieldDescriptorProto {
+		return &descriptor.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   descriptor.FieldDescriptorProto_TYPE_INT32.Enum(),
+		}
+	}
+	b, err := proto.Marshal(&descriptor.FileDescriptorProto{
+		Name:    proto.String("arith.proto"),
+		Package: proto.String("arith"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptor.DescriptorProto{
+			{Name: proto.String("ArithArgs"), Field: []*descriptor.FieldDescriptorProto{int32Field("a", 1), int32Field("b", 2)}},
+			{Name: proto.String("ArithReply"), Field: []*descriptor.FieldDescriptorProto{int32Field("c", 1)}},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}()
+
+type Arith struct{}
+
+func (Arith) Mul(args *ArithArgs, reply *ArithReply) error {
+	reply.C = args.A * args.B
+	return nil
+}
+
+func (Arith) Idempotent(method string) bool { return method == "Mul" }
+
+// EchoArgs is not a protobuf message.
+type EchoArgs struct {
+	Text   string
+	Times  int
+	Labels map[string]string
+	hidden bool
+}
+
+type Echo struct{}
+
+func (Echo) Say(args *EchoArgs, reply *string) error {
+	*reply = args.Text
+	return nil
+}
+
+func TestDescribe(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("arith", new(Arith))
+	srv.NamedRegister("echo", new(Echo))
+	srv.NamedRegister("reflection", NewService(srv))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	c := client.NewClient(client.Client{CallTimeout: 5 * time.Second}, &selector.DirectSelector{
+		Network: "tcp",
+		Address: lis.Addr().String(),
+	})
+	defer c.Close()
+
+	var reply DescribeReply
+	if rpcErr := c.Call("/reflection/describe", "/", &reply); rpcErr != nil {
+		t.Fatal(rpcErr.Error)
+	}
+	if len(reply.Idempotent) != 1 || reply.Idempotent[0] != "/arith/mul" {
+		t.Fatalf("idempotent = %v, want [/arith/mul]", reply.Idempotent)
+	}
+	files, err := reply.Descriptors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("files = %d, want arith.proto and %s", len(files), ServiceFile)
+	}
+	arith, synthesized := files[0], files[1]
+	if arith.GetName() != "arith.proto" || len(arith.MessageType) != 2 ||
+		arith.MessageType[0].GetName() != "ArithArgs" || len(arith.MessageType[0].Field) != 2 ||
+		arith.MessageType[1].GetName() != "ArithReply" || len(arith.MessageType[1].Field) != 1 {
+		t.Fatalf("arith.proto = %v", arith)
+	}
+	if synthesized.GetName() != ServiceFile || len(synthesized.Dependency) != 1 || synthesized.Dependency[0] != "arith.proto" {
+		t.Fatalf("%s = %v", ServiceFile, synthesized)
+	}
+
+	methods := make(map[string]*descriptor.MethodDescriptorProto)
+	for _, s := range synthesized.Service {
+		for _, m := range s.Method {
+			methods[s.GetName()+"."+m.GetName()] = m
+		}
+	}
+	mul := methods["arith.mul"]
+	if mul.GetInputType() != ".arith.ArithArgs" || mul.GetOutputType() != ".arith.ArithReply" {
+		t.Fatalf("arith.mul = %v", mul)
+	}
+	if _, ok := methods["reflection.describe"]; !ok {
+		t.Fatalf("methods = %v", methods)
+	}
+
+	// the other types are described best-effort
+	say := methods["echo.say"]
+	if say.GetInputType() != ".myrpc.EchoArgs" || say.GetOutputType() != ".myrpc.StringValue" {
+		t.Fatalf("echo.say = %v", say)
+	}
+	messages := make(map[string]*descriptor.DescriptorProto)
+	for _, m := range synthesized.MessageType {
+		messages[m.GetName()] = m
+	}
+	echoArgs := messages["EchoArgs"]
+	if echoArgs == nil || len(echoArgs.Field) != 3 {
+		t.Fatalf("EchoArgs = %v", echoArgs)
+	}
+	wantTypes := []descriptor.FieldDescriptorProto_Type{
+		descriptor.FieldDescriptorProto_TYPE_STRING,
+		descriptor.FieldDescriptorProto_TYPE_INT64,
+		descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+	}
+	for i, f := range echoArgs.Field {
+		if f.GetType() != wantTypes[i] || f.GetNumber() != int32(i+1) {
+			t.Errorf("EchoArgs field %d = %v", i, f)
+		}
+	}
+	if labels := echoArgs.Field[2]; labels.GetLabel() != descriptor.FieldDescriptorProto_LABEL_REPEATED ||
+		labels.GetTypeName() != ".myrpc.EchoArgs.LabelsEntry" || !echoArgs.NestedType[0].GetOptions().GetMapEntry() {
+		t.Errorf("EchoArgs.Labels = %v", labels)
+	}
+
+	// the prefix selects the methods
+	files, err = Describe(srv, "/echo/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || len(files[0].Service) != 1 || files[0].Service[0].GetName() != "echo" {
+		t.Fatalf("files = %v", files)
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// countingConn counts the bytes of the requests read from the connection, see MaxCallBytes.
+// It reads through a bufio.Reader exposed as an io.ByteReader, so that e.g. gob doesn't
+// read ahead of the request being read. It is used by the read loop only.
+type countingConn struct {
+	net.Conn
+	r      *bufio.Reader
+	read   int64
+	marked int64
+}
+
+func newCountingConn(conn net.Conn) *countingConn {
+	return &countingConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.read += int64(n)
+	return n, err
+}
+
+// ReadByte implements io.ByteReader.
+func (c *countingConn) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.read++
+	}
+	return b, err
+}
+
+// mark starts counting the bytes of a request.
+func (c *countingConn) mark() {
+	c.marked = c.read
+}
+
+// sinceMark returns the bytes read since the mark.
+func (c *countingConn) sinceMark() int64 {
+	return c.read - c.marked
+}
+
+func callTooLarge(n, max int64) error {
+	return common.NewError("call too large: " + strconv.FormatInt(n, 10) + " bytes > MaxCallBytes (" + strconv.FormatInt(max, 10) + ")")
+}
+
+// measureRequest records the bytes read for the request, and rejects the call
+// if they exceed the MaxCallBytes alone.
+func (ctx *Context) measureRequest() error {
+	if ctx.counting == nil {
+		return nil
+	}
+	ctx.requestBytes = ctx.counting.sinceMark()
+	if max := ctx.server.MaxCallBytes; ctx.requestBytes > max {
+		ctx.rpcErrorType = common.ErrorTypeServerCallTooLarge
+		return callTooLarge(ctx.requestBytes, max)
+	}
+	return nil
+}
+
+// limitResponse limits the encoded body of the response to the bytes left by the request
+// if the codec supports it, the reply exceeding them is reported by the WriteResponse of the codec.
+func (ctx *Context) limitResponse(body interface{}) {
+	if ctx.counting == nil {
+		return
+	}
+	l, ok := ctx.codecConn.GetServerCodec().(common.ResponseLimiter)
+	if !ok {
+		return
+	}
+	if len(ctx.resp.Error) > 0 || body == invalidRequest {
+		l.LimitResponse(-1)
+	} else {
+		l.LimitResponse(ctx.server.MaxCallBytes - ctx.requestBytes)
+	}
+}
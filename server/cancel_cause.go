@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+)
+
+// CancelCause is the reason the ctx.Context() of a call is canceled.
+type CancelCause int
+
+const (
+	// CancelNone means the call is not canceled.
+	CancelNone CancelCause = iota
+	// CancelDeadline means the call timeout elapsed, see SetCallTimeout.
+	CancelDeadline
+	// CancelClientDisconnect means the client closed the connection before the call returned.
+	CancelClientDisconnect
+	// CancelServerShutdown means the server shut down before the call returned.
+	CancelServerShutdown
+	// CancelStream means the client canceled the streamed reply of the call, see Stream.
+	CancelStream
+	// CancelUnknown means the context is canceled for a reason not set by the server.
+	CancelUnknown
+)
+
+var cancelCauseStrs = [...]string{
+	"none",
+	"deadline",
+	"client-disconnect",
+	"server-shutdown",
+	"stream",
+	"unknown",
+}
+
+func (c CancelCause) String() string {
+	if c < 0 || int(c) >= len(cancelCauseStrs) {
+		return cancelCauseStrs[CancelUnknown]
+	}
+	return cancelCauseStrs[c]
+}
+
+// cancelError is the cause of the canceled context, see context.Cause.
+type cancelError struct {
+	cause CancelCause
+}
+
+func (e *cancelError) Error() string {
+	return "rpc: call canceled: " + e.cause.String()
+}
+
+var (
+	errCanceledDeadline         = &cancelError{CancelDeadline}
+	errCanceledClientDisconnect = &cancelError{CancelClientDisconnect}
+	errCanceledServerShutdown   = &cancelError{CancelServerShutdown}
+	errCanceledStream           = &cancelError{CancelStream}
+)
+
+// CancelCause returns why ctx.Context() is canceled, CancelNone if it isn't.
+// context.Cause(ctx.Context()) returns the cause as an error.
+func (ctx *Context) CancelCause() CancelCause {
+	c := ctx.Context()
+	if c == nil || c.Err() == nil {
+		return CancelNone
+	}
+	if e, ok := context.Cause(c).(*cancelError); ok {
+		return e.cause
+	}
+	return CancelUnknown
+}
+
+// baseContext returns the context of all the calls, which is canceled at the shutdown.
+func (server *Server) baseContext() context.Context {
+	if server.baseCtx == nil {
+		return context.Background()
+	}
+	return server.baseCtx
+}
@@ -0,0 +1,40 @@
+package server
+
+import "crypto/tls"
+
+// SetCertificate swaps the certificate of the TLS listeners served by ServeTLS without restart,
+// e.g. for the rotation of the short-lived certificates. The new handshakes use the certificate
+// in preference to the ones of the tls.Config, the established connections continue on the old one.
+func (server *Server) SetCertificate(cert tls.Certificate) {
+	server.certificate.Store(&cert)
+}
+
+// Certificate returns the certificate set by SetCertificate, nil if none.
+func (server *Server) Certificate() *tls.Certificate {
+	cert, _ := server.certificate.Load().(*tls.Certificate)
+	return cert
+}
+
+// tlsConfig returns a copy of the config of ServeTLS that serves the certificate set by SetCertificate
+// when the handshake happens, the GetConfigForClient of the config takes precedence.
+func (server *Server) tlsConfig(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	getConfigForClient := config.GetConfigForClient
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if getConfigForClient != nil {
+			if c, err := getConfigForClient(hello); c != nil || err != nil {
+				return c, err
+			}
+		}
+		cert := server.Certificate()
+		if cert == nil {
+			return nil, nil
+		}
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.GetCertificate = nil
+		c.Certificates = []tls.Certificate{*cert}
+		return c, nil
+	}
+	return config
+}
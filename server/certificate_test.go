@@ -0,0 +1,89 @@
+package server_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// certRecorder records the common names of the server certificates of the handshakes.
+type certRecorder struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (r *certRecorder) config() *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			r.mu.Lock()
+			r.names = append(r.names, cert.Subject.CommonName)
+			r.mu.Unlock()
+			return nil
+		},
+	}
+}
+
+func (r *certRecorder) handshakes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.names...)
+}
+
+func TestSetCertificate(t *testing.T) {
+	lis := listen(t)
+	addr := lis.Addr().String()
+	lis.Close()
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", &worker{name: "w"})
+	go srv.ServeTLS("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t, "old.example.com")},
+	})
+	waitFor(t, "the TLS listener", func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	})
+
+	var before certRecorder
+	c1 := newClient(client.Client{TLSConfig: before.config()}, addr)
+	defer c1.Close()
+	var reply string
+	if e := c1.Call("/worker/name", "x", &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+
+	srv.SetCertificate(selfSignedCert(t, "new.example.com"))
+	if cert := srv.Certificate(); cert == nil {
+		t.Fatal("expect the swapped certificate")
+	}
+
+	var after certRecorder
+	c2 := newClient(client.Client{TLSConfig: after.config()}, addr)
+	defer c2.Close()
+	if e := c2.Call("/worker/name", "x", &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	if names := after.handshakes(); len(names) != 1 || names[0] != "new.example.com" {
+		t.Fatalf("expect the new handshake to use the new certificate, got %v", names)
+	}
+
+	// the pre-existing connection keeps working without a new handshake
+	if e := c1.Call("/worker/name", "y", &reply); e != nil || reply != "w: y" {
+		t.Fatalf("pre-existing connection: reply=%q, err=%v", reply, e)
+	}
+	if names := before.handshakes(); len(names) != 1 || names[0] != "old.example.com" {
+		t.Fatalf("expect the pre-existing connection on the old certificate, got %v", names)
+	}
+}
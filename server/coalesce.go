@@ -0,0 +1,93 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+	"sync"
+)
+
+type (
+	// coalescer coalesces the concurrent calls of the same service and identical arguments,
+	// so that the service runs once and all the callers receive the same reply.
+	coalescer struct {
+		lock  sync.Mutex
+		paths map[string]bool
+		calls map[string]*coalescedCall
+	}
+
+	coalescedCall struct {
+		wg     sync.WaitGroup
+		replyv reflect.Value
+		err    error
+	}
+)
+
+var errCoalescedPanic = errors.New("coalesced call panicked")
+
+// EnableCoalescing enables request coalescing for the service paths:
+// the concurrent requests for the same path, metadata and arguments are served by a single call.
+// The services streaming the reply are not coalesced, the reader can't be shared.
+// Note: Side-effecting services must not be coalesced!
+func (server *Server) EnableCoalescing(paths ...string) {
+	server.coalescer.lock.Lock()
+	defer server.coalescer.lock.Unlock()
+	if server.coalescer.paths == nil {
+		server.coalescer.paths = make(map[string]bool)
+		server.coalescer.calls = make(map[string]*coalescedCall)
+	}
+	for _, p := range paths {
+		server.coalescer.paths[p] = true
+	}
+}
+
+// callCoalesced calls the service of the context, with coalescing if it is enabled.
+func (server *Server) callCoalesced(ctx *Context) (reflect.Value, error) {
+	c := &server.coalescer
+	path := ctx.service.GetPath()
+	c.lock.Lock()
+	enabled := c.paths[path]
+	c.lock.Unlock()
+	if !enabled {
+		return ctx.service.Call(ctx.argv, ctx)
+	}
+	key, ok := callKey(path, ctx.query, ctx.argv)
+	if !ok {
+		return ctx.service.Call(ctx.argv, ctx)
+	}
+
+	c.lock.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.lock.Unlock()
+		call.wg.Wait()
+		return call.replyv, call.err
+	}
+	call := &coalescedCall{err: errCoalescedPanic}
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.calls, key)
+		c.lock.Unlock()
+		call.wg.Done()
+	}()
+	call.replyv, call.err = ctx.service.Call(ctx.argv, ctx)
+	return call.replyv, call.err
+}
+
+// callKey returns the key of the call of the path with the metadata and the argument, which is
+// the path, the sorted metadata and the hash of the JSON-encoded argument, or false if the argument
+// can't be encoded.
+func callKey(path string, query url.Values, argv reflect.Value) (string, bool) {
+	b, err := json.Marshal(argv.Interface())
+	if err != nil {
+		return "", false
+	}
+	sum := sha1.Sum(b)
+	return path + "?" + query.Encode() + "#" + hex.EncodeToString(sum[:]), true
+}
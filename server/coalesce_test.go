@@ -0,0 +1,52 @@
+package server_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// expensive holds the calls until the gate is closed.
+type expensive struct {
+	count int32
+	gate  chan struct{}
+}
+
+func (e *expensive) Square(arg int, reply *int) error {
+	atomic.AddInt32(&e.count, 1)
+	<-e.gate
+	*reply = arg * arg
+	return nil
+}
+
+func TestCoalescing(t *testing.T) {
+	e := &expensive{gate: make(chan struct{})}
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("expensive", e)
+	srv.EnableCoalescing("/expensive/square")
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply int
+			if e := c.Call("/expensive/square", 7, &reply); e != nil || reply != 49 {
+				t.Errorf("reply=%d, err=%v", reply, e)
+			}
+		}()
+	}
+	// release the call once all the callers are in flight.
+	waitFor(t, "the callers in flight", func() bool { return srv.Goroutines() == n })
+	close(e.gate)
+	wg.Wait()
+	if count := atomic.LoadInt32(&e.count); count != 1 {
+		t.Fatalf("handler executed %d times", count)
+	}
+}
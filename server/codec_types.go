@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// probeConn is the connection of the codec created to check the types of the services,
+// see ITypeChecker. It has nothing to read and discards the writes.
+type probeConn struct{}
+
+func (probeConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (probeConn) Write(b []byte) (int, error) { return len(b), nil }
+func (probeConn) Close() error                { return nil }
+
+var streamedReplyType = reflect.TypeOf((*io.Reader)(nil))
+
+// checkCodecTypes returns the errors of the arguments and replies of the services
+// that the codec of the server can't encode, if the codec implements ITypeChecker.
+func (server *Server) checkCodecTypes(services []IService) []error {
+	if server.ServerCodecFunc == nil {
+		return nil
+	}
+	checker, ok := server.ServerCodecFunc(probeConn{}).(ITypeChecker)
+	if !ok {
+		return nil
+	}
+	check := func(path, what string, t reflect.Type) error {
+		if t.Kind() != reflect.Ptr {
+			t = reflect.PtrTo(t)
+		}
+		if err := checker.CheckType(t); err != nil {
+			return fmt.Errorf("the codec can't encode the %s %s of %s: %s", what, t, path, err.Error())
+		}
+		return nil
+	}
+	var errs []error
+	for _, service := range services {
+		path := service.GetPath()
+		if d, ok := service.(interface{ getArgDecoder() ArgDecoder }); !ok || d.getArgDecoder() == nil {
+			if t := service.GetArgType(); t != nil {
+				if err := check(path, "argument", t); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+		if r, ok := service.(interface{ getReplyType() reflect.Type }); ok {
+			if t := r.getReplyType(); t != nil && t != streamedReplyType {
+				if err := check(path, "reply", t); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errs
+}
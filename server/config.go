@@ -0,0 +1,130 @@
+package server
+
+import (
+	"time"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// ServerConfig is a snapshot of the effective configuration of the server,
+// e.g. for a debug endpoint or for asserting the configuration in tests.
+type ServerConfig struct {
+	Timeout       time.Duration
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+	IdleTimeout   time.Duration
+	HeaderTimeout time.Duration
+	// Codec is the name of the ServerCodecFunc.
+	Codec string
+	// ServiceBuilder is the type name of the ServiceBuilder.
+	ServiceBuilder string
+	Capabilities   []string
+	Workers        int
+	// MaxQueuedCalls is the limit of the calls waiting for the Workers.
+	MaxQueuedCalls int
+	// MaxPendingResponses is the limit of the unwritten responses of a connection.
+	MaxPendingResponses int
+	// MaxHops is the limit of the servers a call passes.
+	MaxHops int
+	// DisableHTTP is whether the HTTP CONNECT transport is disabled.
+	DisableHTTP bool
+	// AcceptParallelism is the number of the accept goroutines of a listener.
+	AcceptParallelism int
+	// PanicPolicy is how the panic of a service is handled.
+	PanicPolicy string
+	// PluginPanicPolicy is how the panic of a plugin hook is handled.
+	PluginPanicPolicy string
+	// DecompressRequests is whether the compressed requests are decompressed.
+	DecompressRequests bool
+	// MaxCallBytes is the limit of the request and the reply of a call together.
+	MaxCallBytes int64
+	// MaxGoroutines is the limit of the goroutines running the calls.
+	MaxGoroutines int
+	// Shard is the shard label of the served connections.
+	Shard string
+	// ProtocolVersions are the negotiated protocol versions of the framing.
+	ProtocolVersions []byte
+	// WatchdogTeardown is whether the connection of a runaway call is closed.
+	WatchdogTeardown bool
+	// Watchdogs are the watchdog limits of the service paths.
+	Watchdogs map[string]time.Duration
+	// LoadReporter is whether the LoadReporter is set.
+	LoadReporter bool
+	// FeatureFlag is whether the FeatureFlag is set.
+	FeatureFlag bool
+	// ReusePort is whether SO_REUSEPORT is set on the listeners.
+	ReusePort bool
+	// Backlog is the queue length of the unaccepted connections of the listeners.
+	Backlog int
+	// MaxGatewayBodyBytes is the limit of the body of a request of the HTTP gateway.
+	MaxGatewayBodyBytes int64
+	// NotFoundHandler is whether the NotFoundHandler is set.
+	NotFoundHandler bool
+	Draining        bool
+	// Plugins are the names of the server plugins in order.
+	Plugins []string
+	// Routers are the registered service paths.
+	Routers []string
+	// CallTimeouts are the call timeouts of the service paths.
+	CallTimeouts map[string]time.Duration
+	// Listeners are the addresses of the served listeners.
+	Listeners []string
+}
+
+// ConfigSnapshot returns a snapshot of the effective configuration, taken under the read lock.
+// The snapshot shares nothing with the server.
+func (server *Server) ConfigSnapshot() ServerConfig {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	config := ServerConfig{
+		Timeout:             server.Timeout,
+		ReadTimeout:         server.ReadTimeout,
+		WriteTimeout:        server.WriteTimeout,
+		IdleTimeout:         server.IdleTimeout,
+		HeaderTimeout:       server.HeaderTimeout,
+		Capabilities:        append([]string(nil), server.Capabilities...),
+		Workers:             server.Workers,
+		MaxQueuedCalls:      server.maxQueuedCalls(server.Workers),
+		MaxPendingResponses: server.MaxPendingResponses,
+		MaxHops:             server.MaxHops,
+		DisableHTTP:         server.DisableHTTP,
+		AcceptParallelism:   server.AcceptParallelism,
+		PanicPolicy:         server.PanicPolicy.String(),
+		PluginPanicPolicy:   server.PluginPanicPolicy.String(),
+		DecompressRequests:  server.DecompressRequests,
+		MaxCallBytes:        server.MaxCallBytes,
+		MaxGoroutines:       server.MaxGoroutines,
+		Shard:               server.Shard,
+		ProtocolVersions:    append([]byte(nil), server.ProtocolVersions...),
+		WatchdogTeardown:    server.WatchdogTeardown,
+		LoadReporter:        server.LoadReporter != nil,
+		FeatureFlag:         server.FeatureFlag != nil,
+		ReusePort:           server.ReusePort,
+		Backlog:             server.Backlog,
+		MaxGatewayBodyBytes: server.maxGatewayBodyBytes(),
+		NotFoundHandler:     server.NotFoundHandler != nil,
+		Draining:            server.isDraining(),
+		Routers:             append([]string(nil), server.routers...),
+		CallTimeouts:        make(map[string]time.Duration, len(server.timeouts)),
+		Watchdogs:           make(map[string]time.Duration, len(server.watchdogLimits())),
+	}
+	if server.ServerCodecFunc != nil {
+		config.Codec = common.ObjectName(server.ServerCodecFunc)
+	}
+	if server.ServiceBuilder != nil {
+		config.ServiceBuilder = common.ObjectName(server.ServiceBuilder)
+	}
+	for _, p := range server.PluginContainer.GetAll() {
+		config.Plugins = append(config.Plugins, server.PluginContainer.GetName(p))
+	}
+	for path, timeout := range server.timeouts {
+		config.CallTimeouts[path] = timeout
+	}
+	for path, limit := range server.watchdogLimits() {
+		config.Watchdogs[path] = limit
+	}
+	for _, c := range server.lisCounters {
+		config.Listeners = append(config.Listeners, c.addr)
+	}
+	return config
+}
@@ -0,0 +1,16 @@
+package server
+
+import "github.com/henrylee2cn/myrpc/common"
+
+// checkEnabled rejects the call of the method that the FeatureFlag disables.
+func (ctx *Context) checkEnabled() error {
+	if ctx.server.FeatureFlag == nil {
+		return nil
+	}
+	path := ctx.service.GetPath()
+	if ctx.server.FeatureFlag(ctx, path) {
+		return nil
+	}
+	ctx.rpcErrorType = common.ErrorTypeServerNotEnabled
+	return common.NewError("method '" + path + "' is not enabled")
+}
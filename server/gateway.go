@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"strconv"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/log"
+)
+
+type (
+	// gateway transcodes the JSON requests over HTTP POST to unary RPC calls.
+	gateway struct {
+		server *Server
+	}
+
+	// gatewayCodec is a ServerCodec serving a single JSON request.
+	gatewayCodec struct {
+		serviceMethod string
+		body          []byte
+		resp          rpc.Response
+		reply         interface{}
+	}
+
+	// gatewayConn is the net.Conn of a gateway request.
+	gatewayConn struct {
+		remoteAddr gatewayAddr
+	}
+
+	gatewayAddr string
+)
+
+var errGatewayConn = errors.New("rpc: gateway connection can not be read or written")
+
+// DefaultMaxGatewayBodyBytes is the default limit of the body of a gateway request, see Server.MaxGatewayBodyBytes.
+const DefaultMaxGatewayBodyBytes = 4 << 20
+
+// maxGatewayBodyBytes returns the effective MaxGatewayBodyBytes.
+func (server *Server) maxGatewayBodyBytes() int64 {
+	if server.MaxGatewayBodyBytes > 0 {
+		return server.MaxGatewayBodyBytes
+	}
+	return DefaultMaxGatewayBodyBytes
+}
+
+// MapHTTP maps the URL path of the HTTP JSON gateway to the service path,
+// e.g. server.MapHTTP("/v1/echo", "/worker/echo").
+func (server *Server) MapHTTP(urlPath, servicePath string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.httpMappings == nil {
+		server.httpMappings = make(map[string]string)
+	}
+	server.httpMappings[urlPath] = servicePath
+	log.Infof("rpc: gateway ->\t%s\t%s", urlPath, servicePath)
+}
+
+// HTTPGateway returns the HTTP handler that reads the JSON body of a POST request
+// into the argument of the mapped service, calls the service, and writes the reply as JSON.
+// Only unary methods are supported. The server must be serving, e.g. by ServeGateway.
+func (server *Server) HTTPGateway() http.Handler {
+	return &gateway{server: server}
+}
+
+// ServeGateway serves the HTTP JSON gateway on the listener.
+func (server *Server) ServeGateway(lis net.Listener) {
+	err := grace.Append(lis)
+	if err != nil {
+		log.Fatalf("rpc: %s", err.Error())
+	}
+	server.mu.Lock()
+	server.running = true
+	server.mu.Unlock()
+	log.Infof("rpc: serving HTTP gateway on %s", lis.Addr().String())
+	srv := &http.Server{Handler: server.HTTPGateway()}
+	srv.Serve(lis)
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	g.server.mu.RLock()
+	servicePath, ok := g.server.httpMappings[req.URL.Path]
+	g.server.mu.RUnlock()
+	if !ok {
+		writeGatewayError(w, http.StatusNotFound, "rpc: no service is mapped to "+req.URL.Path)
+		return
+	}
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeGatewayError(w, http.StatusMethodNotAllowed, "rpc: gateway accepts POST only")
+		return
+	}
+	limit := g.server.maxGatewayBodyBytes()
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, limit))
+	if _, ok := err.(*http.MaxBytesError); ok || req.ContentLength > limit {
+		writeGatewayError(w, http.StatusRequestEntityTooLarge, "rpc: request body exceeds "+strconv.FormatInt(limit, 10)+" bytes")
+		return
+	}
+	if err != nil {
+		writeGatewayError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	codec := &gatewayCodec{
+		serviceMethod: servicePath,
+		body:          body,
+	}
+	if len(req.URL.RawQuery) > 0 {
+		codec.serviceMethod += "?" + req.URL.RawQuery
+	}
+	conn := NewServerCodecConn(&gatewayConn{remoteAddr: gatewayAddr(req.RemoteAddr)})
+	conn.SetServerCodec(func(io.ReadWriteCloser) rpc.ServerCodec { return codec })
+	if err = g.server.PluginContainer.doPostConnAccept(conn, g.server.PluginPanicPolicy); err != nil {
+		writeGatewayError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	g.server.ServeRequest(conn)
+
+	if len(codec.resp.Error) > 0 {
+		errorType := common.ErrorType(codec.resp.Error[0])
+		errMsg := codec.resp.Error[1:]
+		switch errorType {
+		case common.ErrorTypeServerInvalidServiceMethod, common.ErrorTypeServerNotFoundService:
+			writeGatewayError(w, http.StatusNotFound, errMsg)
+		case common.ErrorTypeServerReadRequestBody:
+			writeGatewayError(w, http.StatusBadRequest, errMsg)
+		default:
+			writeGatewayError(w, http.StatusInternalServerError, errMsg)
+		}
+		return
+	}
+	if codec.reply == nil {
+		writeGatewayError(w, http.StatusServiceUnavailable, "rpc: server has stopped")
+		return
+	}
+	b, err := json.Marshal(codec.reply)
+	if err != nil {
+		writeGatewayError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func writeGatewayError(w http.ResponseWriter, code int, errMsg string) {
+	b, _ := json.Marshal(map[string]string{"error": errMsg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+func (c *gatewayCodec) ReadRequestHeader(r *rpc.Request) error {
+	r.ServiceMethod = c.serviceMethod
+	r.Seq = 0
+	return nil
+}
+
+func (c *gatewayCodec) ReadRequestBody(body interface{}) error {
+	if body == nil || len(c.body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(c.body, body)
+}
+
+func (c *gatewayCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.resp = *r
+	c.reply = body
+	return nil
+}
+
+// ContentType returns the MIME type of JSON.
+func (c *gatewayCodec) ContentType() string {
+	return "application/json"
+}
+
+func (c *gatewayCodec) Close() error {
+	return nil
+}
+
+func (c *gatewayConn) Read(b []byte) (int, error)         { return 0, errGatewayConn }
+func (c *gatewayConn) Write(b []byte) (int, error)        { return 0, errGatewayConn }
+func (c *gatewayConn) Close() error                       { return nil }
+func (c *gatewayConn) LocalAddr() net.Addr                { return gatewayAddr("") }
+func (c *gatewayConn) RemoteAddr() net.Addr               { return c.remoteAddr }
+func (c *gatewayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *gatewayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *gatewayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (a gatewayAddr) Network() string { return "http" }
+func (a gatewayAddr) String() string  { return string(a) }
@@ -0,0 +1,101 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+func TestHTTPGateway(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", &worker{name: "gateway"})
+	srv.MapHTTP("/api/name", "/worker/name")
+	lis := listen(t)
+	go srv.ServeGateway(lis)
+	url := "http://" + lis.Addr().String()
+
+	resp, err := http.Post(url+"/api/name", "application/json", strings.NewReader(`"hello"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("content type: %q", ct)
+	}
+	var reply string
+	if err = json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != "gateway: hello" {
+		t.Fatalf("reply: %q", reply)
+	}
+
+	// the empty body of a schema-less argument
+	srv.NamedRegister("dynamic", new(dynamicWorker))
+	srv.MapHTTP("/api/echo", "/dynamic/echo")
+	resp, err = http.Post(url+"/api/echo", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(b) != `{"echoed":true}` {
+		t.Fatalf("empty body: status=%d, body=%s", resp.StatusCode, b)
+	}
+
+	resp, err = http.Post(url+"/api/name", "application/json", strings.NewReader(`{"bad"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad JSON status: %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(url+"/api/unknown", "application/json", strings.NewReader(`"hello"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unmapped path status: %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPGatewayBodyLimit(t *testing.T) {
+	srv := server.NewServer(server.Server{MaxGatewayBodyBytes: 16})
+	srv.NamedRegister("worker", &worker{name: "gateway"})
+	srv.MapHTTP("/api/name", "/worker/name")
+	lis := listen(t)
+	go srv.ServeGateway(lis)
+	url := "http://" + lis.Addr().String() + "/api/name"
+
+	large := `"` + strings.Repeat("x", 100) + `"`
+	// with and without the content length
+	for _, body := range []io.Reader{strings.NewReader(large), io.LimitReader(strings.NewReader(large), 1<<20)} {
+		resp, err := http.Post(url, "application/json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Fatalf("large body status: %d", resp.StatusCode)
+		}
+	}
+	resp, err := http.Post(url, "application/json", strings.NewReader(`"hello"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("small body status: %d", resp.StatusCode)
+	}
+}
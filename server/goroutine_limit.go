@@ -0,0 +1,32 @@
+package server
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// acquireGoroutine counts a goroutine about to run a call, false if it would exceed the MaxGoroutines.
+func (server *Server) acquireGoroutine() bool {
+	n := atomic.AddInt64(&server.goroutines, 1)
+	if max := server.MaxGoroutines; max > 0 && n > int64(max) {
+		atomic.AddInt64(&server.goroutines, -1)
+		return false
+	}
+	return true
+}
+
+// releaseGoroutine uncounts a goroutine that has run its call.
+func (server *Server) releaseGoroutine() {
+	atomic.AddInt64(&server.goroutines, -1)
+}
+
+// Goroutines returns the number of the goroutines running the calls, not counting the Workers.
+func (server *Server) Goroutines() int {
+	return int(atomic.LoadInt64(&server.goroutines))
+}
+
+func serverBusy(max int) error {
+	return common.NewError("server busy: " + strconv.Itoa(max) + " goroutines running the calls (MaxGoroutines)")
+}
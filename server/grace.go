@@ -7,6 +7,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/henrylee2cn/myrpc/common"
 	"github.com/henrylee2cn/myrpc/gracenet"
 	"github.com/henrylee2cn/myrpc/log"
 	kcp "github.com/xtaci/kcp-go"
@@ -111,13 +112,32 @@ func shutdown() bool {
 
 var grace = new(gracenet.Net)
 
-func makeListener(network, address string) (ln net.Listener, err error) {
+// makeListener listens on the address, the stream listeners are made by lc unless nil.
+func makeListener(network, address string, lc *net.ListenConfig) (ln net.Listener, err error) {
 	switch network {
 	case "kcp":
 		ln, err = kcp.ListenWithOptions(address, nil, 10, 3)
+	case common.NetworkDualStack:
+		// the wildcard "tcp" listener accepts both IPv4 and IPv6 where the system supports it.
+		var host, port string
+		host, port, err = net.SplitHostPort(address)
+		if err != nil {
+			return
+		}
+		if host != "" && !net.ParseIP(host).IsUnspecified() {
+			return nil, common.NewError("dual-stack listener needs the wildcard address, not " + host)
+		}
+		ln, err = graceListen(lc, "tcp", net.JoinHostPort("", port))
 	default: //tcp
-		ln, err = grace.Listen(network, address)
+		ln, err = graceListen(lc, network, address)
 		// ln, err = net.Listen(network, address)
 	}
 	return ln, err
 }
+
+func graceListen(lc *net.ListenConfig, network, address string) (net.Listener, error) {
+	if lc == nil {
+		return grace.Listen(network, address)
+	}
+	return grace.ListenConfig(lc, network, address)
+}
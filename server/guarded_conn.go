@@ -0,0 +1,95 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+type (
+	// GuardedConn is the connection returned by ServerCodecConn.NetConn.
+	// Reading, writing, closing and the deadlines are reserved for the server and fail,
+	// the rest is for inspection. It also implements:
+	//	- ConnectionState() tls.ConnectionState, if it is a TLS connection,
+	//	  which completes the handshake if necessary;
+	//	- syscall.Conn, if the connection does, e.g. to read the socket options
+	//	  by the Control of the RawConn, whose Read and Write fail.
+	GuardedConn struct {
+		conn net.Conn
+	}
+
+	guardedTLSConn struct {
+		*GuardedConn
+		tlsConn *tls.Conn
+	}
+
+	guardedSyscallConn struct {
+		*GuardedConn
+		syscallConn syscall.Conn
+	}
+
+	guardedRawConn struct {
+		syscall.RawConn
+	}
+)
+
+var errGuardedConn = errors.New("rpc: the connection is reserved for the server")
+
+// guard returns the GuardedConn of conn, implementing the optional interfaces of conn.
+func guard(conn net.Conn) net.Conn {
+	if conn == nil {
+		return nil
+	}
+	g := &GuardedConn{conn: conn}
+	switch c := conn.(type) {
+	case *tls.Conn:
+		return &guardedTLSConn{GuardedConn: g, tlsConn: c}
+	case syscall.Conn:
+		return &guardedSyscallConn{GuardedConn: g, syscallConn: c}
+	}
+	return g
+}
+
+// Read fails, the connection is read by the server.
+func (g *GuardedConn) Read(b []byte) (int, error) { return 0, errGuardedConn }
+
+// Write fails, the connection is written by the server.
+func (g *GuardedConn) Write(b []byte) (int, error) { return 0, errGuardedConn }
+
+// Close fails, the connection is closed by the server.
+func (g *GuardedConn) Close() error { return errGuardedConn }
+
+// LocalAddr returns the local network address.
+func (g *GuardedConn) LocalAddr() net.Addr { return g.conn.LocalAddr() }
+
+// RemoteAddr returns the remote network address.
+func (g *GuardedConn) RemoteAddr() net.Addr { return g.conn.RemoteAddr() }
+
+// SetDeadline fails, the deadlines are set by the server.
+func (g *GuardedConn) SetDeadline(t time.Time) error { return errGuardedConn }
+
+// SetReadDeadline fails, the deadlines are set by the server.
+func (g *GuardedConn) SetReadDeadline(t time.Time) error { return errGuardedConn }
+
+// SetWriteDeadline fails, the deadlines are set by the server.
+func (g *GuardedConn) SetWriteDeadline(t time.Time) error { return errGuardedConn }
+
+// ConnectionState completes the TLS handshake if necessary and returns the TLS state.
+func (g *guardedTLSConn) ConnectionState() tls.ConnectionState {
+	g.tlsConn.Handshake()
+	return g.tlsConn.ConnectionState()
+}
+
+// SyscallConn returns the raw connection, whose Read and Write fail.
+func (g *guardedSyscallConn) SyscallConn() (syscall.RawConn, error) {
+	raw, err := g.syscallConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	return guardedRawConn{raw}, nil
+}
+
+func (r guardedRawConn) Read(func(fd uintptr) bool) error  { return errGuardedConn }
+func (r guardedRawConn) Write(func(fd uintptr) bool) error { return errGuardedConn }
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// headerConn bounds the reading of a request header by the HeaderTimeout from its first byte,
+// while the connection waits for the next request by the other timeouts. It is used by the read loop only.
+type headerConn struct {
+	net.Conn
+	timeout  time.Duration
+	armed    bool      // a header is being read
+	started  bool      // the first byte of the header has arrived
+	deadline time.Time // the read deadline before the header starts
+}
+
+// arm starts reading a header whose read deadline is deadline until its first byte arrives.
+func (c *headerConn) arm(deadline time.Time) {
+	c.armed, c.started, c.deadline = true, false, deadline
+}
+
+// disarm ends reading the header, started is whether its first byte arrived while armed.
+func (c *headerConn) disarm() (started bool) {
+	c.armed = false
+	return c.started
+}
+
+func (c *headerConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.armed && !c.started {
+		c.started = true
+		if d := time.Now().Add(c.timeout); c.deadline.IsZero() || d.Before(c.deadline) {
+			c.Conn.SetReadDeadline(d)
+		}
+	}
+	return n, err
+}
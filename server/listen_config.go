@@ -0,0 +1,22 @@
+package server
+
+import "net"
+
+// listen makes the listener of Serve and ServeTLS with the ReusePort and the Backlog.
+func (server *Server) listen(network, address string) (net.Listener, error) {
+	var lc *net.ListenConfig
+	if server.ReusePort {
+		lc = &net.ListenConfig{Control: reusePortControl}
+	}
+	lis, err := makeListener(network, address, lc)
+	if err != nil {
+		return nil, err
+	}
+	if server.Backlog > 0 {
+		if err = setBacklog(lis, server.Backlog); err != nil {
+			lis.Close()
+			return nil, err
+		}
+	}
+	return lis, nil
+}
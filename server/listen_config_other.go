@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package server
+
+import (
+	"net"
+	"syscall"
+)
+
+// reusePortControl is a no-op, Windows has no SO_REUSEPORT.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+// setBacklog is a no-op.
+func setBacklog(lis net.Listener, backlog int) error {
+	return nil
+}
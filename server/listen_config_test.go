@@ -0,0 +1,55 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package server_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+func TestReusePort(t *testing.T) {
+	lis := listen(t)
+	addr := lis.Addr().String()
+	lis.Close()
+
+	start := func(name string) *server.Server {
+		srv := server.NewServer(server.Server{
+			ReusePort: true,
+			Backlog:   1024,
+		})
+		srv.NamedRegister("worker", &worker{name: name})
+		go srv.Serve("tcp", addr)
+		waitFor(t, "the listener of "+name, func() bool { return srv.Addr() != nil })
+		return srv
+	}
+	call := func(want string) {
+		c := newClient(client.Client{}, addr)
+		defer c.Close()
+		var reply string
+		if e := c.Call("/worker/name", "x", &reply); e != nil || reply != want {
+			t.Fatalf("reply=%q, err=%v", reply, e)
+		}
+	}
+
+	old := start("old")
+	if config := old.ConfigSnapshot(); !config.ReusePort || config.Backlog != 1024 {
+		t.Fatalf("expect the listener options in the config, got %v, %d", config.ReusePort, config.Backlog)
+	}
+	call("old: x")
+	// a listener without SO_REUSEPORT can't share the port.
+	if l, err := net.Listen("tcp", addr); err == nil {
+		l.Close()
+		t.Fatal("expect the port in use")
+	}
+	// the new server listens on the port while the old one still does, without the option Serve fails.
+	start("new")
+	if err := old.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	call("new: x")
+}
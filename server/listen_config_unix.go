@@ -0,0 +1,42 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package server
+
+import (
+	"net"
+	"syscall"
+)
+
+// reusePortControl sets SO_REUSEPORT on the socket before it is bound.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// setBacklog sets the backlog of the listening socket by listening on it again,
+// which the net package doesn't offer. Other listeners, e.g. of kcp, are left as is.
+func setBacklog(lis net.Listener, backlog int) error {
+	sc, ok := lis.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	c, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	err = c.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+	return listenErr
+}
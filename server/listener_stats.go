@@ -0,0 +1,68 @@
+package server
+
+import (
+	"sync/atomic"
+)
+
+type (
+	// ListenerStat is the statistics of a listener served by the server.
+	ListenerStat struct {
+		Network string
+		Addr    string
+		// Accepts is the total number of accepted connections.
+		Accepts int64
+		// Active is the number of connections being served.
+		Active int64
+		// Rejects is the number of connections rejected by draining or the PostConnAccept plugins.
+		Rejects int64
+	}
+
+	// listenerCounter counts the connections of a listener.
+	listenerCounter struct {
+		network string
+		addr    string
+		accepts int64
+		active  int64
+		rejects int64
+	}
+)
+
+// ListenerStats returns the statistics of the listeners in the order they are served.
+func (server *Server) ListenerStats() []ListenerStat {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	stats := make([]ListenerStat, len(server.lisCounters))
+	for i, c := range server.lisCounters {
+		stats[i] = ListenerStat{
+			Network: c.network,
+			Addr:    c.addr,
+			Accepts: atomic.LoadInt64(&c.accepts),
+			Active:  atomic.LoadInt64(&c.active),
+			Rejects: atomic.LoadInt64(&c.rejects),
+		}
+	}
+	return stats
+}
+
+func (server *Server) newListenerCounter(network, addr string) *listenerCounter {
+	c := &listenerCounter{network: network, addr: addr}
+	server.mu.Lock()
+	server.lisCounters = append(server.lisCounters, c)
+	server.mu.Unlock()
+	return c
+}
+
+func (c *listenerCounter) accepted() {
+	atomic.AddInt64(&c.accepts, 1)
+}
+
+func (c *listenerCounter) rejected() {
+	atomic.AddInt64(&c.rejects, 1)
+}
+
+// serve counts the connection as active while fn serves it.
+func (c *listenerCounter) serve(fn func()) {
+	atomic.AddInt64(&c.active, 1)
+	defer atomic.AddInt64(&c.active, -1)
+	fn()
+}
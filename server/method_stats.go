@@ -0,0 +1,57 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// MethodStat is the statistics of a registered service path.
+	MethodStat struct {
+		// LastCall is the time the last call finished, zero if never called.
+		LastCall time.Time
+		// Calls is the total number of calls.
+		Calls int64
+		// Errors is the number of calls that replied an error.
+		Errors int64
+		// LastError is the error of the last call, "" if it succeeded.
+		LastError string
+	}
+
+	// methodCounter counts the calls of a service path.
+	methodCounter struct {
+		lastCall  int64 // UnixNano
+		calls     int64
+		errors    int64
+		lastError atomic.Value // string
+	}
+)
+
+// MethodStats returns the statistics of the registered service paths.
+func (server *Server) MethodStats() map[string]MethodStat {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	stats := make(map[string]MethodStat, len(server.callCounters))
+	for path, c := range server.callCounters {
+		stat := MethodStat{
+			Calls:  atomic.LoadInt64(&c.calls),
+			Errors: atomic.LoadInt64(&c.errors),
+		}
+		if t := atomic.LoadInt64(&c.lastCall); t != 0 {
+			stat.LastCall = time.Unix(0, t)
+		}
+		stat.LastError, _ = c.lastError.Load().(string)
+		stats[path] = stat
+	}
+	return stats
+}
+
+// record counts a finished call replying errmsg.
+func (c *methodCounter) record(errmsg string) {
+	atomic.AddInt64(&c.calls, 1)
+	if errmsg != "" {
+		atomic.AddInt64(&c.errors, 1)
+	}
+	c.lastError.Store(errmsg)
+	atomic.StoreInt64(&c.lastCall, time.Now().UnixNano())
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"reflect"
+)
+
+// MethodType is the signature of a registered service path.
+type MethodType struct {
+	// Path is the service path, e.g. "/arith/mul".
+	Path string
+	// ArgType is the type of the arguments, nil if the service discards the request body.
+	ArgType reflect.Type
+	// ReplyType is the type of the reply, nil if the service doesn't declare it.
+	ReplyType reflect.Type
+	// Idempotent is whether the service is declared idempotent, see IIdempotent.
+	Idempotent bool
+}
+
+// Methods returns the signatures of the registered service paths, sorted by the path.
+func (server *Server) Methods() []MethodType {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	methods := make([]MethodType, 0, len(server.routers))
+	for _, path := range server.routers {
+		s := server.serviceMap[path]
+		m := MethodType{
+			Path:    path,
+			ArgType: s.GetArgType(),
+		}
+		if r, ok := s.(interface{ getReplyType() reflect.Type }); ok {
+			m.ReplyType = r.getReplyType()
+		}
+		if i, ok := s.(IIdempotent); ok {
+			m.Idempotent = i.IsIdempotent()
+		}
+		methods = append(methods, m)
+	}
+	return methods
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// Address families of NetworkInfo.
+const (
+	FamilyIPv4      = "ipv4"
+	FamilyIPv6      = "ipv6"
+	FamilyDualStack = "dual-stack"
+	FamilyUnix      = "unix"
+)
+
+// NetworkInfo is the structured address of the listener, e.g. for the readiness probes
+// and the service registration that need the port apart from the host.
+type NetworkInfo struct {
+	// Network is the network of the listener, see Server.Network.
+	Network string
+	// Host is the IP of the listening address, or the path of the unix socket.
+	Host string
+	// Port is the port of the listening address, 0 if the network has none.
+	Port int
+	// Family is FamilyIPv4, FamilyIPv6, FamilyDualStack or FamilyUnix, empty if unknown.
+	Family string
+}
+
+// Addr returns the address of the listener, nil if the server isn't serving.
+func (server *Server) Addr() net.Addr {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	if server.listener == nil {
+		return nil
+	}
+	return server.listener.Addr()
+}
+
+// NetworkInfo returns the parsed address of the listener, zero if the server isn't serving.
+func (server *Server) NetworkInfo() NetworkInfo {
+	addr := server.Addr()
+	if addr == nil {
+		return NetworkInfo{}
+	}
+	info := NetworkInfo{Network: server.Network()}
+	if unixAddr, ok := addr.(*net.UnixAddr); ok {
+		info.Host = unixAddr.Name
+		info.Family = FamilyUnix
+		return info
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		info.Host = addr.String()
+		return info
+	}
+	info.Host = host
+	info.Port, _ = strconv.Atoi(port)
+	ip := net.ParseIP(host)
+	switch {
+	case info.Network == common.NetworkDualStack:
+		info.Family = FamilyDualStack
+	case ip.To4() != nil:
+		info.Family = FamilyIPv4
+	case ip != nil:
+		info.Family = FamilyIPv6
+	}
+	return info
+}
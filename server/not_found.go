@@ -0,0 +1,61 @@
+package server
+
+import (
+	"reflect"
+)
+
+// notFoundService serves the unknown service paths by Server.NotFoundHandler.
+type notFoundService struct {
+	handler         func(ctx *Context) error
+	pluginContainer IServerPluginContainer
+}
+
+var (
+	_ IService = new(notFoundService)
+
+	emptyReply = reflect.ValueOf(struct{}{})
+
+	// heartbeatService answers common.HeartbeatPath with the empty reply.
+	heartbeatService = newNotFoundService(func(*Context) error { return nil })
+)
+
+func newNotFoundService(handler func(ctx *Context) error) *notFoundService {
+	return &notFoundService{
+		handler:         handler,
+		pluginContainer: new(ServerPluginContainer),
+	}
+}
+
+// SetPluginContainer means as its name
+func (n *notFoundService) SetPluginContainer(p IServerPluginContainer) {
+	n.pluginContainer = p
+}
+
+// GetPluginContainer means as its name
+func (n *notFoundService) GetPluginContainer() IServerPluginContainer {
+	return n.pluginContainer
+}
+
+// GetPath returns "", for it serves any unknown path.
+func (n *notFoundService) GetPath() string {
+	return ""
+}
+
+// GetArgType returns nil, for the request body is discarded.
+func (n *notFoundService) GetArgType() reflect.Type {
+	return nil
+}
+
+// Call calls the NotFoundHandler, and returns the reply set by ctx.SetReply.
+func (n *notFoundService) Call(_ reflect.Value, ctx *Context) (reflect.Value, error) {
+	err := n.handler(ctx)
+	if ctx.reply == nil {
+		return emptyReply, err
+	}
+	return reflect.ValueOf(ctx.reply), err
+}
+
+// SetReply sets the reply of the request served by Server.NotFoundHandler.
+func (ctx *Context) SetReply(reply interface{}) {
+	ctx.reply = reply
+}
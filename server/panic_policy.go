@@ -0,0 +1,56 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// PanicPolicy is how the server handles the panic of a service.
+type PanicPolicy int
+
+const (
+	// RecoverAndRespond recovers the panic, logs it and responds "Service Panic!".
+	RecoverAndRespond PanicPolicy = iota
+	// RecoverAndLog recovers the panic and logs it without responding,
+	// the client gets its call timeout.
+	RecoverAndLog
+	// Propagate logs the panic, responds "Service Panic!" and re-panics on the goroutine
+	// of the call, which crashes the process unless recovered at the process level,
+	// e.g. to restart it and to dump the crash on a fatal invariant violation.
+	Propagate
+)
+
+var panicPolicyStrs = [...]string{
+	"recover-and-respond",
+	"recover-and-log",
+	"propagate",
+}
+
+func (p PanicPolicy) String() string {
+	if p < 0 || int(p) >= len(panicPolicyStrs) {
+		return "unknown"
+	}
+	return panicPolicyStrs[p]
+}
+
+// propagatedPanic carries the panic of a service out of the recover of callWithin.
+type propagatedPanic struct {
+	value interface{}
+}
+
+// servicePanicked handles the logged panic p of the service per the PanicPolicy.
+func (server *Server) servicePanicked(sending *sync.Mutex, ctx *Context, p interface{}) {
+	const errmsg = "Service Panic!"
+	ctx.rpcErrorType = common.ErrorTypeServerServicePanic
+	if server.PanicPolicy == RecoverAndLog {
+		if ctx.calls != nil {
+			ctx.calls.record(errmsg)
+		}
+		return
+	}
+	server.sendResponse(sending, ctx, errmsg)
+	if server.PanicPolicy == Propagate {
+		panic(propagatedPanic{p})
+	}
+}
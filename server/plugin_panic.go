@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/log"
+)
+
+// PluginPanicPolicy is how the server handles the panic of a plugin hook.
+type PluginPanicPolicy int
+
+const (
+	// PluginPanicNone doesn't recover the panic of a plugin hook, which takes down the goroutine
+	// serving the connection, as if there were no policy. The hooks pay no recover then.
+	PluginPanicNone PluginPanicPolicy = iota
+	// PluginPanicFail recovers the panic, logs it and fails the hook as if the plugin returned an error,
+	// e.g. the request is replied the error of the hook, and the accepted connection is closed.
+	PluginPanicFail
+	// PluginPanicSkip recovers the panic, logs it and skips the plugin, the other plugins still run.
+	PluginPanicSkip
+)
+
+var pluginPanicPolicyStrs = [...]string{
+	"none",
+	"fail",
+	"skip",
+}
+
+func (p PluginPanicPolicy) String() string {
+	if p < 0 || int(p) >= len(pluginPanicPolicyStrs) {
+		return "unknown"
+	}
+	return pluginPanicPolicyStrs[p]
+}
+
+// pluginPanic is the error of a panicking plugin hook.
+type pluginPanic struct {
+	value interface{}
+}
+
+func (e *pluginPanic) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// recoverHook calls the hook with the plugin, and recovers its panic as a *pluginPanic error,
+// so that a buggy plugin doesn't take down the goroutine serving the connection.
+func recoverHook(plugin IPlugin, hook func(IPlugin) error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Criticalf("rpc: plugin %s: %v\n[PANIC]\n%s\n", plugin.Name(), p, common.PanicTrace(4))
+			err = &pluginPanic{p}
+		}
+	}()
+	return hook(plugin)
+}
+
+// skipPanicked returns whether the failed hook is skipped per the policy.
+func (p PluginPanicPolicy) skipPanicked(err error) bool {
+	_, ok := err.(*pluginPanic)
+	return ok && p == PluginPanicSkip
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// negotiateProtocol reads the protocol preamble of the client if it starts with
+// common.ProtocolPreamble, and answers the accepted version or the rejection.
+// The client without the preamble speaks common.ProtocolVersion1. It returns the connection
+// reading the rest, and an error if the connection is rejected or broken.
+func (server *Server) negotiateProtocol(conn ServerCodecConn) (net.Conn, error) {
+	c := conn.GetConn()
+	if server.HeaderTimeout > 0 {
+		c.SetDeadline(time.Now().Add(server.HeaderTimeout))
+		defer c.SetDeadline(time.Time{})
+	}
+	br := bufio.NewReader(c)
+	preamble := common.ProtocolPreamble
+	for i := 1; i <= len(preamble); i++ {
+		b, err := br.Peek(i)
+		if err != nil {
+			return nil, err
+		}
+		if b[i-1] != preamble[i-1] {
+			setProtocolVersion(conn, common.ProtocolVersion1)
+			return &readerConn{Conn: c, r: br}, nil
+		}
+	}
+	br.Discard(len(preamble))
+	version, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if !server.supportsProtocol(version) {
+		msg := unsupportedProtocolMsg(version, server.ProtocolVersions)
+		c.Write([]byte(preamble + "\x00" + msg))
+		return nil, errors.New(msg)
+	}
+	if _, err = c.Write([]byte(preamble + string([]byte{version}))); err != nil {
+		return nil, err
+	}
+	setProtocolVersion(conn, version)
+	return &readerConn{Conn: c, r: br}, nil
+}
+
+// httpProtocolVersion returns the protocol version declared by the HTTP CONNECT request,
+// common.ProtocolVersion1 if not declared, and an error if the version is not one of the ProtocolVersions.
+func (server *Server) httpProtocolVersion(header http.Header) (byte, error) {
+	version := common.ProtocolVersion1
+	if v := header.Get(common.ProtocolHeader); v != "" {
+		n, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || n == 0 {
+			return 0, errors.New("invalid protocol version " + strconv.Quote(v))
+		}
+		version = byte(n)
+	}
+	if len(server.ProtocolVersions) > 0 && !server.supportsProtocol(version) {
+		return 0, errors.New(unsupportedProtocolMsg(version, server.ProtocolVersions))
+	}
+	return version, nil
+}
+
+// setProtocolVersion stores the protocol version negotiated with the client in the connection.
+func setProtocolVersion(conn ServerCodecConn, version byte) {
+	conn.SetValue(protocolKey{}, version)
+}
+
+// protocolVersion returns the protocol version of the connection, common.ProtocolVersion1 if not negotiated.
+func protocolVersion(conn ServerCodecConn) byte {
+	if version, ok := conn.GetValue(protocolKey{}).(byte); ok {
+		return version
+	}
+	return common.ProtocolVersion1
+}
+
+// supportsProtocol returns whether the version is one of the ProtocolVersions.
+func (server *Server) supportsProtocol(version byte) bool {
+	for _, v := range server.ProtocolVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func unsupportedProtocolMsg(version byte, supported []byte) string {
+	msg := "unsupported protocol version " + strconv.Itoa(int(version)) + ", the server speaks"
+	for _, v := range supported {
+		msg += " " + strconv.Itoa(int(v))
+	}
+	return msg
+}
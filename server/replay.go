@@ -0,0 +1,50 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+type (
+	// replayConn is the connection of a replayed request, which reads the frame and records the response.
+	replayConn struct {
+		frame *bytes.Reader
+		resp  bytes.Buffer
+	}
+
+	replayAddr struct{}
+)
+
+func (c *replayConn) Read(b []byte) (int, error)         { return c.frame.Read(b) }
+func (c *replayConn) Write(b []byte) (int, error)        { return c.resp.Write(b) }
+func (c *replayConn) Close() error                       { return nil }
+func (c *replayConn) LocalAddr() net.Addr                { return replayAddr{} }
+func (c *replayConn) RemoteAddr() net.Addr               { return replayAddr{} }
+func (c *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (replayAddr) Network() string { return "replay" }
+func (replayAddr) String() string  { return "replay" }
+
+// Replay serves a captured request frame without a network connection, e.g. to reproduce
+// a production bug offline, and returns the response frame as written by the ServerCodecFunc.
+// The frame is a request as the first one of a connection of the ServerCodecFunc,
+// e.g. captured by plugin/capture. The request runs through the server plugins and the service
+// as the requests of a new connection do, but the PostConnAccept plugins don't run.
+// The server needn't be serving. err is returned if no response is written,
+// e.g. the frame can't be read.
+func (server *Server) Replay(frame []byte) (response []byte, err error) {
+	c := &replayConn{frame: bytes.NewReader(frame)}
+	conn := NewServerCodecConn(c)
+	if err = conn.SetServerCodec(server.ServerCodecFunc); err != nil {
+		return nil, err
+	}
+	// the request errors are replied, e.g. an unknown service.
+	err = server.serveSingle(conn, 0)
+	if c.resp.Len() > 0 {
+		return c.resp.Bytes(), nil
+	}
+	return nil, err
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/common/compress"
+)
+
+// readerConn reads the connection through r.
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// decompressRequests returns conn compressed by the type following common.CompressedRequestsPreamble
+// if the client starts with it, or reading the requests as is otherwise. It reads no more
+// than the bytes matching the preamble, so it doesn't wait for a short plain request.
+func (server *Server) decompressRequests(conn net.Conn) (net.Conn, error) {
+	if server.HeaderTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(server.HeaderTimeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	br := bufio.NewReader(conn)
+	preamble := common.CompressedRequestsPreamble
+	for i := 1; i <= len(preamble); i++ {
+		b, err := br.Peek(i)
+		if err != nil {
+			return nil, err
+		}
+		if b[i-1] != preamble[i-1] {
+			return &readerConn{Conn: conn, r: br}, nil
+		}
+	}
+	br.Discard(len(preamble))
+	t, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	compressType := compress.CompressType(t)
+	if !compressType.Valid() {
+		return nil, errors.New("unknown compression type " + strconv.Itoa(int(t)))
+	}
+	return compress.NewCompressConn(&readerConn{Conn: conn, r: br}, compressType), nil
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type (
+	// caching maps the service paths to their response caches.
+	caching struct {
+		lock  sync.RWMutex
+		paths map[string]*responseCache
+	}
+
+	// responseCache caches the successful replies for the TTL, evicting the least recently used
+	// reply beyond the maximum number of entries.
+	responseCache struct {
+		ttl        time.Duration
+		maxEntries int
+		lock       sync.Mutex // protects following
+		entries    map[string]*list.Element
+		lru        *list.List // of *cachedReply, the most recently used first
+		generation uint64     // increased by every invalidation
+	}
+
+	cachedReply struct {
+		key     string
+		path    string
+		replyv  reflect.Value
+		expires time.Time
+	}
+)
+
+// EnableCaching caches the successful replies of the service paths for ttl, keyed on the path,
+// the metadata and the hash of the arguments, so that the identical calls within ttl are replied
+// without calling the service. The paths share a cache of at most maxEntries replies.
+// The replies of a service are dropped when it is replaced or deregistered.
+// The services streaming the reply are not cached, the reader is consumed by the first call.
+// Note: Only the read-only services may be cached, and the replies must not be modified
+// after the service returns!
+func (server *Server) EnableCaching(ttl time.Duration, maxEntries int, paths ...string) {
+	if ttl <= 0 || maxEntries <= 0 {
+		return
+	}
+	cache := &responseCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+	server.caching.lock.Lock()
+	defer server.caching.lock.Unlock()
+	if server.caching.paths == nil {
+		server.caching.paths = make(map[string]*responseCache)
+	}
+	for _, p := range paths {
+		server.caching.paths[p] = cache
+	}
+}
+
+// callService calls the service of the context, with caching and coalescing if they are enabled.
+func (server *Server) callService(ctx *Context) (reflect.Value, error) {
+	if stop := server.watch(ctx); stop != nil {
+		defer stop()
+	}
+	if isStreamed(ctx.service) {
+		// a streamed reply is read once, it is neither cached nor shared.
+		return ctx.service.Call(ctx.argv, ctx)
+	}
+	path := ctx.service.GetPath()
+	server.caching.lock.RLock()
+	cache := server.caching.paths[path]
+	server.caching.lock.RUnlock()
+	if cache == nil {
+		return server.callCoalesced(ctx)
+	}
+	key, ok := callKey(path, ctx.query, ctx.argv)
+	if !ok {
+		return server.callCoalesced(ctx)
+	}
+	replyv, generation, ok := cache.get(key)
+	if ok {
+		return replyv, nil
+	}
+	replyv, err := server.callCoalesced(ctx)
+	if err == nil {
+		cache.put(key, path, replyv, generation)
+	}
+	return replyv, err
+}
+
+// invalidateCaches drops the cached replies of the service paths matching,
+// and stops caching them if stop is true, e.g. for the deregistered services.
+func (server *Server) invalidateCaches(match func(path string) bool, stop bool) {
+	server.caching.lock.Lock()
+	defer server.caching.lock.Unlock()
+	for path, cache := range server.caching.paths {
+		if match(path) {
+			cache.invalidate(path)
+			if stop {
+				delete(server.caching.paths, path)
+			}
+		}
+	}
+}
+
+// get returns the cached reply of the key, otherwise the generation of the cache to put the reply with.
+func (c *responseCache) get(key string) (reflect.Value, uint64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return reflect.Value{}, c.generation, false
+	}
+	r := e.Value.(*cachedReply)
+	if time.Now().After(r.expires) {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		return reflect.Value{}, c.generation, false
+	}
+	c.lru.MoveToFront(e)
+	return r.replyv, c.generation, true
+}
+
+// put caches the reply, unless the cache has been invalidated since the generation,
+// e.g. the reply of the service replaced during the call.
+func (c *responseCache) put(key, path string, replyv reflect.Value, generation uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if generation != c.generation {
+		return
+	}
+	r := &cachedReply{
+		key:     key,
+		path:    path,
+		replyv:  replyv,
+		expires: time.Now().Add(c.ttl),
+	}
+	if e, ok := c.entries[key]; ok {
+		e.Value = r
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(r)
+	for c.lru.Len() > c.maxEntries {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*cachedReply).key)
+	}
+}
+
+// invalidate drops the cached replies of the path.
+func (c *responseCache) invalidate(path string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.generation++
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if r := e.Value.(*cachedReply); r.path == path {
+			c.lru.Remove(e)
+			delete(c.entries, r.key)
+		}
+		e = next
+	}
+}
@@ -0,0 +1,124 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type lookup struct {
+	count int32
+}
+
+func (l *lookup) Get(arg string, reply *string) error {
+	n := atomic.AddInt32(&l.count, 1)
+	*reply = arg + "#" + string(rune('0'+n))
+	return nil
+}
+
+func TestCaching(t *testing.T) {
+	l := new(lookup)
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("lookup", l)
+	srv.EnableCaching(200*time.Millisecond, 1, "/lookup/get")
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	call := func(arg, want string, count int32) {
+		var reply string
+		if e := c.Call("/lookup/get", arg, &reply); e != nil || reply != want {
+			t.Fatalf("%s: reply=%q, err=%v, want %q", arg, reply, e, want)
+		}
+		if n := atomic.LoadInt32(&l.count); n != count {
+			t.Fatalf("%s: handler executed %d times, want %d", arg, n, count)
+		}
+	}
+	call("a", "a#1", 1)
+	call("a", "a#1", 1)
+	// evicts "a" beyond the single entry
+	call("b", "b#2", 2)
+	call("b", "b#2", 2)
+	call("a", "a#3", 3)
+	// served from the cache until the entry expires
+	var reply string
+	waitFor(t, "the cached entry to expire", func() bool {
+		if e := c.Call("/lookup/get", "a", &reply); e != nil {
+			t.Fatal(e.Error)
+		}
+		return reply != "a#3"
+	})
+	if n := atomic.LoadInt32(&l.count); reply != "a#4" || n != 4 {
+		t.Fatalf("expired: reply=%q, handler executed %d times, want %q and 4", reply, n, "a#4")
+	}
+}
+
+type downloads struct {
+	count int32
+}
+
+func (d *downloads) Get(name string, reply *io.Reader) error {
+	atomic.AddInt32(&d.count, 1)
+	*reply = strings.NewReader("content of " + name)
+	return nil
+}
+
+func TestCachingStreamedReply(t *testing.T) {
+	d := new(downloads)
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("downloads", d)
+	srv.EnableCaching(time.Minute, 10, "/downloads/get")
+	srv.EnableCoalescing("/downloads/get")
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	for i := 1; i <= 3; i++ {
+		var buf bytes.Buffer
+		if err := c.CallStreamTo("/downloads/get", "a", &buf); err != nil || buf.String() != "content of a" {
+			t.Fatalf("download %d: %q, err=%v", i, buf.String(), err)
+		}
+		if n := atomic.LoadInt32(&d.count); n != int32(i) {
+			t.Fatalf("download %d: handler executed %d times, the streamed reply must not be cached", i, n)
+		}
+	}
+}
+
+func TestCachingInvalidation(t *testing.T) {
+	l := new(lookup)
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("lookup", l)
+	srv.EnableCaching(time.Minute, 10, "/lookup/get")
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	call := func(serviceMethod, want string) {
+		var reply string
+		if e := c.Call(serviceMethod, "a", &reply); e != nil || reply != want {
+			t.Fatalf("%s: reply=%q, err=%v, want %q", serviceMethod, reply, e, want)
+		}
+	}
+	call("/lookup/get?tenant=x", "a#1")
+	call("/lookup/get?tenant=x", "a#1")
+	// the metadata is part of the key
+	call("/lookup/get?tenant=y", "a#2")
+
+	l2 := &lookup{count: 4}
+	if err := srv.ReplaceService("lookup", l2); err != nil {
+		t.Fatal(err)
+	}
+	call("/lookup/get?tenant=x", "a#5")
+	call("/lookup/get?tenant=x", "a#5")
+
+	if n := srv.DeregisterPrefix("/lookup/"); n != 1 {
+		t.Fatalf("deregistered %d", n)
+	}
+	var reply string
+	if e := c.Call("/lookup/get?tenant=x", "a", &reply); e == nil {
+		t.Fatalf("expect the deregistered service not found, got the cached %q", reply)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package server
+
+import "syscall"
+
+// soReusePort is SO_REUSEPORT of the system.
+const soReusePort = syscall.SO_REUSEPORT
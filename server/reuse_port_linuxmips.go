@@ -0,0 +1,10 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+// +build linux
+// +build mips mipsle mips64 mips64le
+
+package server
+
+import "syscall"
+
+// soReusePort is SO_REUSEPORT of Linux on MIPS.
+const soReusePort = syscall.SO_REUSEPORT
@@ -4,15 +4,19 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/rpc"
 	"net/url"
+	"os"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	codecGob "github.com/henrylee2cn/myrpc/codec/gob"
@@ -28,17 +32,125 @@ type (
 		Timeout         time.Duration
 		ReadTimeout     time.Duration
 		WriteTimeout    time.Duration
+		// IdleTimeout closes the connection on which no request arrives within it,
+		// unless requests are still in progress.
+		IdleTimeout time.Duration
+		// HeaderTimeout closes the connection that doesn't send its first request header within it,
+		// or a later header within it from the first byte of the header, which protects against
+		// slow-loris attacks. The connection waits for the next request by the other timeouts.
+		HeaderTimeout   time.Duration
 		ServerCodecFunc ServerCodecFunc
 		ServiceBuilder  IServiceBuilder
+		// Capabilities are the features advertised to the clients that advertise theirs.
+		Capabilities []string
+		// NotFoundHandler serves the requests for unknown service paths, e.g. forwards them upstream,
+		// instead of the default "can't find service" error. The request body is discarded,
+		// and the reply is set by ctx.SetReply.
+		NotFoundHandler func(ctx *Context) error
+		// PathRewriter rewrites the path of every request before the plugins and the service lookup,
+		// e.g. strips a version prefix. ctx.OriginalPath returns the path before rewritten.
+		PathRewriter func(path string) string
+		// Workers is the number of goroutines running the calls, 0 means a goroutine per call.
+		// With workers, the pending calls of higher priority (see common.PriorityKey) run first.
+		// It is changed while serving by SetWorkers, and the workers exit at Shutdown.
+		Workers int
+		// MaxQueuedCalls limits the calls waiting for the Workers, beyond which the new calls are replied
+		// a "server busy" error instead of queued. 0 means DefaultQueuedCallsPerWorker times the Workers.
+		MaxQueuedCalls int
+		// MaxPendingResponses limits the calls of a connection whose responses are not written yet,
+		// beyond which the server holds the next call and stops reading the connection until the client
+		// catches up, e.g. a pipelining client that doesn't read. The control frames, e.g. canceling a stream,
+		// are not calls and don't count. 0 means unlimited.
+		MaxPendingResponses int
+		// MaxHops rejects the calls that have passed more servers (see common.HopsKey),
+		// e.g. in a forwarding loop. 0 means unlimited.
+		MaxHops int
+		// DisableHTTP disables the HTTP CONNECT transport: ServeHTTP rejects every request
+		// with 403, and ServeByHTTP, ServeByMux and HandleHTTP refuse to register the handler,
+		// e.g. for a server that should only accept raw TCP.
+		DisableHTTP bool
+		// AcceptParallelism is the number of goroutines accepting the connections of a listener,
+		// which speeds up establishing the many short-lived connections on many cores. 0 means 1.
+		AcceptParallelism int
+		// PanicPolicy is how the panic of a service is handled, RecoverAndRespond by default.
+		PanicPolicy PanicPolicy
+		// PluginPanicPolicy is how the panic of a plugin hook is handled. PluginPanicFail and PluginPanicSkip
+		// recover and log the panic, so that a buggy plugin doesn't take down the connection or the listener.
+		// By default (PluginPanicNone) the panic isn't recovered, and the hooks pay no recover.
+		PluginPanicPolicy PluginPanicPolicy
+		// DecompressRequests serves compressed the connections of the clients that compress them
+		// without negotiating (see client.Client.ForceRequestCompression) by the compression type
+		// they declare, and the connections of the others as is. It doesn't apply if a plugin sets the codec.
+		DecompressRequests bool
+		// MaxCallBytes limits the bytes of the request and the encoded reply of a call together,
+		// e.g. for the fairness and the billing. A call exceeding it is replied a "call too large" error
+		// instead of its reply. The request counts the bytes read from the connection, including any
+		// read ahead by the codec, and the reply is limited with the codecs implementing
+		// common.ResponseLimiter only, e.g. gob, protobuf and jsonrpc. It doesn't apply if a plugin
+		// sets the codec. 0 means unlimited.
+		MaxCallBytes int64
+		// MaxGoroutines limits the goroutines running the calls across the connections,
+		// beyond which the new calls are replied a "server busy" error instead of spawning one,
+		// a last resort against the pathological load before the other limits apply.
+		// It doesn't apply to the Workers. 0 means unlimited.
+		MaxGoroutines int
+		// Shard rejects the connections that the PostConnAccept plugins don't label with it
+		// (see SetShard), e.g. for the sticky stateful services partitioned
+		// across the instances. The first request of a rejected connection is replied
+		// an ErrorTypeServerWrongShard error. Empty means accepting any connection.
+		Shard string
+		// ProtocolVersions are the protocol versions of the framing the server speaks (see common.ProtocolVersion),
+		// negotiated with the clients declaring theirs (see client.Client.ProtocolVersion) before the codec
+		// is created. A client of another version is rejected with a clear error instead of failing to decode,
+		// the client without the declaration speaking common.ProtocolVersion1. The HTTP clients declare it
+		// in the CONNECT request (see common.ProtocolHeader). Empty means no negotiation.
+		ProtocolVersions []byte
+		// WatchdogTeardown closes the connection of a call exceeding its watchdog limit (see SetWatchdog)
+		// after capturing its stack, so that the other calls of the client move off the stuck connection.
+		WatchdogTeardown bool
+		// LoadReporter reports the current load of the server in the metadata of every response
+		// (see common.LoadKey), e.g. the utilization of the CPU or the queue depth, for the client
+		// selectors balancing by the load of the backends. Nil means no report.
+		LoadReporter func() float64
+		// FeatureFlag decides whether the method of the service path is enabled for the call at dispatch,
+		// e.g. by the identity attached to the ctx, backed by any feature flag system. The call of a disabled
+		// method is replied an ErrorTypeServerNotEnabled error without running. Nil means all enabled.
+		FeatureFlag func(ctx *Context, path string) bool
+		// ReusePort sets SO_REUSEPORT on the listeners made by Serve and ServeTLS, so that several servers,
+		// e.g. the new one of a rolling restart, listen on the port together and the system balances the connects
+		// between them. SO_REUSEADDR is set by the net package anyway. It applies on the Unix systems.
+		ReusePort bool
+		// Backlog is the length of the queue of the connections not accepted yet of the listeners made by Serve
+		// and ServeTLS, e.g. raised for the bursts of connects, capped by the system (somaxconn on Linux).
+		// It applies on the Unix systems. 0 means the system default.
+		Backlog int
+		// MaxGatewayBodyBytes limits the JSON body of a request of the HTTP gateway (see HTTPGateway),
+		// a larger one is replied 413 without being read. 0 means DefaultMaxGatewayBodyBytes.
+		MaxGatewayBodyBytes int64
 
 		serviceMap   map[string]IService
 		mu           sync.RWMutex // protects the serviceMap
 		routers      []string
 		listener     net.Listener
+		network      string // the network of the listener, see Network
 		contextPool  sync.Pool
 		baseMetadata string
 		callGroup    sync.WaitGroup
 		running      bool
+		sniServers   map[string]*Server
+		coalescer    coalescer
+		caching      caching
+		httpMappings map[string]string
+		timeouts     map[string]time.Duration // service path -> call timeout
+		draining     int32                    // reject new connections if 1
+		workerPool   workerPool
+		lisCounters  []*listenerCounter
+		callCounters map[string]*methodCounter // service path -> call statistics
+		baseCtx      context.Context           // the parent of the contexts of the calls
+		cancelBase   context.CancelCauseFunc
+		goroutines   int64                    // the goroutines running the calls, see MaxGoroutines
+		watchdogs    atomic.Value             // map[string]time.Duration, service path -> runaway limit, see SetWatchdog
+		certificate  atomic.Value             // *tls.Certificate, see SetCertificate
 	}
 
 	// ServiceGroup is the group of service.
@@ -46,6 +158,8 @@ type (
 		prefixes        []string
 		PluginContainer IServerPluginContainer
 		server          *Server
+		baseMetadata    string
+		timeout         time.Duration
 	}
 )
 
@@ -58,12 +172,17 @@ func NewServer(srv Server) *Server {
 func (server *Server) init() *Server {
 	server.routers = []string{}
 	server.serviceMap = make(map[string]IService)
+	server.callCounters = make(map[string]*methodCounter)
+	server.timeouts = make(map[string]time.Duration)
+	server.watchdogs.Store(map[string]time.Duration{})
+	server.baseCtx, server.cancelBase = context.WithCancelCause(context.Background())
 	server.contextPool.New = func() interface{} {
 		return &Context{
-			server: server,
-			req:    new(rpc.Request),
-			resp:   new(rpc.Response),
-			data:   new(Store),
+			server:   server,
+			req:      new(rpc.Request),
+			resp:     new(rpc.Response),
+			data:     new(Store),
+			priority: common.PriorityNormal,
 		}
 	}
 	if server.PluginContainer == nil {
@@ -123,15 +242,52 @@ func (group *ServiceGroup) Group(prefix string, plugins ...plugin.IPlugin) *Serv
 		prefixes:        prefixes,
 		PluginContainer: p,
 		server:          group.server,
+		baseMetadata:    group.baseMetadata,
+		timeout:         group.timeout,
 	}
 }
 
+// SetBaseMetadata sets default meta data of the group's services.
+// Must be called before the registration service.
+// Its priority is higher than the server's base metadata and lower than the register metadata parameter.
+func (group *ServiceGroup) SetBaseMetadata(metadata string) {
+	group.baseMetadata = metadata
+}
+
+// SetTimeout sets the call timeout of the group's services,
+// the service that doesn't return within it is answered with a timeout error.
+// Must be called before the registration service.
+// It can be overridden per service by Server.SetCallTimeout.
+func (group *ServiceGroup) SetTimeout(timeout time.Duration) {
+	group.timeout = timeout
+}
+
+// SetCallTimeout sets the call timeout of the registered service path,
+// the service that doesn't return within it is answered with a timeout error.
+// Zero means no limit.
+func (server *Server) SetCallTimeout(servicePath string, timeout time.Duration) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if timeout > 0 {
+		server.timeouts[servicePath] = timeout
+	} else {
+		delete(server.timeouts, servicePath)
+	}
+}
+
+func (server *Server) callTimeout(servicePath string) time.Duration {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return server.timeouts[servicePath]
+}
+
 // Register publishes in the server the set of methods of the
 // receiver value that satisfy the following conditions:
 //	- exported method of exported type
 //	- two arguments, both of exported type
 //	- the second argument is a pointer
 //	- one return value, of type error
+//	- optionally, a *Context argument before the two arguments
 // It returns an error if the receiver is not an exported type or has
 // no suitable methods. It also logs the error using package log.
 // The client accesses each method using a string of the form "Type.Method",
@@ -148,7 +304,7 @@ func (server *Server) NamedRegister(name string, rcvr interface{}, metadata ...s
 		log.Fatal("rpc: " + err.Error())
 	}
 	p := new(ServerPluginContainer)
-	server.register([]string{name}, rcvr, p, metadata...)
+	server.register([]string{name}, rcvr, p, nil, metadata...)
 }
 
 // Register register service based on group
@@ -173,29 +329,47 @@ func (group *ServiceGroup) NamedRegister(name string, rcvr interface{}, metadata
 			Plugins: all,
 		},
 	}
-	group.server.register(append(group.prefixes, name), rcvr, p, metadata...)
+	group.server.register(append(group.prefixes, name), rcvr, p, group, metadata...)
 }
 
-func (server *Server) register(pathSegments []string, rcvr interface{}, p IServerPluginContainer, metadata ...string) {
+func (server *Server) register(pathSegments []string, rcvr interface{}, p IServerPluginContainer, group *ServiceGroup, metadata ...string) {
+	if err := server.tryRegister(pathSegments, rcvr, p, group, metadata...); err != nil {
+		log.Fatal("rpc: " + err.Error())
+	}
+}
+
+// tryRegister is like register but returns the error instead of fataling.
+func (server *Server) tryRegister(pathSegments []string, rcvr interface{}, p IServerPluginContainer, group *ServiceGroup, metadata ...string) error {
 	server.mu.Lock()
 	defer server.mu.Unlock()
 	services, err := server.ServiceBuilder.NewServices(rcvr, pathSegments...)
 	if err != nil {
-		log.Fatal("rpc: " + err.Error())
+		return err
 	}
 	if len(services) == 0 {
-		log.Fatal("rpc: can not register invalid service: '" + reflect.ValueOf(rcvr).String() + "'")
+		return errors.New("can not register invalid service: '" + reflect.ValueOf(rcvr).String() + "'")
 	}
+	if group != nil && len(group.baseMetadata) > 0 {
+		metadata = append(metadata, group.baseMetadata)
+	}
+	metadata = append(metadata, server.baseMetadata)
 	var errs []error
+	for _, service := range services {
+		if _, present := server.serviceMap[service.GetPath()]; present {
+			errs = append(errs, common.ErrServiceAlreadyExists.Format(service.GetPath()))
+		}
+	}
+	errs = append(errs, server.checkCodecTypes(services)...)
+	if len(errs) > 0 {
+		return common.NewMultiError(errs)
+	}
 	for _, service := range services {
 		spath := service.GetPath()
 
-		if _, present := server.serviceMap[spath]; present {
-			errs = append(errs, common.ErrServiceAlreadyExists.Format(spath))
+		if group != nil && group.timeout > 0 {
+			server.timeouts[spath] = group.timeout
 		}
 
-		metadata = append(metadata, server.baseMetadata)
-
 		var err error
 		err = server.PluginContainer.doRegister(spath, rcvr, metadata...)
 		if err != nil {
@@ -213,12 +387,145 @@ func (server *Server) register(pathSegments []string, rcvr interface{}, p IServe
 		log.Infof("rpc: route ->	%s", spath)
 
 		server.serviceMap[spath] = service
-	}
-	if len(errs) > 0 {
-		log.Fatal("rpc: " + common.NewMultiError(errs).Error())
+		server.callCounters[spath] = new(methodCounter)
 	}
 	// sort router
 	sort.Strings(server.routers)
+	if len(errs) > 0 {
+		return common.NewMultiError(errs)
+	}
+	return nil
+}
+
+// RegisterAll registers each receiver of the map under its key, e.g. assembled from a registry
+// at runtime. Unlike NamedRegister, it goes on after a failed entry and returns the aggregated
+// *common.MultiError naming the failed entries, or nil.
+func (server *Server) RegisterAll(rcvrs map[string]interface{}, metadata ...string) error {
+	names := make([]string, 0, len(rcvrs))
+	for name := range rcvrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		err := common.CheckSname(name)
+		if err == nil {
+			err = server.tryRegister([]string{name}, rcvrs[name], new(ServerPluginContainer), nil, metadata...)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rpc: can not register '%s': %s", name, err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return common.NewMultiError(errs)
+	}
+	return nil
+}
+
+// ReplaceService atomically replaces the services registered under the path,
+// e.g. "/worker", by the methods of rcvr. Every method of rcvr must replace a registered service,
+// which keeps its plugins. The in-flight calls complete on the old services, the new calls use the new ones.
+func (server *Server) ReplaceService(path string, rcvr interface{}) error {
+	services, err := server.ServiceBuilder.NewServices(rcvr, path)
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		return common.NewError("can not register invalid service: '" + reflect.ValueOf(rcvr).String() + "'")
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, service := range services {
+		old, ok := server.serviceMap[service.GetPath()]
+		if !ok {
+			return common.NewError("can not replace the unregistered service: '" + service.GetPath() + "'")
+		}
+		service.SetPluginContainer(old.GetPluginContainer())
+	}
+	if errs := server.checkCodecTypes(services); len(errs) > 0 {
+		return common.NewMultiError(errs)
+	}
+	replaced := make(map[string]bool, len(services))
+	for _, service := range services {
+		server.serviceMap[service.GetPath()] = service
+		replaced[service.GetPath()] = true
+		log.Infof("rpc: replace ->\t%s", service.GetPath())
+	}
+	server.invalidateCaches(func(path string) bool { return replaced[path] }, false)
+	return nil
+}
+
+// Deregister removes the service of the path, together with its call timeout, watchdog, statistics
+// and cached replies, and returns whether it was registered. The calls in progress complete,
+// the later ones can't find the service.
+func (server *Server) Deregister(path string) bool {
+	return server.deregister(func(p string) bool { return p == path }) > 0
+}
+
+// DeregisterPrefix removes the services whose path has the prefix, e.g. "/admin/" of the services
+// registered by a plugin being torn down, like Deregister, and returns the number removed.
+func (server *Server) DeregisterPrefix(prefix string) int {
+	return server.deregister(func(path string) bool { return strings.HasPrefix(path, prefix) })
+}
+
+// deregister removes the services whose path matches.
+func (server *Server) deregister(match func(path string) bool) int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	// a new slice, for Routers returns the former one to the callers.
+	routers := make([]string, 0, len(server.routers))
+	removed := 0
+	for _, path := range server.routers {
+		if !match(path) {
+			routers = append(routers, path)
+			continue
+		}
+		delete(server.serviceMap, path)
+		delete(server.timeouts, path)
+		delete(server.callCounters, path)
+		removed++
+		log.Infof("rpc: deregister ->\t%s", path)
+	}
+	sort.Strings(routers)
+	server.routers = routers
+	server.updateWatchdogs(func(limits map[string]time.Duration) {
+		for path := range limits {
+			if match(path) {
+				delete(limits, path)
+			}
+		}
+	})
+	server.invalidateCaches(match, true)
+	return removed
+}
+
+// PreviewPaths returns the sorted service paths that NamedRegister would register
+// for the receiver and name, without registering them.
+func (server *Server) PreviewPaths(name string, rcvr interface{}) ([]string, error) {
+	if err := common.CheckSname(name); err != nil {
+		return nil, err
+	}
+	services, err := server.ServiceBuilder.NewServices(rcvr, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, common.NewError("can not register invalid service: '" + reflect.ValueOf(rcvr).String() + "'")
+	}
+	paths := make([]string, len(services))
+	for i, service := range services {
+		paths[i] = service.GetPath()
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// IsIdempotent returns whether the service of the path is declared idempotent.
+func (server *Server) IsIdempotent(servicePath string) bool {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	service, ok := server.serviceMap[servicePath].(IIdempotent)
+	return ok && service.IsIdempotent()
 }
 
 // Routers return registered routers.
@@ -228,21 +535,22 @@ func (server *Server) Routers() []string {
 
 // Serve open RPC service at the specified network address.
 func (server *Server) Serve(network, address string) {
-	lis, err := makeListener(network, address)
+	lis, err := server.listen(network, address)
 	if err != nil {
 		log.Fatal("rpc: " + err.Error())
 	}
-	server.serveListener(lis)
+	server.serveListener(network, lis)
 }
 
 // ServeTLS open secure RPC service at the specified network address.
+// The certificate can be swapped by SetCertificate without restart, or served by the GetCertificate of the config.
 func (server *Server) ServeTLS(network, address string, config *tls.Config) {
-	lis, err := makeListener(network, address)
+	lis, err := server.listen(network, address)
 	if err != nil {
 		log.Fatalf("rpc: %s", err.Error())
 	}
-	lis = tls.NewListener(lis, config)
-	server.serveListener(lis)
+	lis = tls.NewListener(lis, server.tlsConfig(config))
+	server.serveListener(network, lis)
 }
 
 // ServeListener accepts connection on the listener and serves requests.
@@ -253,21 +561,64 @@ func (server *Server) ServeListener(lis net.Listener) {
 	if err != nil {
 		log.Fatalf("rpc: %s", err.Error())
 	}
-	server.serveListener(lis)
+	server.serveListener(lis.Addr().Network(), lis)
+}
+
+// ServeFD adopts the listener of an inherited file descriptor, such as
+// one passed by systemd socket activation, and serves requests on it.
+// The network is checked by its family, e.g. "tcp4" accepts any TCP listener.
+// ServeFD blocks until the listener returns a non-nil error.
+func (server *Server) ServeFD(fd uintptr, network string) {
+	f := os.NewFile(fd, fmt.Sprintf("%s:fd%d", network, fd))
+	if f == nil {
+		log.Fatalf("rpc: invalid file descriptor %d", fd)
+	}
+	lis, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("rpc: %s", err.Error())
+	}
+	if networkFamily(lis.Addr().Network()) != networkFamily(network) {
+		lis.Close()
+		log.Fatalf("rpc: file descriptor %d is a %s listener, not %s", fd, lis.Addr().Network(), network)
+	}
+	server.ServeListener(lis)
 }
 
 // serveListener accepts connection on the listener and serves requests.
 // serveListener blocks until the listener returns a non-nil error.
 // The caller typically invokes serveListener in a go statement.
-func (server *Server) serveListener(lis net.Listener) {
+func (server *Server) serveListener(network string, lis net.Listener) {
+	network = listenerNetwork(network, lis.Addr())
 	server.mu.Lock()
 	server.listener = lis
+	server.network = network
 	server.running = true
 	server.mu.Unlock()
 	defer func() {
 		<-exit
 	}()
-	log.Infof("rpc: listening and serving %s on %s", strings.ToUpper(server.listener.Addr().Network()), server.listener.Addr().String())
+	log.Infof("rpc: listening and serving %s on %s", strings.ToUpper(network), lis.Addr().String())
+	counter := server.newListenerCounter(network, lis.Addr().String())
+	if server.AcceptParallelism <= 1 {
+		server.acceptLoop(lis, counter)
+		return
+	}
+	// all accept goroutines return once the listener is closed.
+	var wg sync.WaitGroup
+	wg.Add(server.AcceptParallelism)
+	for i := 0; i < server.AcceptParallelism; i++ {
+		go func() {
+			defer wg.Done()
+			server.acceptLoop(lis, counter)
+		}()
+	}
+	wg.Wait()
+}
+
+// acceptLoop accepts connection on the listener and serves requests,
+// until the listener returns a non-nil error.
+func (server *Server) acceptLoop(lis net.Listener, counter *listenerCounter) {
 	for {
 		c, err := lis.Accept()
 		if err != nil {
@@ -276,17 +627,66 @@ func (server *Server) serveListener(lis net.Listener) {
 			}
 			return
 		}
+		counter.accepted()
 		conn := NewServerCodecConn(c)
-		if err = server.PluginContainer.doPostConnAccept(conn); err != nil {
-			log.Debugf("rpc: PostConnAccept: %s", err.Error())
+		if server.isDraining() {
+			counter.rejected()
+			go server.reject(conn, common.ErrorTypeServerDraining, drainingMsg)
 			continue
 		}
-		go server.ServeConn(conn)
+		if server.hasSNI() {
+			go counter.serve(func() { server.serveSNI(conn) })
+			continue
+		}
+		go counter.serve(func() {
+			// the handshake and the plugins don't hold up the other accepts.
+			if err := server.handshake(conn); err != nil {
+				counter.rejected()
+				log.Debugf("rpc: TLS handshake with %s: %s", conn.RemoteAddr().String(), err.Error())
+				conn.Close()
+				return
+			}
+			if err := server.PluginContainer.doPostConnAccept(conn, server.PluginPanicPolicy); err != nil {
+				counter.rejected()
+				log.Debugf("rpc: PostConnAccept: %s", err.Error())
+				return
+			}
+			server.ServeConn(conn)
+		})
+	}
+}
+
+// defaultHandshakeTimeout bounds the TLS handshake of a server without the timeouts.
+const defaultHandshakeTimeout = 10 * time.Second
+
+// handshake completes the TLS handshake of the connection if it is a TLS one, within the HeaderTimeout,
+// or else the ReadTimeout, the Timeout or defaultHandshakeTimeout, so that the PostConnAccept plugins
+// reading the TLS state don't wait for the client.
+func (server *Server) handshake(conn ServerCodecConn) error {
+	tlsConn, ok := conn.GetConn().(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	timeout := defaultHandshakeTimeout
+	for _, t := range []time.Duration{server.Timeout, server.ReadTimeout, server.HeaderTimeout} {
+		if t > 0 {
+			timeout = t
+		}
 	}
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	err := tlsConn.Handshake()
+	tlsConn.SetDeadline(time.Time{})
+	return err
 }
 
+const httpDisabledMsg = "HTTP transport is disabled"
+
 // ServeByHTTP serves
 func (server *Server) ServeByHTTP(lis net.Listener, rpcPath ...string) {
+	if server.DisableHTTP {
+		log.Error("rpc: " + httpDisabledMsg + ", refuse to serve on " + lis.Addr().String())
+		return
+	}
 	err := grace.Append(lis)
 	if err != nil {
 		log.Fatalf("rpc: %s", err.Error())
@@ -295,6 +695,7 @@ func (server *Server) ServeByHTTP(lis net.Listener, rpcPath ...string) {
 	if len(rpcPath) > 0 && len(rpcPath[0]) > 0 {
 		p = rpcPath[0]
 	}
+	server.setRunning()
 	http.Handle(p, server)
 	srv := &http.Server{Handler: nil}
 	srv.Serve(lis)
@@ -302,6 +703,10 @@ func (server *Server) ServeByHTTP(lis net.Listener, rpcPath ...string) {
 
 // ServeByMux serves
 func (server *Server) ServeByMux(lis net.Listener, mux *http.ServeMux, rpcPath ...string) {
+	if server.DisableHTTP {
+		log.Error("rpc: " + httpDisabledMsg + ", refuse to serve on " + lis.Addr().String())
+		return
+	}
 	err := grace.Append(lis)
 	if err != nil {
 		log.Fatalf("rpc: %s", err.Error())
@@ -310,6 +715,7 @@ func (server *Server) ServeByMux(lis net.Listener, mux *http.ServeMux, rpcPath .
 	if len(rpcPath) > 0 && len(rpcPath[0]) > 0 {
 		p = rpcPath[0]
 	}
+	server.setRunning()
 	mux.Handle(p, server)
 	srv := &http.Server{Handler: mux}
 	srv.Serve(lis)
@@ -317,6 +723,12 @@ func (server *Server) ServeByMux(lis net.Listener, mux *http.ServeMux, rpcPath .
 
 // ServeHTTP implements an http.Handler that answers RPC requests.
 func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if server.DisableHTTP {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "403 "+httpDisabledMsg+"\n")
+		return
+	}
 	if req.Method != "CONNECT" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -330,13 +742,34 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if server.isDraining() {
+		io.WriteString(c, "HTTP/1.0 503 "+drainingMsg+"\n\n")
+		c.Close()
+		return
+	}
+	// the HTTP clients declare the protocol version in the header instead of the preamble.
+	version, err := server.httpProtocolVersion(req.Header)
+	if err != nil {
+		io.WriteString(c, "HTTP/1.0 400 "+err.Error()+"\n\n")
+		c.Close()
+		return
+	}
 	conn := NewServerCodecConn(c)
-	if err = server.PluginContainer.doPostConnAccept(conn); err != nil {
+	setProtocolVersion(conn, version)
+	if err = server.PluginContainer.doPostConnAccept(conn, server.PluginPanicPolicy); err != nil {
 		log.Debugf("rpc: PostConnAccept: %s", err.Error())
 		return
 	}
 
-	io.WriteString(conn, "HTTP/1.0 "+common.Connected+"\n\n")
+	if conn.GetServerCodec() == nil {
+		if err = conn.SetServerCodec(server.ServerCodecFunc); err != nil {
+			log.Errorf("rpc: setting codec for %s: %s", req.RemoteAddr, err.Error())
+			conn.Close()
+			return
+		}
+	}
+	io.WriteString(conn, "HTTP/1.0 "+common.Connected+"\nContent-Type: "+ContentType(conn.GetServerCodec())+
+		"\n"+common.ProtocolHeader+": "+strconv.Itoa(int(version))+"\n\n")
 	server.ServeConn(conn)
 }
 
@@ -344,27 +777,91 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 // and a debugging handler on debugPath.
 // It is still necessary to invoke http.Serve(), typically in a go statement.
 func (server *Server) HandleHTTP(rpcPath string) {
+	if server.DisableHTTP {
+		log.Error("rpc: " + httpDisabledMsg + ", refuse to handle " + rpcPath)
+		return
+	}
 	http.Handle(rpcPath, server)
 }
 
-// Address return the listening address.
+// Address return the listening address, see Addr and NetworkInfo for the structured one.
+// It is empty if the server isn't serving.
 func (server *Server) Address() string {
-	return server.listener.Addr().String()
+	if addr := server.Addr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// Network returns the network of the listener, with the address family of the TCP listener:
+// "tcp4", "tcp6", or common.NetworkDualStack.
+func (server *Server) Network() string {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return server.network
+}
+
+// networkFamily returns the network without the IP version, e.g. "tcp" of "tcp4".
+func networkFamily(network string) string {
+	switch network {
+	case "tcp4", "tcp6", common.NetworkDualStack:
+		return "tcp"
+	case "udp4", "udp6":
+		return "udp"
+	case "ip4", "ip6":
+		return "ip"
+	}
+	return network
+}
+
+// listenerNetwork resolves the address family of the TCP listener served by the network "tcp",
+// which listens on both IPv4 and IPv6 on the IPv6 wildcard address.
+// Note: A "tcp6" listener on the IPv6 wildcard address given to ServeListener is reported
+// as common.NetworkDualStack too.
+func listenerNetwork(network string, addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || network != "tcp" {
+		return network
+	}
+	switch {
+	case tcpAddr.IP.To4() != nil:
+		return "tcp4"
+	case tcpAddr.IP == nil || tcpAddr.IP.IsUnspecified():
+		return common.NetworkDualStack
+	default:
+		return "tcp6"
+	}
+}
+
+// Shutdown stops the server gracefully: it closes the listener, rejects the new connections
+// and waits for the outstanding calls to complete. If ctx is done before they complete,
+// their ctx.Context() is canceled with CancelServerShutdown and ctx.Err() is returned.
+// See the package level Shutdown for shutting down all servers.
+func (server *Server) Shutdown(ctx context.Context) error {
+	return server.close(ctx)
 }
 
 // close listener and server.
 func (server *Server) close(ctx context.Context) error {
-	if server.listener == nil {
-		return nil
-	}
-	server.listener.Close()
+	server.SetDraining(true)
 	server.mu.Lock()
 	defer server.mu.Unlock()
+	// the server isn't serving any more, see Addr.
+	lis := server.listener
+	server.listener = nil
+	server.network = ""
+	if lis != nil {
+		lis.Close()
+	}
 	if !server.running {
 		return nil
 	}
-	log.Infof("rpc: stopped listening %s", server.Address())
+	if lis != nil {
+		log.Infof("rpc: stopped listening %s", lis.Addr().String())
+	}
 	server.running = false
+	// the workers exit once they have run the queued calls.
+	server.workerPool.resize(0)
 	var c = make(chan bool)
 	go func() {
 		server.callGroup.Wait()
@@ -372,12 +869,69 @@ func (server *Server) close(ctx context.Context) error {
 	}()
 	select {
 	case <-ctx.Done():
+		if server.cancelBase != nil {
+			server.cancelBase(errCanceledServerShutdown)
+		}
 		return ctx.Err()
 	case <-c:
 		return nil
 	}
 }
 
+const drainingMsg = "server is draining"
+
+// SetDraining sets whether the server is draining.
+// While draining, the newly accepted connections are rejected with an ErrorTypeServerDraining
+// response to the first request, so that clients redistribute to other servers fast,
+// and the existing connections are still served, but their heartbeats (see common.HeartbeatPath)
+// are answered with the draining error, so that the health checks see the server not serving.
+// Shutdown sets it automatically.
+func (server *Server) SetDraining(draining bool) {
+	var v int32
+	if draining {
+		v = 1
+	}
+	atomic.StoreInt32(&server.draining, v)
+}
+
+func (server *Server) isDraining() bool {
+	return atomic.LoadInt32(&server.draining) == 1
+}
+
+// reject answers the first request of the connection with the error, e.g. the draining error, and closes it.
+func (server *Server) reject(conn ServerCodecConn, errorType common.ErrorType, errMsg string) {
+	defer conn.Close()
+	timeout := server.HeaderTimeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if conn.GetServerCodec() == nil {
+		if err := conn.SetServerCodec(server.ServerCodecFunc); err != nil {
+			return
+		}
+	}
+	var req rpc.Request
+	if err := conn.ReadRequestHeader(&req); err != nil {
+		return
+	}
+	if err := conn.ReadRequestBody(nil); err != nil {
+		return
+	}
+	conn.WriteResponse(&rpc.Response{
+		ServiceMethod: req.ServiceMethod,
+		Seq:           req.Seq,
+		Error:         string(rune(errorType)) + errMsg,
+	}, invalidRequest)
+}
+
+// setRunning marks the server serving the connections of a transport without its own listener, e.g. HTTP.
+func (server *Server) setRunning() {
+	server.mu.Lock()
+	server.running = true
+	server.mu.Unlock()
+}
+
 func (server *Server) isRunning() bool {
 	server.mu.RLock()
 	defer server.mu.RUnlock()
@@ -385,33 +939,144 @@ func (server *Server) isRunning() bool {
 }
 
 // ServeConn runs the server on a single connection.
-// ServeConn blocks, serving the connection until the client hangs up.
+// ServeConn blocks, serving the connection until the client hangs up,
+// or until a response fails to be written, which may leave the connection in a corrupt state.
 // The caller typically invokes ServeConn in a go statement.
 // ServeConn uses the gob wire format (see package gob) on the
 // connection. To use an alternate codec, use ServeCodec.
 func (server *Server) ServeConn(conn ServerCodecConn) {
+	var header *headerConn
+	var counting *countingConn
 	if conn.GetServerCodec() == nil {
-		conn.SetServerCodec(server.ServerCodecFunc)
+		if len(server.ProtocolVersions) > 0 {
+			c, err := server.negotiateProtocol(conn)
+			if err != nil {
+				log.Debugf("rpc: negotiating the protocol of %s: %s", conn.RemoteAddr().String(), err.Error())
+				conn.Close()
+				return
+			}
+			conn.SetConn(c)
+		}
+		if server.DecompressRequests {
+			c, err := server.decompressRequests(conn.GetConn())
+			if err != nil {
+				log.Debugf("rpc: reading the preamble of %s: %s", conn.RemoteAddr().String(), err.Error())
+				conn.Close()
+				return
+			}
+			conn.SetConn(c)
+		}
+		if server.HeaderTimeout > 0 {
+			header = &headerConn{Conn: conn.GetConn(), timeout: server.HeaderTimeout}
+			conn.SetConn(header)
+		}
+		if server.MaxCallBytes > 0 {
+			counting = newCountingConn(conn.GetConn())
+			conn.SetConn(counting)
+		}
+		if err := conn.SetServerCodec(server.ServerCodecFunc); err != nil {
+			log.Errorf("rpc: setting codec for %s: %s", conn.RemoteAddr().String(), err.Error())
+			conn.Close()
+			return
+		}
+	}
+	if shard := GetShard(conn); server.Shard != "" && shard != server.Shard {
+		log.Debugf("rpc: reject %s of shard %q", conn.RemoteAddr().String(), shard)
+		server.reject(conn, common.ErrorTypeServerWrongShard, wrongShardMsg(server.Shard, shard))
+		return
+	}
+	if version := protocolVersion(conn); len(server.ProtocolVersions) > 0 && !server.supportsProtocol(version) {
+		log.Debugf("rpc: reject %s of protocol version %d", conn.RemoteAddr().String(), version)
+		server.reject(conn, common.ErrorTypeServerProtocolVersion, unsupportedProtocolMsg(version, server.ProtocolVersions))
+		return
 	}
 	sending := new(sync.Mutex)
+	broken := new(int32)
+	streams := new(streamCalls)
+	// the calls of the connection are canceled once the client hangs up.
+	connCtx, cancel := context.WithCancelCause(server.baseContext())
 	var ctx *Context
-	for server.isRunning() {
-		ctx = server.getContext(conn)
+	var inflight int32
+	var calls sync.WaitGroup // the in-flight calls
+	// pending holds a slot for every call until its response is written.
+	var pending chan struct{}
+	if server.MaxPendingResponses > 0 {
+		pending = make(chan struct{}, server.MaxPendingResponses)
+	}
+	first := true
+	for server.isRunning() && atomic.LoadInt32(broken) == 0 {
+		ctx = server.getContext(connCtx, conn)
+		ctx.sending = sending
+		ctx.broken = broken
+		ctx.streams = streams
+		ctx.header = header
+		ctx.counting = counting
+		ctx.first = first
 		keepReading, notSend, err := server.readRequest(ctx)
-		server.callGroup.Add(1)
+		first = first && !keepReading
+		if err == nil && ctx.control {
+			// handled as it is read, it is not a call.
+			server.putContext(ctx)
+			continue
+		}
+		if err == nil && pending != nil {
+			pending <- struct{}{}
+		}
+		workers := server.workers()
+		acquired := err == nil && pending != nil
+		if err == nil && workers <= 0 && !server.acquireGoroutine() {
+			ctx.rpcErrorType = common.ErrorTypeServerBusy
+			err, keepReading = serverBusy(server.MaxGoroutines), true
+		}
+		if err == nil && workers > 0 && !server.workerPool.admit(server.maxQueuedCalls(workers)) {
+			ctx.rpcErrorType = common.ErrorTypeServerBusy
+			err, keepReading = workersBusy(server.maxQueuedCalls(workers)), true
+		}
 		if err == nil {
-			go func(c *Context) {
+			server.callGroup.Add(1)
+			atomic.AddInt32(&inflight, 1)
+			calls.Add(1)
+			ctx.trackStream()
+			c := ctx
+			run := func() {
 				server.call(sending, c)
+				c.untrackStream()
+				if pending != nil {
+					<-pending
+				}
 				server.putContext(c)
 				server.callGroup.Done()
-			}(ctx)
+				atomic.AddInt32(&inflight, -1)
+				calls.Done()
+			}
+			if workers > 0 {
+				server.workerPool.submit(c.priority, run)
+			} else {
+				go func() {
+					run()
+					server.releaseGoroutine()
+				}()
+			}
 			continue
 		}
+		if acquired {
+			<-pending
+		}
+		if ctx.idle {
+			server.putContext(ctx)
+			if atomic.LoadInt32(&inflight) > 0 {
+				// in-flight requests reset the idle clock.
+				continue
+			}
+			log.Debugf("rpc: close idle connection %s", conn.RemoteAddr().String())
+			break
+		}
 		if err != io.EOF {
 			log.Debugf("rpc: %s", err.Error())
 		}
 		if keepReading {
 			// send a response if we actually managed to read a header.
+			server.callGroup.Add(1)
 			if !notSend {
 				server.sendResponse(sending, ctx, err.Error())
 			}
@@ -420,27 +1085,72 @@ func (server *Server) ServeConn(conn ServerCodecConn) {
 			continue
 		}
 		server.putContext(ctx)
-		server.callGroup.Done()
+		cancel(errCanceledClientDisconnect)
 		break
 	}
+	if atomic.LoadInt32(broken) == 1 {
+		cancel(errCanceledClientDisconnect)
+	}
 	conn.Close()
+	go func() {
+		calls.Wait()
+		cancel(nil)
+	}()
 }
 
 // ServeRequest is like ServeConn but synchronously serves a single request.
 // It does not close the codec upon completion.
 func (server *Server) ServeRequest(conn ServerCodecConn) error {
+	return server.serveRequest(conn, 0)
+}
+
+// ServeRequestTimeout is like ServeRequest but gives up the service that doesn't return within d:
+// it cancels the ctx.Context() of the service, sends the timeout error to the client and returns
+// a timeout error without waiting for the service. The shorter call timeout of the service applies.
+func (server *Server) ServeRequestTimeout(conn ServerCodecConn, d time.Duration) error {
+	return server.serveRequest(conn, d)
+}
+
+func (server *Server) serveRequest(conn ServerCodecConn, d time.Duration) error {
 	if !server.isRunning() {
 		return errors.New("rpc: server has stopped")
 	}
+	return server.serveSingle(conn, d)
+}
+
+// serveSingle serves a request of the connection whether the server is running or not.
+func (server *Server) serveSingle(conn ServerCodecConn, d time.Duration) error {
 	if conn.GetServerCodec() == nil {
-		conn.SetServerCodec(server.ServerCodecFunc)
+		if err := conn.SetServerCodec(server.ServerCodecFunc); err != nil {
+			return err
+		}
 	}
 	sending := new(sync.Mutex)
-	ctx := server.getContext(conn)
+	ctx := server.getContext(server.baseContext(), conn)
+	ctx.sending = sending
 	keepReading, notSend, err := server.readRequest(ctx)
+	if err == nil && ctx.control {
+		server.putContext(ctx)
+		return nil
+	}
 	server.callGroup.Add(1)
 	if err == nil {
-		server.call(sending, ctx)
+		timeout := server.callTimeout(ctx.service.GetPath())
+		if d > 0 && (timeout <= 0 || d < timeout) {
+			timeout = d
+		}
+		if done := server.callWithin(sending, ctx, timeout); done != nil {
+			if d <= 0 {
+				<-done
+			} else {
+				go func() {
+					<-done
+					server.putContext(ctx)
+					server.callGroup.Done()
+				}()
+				return common.NewError("rpc: service timeout (" + timeout.String() + ")")
+			}
+		}
 		server.putContext(ctx)
 		server.callGroup.Done()
 		return nil
@@ -455,7 +1165,13 @@ func (server *Server) ServeRequest(conn ServerCodecConn) error {
 }
 
 func (server *Server) readRequest(ctx *Context) (keepReading bool, notSend bool, err error) {
+	if ctx.counting != nil {
+		ctx.counting.mark()
+	}
 	keepReading, notSend, err = ctx.readRequestHeader()
+	if ctx.control {
+		return
+	}
 	if err != nil {
 		if !keepReading {
 			return
@@ -465,8 +1181,29 @@ func (server *Server) readRequest(ctx *Context) (keepReading bool, notSend bool,
 		return
 	}
 
+	if _, ok := ctx.service.(*notFoundService); ok {
+		err = ctx.readRequestBody(nil)
+		return
+	}
+	if err = ctx.checkEnabled(); err != nil {
+		// discard body
+		ctx.codecConn.ReadRequestBody(nil)
+		return
+	}
+
 	// get arg value
 	argType := ctx.service.GetArgType()
+	if s, ok := ctx.service.(interface{ getArgDecoder() ArgDecoder }); ok && s.getArgDecoder() != nil {
+		err = ctx.decodeArg(s.getArgDecoder(), argType)
+		if err != nil {
+			return
+		}
+		if err = ctx.measureRequest(); err != nil {
+			return
+		}
+		err = ctx.interceptArg()
+		return
+	}
 	argIsValue := false // if true, need to indirect before calling.
 	var argv reflect.Value
 	if argType.Kind() == reflect.Ptr {
@@ -484,25 +1221,102 @@ func (server *Server) readRequest(ctx *Context) (keepReading bool, notSend bool,
 
 	// Decode the argument value.
 	err = ctx.readRequestBody(argv.Interface())
+	if err != nil {
+		return
+	}
+	// e.g. the null or the empty body of a map[string]interface{}.
+	makeMap(argv)
+	if err = ctx.measureRequest(); err != nil {
+		return
+	}
+
+	// intercept the argument value.
+	err = ctx.interceptArg()
 	return
 }
 
 func (server *Server) call(sending *sync.Mutex, ctx *Context) {
+	if done := server.callWithin(sending, ctx, server.callTimeout(ctx.service.GetPath())); done != nil {
+		// the context must not be reused until the service returns.
+		<-done
+	}
+}
+
+// callWithin calls the service and sends the response. If the service doesn't return
+// within the timeout, it cancels the ctx.Context(), sends the timeout error and returns a channel
+// closed when the service returns; otherwise it returns nil after the service returns.
+func (server *Server) callWithin(sending *sync.Mutex, ctx *Context, timeout time.Duration) <-chan struct{} {
 	defer func() {
 		if p := recover(); p != nil {
+			if pp, ok := p.(propagatedPanic); ok {
+				panic(pp.value)
+			}
 			log.Criticalf("rpc: (%s): %v\n[PANIC]\n%s\n", ctx.Path(), p, common.PanicTrace(4))
-			ctx.rpcErrorType = common.ErrorTypeServerServicePanic
-			server.sendResponse(sending, ctx, "Service Panic!")
+			server.servicePanicked(sending, ctx, p)
 		}
 	}()
-	var err error
-	ctx.replyv, err = ctx.service.Call(ctx.argv, ctx)
-	errmsg := ""
-	if err != nil {
-		errmsg = err.Error()
-		ctx.rpcErrorType = common.ErrorTypeServerService
+	if timeout <= 0 {
+		var err error
+		ctx.replyv, err = server.callService(ctx)
+		errmsg := ""
+		if err != nil {
+			errmsg = err.Error()
+			ctx.rpcErrorType = common.ErrorTypeServerService
+		}
+		server.sendResponse(sending, ctx, errmsg)
+		return nil
+	}
+
+	type result struct {
+		replyv reflect.Value
+		err    error
+		panic  interface{}
+	}
+	// the service can stop by the Done of ctx.Context().
+	values, cancel := context.WithTimeoutCause(ctx.Context(), timeout, errCanceledDeadline)
+	defer cancel()
+	ctx.Lock()
+	ctx.values = values
+	ctx.Unlock()
+	c := make(chan result, 1)
+	go func() {
+		var res result
+		defer func() {
+			if p := recover(); p != nil {
+				log.Criticalf("rpc: (%s): %v\n[PANIC]\n%s\n", ctx.Path(), p, common.PanicTrace(4))
+				res.panic = p
+			}
+			c <- res
+		}()
+		res.replyv, res.err = server.callService(ctx)
+	}()
+	select {
+	case res := <-c:
+		if res.panic != nil {
+			server.servicePanicked(sending, ctx, res.panic)
+			return nil
+		}
+		errmsg := ""
+		if res.err != nil {
+			errmsg = res.err.Error()
+			ctx.rpcErrorType = common.ErrorTypeServerService
+		}
+		ctx.replyv = res.replyv
+		server.sendResponse(sending, ctx, errmsg)
+		return nil
+	case <-values.Done():
+		ctx.rpcErrorType = common.ErrorTypeServerTimeout
+		server.sendResponse(sending, ctx, "service timeout ("+timeout.String()+")")
+		done := make(chan struct{})
+		go func() {
+			res := <-c
+			close(done)
+			if res.panic != nil && server.PanicPolicy == Propagate {
+				panic(res.panic)
+			}
+		}()
+		return done
 	}
-	server.sendResponse(sending, ctx, errmsg)
 }
 
 // A value sent as a placeholder for the server's response value when the server
@@ -514,13 +1328,31 @@ func (server *Server) sendResponse(sending *sync.Mutex, ctx *Context, errmsg str
 	var reply interface{}
 	// Encode the response header
 	ctx.resp.ServiceMethod = ctx.req.ServiceMethod
+	if ctx.advertise {
+		ctx.resp.ServiceMethod = advertise(ctx.ServiceMethod(), common.NewCapabilities(server.Capabilities...))
+	}
+	if server.LoadReporter != nil {
+		ctx.SetResponseMetadata(common.LoadKey, common.FormatLoad(server.LoadReporter()))
+	}
+	if errmsg == "" {
+		reply = ctx.replyv.Interface()
+		if r, ok := streamedReply(reply); ok {
+			// the chunks go ahead, the final response carries no reply.
+			reply = invalidRequest
+			if err := ctx.streamReply(r); err != nil {
+				ctx.rpcErrorType = common.ErrorTypeServerService
+				errmsg = "stream reply: " + err.Error()
+			}
+		}
+	}
 	if errmsg != "" {
 		ctx.resp.Error = errmsg
 		reply = invalidRequest
-	} else {
-		reply = ctx.replyv.Interface()
 	}
 	ctx.resp.Seq = ctx.req.Seq
+	if ctx.calls != nil {
+		ctx.calls.record(errmsg)
+	}
 	sending.Lock()
 	err := ctx.writeResponse(reply)
 	if err != nil {
@@ -529,11 +1361,12 @@ func (server *Server) sendResponse(sending *sync.Mutex, ctx *Context, errmsg str
 	sending.Unlock()
 }
 
-func (server *Server) getContext(conn ServerCodecConn) *Context {
+func (server *Server) getContext(parent context.Context, conn ServerCodecConn) *Context {
 	ctx := server.contextPool.Get().(*Context)
 	ctx.Lock()
 	ctx.codecConn = conn
 	ctx.data.data = make(map[interface{}]interface{})
+	ctx.values = parent
 	ctx.Unlock()
 	return ctx
 }
@@ -541,13 +1374,32 @@ func (server *Server) getContext(conn ServerCodecConn) *Context {
 func (server *Server) putContext(ctx *Context) {
 	ctx.Lock()
 	ctx.data.data = nil
+	ctx.values = nil
 	ctx.codecConn = nil
 	ctx.req.ServiceMethod = ""
 	ctx.req.Seq = 0
 	ctx.resp.Error = ""
 	ctx.resp.Seq = 0
+	ctx.rpcErrorType = common.ErrorTypeUnknown
 	ctx.resp.ServiceMethod = ""
 	ctx.service = nil
+	ctx.calls = nil
+	ctx.idle = false
+	ctx.first = false
+	ctx.header = nil
+	ctx.counting = nil
+	ctx.advertise = false
+	ctx.control = false
+	ctx.respMetadata = nil
+	ctx.trailers = nil
+	ctx.priority = common.PriorityNormal
+	ctx.requestID = ""
+	ctx.sending = nil
+	ctx.broken = nil
+	ctx.streams = nil
+	ctx.cancelStream = nil
+	ctx.requestBytes = 0
+	ctx.reply = nil
 	ctx.query = url.Values{}
 	ctx.argv = reflect.Value{}
 	ctx.replyv = reflect.Value{}
@@ -1,9 +1,14 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"io"
 	"net"
 	"net/rpc"
+	"reflect"
+
+	"github.com/henrylee2cn/myrpc/common"
 )
 
 type (
@@ -12,7 +17,23 @@ type (
 		// Conn
 		net.Conn
 		SetConn(net.Conn)
+		// GetConn returns the connection the server reads, e.g. for a PostConnAccept plugin to wrap it
+		// by SetConn, such as the compression. The plugins only inspecting it use NetConn instead.
 		GetConn() net.Conn
+		// NetConn returns the underlying connection for inspection, see GuardedConn.
+		// The server completes the TLS handshake before the PostConnAccept plugins run.
+		NetConn() net.Conn
+		// ConnectionState completes the TLS handshake if necessary and returns the TLS state,
+		// ok is false if it is not a TLS connection.
+		ConnectionState() (state tls.ConnectionState, ok bool)
+		// SetValue stores the data with given key in this connection.
+		SetValue(key, val interface{})
+		// GetValue returns the stored data in this connection.
+		GetValue(key interface{}) interface{}
+		// SetCapabilities stores the capabilities advertised by the client.
+		SetCapabilities(common.Capabilities)
+		// Supports returns whether the client advertised the feature.
+		Supports(feature string) bool
 
 		// ServerCodec
 		ReadRequestHeader(*rpc.Request) error
@@ -22,8 +43,27 @@ type (
 
 		GetServerCodec() rpc.ServerCodec
 
-		//Must ensure that both Conn and ServerCodecFunc are not nil
-		SetServerCodec(ServerCodecFunc)
+		//Must ensure that both Conn and ServerCodecFunc are not nil,
+		//returns an error if the ServerCodec can not be created.
+		SetServerCodec(ServerCodecFunc) error
+	}
+
+	// IContentType can be implemented by a ServerCodec to report its content type for HTTP transports.
+	IContentType interface {
+		ContentType() string
+	}
+
+	// IInitError can be implemented by a ServerCodec to report the failure of its creation,
+	// e.g. a malformed handshake read by the ServerCodecFunc.
+	IInitError interface {
+		InitError() error
+	}
+
+	// ITypeChecker can be implemented by a ServerCodec that encodes only some types, e.g. colfer,
+	// to reject at the registration the services whose arguments or reply it can't encode,
+	// instead of failing at the first call. The types are pointers.
+	ITypeChecker interface {
+		CheckType(t reflect.Type) error
 	}
 
 	// ServerCodecFunc is used to create a ServerCodec from io.ReadWriteCloser.
@@ -32,12 +72,32 @@ type (
 	serverCodecConn struct {
 		net.Conn
 		rpc.ServerCodec
+		data *Store
 	}
+
+	capabilitiesKey struct{}
+	protocolKey     struct{}
 )
 
+var errNilServerCodec = errors.New("rpc: ServerCodecFunc returns nil")
+
+// DefaultContentType is the content type of the ServerCodec that doesn't implement IContentType.
+const DefaultContentType = "application/octet-stream"
+
+// ContentType returns the content type reported by the codec.
+func ContentType(codec rpc.ServerCodec) string {
+	if c, ok := codec.(IContentType); ok {
+		return c.ContentType()
+	}
+	return DefaultContentType
+}
+
 // NewServerCodecConn get a ServerCodecConn.
 func NewServerCodecConn(conn net.Conn) ServerCodecConn {
-	return &serverCodecConn{Conn: conn}
+	return &serverCodecConn{
+		Conn: conn,
+		data: &Store{data: make(map[interface{}]interface{})},
+	}
 }
 
 func (conn *serverCodecConn) SetConn(c net.Conn) {
@@ -48,11 +108,62 @@ func (conn *serverCodecConn) GetConn() net.Conn {
 	return conn.Conn
 }
 
-// SetServerCodec must ensure that both Conn and ServerCodecFunc are not nil
-func (conn *serverCodecConn) SetServerCodec(fn ServerCodecFunc) {
-	if fn != nil && conn.Conn != nil {
-		conn.ServerCodec = fn(conn.Conn)
+// NetConn returns the underlying connection for inspection, e.g. by the PostConnAccept plugins
+// reading the socket options or the TLS state, see GuardedConn.
+func (conn *serverCodecConn) NetConn() net.Conn {
+	return guard(conn.Conn)
+}
+
+// ConnectionState completes the TLS handshake if necessary and returns the TLS state,
+// ok is false if it is not a TLS connection.
+func (conn *serverCodecConn) ConnectionState() (state tls.ConnectionState, ok bool) {
+	tlsConn, ok := conn.Conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+	tlsConn.Handshake()
+	return tlsConn.ConnectionState(), true
+}
+
+// SetValue stores the data with given key in this connection.
+// The data are cleared when the connection is closed.
+func (conn *serverCodecConn) SetValue(key, val interface{}) {
+	conn.data.Set(key, val)
+}
+
+// GetValue returns the stored data in this connection.
+func (conn *serverCodecConn) GetValue(key interface{}) interface{} {
+	return conn.data.Get(key)
+}
+
+// SetCapabilities stores the capabilities advertised by the client.
+func (conn *serverCodecConn) SetCapabilities(caps common.Capabilities) {
+	conn.data.Set(capabilitiesKey{}, caps)
+}
+
+// Supports returns whether the client advertised the feature.
+func (conn *serverCodecConn) Supports(feature string) bool {
+	caps, _ := conn.data.Get(capabilitiesKey{}).(common.Capabilities)
+	return caps.Supports(feature)
+}
+
+// SetServerCodec must ensure that both Conn and ServerCodecFunc are not nil,
+// returns an error if the ServerCodecFunc returns nil or a ServerCodec reporting IInitError.
+func (conn *serverCodecConn) SetServerCodec(fn ServerCodecFunc) error {
+	if fn == nil || conn.Conn == nil {
+		return nil
+	}
+	codec := fn(conn.Conn)
+	if codec == nil {
+		return errNilServerCodec
+	}
+	if c, ok := codec.(IInitError); ok {
+		if err := c.InitError(); err != nil {
+			return err
+		}
 	}
+	conn.ServerCodec = codec
+	return nil
 }
 
 func (conn *serverCodecConn) GetServerCodec() rpc.ServerCodec {
@@ -62,6 +173,9 @@ func (conn *serverCodecConn) GetServerCodec() rpc.ServerCodec {
 // Close closes the connection.
 // Any blocked Read or Write operations will be unblocked and return errors.
 func (conn *serverCodecConn) Close() error {
+	conn.data.lock.Lock()
+	conn.data.data = make(map[interface{}]interface{})
+	conn.data.lock.Unlock()
 	var err error
 	if conn.ServerCodec != nil {
 		err = conn.ServerCodec.Close()
@@ -1,12 +1,17 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"net"
 	"net/rpc"
 	"net/url"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/henrylee2cn/myrpc/common"
@@ -21,12 +26,31 @@ type (
 		req          *rpc.Request
 		resp         *rpc.Response
 		service      IService
+		calls        *methodCounter // the call statistics of the service
 		argv         reflect.Value
 		replyv       reflect.Value
 		path         string
+		originalPath string // the path before rewritten by the PathRewriter
 		query        url.Values
 		data         *Store
+		values       context.Context
 		rpcErrorType common.ErrorType
+		idle         bool        // no request arrived within the IdleTimeout
+		first        bool        // the first request on the connection
+		advertise    bool        // the client advertised its capabilities, reply with the server's
+		control      bool        // a control frame, e.g. canceling a stream, handled as it is read
+		reply        interface{} // set by the NotFoundHandler
+		priority     common.Priority
+		requestID    string
+		sending      *sync.Mutex             // protects writing the responses of the connection
+		broken       *int32                  // set to 1 after a write of the connection fails
+		streams      *streamCalls            // the calls of the connection streaming the replies
+		header       *headerConn             // bounds the request headers by the HeaderTimeout if not nil
+		counting     *countingConn           // counts the bytes of the requests for the MaxCallBytes if not nil
+		cancelStream context.CancelCauseFunc // cancels the ctx.Context() of the streamed call, see trackStream
+		requestBytes int64                   // the bytes read for the request, see MaxCallBytes
+		respMetadata url.Values              // appended to the response, see SetResponseMetadata
+		trailers     url.Values              // see SetTrailer
 		sync.RWMutex
 	}
 	// Store concurrent secure data storage.
@@ -81,6 +105,33 @@ func (store *Store) Each(callback func(key interface{}, data map[interface{}]int
 	}
 }
 
+// WithValue attaches the value with given key to this context,
+// e.g. a plugin passes the auth identity to the service.
+// Use an unexported key type to avoid collisions, as with context.WithValue.
+func (ctx *Context) WithValue(key, val interface{}) {
+	ctx.Lock()
+	defer ctx.Unlock()
+	ctx.values = context.WithValue(ctx.values, key, val)
+}
+
+// Value returns the value attached by WithValue with given key, or nil.
+func (ctx *Context) Value(key interface{}) interface{} {
+	return ctx.Context().Value(key)
+}
+
+// Context returns the context.Context carrying the values attached by WithValue.
+// It is only available during the request.
+func (ctx *Context) Context() context.Context {
+	ctx.RLock()
+	defer ctx.RUnlock()
+	return ctx.values
+}
+
+// Conn returns the connection of the request.
+func (ctx *Context) Conn() ServerCodecConn {
+	return ctx.codecConn
+}
+
 // RemoteAddr returns remote address
 func (ctx *Context) RemoteAddr() string {
 	addr := ctx.codecConn.RemoteAddr()
@@ -104,11 +155,86 @@ func (ctx *Context) ServiceMethod() string {
 	return ctx.server.ServiceBuilder.URIEncode(ctx.query, ctx.path)
 }
 
+// RawServiceMethod returns the serviceMethod as read from the request header,
+// before the PathRewriter and the plugins change the path and the query.
+func (ctx *Context) RawServiceMethod() string {
+	return ctx.req.ServiceMethod
+}
+
 // Path returns request serviceMethod path.
 func (ctx *Context) Path() string {
 	return ctx.path
 }
 
+// Priority returns the priority of the request.
+func (ctx *Context) Priority() common.Priority {
+	return ctx.priority
+}
+
+// RequestID returns the request ID given by the client in the metadata,
+// or generates one if it is not given.
+func (ctx *Context) RequestID() string {
+	if ctx.requestID == "" {
+		ctx.requestID = common.NewRequestID()
+	}
+	return ctx.requestID
+}
+
+// Logger returns the logger tagged with the request ID, service path and remote address,
+// which correlates the logs of the handler with the request.
+func (ctx *Context) Logger() log.Logger {
+	return log.With("rid=" + ctx.RequestID() + " path=" + ctx.Path() + " remote=" + ctx.RemoteAddr() + " ")
+}
+
+// Progress sends an interim progress of the call before the final reply,
+// which the client receives by the callback of client.WithProgress.
+// It is dropped if the client doesn't support common.FeatureProgress.
+func (ctx *Context) Progress(percent int, msg string) error {
+	return ctx.ProgressWithMetadata(percent, msg, nil)
+}
+
+// ProgressWithMetadata is like Progress, and sends the metadata with the progress,
+// which the client receives by the callback of client.WithProgressMetadata.
+func (ctx *Context) ProgressWithMetadata(percent int, msg string, metadata url.Values) error {
+	if ctx.sending == nil || !ctx.codecConn.Supports(common.FeatureProgress) {
+		return nil
+	}
+	resp := &rpc.Response{
+		ServiceMethod: common.EncodeProgressMetadata(ctx.Path(), percent, msg, metadata),
+		Seq:           ctx.req.Seq,
+	}
+	ctx.sending.Lock()
+	defer ctx.sending.Unlock()
+	err := ctx.codecConn.WriteResponse(resp, invalidRequest)
+	if err != nil {
+		ctx.breakConn()
+	}
+	return err
+}
+
+// breakConn marks the connection broken after a failed write, which may have left a partial
+// response on it, and closes it, so that ServeConn stops serving it instead of writing
+// the further responses out of frame.
+func (ctx *Context) breakConn() {
+	if ctx.broken == nil || !atomic.CompareAndSwapInt32(ctx.broken, 0, 1) {
+		return
+	}
+	log.Debugf("rpc: close broken connection %s", ctx.RemoteAddr())
+	ctx.codecConn.GetConn().Close()
+}
+
+// Hops returns the number of servers the call has passed before this one.
+// The calls made with ctx.Context() carry it plus one.
+func (ctx *Context) Hops() int {
+	hops, _ := common.HopsFrom(ctx.Context())
+	return hops
+}
+
+// OriginalPath returns the request serviceMethod path before rewritten by the Server.PathRewriter.
+func (ctx *Context) OriginalPath() string {
+	return ctx.originalPath
+}
+
 // SetPath sets request serviceMethod path.
 func (ctx *Context) SetPath(p string) {
 	ctx.path = p
@@ -119,38 +245,136 @@ func (ctx *Context) Query() url.Values {
 	return ctx.query
 }
 
+// SetResponseMetadata sets the metadata of the response with given key, e.g. by a PreWriteResponse plugin.
+// The client selectors are told the metadata of the responses (see client.SelectorMetadataFeedback).
+func (ctx *Context) SetResponseMetadata(key, value string) {
+	ctx.Lock()
+	defer ctx.Unlock()
+	if ctx.respMetadata == nil {
+		ctx.respMetadata = make(url.Values)
+	}
+	ctx.respMetadata.Set(key, value)
+}
+
+// ResponseMetadata returns the metadata of the response set by SetResponseMetadata.
+func (ctx *Context) ResponseMetadata() url.Values {
+	ctx.RLock()
+	defer ctx.RUnlock()
+	return ctx.respMetadata
+}
+
+// SetTrailer sets the trailer of the response with given key, the metadata known after the service
+// produces the reply, e.g. whether the reply is cached or the cost of the call. The trailers are carried
+// by the header of the final response, after the chunks of a streamed reply, not after the reply body
+// (see common.TrailerKey), so they must be set before the response is written. See client.CallWithTrailers.
+func (ctx *Context) SetTrailer(key, value string) {
+	ctx.Lock()
+	defer ctx.Unlock()
+	if ctx.trailers == nil {
+		ctx.trailers = make(url.Values)
+	}
+	ctx.trailers.Set(key, value)
+}
+
+// Trailers returns the trailers set by SetTrailer.
+func (ctx *Context) Trailers() url.Values {
+	ctx.RLock()
+	defer ctx.RUnlock()
+	return ctx.trailers
+}
+
+// appendMetadata appends the metadata to the serviceMethod.
+func appendMetadata(serviceMethod string, metadata url.Values) string {
+	sep := "?"
+	if strings.Contains(serviceMethod, "?") {
+		sep = "&"
+	}
+	return serviceMethod + sep + metadata.Encode()
+}
+
+// advertise appends the capabilities to the serviceMethod.
+func advertise(serviceMethod string, caps common.Capabilities) string {
+	return appendMetadata(serviceMethod, url.Values{common.CapabilitiesKey: {caps.String()}})
+}
+
 func (ctx *Context) readRequestHeader() (keepReading bool, notSend bool, err error) {
 	// set timeout
+	var deadline time.Time // of reading the header
 	if ctx.server.Timeout > 0 {
-		ctx.codecConn.SetDeadline(time.Now().Add(ctx.server.Timeout))
+		deadline = time.Now().Add(ctx.server.Timeout)
+		ctx.codecConn.SetDeadline(deadline)
 	}
 	if ctx.server.ReadTimeout > 0 {
-		ctx.codecConn.SetReadDeadline(time.Now().Add(ctx.server.ReadTimeout))
+		deadline = time.Now().Add(ctx.server.ReadTimeout)
+		ctx.codecConn.SetReadDeadline(deadline)
+	}
+	if ctx.server.IdleTimeout > 0 {
+		// wait for the next request no longer than IdleTimeout.
+		deadline = time.Now().Add(ctx.server.IdleTimeout)
+		ctx.codecConn.SetReadDeadline(deadline)
+	}
+	if ctx.first && ctx.server.HeaderTimeout > 0 {
+		// the first request header must arrive within HeaderTimeout.
+		ctx.codecConn.SetReadDeadline(time.Now().Add(ctx.server.HeaderTimeout))
+	} else if ctx.header != nil {
+		// a later one within HeaderTimeout from its first byte.
+		ctx.header.arm(deadline)
 	}
 
 	// pre
 	err = ctx.server.PluginContainer.doPreReadRequestHeader(ctx)
 	if err != nil {
-		ctx.rpcErrorType = common.ErrorTypeServerPreReadRequestHeader
+		ctx.pluginFailed(common.ErrorTypeServerPreReadRequestHeader)
 		return
 	}
 
 	// decode request header
 	err = ctx.codecConn.ReadRequestHeader(ctx.req)
+	started := ctx.first
+	if ctx.header != nil && ctx.header.disarm() {
+		started = true
+	}
 	if err != nil {
 		ctx.rpcErrorType = common.ErrorTypeServerReadRequestHeader
 		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			notSend = true
 			return
 		}
+		if e, ok := err.(net.Error); ok && e.Timeout() && ctx.server.IdleTimeout > 0 && !started {
+			ctx.idle = true
+		}
 		err = common.NewError("ReadRequestHeader: " + err.Error())
 		return
 	}
 
+	if ctx.server.IdleTimeout > 0 || ctx.server.HeaderTimeout > 0 {
+		// restore the read deadline for the request body.
+		var deadline time.Time
+		if ctx.server.ReadTimeout > 0 {
+			deadline = time.Now().Add(ctx.server.ReadTimeout)
+		} else if ctx.server.Timeout > 0 {
+			deadline = time.Now().Add(ctx.server.Timeout)
+		}
+		ctx.codecConn.SetReadDeadline(deadline)
+	}
+
 	// We read the header successfully. If we see an error now,
 	// we can still recover and move on to the next request.
 	keepReading = true
 
+	// a control frame is handled here, before the plugins and the limits of the calls.
+	if seq, ok := common.ParseCancelStream(ctx.req.ServiceMethod); ok {
+		ctx.control, notSend = true, true
+		if err = ctx.codecConn.ReadRequestBody(nil); err != nil {
+			ctx.rpcErrorType = common.ErrorTypeServerReadRequestBody
+			return
+		}
+		if ctx.streams != nil {
+			ctx.streams.cancel(seq)
+		}
+		return
+	}
+
 	// parse serviceMethod
 	ctx.path, ctx.query, err = ctx.server.ServiceBuilder.URIParse(ctx.req.ServiceMethod)
 	if err != nil {
@@ -158,18 +382,65 @@ func (ctx *Context) readRequestHeader() (keepReading bool, notSend bool, err err
 		err = common.NewError(err.Error())
 		return
 	}
+	if key := common.ReservedKey(ctx.req.ServiceMethod); key != "" {
+		// the error response must not echo the key, or the client takes it for an interim response.
+		ctx.req.ServiceMethod = ctx.path
+		ctx.rpcErrorType = common.ErrorTypeServerInvalidServiceMethod
+		err = common.NewError("reserved metadata key '" + key + "'")
+		return
+	}
+	if caps, ok := ctx.query[common.CapabilitiesKey]; ok {
+		ctx.codecConn.SetCapabilities(common.ParseCapabilities(strings.Join(caps, ",")))
+		ctx.query.Del(common.CapabilitiesKey)
+		ctx.advertise = true
+	}
+	if p, ok := ctx.query[common.PriorityKey]; ok {
+		ctx.priority = common.ParsePriority(p[0])
+		ctx.query.Del(common.PriorityKey)
+	}
+	if rid := ctx.query.Get(common.RequestIDKey); rid != "" {
+		ctx.requestID = rid
+		ctx.query.Del(common.RequestIDKey)
+	}
+	hops := common.ParseHops(ctx.query.Get(common.HopsKey))
+	ctx.query.Del(common.HopsKey)
+	ctx.Lock()
+	ctx.values = common.WithHops(ctx.values, hops)
+	ctx.Unlock()
+	if ctx.server.MaxHops > 0 && hops > ctx.server.MaxHops {
+		ctx.rpcErrorType = common.ErrorTypeServerTooManyHops
+		err = common.NewError("too many hops: " + strconv.Itoa(hops) + " > " + strconv.Itoa(ctx.server.MaxHops))
+		return
+	}
+	ctx.originalPath = ctx.path
+	if ctx.server.PathRewriter != nil {
+		ctx.path = ctx.server.PathRewriter(ctx.path)
+	}
 
 	// post
 	err = ctx.server.PluginContainer.doPostReadRequestHeader(ctx)
 	if err != nil {
-		ctx.rpcErrorType = common.ErrorTypeServerPostReadRequestHeader
+		ctx.pluginFailed(common.ErrorTypeServerPostReadRequestHeader)
 		return
 	}
 
 	// get service
 	ctx.server.mu.RLock()
 	ctx.service = ctx.server.serviceMap[ctx.path]
+	ctx.calls = ctx.server.callCounters[ctx.path]
 	ctx.server.mu.RUnlock()
+	if ctx.service == nil && ctx.path == common.HeartbeatPath {
+		if ctx.server.isDraining() {
+			// the health checks see the draining server not serving.
+			ctx.rpcErrorType = common.ErrorTypeServerDraining
+			err = common.NewError(drainingMsg)
+			return
+		}
+		ctx.service = heartbeatService
+	}
+	if ctx.service == nil && ctx.server.NotFoundHandler != nil {
+		ctx.service = newNotFoundService(ctx.server.NotFoundHandler)
+	}
 	if ctx.service == nil {
 		ctx.rpcErrorType = common.ErrorTypeServerNotFoundService
 		err = common.NewError("can't find service '" + ctx.path + "'")
@@ -186,7 +457,7 @@ func (ctx *Context) readRequestBody(body interface{}) error {
 		err = ctx.service.GetPluginContainer().doPreReadRequestBody(ctx, body)
 	}
 	if err != nil {
-		ctx.rpcErrorType = common.ErrorTypeServerPreReadRequestBody
+		ctx.pluginFailed(common.ErrorTypeServerPreReadRequestBody)
 		return err
 	}
 
@@ -204,11 +475,56 @@ func (ctx *Context) readRequestBody(body interface{}) error {
 		err = ctx.server.PluginContainer.doPostReadRequestBody(ctx, body)
 	}
 	if err != nil {
-		ctx.rpcErrorType = common.ErrorTypeServerPostReadRequestBody
+		ctx.pluginFailed(common.ErrorTypeServerPostReadRequestBody)
 	}
 	return err
 }
 
+// decodeArg reads the request body as []byte and decodes the argument by the ArgDecoder.
+func (ctx *Context) decodeArg(d ArgDecoder, argType reflect.Type) error {
+	var body []byte
+	err := ctx.readRequestBody(&body)
+	if err != nil {
+		return err
+	}
+	arg, err := d.DecodeArg(ctx.path, body)
+	if err != nil {
+		ctx.rpcErrorType = common.ErrorTypeServerReadRequestBody
+		return common.NewError("DecodeArg: " + err.Error())
+	}
+	argv := reflect.ValueOf(arg)
+	if !argv.IsValid() || !argv.Type().AssignableTo(argType) {
+		ctx.rpcErrorType = common.ErrorTypeServerReadRequestBody
+		return common.NewError(fmt.Sprintf("DecodeArg: %T is not assignable to %s", arg, argType))
+	}
+	ctx.argv = argv
+	return nil
+}
+
+func (ctx *Context) interceptArg() error {
+	err := ctx.server.PluginContainer.doInterceptArg(ctx, ctx.argv)
+	if err == nil {
+		err = ctx.service.GetPluginContainer().doInterceptArg(ctx, ctx.argv)
+	}
+	if err != nil {
+		ctx.pluginFailed(common.ErrorTypeServerInterceptArg)
+	}
+	return err
+}
+
+// SetErrorType sets the type of the error replied when the running plugin hook reading the request
+// fails the call, e.g. common.ErrorTypeServerForbidden, instead of the type of the hook.
+func (ctx *Context) SetErrorType(errorType common.ErrorType) {
+	ctx.rpcErrorType = errorType
+}
+
+// pluginFailed sets the type of the error of the failed plugin hook, unless the plugin has set one.
+func (ctx *Context) pluginFailed(hookType common.ErrorType) {
+	if ctx.rpcErrorType == common.ErrorTypeUnknown {
+		ctx.rpcErrorType = hookType
+	}
+}
+
 // writeResponse must be safe for concurrent use by multiple goroutines.
 func (ctx *Context) writeResponse(body interface{}) error {
 	// set timeout
@@ -232,15 +548,35 @@ func (ctx *Context) writeResponse(body interface{}) error {
 		body = nil
 	}
 
+	if trailers := ctx.Trailers(); len(trailers) > 0 {
+		ctx.SetResponseMetadata(common.TrailerKey, common.EncodeTrailers(trailers))
+	}
+	if metadata := ctx.ResponseMetadata(); len(metadata) > 0 {
+		ctx.resp.ServiceMethod = appendMetadata(ctx.resp.ServiceMethod, metadata)
+	}
+
 	// decode request header
 	if len(ctx.resp.Error) > 0 {
-		ctx.resp.Error = string(ctx.rpcErrorType) + ctx.resp.Error
+		ctx.resp.Error = string(rune(ctx.rpcErrorType)) + ctx.resp.Error
 	}
+	ctx.limitResponse(body)
 	err = ctx.codecConn.WriteResponse(ctx.resp, body)
 	if err != nil {
-		ctx.rpcErrorType = common.ErrorTypeServerWriteResponse
-		ctx.resp.Error = string(ctx.rpcErrorType) + err.Error()
-		ctx.codecConn.WriteResponse(ctx.resp, invalidRequest)
+		if e, ok := err.(*common.EncodeError); ok {
+			// the error response is written in place of the reply that fails to encode.
+			ctx.rpcErrorType = common.ErrorTypeServerEncodeResponse
+			ctx.resp.Error = err.Error()
+			if tooLarge, ok := e.Err.(*common.TooLargeError); ok {
+				ctx.rpcErrorType = common.ErrorTypeServerCallTooLarge
+				ctx.resp.Error = callTooLarge(ctx.requestBytes+tooLarge.Size, ctx.server.MaxCallBytes).Error()
+			}
+			ctx.resp.Error = string(rune(ctx.rpcErrorType)) + ctx.resp.Error
+			ctx.limitResponse(invalidRequest)
+			ctx.codecConn.WriteResponse(ctx.resp, invalidRequest)
+		} else {
+			ctx.rpcErrorType = common.ErrorTypeServerWriteResponse
+			ctx.breakConn()
+		}
 		return common.NewError("WriteResponse: " + err.Error())
 	}
 
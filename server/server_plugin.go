@@ -1,6 +1,8 @@
 package server
 
 import (
+	"reflect"
+
 	"github.com/henrylee2cn/myrpc/common"
 	"github.com/henrylee2cn/myrpc/plugin"
 )
@@ -42,6 +44,11 @@ type (
 		PostReadRequestBody(ctx *Context, body interface{}) error
 	}
 
+	//IInterceptArgPlugin can modify the decoded argument before the service is called.
+	IInterceptArgPlugin interface {
+		InterceptArg(ctx *Context, argv reflect.Value) error
+	}
+
 	//IPreWriteResponsePlugin means as its name.
 	IPreWriteResponsePlugin interface {
 		PreWriteResponse(ctx *Context, body interface{}) error
@@ -59,7 +66,7 @@ type (
 
 		doRegister(nodePath string, rcvr interface{}, metadata ...string) error
 
-		doPostConnAccept(ServerCodecConn) error
+		doPostConnAccept(ServerCodecConn, PluginPanicPolicy) error
 
 		doPreReadRequestHeader(*Context) error
 		doPostReadRequestHeader(*Context) error
@@ -67,6 +74,8 @@ type (
 		doPreReadRequestBody(ctx *Context, body interface{}) error
 		doPostReadRequestBody(ctx *Context, body interface{}) error
 
+		doInterceptArg(ctx *Context, argv reflect.Value) error
+
 		doPreWriteResponse(ctx *Context, body interface{}) error
 		doPostWriteResponse(ctx *Context, body interface{}) error
 	}
@@ -79,16 +88,40 @@ type ServerPluginContainer struct {
 
 var _ IServerPluginContainer = new(ServerPluginContainer)
 
+// runHooks calls the hook with every plugin until one fails, and returns the error formatted
+// by errFormat with the name of the plugin. The hook does nothing for the plugins not implementing it.
+// The panic of a hook is recovered per the policy, not at all by PluginPanicNone.
+func (p *ServerPluginContainer) runHooks(policy PluginPanicPolicy, errFormat *common.Error, hook func(IPlugin) error) error {
+	for i := range p.Plugins {
+		var err error
+		if policy == PluginPanicNone {
+			err = hook(p.Plugins[i])
+		} else {
+			err = recoverHook(p.Plugins[i], hook)
+		}
+		if err != nil {
+			if policy.skipPanicked(err) {
+				continue
+			}
+			return errFormat.Format(p.Plugins[i].Name(), err.Error())
+		}
+	}
+	return nil
+}
+
 // doRegister invokes doRegister plugin.
+// The panic of a plugin fails the registration, whatever the PluginPanicPolicy.
 func (p *ServerPluginContainer) doRegister(nodePath string, rcvr interface{}, metadata ...string) error {
 	var errors []error
 	for i := range p.Plugins {
-
-		if plugin, ok := p.Plugins[i].(IRegisterPlugin); ok {
-			err := plugin.Register(nodePath, rcvr, metadata...)
-			if err != nil {
-				errors = append(errors, common.ErrRegisterPlugin.Format(p.Plugins[i].Name(), err.Error()))
+		err := recoverHook(p.Plugins[i], func(plugin IPlugin) error {
+			if plugin, ok := plugin.(IRegisterPlugin); ok {
+				return plugin.Register(nodePath, rcvr, metadata...)
 			}
+			return nil
+		})
+		if err != nil {
+			errors = append(errors, common.ErrRegisterPlugin.Format(p.Plugins[i].Name(), err.Error()))
 		}
 	}
 
@@ -99,100 +132,85 @@ func (p *ServerPluginContainer) doRegister(nodePath string, rcvr interface{}, me
 }
 
 //doPostConnAccept handles accepted conn
-func (p *ServerPluginContainer) doPostConnAccept(conn ServerCodecConn) error {
-	var err error
-	for i := range p.Plugins {
-		if plugin, ok := p.Plugins[i].(IPostConnAcceptPlugin); ok {
-			err = plugin.PostConnAccept(conn)
-			if err != nil { //interrupt
-				conn.Close()
-				return common.ErrPostConnAccept.Format(p.Plugins[i].Name(), err.Error())
-			}
+func (p *ServerPluginContainer) doPostConnAccept(conn ServerCodecConn, policy PluginPanicPolicy) error {
+	err := p.runHooks(policy, common.ErrPostConnAccept, func(plugin IPlugin) error {
+		if plugin, ok := plugin.(IPostConnAcceptPlugin); ok {
+			return plugin.PostConnAccept(conn)
 		}
+		return nil
+	})
+	if err != nil { //interrupt
+		conn.Close()
 	}
-	return nil
+	return err
 }
 
 // doPreReadRequestHeader invokes doPreReadRequestHeader plugin.
 func (p *ServerPluginContainer) doPreReadRequestHeader(ctx *Context) error {
-	for i := range p.Plugins {
-		if plugin, ok := p.Plugins[i].(IPreReadRequestHeaderPlugin); ok {
-			err := plugin.PreReadRequestHeader(ctx)
-			if err != nil {
-				return common.ErrPreReadRequestHeader.Format(p.Plugins[i].Name(), err.Error())
-			}
+	return p.runHooks(ctx.server.PluginPanicPolicy, common.ErrPreReadRequestHeader, func(plugin IPlugin) error {
+		if plugin, ok := plugin.(IPreReadRequestHeaderPlugin); ok {
+			return plugin.PreReadRequestHeader(ctx)
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
 // doPostReadRequestHeader invokes doPostReadRequestHeader plugin.
 func (p *ServerPluginContainer) doPostReadRequestHeader(ctx *Context) error {
-	for i := range p.Plugins {
-		if plugin, ok := p.Plugins[i].(IPostReadRequestHeaderPlugin); ok {
-			err := plugin.PostReadRequestHeader(ctx)
-			if err != nil {
-				return common.ErrPostReadRequestHeader.Format(p.Plugins[i].Name(), err.Error())
-			}
+	return p.runHooks(ctx.server.PluginPanicPolicy, common.ErrPostReadRequestHeader, func(plugin IPlugin) error {
+		if plugin, ok := plugin.(IPostReadRequestHeaderPlugin); ok {
+			return plugin.PostReadRequestHeader(ctx)
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
 // doPreReadRequestBody invokes doPreReadRequestBody plugin.
 func (p *ServerPluginContainer) doPreReadRequestBody(ctx *Context, body interface{}) error {
-	for i := range p.Plugins {
-		if plugin, ok := p.Plugins[i].(IPreReadRequestBodyPlugin); ok {
-			err := plugin.PreReadRequestBody(ctx, body)
-			if err != nil {
-				return common.ErrPreReadRequestBody.Format(p.Plugins[i].Name(), err.Error())
-			}
+	return p.runHooks(ctx.server.PluginPanicPolicy, common.ErrPreReadRequestBody, func(plugin IPlugin) error {
+		if plugin, ok := plugin.(IPreReadRequestBodyPlugin); ok {
+			return plugin.PreReadRequestBody(ctx, body)
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
 // doPostReadRequestBody invokes doPostReadRequestBody plugin.
 func (p *ServerPluginContainer) doPostReadRequestBody(ctx *Context, body interface{}) error {
-	for i := range p.Plugins {
-		if plugin, ok := p.Plugins[i].(IPostReadRequestBodyPlugin); ok {
-			err := plugin.PostReadRequestBody(ctx, body)
-			if err != nil {
-				return common.ErrPostReadRequestBody.Format(p.Plugins[i].Name(), err.Error())
-			}
+	return p.runHooks(ctx.server.PluginPanicPolicy, common.ErrPostReadRequestBody, func(plugin IPlugin) error {
+		if plugin, ok := plugin.(IPostReadRequestBodyPlugin); ok {
+			return plugin.PostReadRequestBody(ctx, body)
 		}
-	}
+		return nil
+	})
+}
 
-	return nil
+// doInterceptArg invokes doInterceptArg plugin.
+func (p *ServerPluginContainer) doInterceptArg(ctx *Context, argv reflect.Value) error {
+	return p.runHooks(ctx.server.PluginPanicPolicy, common.ErrInterceptArg, func(plugin IPlugin) error {
+		if plugin, ok := plugin.(IInterceptArgPlugin); ok {
+			return plugin.InterceptArg(ctx, argv)
+		}
+		return nil
+	})
 }
 
 // doPreWriteResponse invokes doPreWriteResponse plugin.
 func (p *ServerPluginContainer) doPreWriteResponse(ctx *Context, body interface{}) error {
-	for i := range p.Plugins {
-		if plugin, ok := p.Plugins[i].(IPreWriteResponsePlugin); ok {
-			err := plugin.PreWriteResponse(ctx, body)
-			if err != nil {
-				return common.ErrPreWriteResponse.Format(p.Plugins[i].Name(), err.Error())
-			}
+	return p.runHooks(ctx.server.PluginPanicPolicy, common.ErrPreWriteResponse, func(plugin IPlugin) error {
+		if plugin, ok := plugin.(IPreWriteResponsePlugin); ok {
+			return plugin.PreWriteResponse(ctx, body)
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
 // doPostWriteResponse invokes doPostWriteResponse plugin.
 func (p *ServerPluginContainer) doPostWriteResponse(ctx *Context, body interface{}) error {
-	for i := range p.Plugins {
-		if plugin, ok := p.Plugins[i].(IPostWriteResponsePlugin); ok {
-			err := plugin.PostWriteResponse(ctx, body)
-			if err != nil {
-				return common.ErrPostWriteResponse.Format(p.Plugins[i].Name(), err.Error())
-			}
+	return p.runHooks(ctx.server.PluginPanicPolicy, common.ErrPostWriteResponse, func(plugin IPlugin) error {
+		if plugin, ok := plugin.(IPostWriteResponsePlugin); ok {
+			return plugin.PostWriteResponse(ctx, body)
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
@@ -0,0 +1,237 @@
+package server_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/log/logtest"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type TenantArgs struct {
+	Tenant string
+	Name   string
+}
+
+type tenantWorker struct{}
+
+func (*tenantWorker) Hello(args *TenantArgs, reply *string) error {
+	*reply = args.Tenant + "/" + args.Name
+	return nil
+}
+
+type tenantPlugin struct{}
+
+func (*tenantPlugin) Name() string {
+	return "tenant_plugin"
+}
+
+func (*tenantPlugin) InterceptArg(ctx *server.Context, argv reflect.Value) error {
+	field := reflect.Indirect(argv).FieldByName("Tenant")
+	if field.IsValid() {
+		field.SetString(ctx.Query().Get("tenant"))
+	}
+	return nil
+}
+
+func TestInterceptArgPlugin(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.PluginContainer.Add(new(tenantPlugin))
+	srv.NamedRegister("worker", new(tenantWorker))
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/worker/hello?tenant=acme", &TenantArgs{Name: "henry"}, &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	if reply != "acme/henry" {
+		t.Fatalf("expect %q, got %q", "acme/henry", reply)
+	}
+}
+
+type identityPlugin struct{}
+
+func (*identityPlugin) Name() string {
+	return "identity_plugin"
+}
+
+func (*identityPlugin) PostConnAccept(conn server.ServerCodecConn) error {
+	conn.SetValue("identity", "conn-"+conn.RemoteAddr().String())
+	return nil
+}
+
+type identityWorker struct{}
+
+func (*identityWorker) Whoami(ctx *server.Context, _ string, reply *string) error {
+	*reply, _ = ctx.Conn().GetValue("identity").(string)
+	return nil
+}
+
+func TestConnValue(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.PluginContainer.Add(new(identityPlugin))
+	srv.NamedRegister("worker", new(identityWorker))
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	var first, second string
+	if e := c.Call("/worker/whoami", "", &first); e != nil {
+		t.Fatal(e.Error)
+	}
+	if e := c.Call("/worker/whoami", "", &second); e != nil {
+		t.Fatal(e.Error)
+	}
+	if first == "" || first != second {
+		t.Fatalf("unexpected identities: %q, %q", first, second)
+	}
+}
+
+// shardPlugin labels the connections with a shard, e.g. decided from the TLS identity.
+type shardPlugin struct{ shard string }
+
+func (*shardPlugin) Name() string {
+	return "shard_plugin"
+}
+
+func (p *shardPlugin) PostConnAccept(conn server.ServerCodecConn) error {
+	server.SetShard(conn, p.shard)
+	return nil
+}
+
+type shardWorker struct{}
+
+func (*shardWorker) Shard(ctx *server.Context, _ string, reply *string) error {
+	*reply = ctx.Shard()
+	return nil
+}
+
+func TestShard(t *testing.T) {
+	srv := server.NewServer(server.Server{Shard: "shard-1"})
+	srv.PluginContainer.Add(&shardPlugin{shard: "shard-1"})
+	srv.NamedRegister("worker", new(shardWorker))
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+	var reply string
+	if e := c.Call("/worker/shard", "", &reply); e != nil || reply != "shard-1" {
+		t.Fatalf("shard: reply=%q, err=%v", reply, e)
+	}
+
+	for _, shard := range []string{"shard-2", ""} {
+		srv := server.NewServer(server.Server{Shard: "shard-1"})
+		srv.PluginContainer.Add(&shardPlugin{shard: shard})
+		srv.NamedRegister("worker", new(shardWorker))
+		c := newClient(client.Client{MaxTry: 1}, serve(t, srv))
+		e := c.Call("/worker/shard", "", &reply)
+		if e == nil || e.Type != common.ErrorTypeServerWrongShard || !strings.Contains(e.Error, "shard-1") {
+			t.Fatalf("shard %q: expect the wrong shard error, got: %v", shard, e)
+		}
+		c.Close()
+	}
+}
+
+// panicPlugin panics in PostConnAccept if accept is set,
+// and in PostReadRequestHeader of the requests asking it.
+type panicPlugin struct{ accept bool }
+
+func (*panicPlugin) Name() string {
+	return "panic_plugin"
+}
+
+func (p *panicPlugin) PostConnAccept(conn server.ServerCodecConn) error {
+	if p.accept {
+		panic("accept bug")
+	}
+	return nil
+}
+
+func (*panicPlugin) PostReadRequestHeader(ctx *server.Context) error {
+	if ctx.Query().Get("panic") != "" {
+		panic("header bug")
+	}
+	return nil
+}
+
+func TestPluginPanicPolicy(t *testing.T) {
+	logs, restore := logtest.Capture()
+	defer restore()
+
+	srv := server.NewServer(server.Server{PluginPanicPolicy: server.PluginPanicFail})
+	srv.PluginContainer.Add(new(panicPlugin), new(identityPlugin))
+	srv.NamedRegister("worker", new(identityWorker))
+	c := newClient(client.Client{MaxTry: 1}, serve(t, srv))
+	defer c.Close()
+	var reply string
+	e := c.Call("/worker/whoami?panic=1", "", &reply)
+	if e == nil || e.Type != common.ErrorTypeServerPostReadRequestHeader || !strings.Contains(e.Error, "panic: header bug") {
+		t.Fatalf("fail: expect the hook error, got: %v", e)
+	}
+	if !strings.Contains(logs.String(), "plugin panic_plugin: header bug") {
+		t.Fatalf("the panic isn't logged:\n%s", logs.String())
+	}
+	if e := c.Call("/worker/whoami", "", &reply); e != nil || reply == "" {
+		t.Fatalf("after the panic: reply=%q, err=%v", reply, e)
+	}
+
+	// the panicking plugin is skipped, the others still run.
+	srv = server.NewServer(server.Server{PluginPanicPolicy: server.PluginPanicSkip})
+	srv.PluginContainer.Add(&panicPlugin{accept: true}, new(identityPlugin))
+	srv.NamedRegister("worker", new(identityWorker))
+	c2 := newClient(client.Client{MaxTry: 1}, serve(t, srv))
+	defer c2.Close()
+	if e := c2.Call("/worker/whoami?panic=1", "", &reply); e != nil || reply == "" {
+		t.Fatalf("skip: reply=%q, err=%v", reply, e)
+	}
+	if !strings.Contains(logs.String(), "plugin panic_plugin: accept bug") {
+		t.Fatalf("the accept panic isn't logged:\n%s", logs.String())
+	}
+}
+
+type (
+	userKey struct{}
+	user    struct {
+		Name string
+	}
+	userPlugin struct{}
+	userWorker struct{}
+)
+
+func (*userPlugin) Name() string {
+	return "user_plugin"
+}
+
+func (*userPlugin) PostReadRequestHeader(ctx *server.Context) error {
+	ctx.WithValue(userKey{}, &user{Name: ctx.Query().Get("user")})
+	return nil
+}
+
+func (*userWorker) Whoami(ctx *server.Context, _ string, reply *string) error {
+	u, ok := ctx.Value(userKey{}).(*user)
+	if !ok {
+		return errors.New("no user")
+	}
+	*reply = u.Name
+	return nil
+}
+
+func TestContextValue(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.PluginContainer.Add(new(userPlugin))
+	srv.NamedRegister("worker", new(userWorker))
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	for _, name := range []string{"alice", "bob"} {
+		var reply string
+		if e := c.Call("/worker/whoami?user="+name, "", &reply); e != nil {
+			t.Fatal(e.Error)
+		}
+		if reply != name {
+			t.Fatalf("expect %q, got %q", name, reply)
+		}
+	}
+}
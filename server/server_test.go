@@ -0,0 +1,2010 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/codec/colfer"
+	codecGob "github.com/henrylee2cn/myrpc/codec/gob"
+	"github.com/henrylee2cn/myrpc/codec/jsonrpc"
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/log/logtest"
+	"github.com/henrylee2cn/myrpc/plugin/compression"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+type worker struct {
+	name string
+}
+
+func (w *worker) Name(arg string, reply *string) error {
+	*reply = w.name + ": " + arg
+	return nil
+}
+
+func (*worker) Sleep(arg time.Duration, reply *string) error {
+	time.Sleep(arg)
+	*reply = "OK"
+	return nil
+}
+
+// listen returns a listener on a random local port.
+func listen(t *testing.T) net.Listener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lis
+}
+
+// serve serves srv on a random local port and returns the address.
+func serve(t *testing.T, srv *server.Server) string {
+	lis := listen(t)
+	go srv.ServeListener(lis)
+	return lis.Addr().String()
+}
+
+func newClient(c client.Client, addr string) *client.Client {
+	return client.NewClient(c, &selector.DirectSelector{
+		Network: "tcp",
+		Address: addr,
+	})
+}
+
+func TestIdleTimeout(t *testing.T) {
+	srv := server.NewServer(server.Server{IdleTimeout: 100 * time.Millisecond})
+	srv.NamedRegister("worker", new(worker))
+	conn, err := net.Dial("tcp", serve(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		t.Fatal("idle connection is not closed by server")
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("idle connection is closed too early: %v", d)
+	}
+}
+
+func TestHTTPContentType(t *testing.T) {
+	pipe, _ := net.Pipe()
+	defer pipe.Close()
+	if ct := server.ContentType(jsonrpc.NewJSONRPCServerCodec(pipe)); ct != "application/json" {
+		t.Fatalf("JSON codec reports content type %q", ct)
+	}
+
+	srv := server.NewServer(server.Server{ServerCodecFunc: jsonrpc.NewJSONRPCServerCodec})
+	srv.NamedRegister("worker", new(worker))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("HTTP response carries content type %q", ct)
+	}
+}
+
+func TestDisableHTTP(t *testing.T) {
+	srv := server.NewServer(server.Server{DisableHTTP: true})
+	srv.NamedRegister("worker", new(worker))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("CONNECT is answered with %s", resp.Status)
+	}
+
+	srv.HandleHTTP("/disabled_rpc")
+	req, _ := http.NewRequest("CONNECT", "/disabled_rpc", nil)
+	if _, pattern := http.DefaultServeMux.Handler(req); pattern != "" {
+		t.Fatalf("HandleHTTP registered %q", pattern)
+	}
+}
+
+func TestHeaderTimeout(t *testing.T) {
+	srv := server.NewServer(server.Server{HeaderTimeout: 100 * time.Millisecond})
+	srv.NamedRegister("worker", new(worker))
+	addr := serve(t, srv)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// send one byte of the header, then stall.
+	conn.Write([]byte{0x20})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		t.Fatal("stalled connection is not closed by server")
+	}
+
+	// a later header is bounded from its first byte, while the idle connection is kept.
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	codec := codecGob.NewGobClientCodec(conn)
+	call := func(seq uint64) {
+		var resp rpc.Response
+		var reply string
+		if err := codec.WriteRequest(&rpc.Request{ServiceMethod: "/worker/name", Seq: seq}, "x"); err != nil {
+			t.Fatal(err)
+		}
+		if err := codec.ReadResponseHeader(&resp); err != nil || resp.Error != "" {
+			t.Fatalf("call %d: %v %s", seq, err, resp.Error)
+		}
+		if err := codec.ReadResponseBody(&reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	call(1)
+	time.Sleep(200 * time.Millisecond) // idle beyond the HeaderTimeout
+	call(2)
+	conn.Write([]byte{0x20})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		t.Fatal("the stalled later header is not cut by the server")
+	}
+}
+
+func TestServeFD(t *testing.T) {
+	// the IPv4 listener is served as "tcp" and as "tcp4" alike.
+	for _, network := range []string{"tcp", "tcp4"} {
+		lis := listen(t)
+		f, err := lis.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		addr := lis.Addr().String()
+		lis.Close()
+
+		srv := server.NewServer(server.Server{})
+		srv.NamedRegister("worker", &worker{name: "fd"})
+		go srv.ServeFD(f.Fd(), network)
+
+		c := newClient(client.Client{}, addr)
+		defer c.Close()
+		var reply string
+		if e := c.Call("/worker/name", "hello", &reply); e != nil {
+			t.Fatalf("%s: %s", network, e.Error)
+		}
+		if reply != "fd: hello" {
+			t.Fatalf("%s: reply: %q", network, reply)
+		}
+	}
+}
+
+func TestGroupTimeout(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	fast := srv.Group("fast")
+	fast.SetTimeout(100 * time.Millisecond)
+	fast.NamedRegister("worker", new(worker))
+	admin := srv.Group("admin")
+	admin.SetTimeout(time.Second)
+	admin.NamedRegister("worker", new(worker))
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	var reply string
+	e := c.Call("/fast/worker/sleep", 300*time.Millisecond, &reply)
+	if e == nil || e.Type != common.ErrorTypeServerTimeout {
+		t.Fatalf("fast group: expect timeout error, got: %v", e)
+	}
+	if e = c.Call("/admin/worker/sleep", 300*time.Millisecond, &reply); e != nil || reply != "OK" {
+		t.Fatalf("admin group: reply=%q, err=%v", reply, e)
+	}
+
+	// override per method.
+	srv.SetCallTimeout("/fast/worker/sleep", time.Second)
+	reply = ""
+	if e = c.Call("/fast/worker/sleep", 300*time.Millisecond, &reply); e != nil || reply != "OK" {
+		t.Fatalf("overridden method: reply=%q, err=%v", reply, e)
+	}
+}
+
+func TestDraining(t *testing.T) {
+	w := &gatedWorker{started: make(chan string, 1), gate: make(chan struct{})}
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("queue", w)
+	addr := serve(t, srv)
+	c1 := newClient(client.Client{}, addr)
+	defer c1.Close()
+	var reply string
+	if e := c1.Call("/queue/work", "free", &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+
+	done := make(chan *common.RPCError, 1)
+	go func() {
+		var reply string
+		done <- c1.Call("/queue/work", "in-flight", &reply)
+	}()
+	<-w.started
+	srv.SetDraining(true)
+
+	c2 := newClient(client.Client{}, addr)
+	defer c2.Close()
+	start := time.Now()
+	e := c2.Call("/queue/work", "free", &reply)
+	if e == nil || e.Type != common.ErrorTypeServerDraining {
+		t.Fatalf("new connection: expect draining error, got: %v", e)
+	}
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Fatalf("new connection is rejected after %v", d)
+	}
+	close(w.gate)
+	if e = <-done; e != nil {
+		t.Fatalf("in-flight call failed: %v", e)
+	}
+
+	srv.SetDraining(false)
+	if e = c2.Call("/queue/work", "free", &reply); e != nil {
+		t.Fatalf("after draining: %v", e)
+	}
+}
+
+type streamWorker struct{}
+
+func (*streamWorker) Stream(ctx *server.Context, arg string, reply *string) error {
+	if !ctx.Conn().Supports(common.FeatureStream) {
+		return errors.New("streaming is unsupported by the client")
+	}
+	*reply = "streaming " + arg
+	return nil
+}
+
+// connPlugin records the client connection.
+type connPlugin struct {
+	conn client.ClientCodecConn
+}
+
+func (*connPlugin) Name() string {
+	return "conn_plugin"
+}
+
+func (p *connPlugin) PostConnected(conn client.ClientCodecConn) error {
+	p.conn = conn
+	return nil
+}
+
+func TestCapabilities(t *testing.T) {
+	srv := server.NewServer(server.Server{
+		Capabilities: []string{common.FeatureStream, common.FeatureSnappy},
+	})
+	srv.NamedRegister("worker", new(streamWorker))
+	addr := serve(t, srv)
+
+	c1 := newClient(client.Client{Capabilities: []string{common.FeatureSnappy}}, addr)
+	defer c1.Close()
+	var reply string
+	e := c1.Call("/worker/stream", "x", &reply)
+	if e == nil || !strings.Contains(e.Error, "unsupported") {
+		t.Fatalf("expect unsupported error, got: %v", e)
+	}
+
+	p := new(connPlugin)
+	c2 := newClient(client.Client{
+		PluginContainer: new(client.ClientPluginContainer),
+		Capabilities:    []string{common.FeatureStream},
+	}, addr)
+	defer c2.Close()
+	c2.PluginContainer.Add(p)
+	if e = c2.Call("/worker/stream", "x", &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	if reply != "streaming x" {
+		t.Fatalf("reply: %q", reply)
+	}
+	if !p.conn.Supports(common.FeatureStream) || !p.conn.Supports(common.FeatureSnappy) || p.conn.Supports(common.FeatureLZ4) {
+		t.Fatal("server capabilities are not received")
+	}
+}
+
+// Point has a private layout that gob can't decode.
+type Point struct {
+	x, y int32
+}
+
+type pointWorker struct{}
+
+func (*pointWorker) DecodeArg(path string, body []byte) (interface{}, error) {
+	if len(body) != 8 {
+		return nil, errors.New("a point must be 8 bytes")
+	}
+	return Point{
+		x: int32(binary.BigEndian.Uint32(body)),
+		y: int32(binary.BigEndian.Uint32(body[4:])),
+	}, nil
+}
+
+func (*pointWorker) Sum(arg Point, reply *int32) error {
+	*reply = arg.x + arg.y
+	return nil
+}
+
+func TestArgDecoder(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("point", new(pointWorker))
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint32(body, 3)
+	binary.BigEndian.PutUint32(body[4:], 4)
+	var reply int32
+	if e := c.Call("/point/sum", body, &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	if reply != 7 {
+		t.Fatalf("expect 7, got %d", reply)
+	}
+	if e := c.Call("/point/sum", []byte{1}, &reply); e == nil || e.Type != common.ErrorTypeServerReadRequestBody {
+		t.Fatalf("expect decode error, got: %v", e)
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	srv := server.NewServer(server.Server{
+		NotFoundHandler: func(ctx *server.Context) error {
+			if ctx.Path() == "/upstream/fail" {
+				return errors.New("upstream failed")
+			}
+			ctx.SetReply("forwarded " + ctx.Path())
+			return nil
+		},
+	})
+	srv.NamedRegister("worker", &worker{name: "local"})
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/worker/name", "x", &reply); e != nil || reply != "local: x" {
+		t.Fatalf("registered: reply=%q, err=%v", reply, e)
+	}
+	if e := c.Call("/upstream/echo", "x", &reply); e != nil || reply != "forwarded /upstream/echo" {
+		t.Fatalf("unregistered: reply=%q, err=%v", reply, e)
+	}
+	e := c.Call("/upstream/fail", "x", &reply)
+	if e == nil || e.Type != common.ErrorTypeServerService || e.Error != "upstream failed" {
+		t.Fatalf("expect handler error, got: %v", e)
+	}
+}
+
+func TestReservedKey(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", &worker{name: "local"})
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	var reply string
+	for _, key := range []string{common.ChunkKey, common.ProgressKey, common.ProgressMsgKey} {
+		e := c.Call("/worker/name?"+key+"=1", "x", &reply)
+		if e == nil || e.Type != common.ErrorTypeServerInvalidServiceMethod || !strings.Contains(e.Error, "'"+key+"'") {
+			t.Fatalf("expect the reserved key %s rejected, got: %v", key, e)
+		}
+	}
+	// a key of the same prefix is not reserved.
+	if e := c.Call("/worker/name?"+common.ChunkKey+"_size=1", "x", &reply); e != nil || reply != "local: x" {
+		t.Fatalf("reply=%q, err=%v", reply, e)
+	}
+}
+
+type userService struct{}
+
+func (*userService) GetUserInfo(arg string, reply *string) error { return nil }
+
+func (*userService) ListHTTPRoutes(arg string, reply *string) error { return nil }
+
+func TestPreviewPaths(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	paths, err := srv.PreviewPaths("UserService", new(userService))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.Routers()) != 0 {
+		t.Fatalf("preview registered routers: %v", srv.Routers())
+	}
+	srv.NamedRegister("UserService", new(userService))
+	routers := srv.Routers()
+	if strings.Join(paths, ",") != strings.Join(routers, ",") {
+		t.Fatalf("preview %v does not match routers %v", paths, routers)
+	}
+	if _, err = srv.PreviewPaths("bad name", new(userService)); err == nil {
+		t.Fatal("expect invalid name error")
+	}
+}
+
+// handshakeCodec is a ServerCodec that requires the "MYRPC" handshake.
+type handshakeCodec struct {
+	rpc.ServerCodec
+	err error
+}
+
+func (c *handshakeCodec) InitError() error { return c.err }
+
+func newHandshakeCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return &handshakeCodec{err: err}
+	}
+	if string(b) != "MYRPC" {
+		return &handshakeCodec{err: errors.New("bad handshake " + strconv.Quote(string(b)))}
+	}
+	return &handshakeCodec{ServerCodec: jsonrpc.NewJSONRPCServerCodec(conn)}
+}
+
+func TestCodecInitError(t *testing.T) {
+	logs, restore := logtest.Capture()
+	defer restore()
+
+	srv := server.NewServer(server.Server{ServerCodecFunc: newHandshakeCodec})
+	srv.NamedRegister("worker", &worker{name: "w"})
+	addr := serve(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = io.WriteString(conn, "HELLO"); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect the connection closed, got: n=%d, err=%v", n, err)
+	}
+	if !strings.Contains(logs.String(), `bad handshake "HELLO"`) {
+		t.Fatalf("expect the codec error logged, got: %s", logs.String())
+	}
+}
+
+// queueWorker records the order of the served calls, blocking the "block" ones until the gate is closed.
+type queueWorker struct {
+	gate    chan struct{}
+	started chan struct{} // receives the blocked calls, if not nil
+	mu      sync.Mutex
+	order   []string
+}
+
+func (w *queueWorker) Work(arg string, reply *string) error {
+	if arg == "block" {
+		if w.started != nil {
+			w.started <- struct{}{}
+		}
+		<-w.gate
+	}
+	w.mu.Lock()
+	w.order = append(w.order, arg)
+	w.mu.Unlock()
+	*reply = arg
+	return nil
+}
+
+func TestPriority(t *testing.T) {
+	w := &queueWorker{gate: make(chan struct{}), started: make(chan struct{}, 1)}
+	srv := server.NewServer(server.Server{Workers: 1})
+	srv.NamedRegister("queue", w)
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+	var reply string
+	if e := c.Call("/queue/work", "warmup", &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+
+	var wg sync.WaitGroup
+	call := func(arg string, priority common.Priority) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply string
+			if e := c.CallWithPriority("/queue/work", priority, arg, &reply); e != nil {
+				t.Error(e.Error)
+			}
+		}()
+	}
+	// saturate the only worker, then queue the backlog.
+	call("block", common.PriorityNormal)
+	<-w.started
+	for i := 0; i < 5; i++ {
+		call("low", common.PriorityLow)
+	}
+	waitFor(t, "the low priority calls queued", func() bool { return srv.QueuedCalls() == 5 })
+	call("high", common.PriorityHigh)
+	waitFor(t, "the high priority call queued", func() bool { return srv.QueuedCalls() == 6 })
+	close(w.gate)
+	wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.order) != 8 || w.order[1] != "block" || w.order[2] != "high" {
+		t.Fatalf("expect the high priority call served first after the blocking one, got: %v", w.order)
+	}
+}
+
+// gatedWorker blocks the calls until the gate is closed, except the "free" ones.
+type gatedWorker struct {
+	started chan string
+	gate    chan struct{}
+}
+
+func (w *gatedWorker) Work(arg string, reply *string) error {
+	if arg != "free" {
+		w.started <- arg
+		<-w.gate
+	}
+	*reply = arg
+	return nil
+}
+
+func TestWorkerPool(t *testing.T) {
+	w := &gatedWorker{started: make(chan string, 10), gate: make(chan struct{})}
+	srv := server.NewServer(server.Server{Workers: 1, MaxQueuedCalls: 2})
+	srv.NamedRegister("queue", w)
+	addr := serve(t, srv)
+	c := newClient(client.Client{}, addr)
+	defer c.Close()
+	// the failed calls close the connection of their client, which mustn't be the blocked one.
+	busy := newClient(client.Client{MaxTry: 1}, addr)
+	defer busy.Close()
+	var reply string
+	for _, c := range []*client.Client{c, busy} {
+		if e := c.Call("/queue/work", "free", &reply); e != nil {
+			t.Fatal(e.Error)
+		}
+	}
+	// the worker is running.
+	before := runtime.NumGoroutine()
+
+	var wg sync.WaitGroup
+	call := func(arg string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply string
+			if e := c.Call("/queue/work", arg, &reply); e != nil {
+				t.Error(e.Error)
+			}
+		}()
+	}
+	// the only worker runs the first call, the next two wait in the queue.
+	call("first")
+	<-w.started
+	call("second")
+	call("third")
+	waitFor(t, "the queued calls", func() bool { return srv.QueuedCalls() == 2 })
+	if e := busy.Call("/queue/work", "free", &reply); e == nil || e.Type != common.ErrorTypeServerBusy || !strings.Contains(e.Error, "MaxQueuedCalls") {
+		t.Fatalf("expect the server busy error, got: %v", e)
+	}
+
+	// more workers take the queued calls, and run a new one without waiting for them.
+	srv.SetWorkers(4)
+	<-w.started
+	<-w.started
+	if e := c.Call("/queue/work", "free", &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	close(w.gate)
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the workers to exit", func() bool { return runtime.NumGoroutine() < before })
+}
+
+// versionWorker holds the calls of true until the gate is closed.
+type versionWorker struct {
+	version string
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (w *versionWorker) Version(hold bool, reply *string) error {
+	if hold {
+		w.started <- struct{}{}
+		<-w.gate
+	}
+	*reply = w.version
+	return nil
+}
+
+func TestReplaceService(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	blue := &versionWorker{version: "blue", started: make(chan struct{}, 1), gate: make(chan struct{})}
+	srv.NamedRegister("worker", blue)
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/worker/version", false, &reply); e != nil || reply != "blue" {
+		t.Fatalf("blue: reply=%q, err=%v", reply, e)
+	}
+	slow := c.Go("/worker/version", true, new(string), nil)
+	<-blue.started
+
+	if err := srv.ReplaceService("/worker", &versionWorker{version: "green"}); err != nil {
+		t.Fatal(err)
+	}
+	if e := c.Call("/worker/version", false, &reply); e != nil || reply != "green" {
+		t.Fatalf("green: reply=%q, err=%v", reply, e)
+	}
+	close(blue.gate)
+	call := <-slow.Done
+	if call.Error != nil || *call.Reply.(*string) != "blue" {
+		t.Fatalf("in-flight call: reply=%v, err=%v", call.Reply, call.Error)
+	}
+	if err := srv.ReplaceService("/other", &versionWorker{version: "green"}); err == nil {
+		t.Fatal("expect error replacing unregistered service")
+	}
+}
+
+func TestDeregisterPrefix(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	admin := srv.Group("admin")
+	admin.NamedRegister("users", &worker{name: "users"})
+	admin.NamedRegister("roles", &worker{name: "roles"})
+	srv.NamedRegister("public", &worker{name: "public"})
+	srv.SetCallTimeout("/admin/users/name", time.Second)
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	if n := srv.DeregisterPrefix("/admin/"); n != 4 {
+		t.Fatalf("removed = %d, want the 2 methods of 2 services", n)
+	}
+	if routers := srv.Routers(); !reflect.DeepEqual(routers, []string{"/public/name", "/public/sleep"}) {
+		t.Fatalf("routers = %v", routers)
+	}
+	if _, ok := srv.ConfigSnapshot().CallTimeouts["/admin/users/name"]; ok {
+		t.Fatal("the call timeout of the removed service is left")
+	}
+	var reply string
+	if e := c.Call("/admin/users/name", "x", &reply); e == nil || e.Type != common.ErrorTypeServerNotFoundService {
+		t.Fatalf("expect the removed service not found, got: %v", e)
+	}
+	if e := c.Call("/public/name", "x", &reply); e != nil || reply != "public: x" {
+		t.Fatalf("public: reply=%q, err=%v", reply, e)
+	}
+	if n := srv.DeregisterPrefix("/admin/"); n != 0 {
+		t.Fatalf("removed again = %d", n)
+	}
+
+	if !srv.Deregister("/public/sleep") || srv.Deregister("/public/sleep") {
+		t.Fatal("expect the single path removed once")
+	}
+	if routers := srv.Routers(); !reflect.DeepEqual(routers, []string{"/public/name"}) {
+		t.Fatalf("routers = %v", routers)
+	}
+}
+
+type loggingWorker struct{}
+
+func (*loggingWorker) Hello(ctx *server.Context, arg string, reply *string) error {
+	ctx.Logger().Infof("hello %s", arg)
+	*reply = ctx.RequestID()
+	return nil
+}
+
+func TestContextLogger(t *testing.T) {
+	logs, restore := logtest.Capture()
+	defer restore()
+
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("logging", new(loggingWorker))
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/logging/hello?"+common.RequestIDKey+"=abc123", "world", &reply); e != nil || reply != "abc123" {
+		t.Fatalf("reply=%q, err=%v", reply, e)
+	}
+	if !strings.Contains(logs.String(), "rid=abc123 path=/logging/hello remote=127.0.0.1:") ||
+		!strings.Contains(logs.String(), "hello world") {
+		t.Fatalf("expect the correlated log line, got: %s", logs.String())
+	}
+
+	if e := c.Call("/logging/hello", "world", &reply); e != nil || len(reply) == 0 {
+		t.Fatalf("expect a generated request ID: reply=%q, err=%v", reply, e)
+	}
+}
+
+// waitFor polls cond until it holds or the timeout expires.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListenerStats(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", &worker{name: "w"})
+	addrs := []string{serve(t, srv), serve(t, srv)}
+	waitFor(t, "listeners", func() bool { return len(srv.ListenerStats()) == 2 })
+
+	dial := func(addr string) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	stat := func(addr string) server.ListenerStat {
+		for _, s := range srv.ListenerStats() {
+			if s.Addr == addr {
+				return s
+			}
+		}
+		t.Fatalf("no stat of %s", addr)
+		return server.ListenerStat{}
+	}
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conns = append(conns, dial(addrs[0]))
+	}
+	conns = append(conns, dial(addrs[1]))
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	waitFor(t, "active connections", func() bool {
+		return stat(addrs[0]).Active == 3 && stat(addrs[1]).Active == 1
+	})
+	conns[0].Close()
+	waitFor(t, "closed connection", func() bool { return stat(addrs[0]).Active == 2 })
+
+	srv.SetDraining(true)
+	conns = append(conns, dial(addrs[1]))
+	waitFor(t, "rejected connection", func() bool { return stat(addrs[1]).Rejects == 1 })
+
+	if s := stat(addrs[0]); s.Accepts != 3 || s.Active != 2 || s.Rejects != 0 {
+		t.Fatalf("first listener: %+v", s)
+	}
+	if s := stat(addrs[1]); s.Accepts != 2 || s.Active != 1 || s.Rejects != 1 {
+		t.Fatalf("second listener: %+v", s)
+	}
+}
+
+type namedPlugin string
+
+func (p namedPlugin) Name() string { return string(p) }
+
+func TestConfigSnapshot(t *testing.T) {
+	srv := server.NewServer(server.Server{
+		Timeout:         time.Minute,
+		IdleTimeout:     time.Second,
+		ServerCodecFunc: jsonrpc.NewJSONRPCServerCodec,
+		Capabilities:    []string{common.FeatureStream},
+		Workers:         4,
+	})
+	srv.PluginContainer.Add(namedPlugin("first"), namedPlugin("second"))
+	srv.NamedRegister("worker", &worker{name: "w"})
+	srv.SetCallTimeout("/worker/sleep", 3*time.Second)
+
+	config := srv.ConfigSnapshot()
+	if config.Timeout != time.Minute || config.IdleTimeout != time.Second || config.ReadTimeout != 0 {
+		t.Fatalf("timeouts: %+v", config)
+	}
+	if !strings.HasSuffix(config.Codec, "jsonrpc.NewJSONRPCServerCodec") {
+		t.Fatalf("codec: %q", config.Codec)
+	}
+	if config.ServiceBuilder != "NormServiceBuilder" || config.Workers != 4 || config.NotFoundHandler || config.Draining {
+		t.Fatalf("config: %+v", config)
+	}
+	if strings.Join(config.Capabilities, ",") != common.FeatureStream {
+		t.Fatalf("capabilities: %v", config.Capabilities)
+	}
+	if strings.Join(config.Plugins, ",") != "first,second" {
+		t.Fatalf("plugins: %v", config.Plugins)
+	}
+	if strings.Join(config.Routers, ",") != "/worker/name,/worker/sleep" {
+		t.Fatalf("routers: %v", config.Routers)
+	}
+	if len(config.CallTimeouts) != 1 || config.CallTimeouts["/worker/sleep"] != 3*time.Second {
+		t.Fatalf("call timeouts: %v", config.CallTimeouts)
+	}
+
+	// the snapshot is not changed by the server.
+	srv.SetDraining(true)
+	if config.Draining || !srv.ConfigSnapshot().Draining {
+		t.Fatal("expect a frozen snapshot")
+	}
+}
+
+type arith struct{}
+
+func (*arith) Mul(ctx *server.Context, arg [2]int, reply *string) error {
+	*reply = strconv.Itoa(arg[0]*arg[1]) + " " + ctx.OriginalPath() + " -> " + ctx.Path()
+	return nil
+}
+
+func TestPathRewriter(t *testing.T) {
+	srv := server.NewServer(server.Server{
+		PathRewriter: func(path string) string {
+			return strings.TrimPrefix(path, "/v1")
+		},
+	})
+	srv.NamedRegister("arith", new(arith))
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	var reply string
+	if e := c.Call("/v1/arith/mul", [2]int{6, 7}, &reply); e != nil || reply != "42 /v1/arith/mul -> /arith/mul" {
+		t.Fatalf("reply=%q, err=%v", reply, e)
+	}
+	if e := c.Call("/arith/mul", [2]int{2, 3}, &reply); e != nil || reply != "6 /arith/mul -> /arith/mul" {
+		t.Fatalf("reply=%q, err=%v", reply, e)
+	}
+}
+
+type counter struct{}
+
+func (*counter) Get(arg string, reply *int) error { return nil }
+
+func (*counter) Incr(arg string, reply *int) error { return nil }
+
+func (*counter) Idempotent(method string) bool { return method == "Get" }
+
+func TestIdempotency(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("counter", new(counter))
+	srv.NamedRegister("worker", &worker{name: "w"})
+	if !srv.IsIdempotent("/counter/get") {
+		t.Fatal("expect /counter/get idempotent")
+	}
+	for _, path := range []string{"/counter/incr", "/counter/idempotent", "/worker/name", "/unknown"} {
+		if srv.IsIdempotent(path) {
+			t.Fatalf("expect %s not idempotent", path)
+		}
+	}
+	for _, m := range srv.Methods() {
+		if m.Idempotent != (m.Path == "/counter/get") {
+			t.Fatalf("%s: idempotent = %v", m.Path, m.Idempotent)
+		}
+	}
+}
+
+// acceptPlugin is a connection-level plugin, which is ineffective in a group.
+type acceptPlugin struct{ namedPlugin }
+
+func (acceptPlugin) PostConnAccept(server.ServerCodecConn) error { return nil }
+
+func TestValidate(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", &worker{name: "w"})
+	srv.MapHTTP("/v1/name", "/worker/name")
+	if err := srv.Validate(); err != nil {
+		t.Fatalf("expect a valid config, got: %v", err)
+	}
+
+	srv.Group("v1", acceptPlugin{"conn"}).NamedRegister("worker", &worker{name: "w"})
+	srv.SetCallTimeout("/worker/missing", time.Second)
+	srv.MapHTTP("/v1/missing", "/missing/name")
+	srv.Workers = -1
+	srv.ServerCodecFunc = nil
+
+	err := srv.Validate()
+	if _, ok := err.(*common.MultiError); !ok {
+		t.Fatalf("expect a MultiError, got: %v", err)
+	}
+	for _, expect := range []string{
+		"ServerCodecFunc is not set",
+		"Workers is negative",
+		"'PostConnAccept()' of 'conn' plugin",
+		"call timeout is set for unregistered service '/worker/missing'",
+		"gateway '/v1/missing' is mapped to unregistered service '/missing/name'",
+	} {
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("expect %q in:\n%s", expect, err)
+		}
+	}
+	if strings.Count(err.Error(), "PostConnAccept") != 1 {
+		t.Errorf("expect the group plugin reported once:\n%s", err)
+	}
+}
+
+var errUnencodable = errors.New("Unencodable")
+
+// Unencodable is an exported reply type that fails to encode by gob or JSON.
+type Unencodable struct{ V int }
+
+func (Unencodable) GobEncode() ([]byte, error)   { return nil, errUnencodable }
+func (*Unencodable) GobDecode([]byte) error      { return nil }
+func (Unencodable) MarshalJSON() ([]byte, error) { return nil, errUnencodable }
+
+type encoder struct{}
+
+func (*encoder) Bad(arg int, reply *Unencodable) error {
+	reply.V = arg
+	return nil
+}
+
+func (*encoder) Good(arg int, reply *int) error {
+	*reply = arg
+	return nil
+}
+
+func TestEncodeResponseError(t *testing.T) {
+	for _, codec := range []struct {
+		server server.ServerCodecFunc
+		client client.ClientCodecFunc
+	}{
+		{nil, nil},
+		{jsonrpc.NewJSONRPCServerCodec, jsonrpc.NewJSONRPCClientCodec},
+	} {
+		srv := server.NewServer(server.Server{ServerCodecFunc: codec.server})
+		srv.NamedRegister("encoder", new(encoder))
+		c := newClient(client.Client{ClientCodecFunc: codec.client, CallTimeout: time.Second}, serve(t, srv))
+
+		var bad Unencodable
+		e := c.Call("/encoder/bad", 1, &bad)
+		if e == nil || e.Type != common.ErrorTypeServerEncodeResponse || !strings.Contains(e.Error, errUnencodable.Error()) {
+			t.Fatalf("expect the encode error, got: %v", e)
+		}
+		// the connection is still usable.
+		var good int
+		if e := c.Call("/encoder/good", 2, &good); e != nil || good != 2 {
+			t.Fatalf("after the encode error: reply=%d, err=%v", good, e)
+		}
+		c.Close()
+	}
+}
+
+func TestRegisterAll(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	err := srv.RegisterAll(map[string]interface{}{
+		"first":    &worker{name: "first"},
+		"second":   &worker{name: "second"},
+		"bad name": &worker{name: "bad"},
+	})
+	if _, ok := err.(*common.MultiError); !ok || !strings.Contains(err.Error(), "'bad name'") {
+		t.Fatalf("expect the error naming the invalid entry, got: %v", err)
+	}
+	if strings.Contains(err.Error(), "'first'") || strings.Contains(err.Error(), "'second'") {
+		t.Fatalf("expect only the invalid entry failed, got: %v", err)
+	}
+
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+	for _, name := range []string{"first", "second"} {
+		var reply string
+		if e := c.Call("/"+name+"/name", "x", &reply); e != nil || reply != name+": x" {
+			t.Fatalf("%s: reply=%q, err=%v", name, reply, e)
+		}
+	}
+}
+
+// blob holds the calls until the gate is closed, recording the most calls running at once.
+type blob struct {
+	calls, running, maxRunning int32
+	gate                       chan struct{}
+}
+
+func (b *blob) Get(size int, reply *[]byte) error {
+	atomic.AddInt32(&b.calls, 1)
+	n := atomic.AddInt32(&b.running, 1)
+	defer atomic.AddInt32(&b.running, -1)
+	for max := atomic.LoadInt32(&b.maxRunning); n > max; max = atomic.LoadInt32(&b.maxRunning) {
+		if atomic.CompareAndSwapInt32(&b.maxRunning, max, n) {
+			break
+		}
+	}
+	<-b.gate
+	*reply = make([]byte, size)
+	return nil
+}
+
+func TestMaxPendingResponses(t *testing.T) {
+	b := &blob{gate: make(chan struct{})}
+	srv := server.NewServer(server.Server{MaxPendingResponses: 4})
+	srv.NamedRegister("blob", b)
+	conn, err := net.Dial("tcp", serve(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// pipeline the requests but never read the responses.
+	const n = 100
+	go func() {
+		codec := codecGob.NewGobClientCodec(conn)
+		for i := 0; i < n; i++ {
+			req := &rpc.Request{ServiceMethod: "/blob/get", Seq: uint64(i)}
+			if codec.WriteRequest(req, 1) != nil {
+				return
+			}
+		}
+	}()
+	// the server stops reading the connection while the pending responses are held.
+	waitFor(t, "the pending calls", func() bool { return atomic.LoadInt32(&b.calls) >= 4 })
+	close(b.gate)
+	waitFor(t, "all the calls", func() bool { return atomic.LoadInt32(&b.calls) == n })
+	if max := atomic.LoadInt32(&b.maxRunning); max > 4 {
+		t.Fatalf("expect at most 4 pending calls, got %d running at once", max)
+	}
+}
+
+// forwarder forwards every call to the next server.
+type forwarder struct {
+	next *client.Client
+}
+
+func (f *forwarder) Forward(ctx *server.Context, arg string, reply *string) error {
+	if e := f.next.CallContext(ctx.Context(), "/forwarder/forward", arg, reply); e != nil {
+		return errors.New(e.Error)
+	}
+	return nil
+}
+
+func TestMaxHops(t *testing.T) {
+	// a loop of three servers.
+	forwarders := make([]*forwarder, 3)
+	addrs := make([]string, 3)
+	for i := range forwarders {
+		forwarders[i] = new(forwarder)
+		srv := server.NewServer(server.Server{MaxHops: 2})
+		srv.NamedRegister("forwarder", forwarders[i])
+		addrs[i] = serve(t, srv)
+	}
+	for i, f := range forwarders {
+		f.next = newClient(client.Client{}, addrs[(i+1)%3])
+		defer f.next.Close()
+	}
+
+	c := newClient(client.Client{CallTimeout: 5 * time.Second}, addrs[0])
+	defer c.Close()
+	var reply string
+	e := c.Call("/forwarder/forward", "x", &reply)
+	if e == nil || !strings.Contains(e.Error, "too many hops: 3 > 2") {
+		t.Fatalf("expect the loop broken by the too many hops error, got: %v", e)
+	}
+}
+
+// stopper sleeps until its context is done.
+type stopper struct {
+	stopped chan error
+}
+
+func (s *stopper) Wait(ctx *server.Context, arg time.Duration, reply *string) error {
+	select {
+	case <-ctx.Context().Done():
+		s.stopped <- ctx.Context().Err()
+	case <-time.After(arg):
+		s.stopped <- nil
+	}
+	*reply = "OK"
+	return nil
+}
+
+func TestServeRequestTimeout(t *testing.T) {
+	s := &stopper{stopped: make(chan error, 1)}
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("stopper", s)
+	serve(t, srv)
+	waitFor(t, "the server running", func() bool { return len(srv.ListenerStats()) > 0 })
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	resps := make(chan rpc.Response, 1)
+	go func() {
+		codec := codecGob.NewGobClientCodec(clientConn)
+		codec.WriteRequest(&rpc.Request{ServiceMethod: "/stopper/wait", Seq: 1}, 5*time.Second)
+		var resp rpc.Response
+		codec.ReadResponseHeader(&resp)
+		codec.ReadResponseBody(nil)
+		resps <- resp
+	}()
+
+	start := time.Now()
+	err := srv.ServeRequestTimeout(server.NewServerCodecConn(serverConn), 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("expect a timeout error, got: %v", err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > time.Second {
+		t.Fatalf("returned after %v", d)
+	}
+	select {
+	case err := <-s.stopped:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expect the handler canceled, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the handler is not canceled")
+	}
+	if resp := <-resps; len(resp.Error) == 0 || common.ErrorType(resp.Error[0]) != common.ErrorTypeServerTimeout {
+		t.Fatalf("expect the timeout response, got: %+v", resp)
+	}
+}
+
+type checker struct{}
+
+func (*checker) Ok(arg int, reply *int) error {
+	*reply = arg
+	return nil
+}
+
+func (*checker) Check(arg int, reply *int) error {
+	if arg < 0 {
+		return errors.New("negative " + strconv.Itoa(arg))
+	}
+	*reply = arg
+	return nil
+}
+
+func TestMethodStats(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("checker", new(checker))
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	if stats := srv.MethodStats(); len(stats) != 2 || stats["/checker/ok"].Calls != 0 || !stats["/checker/ok"].LastCall.IsZero() {
+		t.Fatalf("expect the empty stats of two methods, got: %+v", stats)
+	}
+	start := time.Now()
+	var reply int
+	for i := 0; i < 2; i++ {
+		if err := c.Call("/checker/ok", i, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Call("/checker/check", 1, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Call("/checker/check", -1, &reply); err == nil {
+		t.Fatal("expect an error")
+	}
+
+	stats := srv.MethodStats()
+	if s := stats["/checker/ok"]; s.Calls != 2 || s.Errors != 0 || s.LastError != "" || s.LastCall.Before(start) {
+		t.Fatalf("ok: %+v", s)
+	}
+	if s := stats["/checker/check"]; s.Calls != 2 || s.Errors != 1 || s.LastError != "negative -1" || s.LastCall.Before(stats["/checker/ok"].LastCall) {
+		t.Fatalf("check: %+v", s)
+	}
+
+	if err := c.Call("/checker/check", 2, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if s := srv.MethodStats()["/checker/check"]; s.Calls != 3 || s.Errors != 1 || s.LastError != "" {
+		t.Fatalf("check after success: %+v", s)
+	}
+}
+
+func TestIPv6(t *testing.T) {
+	lis, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback is unavailable: %v", err)
+	}
+	lis.Close()
+
+	call := func(network, addr string) {
+		c := client.NewClient(client.Client{}, &selector.DirectSelector{
+			Network: network,
+			Address: addr,
+		})
+		defer c.Close()
+		var reply string
+		if e := c.Call("/worker/name", "x", &reply); e != nil || reply != "w: x" {
+			t.Fatalf("%s %s: reply=%q, err=%v", network, addr, reply, e)
+		}
+	}
+
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", &worker{name: "w"})
+	go srv.Serve("tcp6", "[::1]:0")
+	waitFor(t, "the tcp6 listener", func() bool { return len(srv.ListenerStats()) == 1 })
+	if network := srv.Network(); network != "tcp6" {
+		t.Fatalf("network = %q", network)
+	}
+	if s := srv.ListenerStats()[0]; s.Network != "tcp6" || s.Addr != srv.Address() || !strings.HasPrefix(s.Addr, "[::1]:") {
+		t.Fatalf("listener: %+v", s)
+	}
+	call("tcp6", srv.Address())
+
+	dual := server.NewServer(server.Server{})
+	dual.NamedRegister("worker", &worker{name: "w"})
+	go dual.Serve(common.NetworkDualStack, ":0")
+	waitFor(t, "the dual-stack listener", func() bool { return len(dual.ListenerStats()) == 1 })
+	if network := dual.Network(); network != common.NetworkDualStack {
+		t.Skipf("the listener is not dual-stack: %s", network)
+	}
+	_, port, _ := net.SplitHostPort(dual.Address())
+	call("tcp4", "127.0.0.1:"+port)
+	call("tcp6", "[::1]:"+port)
+	call(common.NetworkDualStack, "localhost:"+port)
+}
+
+// failingConn fails to write after n bytes, cutting the response in the middle.
+type failingConn struct {
+	net.Conn
+	n      int32
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *failingConn) Write(b []byte) (int, error) {
+	n := atomic.AddInt32(&c.n, -int32(len(b)))
+	if n < 0 {
+		if m := len(b) + int(n); m > 0 {
+			c.Conn.Write(b[:m])
+		}
+		atomic.StoreInt32(&c.n, 0)
+		return 0, errors.New("write failure")
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *failingConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func TestPartialWrite(t *testing.T) {
+	w := &worker{name: "w"}
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", w)
+	serve(t, srv)
+	waitFor(t, "the server running", func() bool { return len(srv.ListenerStats()) > 0 })
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	conn := &failingConn{Conn: serverConn, n: 10, closed: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		srv.ServeConn(server.NewServerCodecConn(conn))
+		close(done)
+	}()
+	received := make(chan int, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, clientConn)
+		received <- int(n)
+	}()
+
+	codec := codecGob.NewGobClientCodec(clientConn)
+	if err := codec.WriteRequest(&rpc.Request{ServiceMethod: "/worker/name", Seq: 1}, "first"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the broken connection is still served")
+	}
+	select {
+	case <-conn.closed:
+	default:
+		t.Fatal("the broken connection is not closed")
+	}
+	if n := <-received; n != 10 {
+		t.Fatalf("received %d bytes, want the 10 bytes written before the failure", n)
+	}
+	if err := codec.WriteRequest(&rpc.Request{ServiceMethod: "/worker/name", Seq: 2}, "second"); err == nil {
+		t.Fatal("the broken connection accepts further requests")
+	}
+}
+
+func TestAcceptParallelism(t *testing.T) {
+	srv := server.NewServer(server.Server{AcceptParallelism: 4})
+	srv.NamedRegister("worker", &worker{name: "w"})
+	addr := serve(t, srv)
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := newClient(client.Client{}, addr)
+			defer c.Close()
+			var reply string
+			if e := c.Call("/worker/name", "x", &reply); e != nil || reply != "w: x" {
+				t.Errorf("got %q, %v", reply, e)
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := srv.ListenerStats(); len(stats) != 1 || stats[0].Accepts != n {
+		t.Fatalf("expect %d connections accepted, got %+v", n, stats)
+	}
+}
+
+// BenchmarkAcceptParallelism dials a connection for every call, like the clients of high connection churn.
+func BenchmarkAcceptParallelism(b *testing.B) {
+	_, restore := logtest.Capture()
+	defer restore()
+	for _, parallelism := range []int{1, 8} {
+		b.Run("accept"+strconv.Itoa(parallelism), func(b *testing.B) {
+			srv := server.NewServer(server.Server{AcceptParallelism: parallelism})
+			srv.NamedRegister("worker", &worker{name: "w"})
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer lis.Close()
+			go srv.ServeListener(lis)
+			addr := lis.Addr().String()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					conn, err := net.Dial("tcp", addr)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					codec := codecGob.NewGobClientCodec(conn)
+					codec.WriteRequest(&rpc.Request{ServiceMethod: "/worker/name", Seq: 1}, "x")
+					var resp rpc.Response
+					codec.ReadResponseHeader(&resp)
+					var reply string
+					codec.ReadResponseBody(&reply)
+					codec.Close()
+				}
+			})
+		})
+	}
+}
+
+type canceled struct {
+	started chan struct{}
+	causes  chan server.CancelCause
+}
+
+func (c *canceled) Wait(ctx *server.Context, arg string, reply *string) error {
+	c.started <- struct{}{}
+	select {
+	case <-ctx.Context().Done():
+		c.causes <- ctx.CancelCause()
+	case <-time.After(5 * time.Second):
+		c.causes <- ctx.CancelCause()
+	}
+	return nil
+}
+
+func TestCancelCause(t *testing.T) {
+	newServer := func() (*server.Server, *canceled, string) {
+		c := &canceled{started: make(chan struct{}, 1), causes: make(chan server.CancelCause, 1)}
+		srv := server.NewServer(server.Server{})
+		srv.NamedRegister("canceled", c)
+		addr := serve(t, srv)
+		waitFor(t, "the server running", func() bool { return len(srv.ListenerStats()) > 0 })
+		return srv, c, addr
+	}
+	expect := func(c *canceled, want server.CancelCause) {
+		t.Helper()
+		select {
+		case cause := <-c.causes:
+			if cause != want {
+				t.Fatalf("expect the cause %v, got %v", want, cause)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("the call is not canceled by %v", want)
+		}
+	}
+
+	srv, c, addr := newServer()
+	srv.SetCallTimeout("/canceled/wait", 50*time.Millisecond)
+	cli := newClient(client.Client{}, addr)
+	var reply string
+	cli.Call("/canceled/wait", "", &reply)
+	cli.Close()
+	expect(c, server.CancelDeadline)
+
+	_, c, addr = newServer()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := codecGob.NewGobClientCodec(conn)
+	codec.WriteRequest(&rpc.Request{ServiceMethod: "/canceled/wait", Seq: 1}, "")
+	<-c.started
+	codec.Close()
+	expect(c, server.CancelClientDisconnect)
+
+	srv, c, addr = newServer()
+	cli = newClient(client.Client{}, addr)
+	done := make(chan struct{})
+	go func() {
+		var reply string
+		cli.Call("/canceled/wait", "", &reply)
+		close(done)
+	}()
+	<-c.started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect the shutdown to time out, got %v", err)
+	}
+	expect(c, server.CancelServerShutdown)
+	<-done
+	cli.Close()
+}
+
+type invariant struct{}
+
+func (*invariant) Check(arg string, reply *string) error {
+	panic("invariant violated: " + arg)
+}
+
+func TestPanicPolicy(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("invariant", new(invariant))
+	c := newClient(client.Client{CallTimeout: 300 * time.Millisecond}, serve(t, srv))
+	defer c.Close()
+	var reply string
+	if rpcErr := c.Call("/invariant/check", "respond", &reply); rpcErr == nil || rpcErr.Error != "Service Panic!" {
+		t.Fatalf("RecoverAndRespond: expect Service Panic!, got: %v", rpcErr)
+	}
+
+	srv = server.NewServer(server.Server{PanicPolicy: server.RecoverAndLog})
+	srv.NamedRegister("invariant", new(invariant))
+	c2 := newClient(client.Client{CallTimeout: 300 * time.Millisecond}, serve(t, srv))
+	defer c2.Close()
+	rpcErr := c2.Call("/invariant/check", "log", &reply)
+	if rpcErr == nil || rpcErr.Error == "Service Panic!" {
+		t.Fatalf("RecoverAndLog: expect no response, got: %v", rpcErr)
+	}
+	if stat := srv.MethodStats()["/invariant/check"]; stat.Errors != 1 || stat.LastError != "Service Panic!" {
+		t.Fatalf("RecoverAndLog: stat = %+v", stat)
+	}
+	if p := srv.ConfigSnapshot().PanicPolicy; p != "recover-and-log" {
+		t.Fatalf("config PanicPolicy = %q", p)
+	}
+}
+
+// TestPanicPolicyPropagate runs the server in a child process, which must crash
+// after responding to the panicking call.
+func TestPanicPolicyPropagate(t *testing.T) {
+	if os.Getenv("MYRPC_PANIC_CHILD") == "1" {
+		srv := server.NewServer(server.Server{PanicPolicy: server.Propagate})
+		srv.NamedRegister("invariant", new(invariant))
+		lis := listen(t)
+		os.Stdout.WriteString("\naddr=" + lis.Addr().String() + "\n")
+		srv.ServeListener(lis)
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPanicPolicyPropagate$")
+	cmd.Env = append(os.Environ(), "MYRPC_PANIC_CHILD=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	var addr string
+	scanner := bufio.NewScanner(stdout)
+	for addr == "" && scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "addr=") {
+			addr = strings.TrimPrefix(line, "addr=")
+		}
+	}
+	if addr == "" {
+		t.Fatalf("child didn't listen: %s", stderr.String())
+	}
+	go io.Copy(io.Discard, stdout)
+
+	c := newClient(client.Client{CallTimeout: 3 * time.Second}, addr)
+	defer c.Close()
+	var reply string
+	if rpcErr := c.Call("/invariant/check", "propagate", &reply); rpcErr == nil || rpcErr.Error != "Service Panic!" {
+		t.Fatalf("Propagate: expect Service Panic!, got: %v", rpcErr)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Propagate: child exited without crashing")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Propagate: child didn't crash")
+	}
+	if !strings.Contains(stderr.String(), "panic: invariant violated: propagate") {
+		t.Fatalf("Propagate: stderr doesn't carry the panic: %s", stderr.String())
+	}
+}
+
+type colferWorker struct{}
+
+func (*colferWorker) Echo(arg *colfer.Header, reply *colfer.Header) error {
+	*reply = *arg
+	return nil
+}
+
+type plainWorker struct{}
+
+func (*plainWorker) Echo(arg *colfer.Header, reply *string) error {
+	*reply = arg.Method
+	return nil
+}
+
+func TestRegisterCodecTypes(t *testing.T) {
+	srv := server.NewServer(server.Server{ServerCodecFunc: colfer.NewServerCodec})
+	if err := srv.RegisterAll(map[string]interface{}{"colfer": new(colferWorker)}); err != nil {
+		t.Fatal(err)
+	}
+	err := srv.RegisterAll(map[string]interface{}{"plain": new(plainWorker)})
+	if err == nil {
+		t.Fatal("expect the reply that colfer can't encode to be rejected")
+	}
+	for _, want := range []string{"reply *string", "/plain/echo", "missing method MarshalLen"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't contain %q", err.Error(), want)
+		}
+	}
+	if len(srv.Routers()) != 1 {
+		t.Fatalf("routers = %v", srv.Routers())
+	}
+	if err := srv.ReplaceService("/colfer", new(plainWorker)); err == nil {
+		t.Fatal("expect the replacement that colfer can't encode to be rejected")
+	}
+}
+
+type budgetWorker struct {
+	calls int32
+}
+
+func (w *budgetWorker) Repeat(arg string, reply *string) error {
+	atomic.AddInt32(&w.calls, 1)
+	*reply = strings.Repeat(arg, 100)
+	return nil
+}
+
+type budgetPart struct {
+	Text string
+}
+
+func (w *budgetWorker) Split(arg string, reply *[]budgetPart) error {
+	*reply = make([]budgetPart, 100)
+	for i := range *reply {
+		(*reply)[i].Text = arg
+	}
+	return nil
+}
+
+func TestMaxCallBytes(t *testing.T) {
+	srv := server.NewServer(server.Server{MaxCallBytes: 4000})
+	worker := new(budgetWorker)
+	srv.NamedRegister("budget", worker)
+	c := newClient(client.Client{}, serve(t, srv))
+	defer c.Close()
+
+	var reply string
+	if rpcErr := c.Call("/budget/repeat", "x", &reply); rpcErr != nil || len(reply) != 100 {
+		t.Fatalf("small call: len=%d, err=%v", len(reply), rpcErr)
+	}
+	// a moderate request within the budget with a reply beyond it
+	arg := strings.Repeat("y", 1000)
+	rpcErr := c.Call("/budget/repeat", arg, &reply)
+	if rpcErr == nil || !strings.Contains(rpcErr.Error, "call too large") || !strings.Contains(rpcErr.Error, "MaxCallBytes (4000)") {
+		t.Fatalf("expect the call too large error, got: %v", rpcErr)
+	}
+	// a request beyond the budget alone is rejected before the service
+	if rpcErr := c.Call("/budget/repeat", strings.Repeat("z", 5000), &reply); rpcErr == nil || !strings.Contains(rpcErr.Error, "call too large") {
+		t.Fatalf("expect the call too large error, got: %v", rpcErr)
+	}
+	if n := atomic.LoadInt32(&worker.calls); n != 2 {
+		t.Fatalf("the oversized request must not reach the service, calls = %d", n)
+	}
+	if rpcErr := c.Call("/budget/repeat", "x", &reply); rpcErr != nil || len(reply) != 100 {
+		t.Fatalf("small call after the rejected ones: len=%d, err=%v", len(reply), rpcErr)
+	}
+
+	// the reply rejected first on a connection doesn't break the next ones of its type,
+	// over a connection the client doesn't redial after the errors
+	conn, err := net.Dial("tcp", serve(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := rpc.NewClientWithCodec(codecGob.NewGobClientCodec(conn))
+	defer rc.Close()
+	var parts []budgetPart
+	if err := rc.Call("/budget/split", arg, &parts); err == nil || !strings.Contains(err.Error(), "call too large") {
+		t.Fatalf("expect the call too large error, got: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := rc.Call("/budget/split", "x", &parts); err != nil || len(parts) != 100 {
+			t.Fatalf("small call after the first rejected: len=%d, err=%v", len(parts), err)
+		}
+	}
+}
+
+// waitGoroutines waits for the server to run n call goroutines,
+// for a goroutine is released right after its response is written.
+func waitGoroutines(t *testing.T, srv *server.Server, n int) {
+	for deadline := time.Now().Add(time.Second); srv.Goroutines() != n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines = %d, want %d", srv.Goroutines(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMaxGoroutines(t *testing.T) {
+	w := &queueWorker{gate: make(chan struct{})}
+	srv := server.NewServer(server.Server{MaxGoroutines: 3})
+	srv.NamedRegister("queue", w)
+	addr := serve(t, srv)
+	c := newClient(client.Client{}, addr)
+	defer c.Close()
+	// the failed calls close the connection of their client, which mustn't be the blocked one.
+	busy := newClient(client.Client{MaxTry: 1}, addr)
+	defer busy.Close()
+	var reply string
+	if e := c.Call("/queue/work", "warmup", &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	waitGoroutines(t, srv, 0)
+
+	// saturate the cap with the blocked calls.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply string
+			if e := c.Call("/queue/work", "block", &reply); e != nil {
+				t.Error(e.Error)
+			}
+		}()
+	}
+	waitGoroutines(t, srv, 3)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		e := busy.Call("/queue/work", "more", &reply)
+		if e == nil || e.Type != common.ErrorTypeServerBusy || !strings.Contains(e.Error, "server busy") {
+			t.Fatalf("expect the server busy error, got: %v", e)
+		}
+	}
+	if n := srv.Goroutines(); n != 3 {
+		t.Fatalf("goroutines = %d, want 3", n)
+	}
+	if n := runtime.NumGoroutine(); n > before+5 {
+		t.Fatalf("the busy calls grew the goroutines from %d to %d", before, n)
+	}
+
+	close(w.gate)
+	wg.Wait()
+	if e := c.Call("/queue/work", "after", &reply); e != nil {
+		t.Fatalf("call after the blocked ones: %v", e)
+	}
+	waitGoroutines(t, srv, 0)
+}
+
+// dynamicWorker serves the JSON of unknown shapes.
+type dynamicWorker struct{}
+
+func (*dynamicWorker) Echo(arg map[string]interface{}, reply *json.RawMessage) error {
+	arg["echoed"] = true
+	b, err := json.Marshal(arg)
+	*reply = b
+	return err
+}
+
+func (*dynamicWorker) Keys(arg json.RawMessage, reply *map[string]interface{}) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(arg, &m); err != nil {
+		return err
+	}
+	for k := range m {
+		(*reply)[k] = true
+	}
+	return nil
+}
+
+func TestSchemalessJSON(t *testing.T) {
+	srv := server.NewServer(server.Server{ServerCodecFunc: jsonrpc.NewJSONRPCServerCodec})
+	srv.NamedRegister("dynamic", new(dynamicWorker))
+	c := newClient(client.Client{ClientCodecFunc: jsonrpc.NewJSONRPCClientCodec, CallTimeout: time.Second}, serve(t, srv))
+	defer c.Close()
+
+	arg := map[string]interface{}{"name": "x", "tags": []interface{}{"a", "b"}, "nested": map[string]interface{}{"n": 1.5}}
+	var reply json.RawMessage
+	if e := c.Call("/dynamic/echo", arg, &reply); e != nil {
+		t.Fatal(e.Error)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(reply, &got); err != nil {
+		t.Fatalf("reply %s: %v", reply, err)
+	}
+	arg["echoed"] = true
+	if !reflect.DeepEqual(got, arg) {
+		t.Fatalf("reply = %v, want %v", got, arg)
+	}
+	// a null map is made for the method to fill.
+	if e := c.Call("/dynamic/echo", nil, &reply); e != nil || string(reply) != `{"echoed":true}` {
+		t.Fatalf("null arg: reply=%s, err=%v", reply, e)
+	}
+
+	var keys map[string]interface{}
+	if e := c.Call("/dynamic/keys", json.RawMessage(`{"a":[1,{"b":null}],"c":"d"}`), &keys); e != nil {
+		t.Fatal(e.Error)
+	}
+	if !reflect.DeepEqual(keys, map[string]interface{}{"a": true, "c": true}) {
+		t.Fatalf("reply = %v", keys)
+	}
+}
+
+// runawayWorker spins ignoring the cancellation until stopped.
+type runawayWorker struct {
+	stop int32
+}
+
+func (w *runawayWorker) Spin(arg int, reply *int) error {
+	for atomic.LoadInt32(&w.stop) == 0 {
+		*reply++
+	}
+	return nil
+}
+
+func TestWatchdog(t *testing.T) {
+	logs, restore := logtest.Capture()
+	defer restore()
+
+	w := new(runawayWorker)
+	srv := server.NewServer(server.Server{WatchdogTeardown: true})
+	// the handler logs its response error, which mustn't race the logger restored.
+	defer waitGoroutines(t, srv, 0)
+	defer atomic.StoreInt32(&w.stop, 1)
+	srv.NamedRegister("runaway", w)
+	srv.SetWatchdog("/runaway/spin", 100*time.Millisecond)
+	c := newClient(client.Client{MaxTry: 1, CallTimeout: 5 * time.Second}, serve(t, srv))
+	defer c.Close()
+
+	start := time.Now()
+	var reply int
+	if e := c.Call("/runaway/spin", 0, &reply); e == nil {
+		t.Fatal("expect the torn down connection failing the call")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("the call failed after %v, not torn down by the watchdog", d)
+	}
+	out := logs.String()
+	if !strings.Contains(out, "/runaway/spin") || !strings.Contains(out, "runaway call exceeds 100ms") {
+		t.Fatalf("expect the runaway call logged, got:\n%s", out)
+	}
+	if !strings.Contains(out, "(*runawayWorker).Spin") {
+		t.Fatalf("expect the stack of the handler logged, got:\n%s", out)
+	}
+	if strings.Contains(out, "testing.tRunner") {
+		t.Fatalf("expect the stack of the handler only, got:\n%s", out)
+	}
+	if got := srv.ConfigSnapshot().Watchdogs["/runaway/spin"]; got != 100*time.Millisecond {
+		t.Fatalf("config watchdog = %v", got)
+	}
+}
+
+func TestProtocolVersions(t *testing.T) {
+	srv := server.NewServer(server.Server{ProtocolVersions: []byte{common.ProtocolVersion1}, DecompressRequests: true})
+	srv.NamedRegister("worker", &worker{name: "v1"})
+	addr := serve(t, srv)
+
+	for _, c := range []client.Client{
+		{ProtocolVersion: common.ProtocolVersion1},
+		{ProtocolVersion: common.ProtocolVersion1, ForceRequestCompression: compression.CompressFlate},
+		{}, // speaks version 1 without declaring it
+	} {
+		c.MaxTry = 1
+		cli := newClient(c, addr)
+		var reply string
+		if e := cli.Call("/worker/name", "hi", &reply); e != nil || reply != "v1: hi" {
+			t.Fatalf("%+v: reply=%q, err=%v", c, reply, e)
+		}
+		cli.Close()
+	}
+
+	// rejected at the handshake
+	cli := newClient(client.Client{ProtocolVersion: 9, MaxTry: 1}, addr)
+	defer cli.Close()
+	var reply string
+	e := cli.Call("/worker/name", "hi", &reply)
+	if e == nil || e.Type != common.ErrorTypeClientConnect || !strings.Contains(e.Error, "unsupported protocol version 9, the server speaks 1") {
+		t.Fatalf("expect the rejection of version 9, got: %v", e)
+	}
+
+	// the client without the declaration is rejected by its first call
+	srv2 := server.NewServer(server.Server{ProtocolVersions: []byte{2}})
+	srv2.NamedRegister("worker", &worker{name: "v2"})
+	legacy := newClient(client.Client{MaxTry: 1}, serve(t, srv2))
+	defer legacy.Close()
+	e = legacy.Call("/worker/name", "hi", &reply)
+	if e == nil || e.Type != common.ErrorTypeServerProtocolVersion {
+		t.Fatalf("expect the protocol version error, got: %v", e)
+	}
+}
+
+// TestProtocolVersionsWrapped checks the negotiation on the connection wrapped by the plugins,
+// and the version declared by the HTTP clients.
+func TestProtocolVersionsWrapped(t *testing.T) {
+	srv := server.NewServer(server.Server{ProtocolVersions: []byte{common.ProtocolVersion1}})
+	srv.PluginContainer.Add(compression.NewCompressionPlugin(compression.CompressFlate))
+	srv.NamedRegister("worker", &worker{name: "v1"})
+	addr := serve(t, srv)
+
+	for _, version := range []byte{0, common.ProtocolVersion1} {
+		c := newClient(client.Client{ProtocolVersion: version, MaxTry: 1}, addr)
+		c.PluginContainer.Add(compression.NewCompressionPlugin(compression.CompressFlate))
+		var reply string
+		if e := c.Call("/worker/name", "hi", &reply); e != nil || reply != "v1: hi" {
+			t.Fatalf("version %d: reply=%q, err=%v", version, reply, e)
+		}
+		c.Close()
+	}
+
+	httpSrv := server.NewServer(server.Server{ProtocolVersions: []byte{common.ProtocolVersion1}})
+	httpSrv.NamedRegister("worker", &worker{name: "http"})
+	httpLis := listen(t)
+	go httpSrv.ServeByMux(httpLis, http.NewServeMux())
+	for _, version := range []byte{0, common.ProtocolVersion1} {
+		c := client.NewClient(client.Client{ProtocolVersion: version, MaxTry: 1}, &selector.DirectSelector{
+			Network: "http",
+			Address: httpLis.Addr().String(),
+		})
+		var reply string
+		if e := c.Call("/worker/name", "hi", &reply); e != nil || reply != "http: hi" {
+			t.Fatalf("http version %d: reply=%q, err=%v", version, reply, e)
+		}
+		c.Close()
+	}
+
+	// the HTTP client is rejected at the CONNECT
+	c := client.NewClient(client.Client{ProtocolVersion: 9, MaxTry: 1}, &selector.DirectSelector{
+		Network: "http",
+		Address: httpLis.Addr().String(),
+	})
+	defer c.Close()
+	var reply string
+	e := c.Call("/worker/name", "hi", &reply)
+	if e == nil || e.Type != common.ErrorTypeClientConnect || !strings.Contains(e.Error, "unsupported protocol version 9, the server speaks 1") {
+		t.Fatalf("expect the rejection of version 9, got: %v", e)
+	}
+}
+
+type flaggedWorker struct {
+	runs int32
+}
+
+func (w *flaggedWorker) Experiment(ctx *server.Context, arg string, reply *string) error {
+	atomic.AddInt32(&w.runs, 1)
+	*reply = "experiment: " + arg
+	return nil
+}
+
+// countingArgPlugin counts the intercepted arguments.
+type countingArgPlugin struct {
+	namedPlugin
+	calls int32
+}
+
+func (p *countingArgPlugin) InterceptArg(*server.Context, reflect.Value) error {
+	atomic.AddInt32(&p.calls, 1)
+	return nil
+}
+
+func TestFeatureFlag(t *testing.T) {
+	srv := server.NewServer(server.Server{
+		FeatureFlag: func(ctx *server.Context, path string) bool {
+			return path != "/flagged/experiment" || ctx.Query().Get("user") != "bob"
+		},
+	})
+	intercepted := &countingArgPlugin{namedPlugin: "counting"}
+	srv.PluginContainer.Add(intercepted)
+	w := new(flaggedWorker)
+	srv.NamedRegister("flagged", w)
+	srv.NamedRegister("worker", &worker{name: "w"})
+	addr := serve(t, srv)
+
+	// a failed call closes the connection, so every identity has a client of its own
+	bob := newClient(client.Client{MaxTry: 1}, addr)
+	defer bob.Close()
+	var reply string
+	e := bob.Call("/flagged/experiment?user=bob", "x", &reply)
+	if e == nil || e.Type != common.ErrorTypeServerNotEnabled || !strings.Contains(e.Error, "not enabled") {
+		t.Fatalf("expect the disabled method rejected, got: %v", e)
+	}
+	if runs := atomic.LoadInt32(&w.runs); runs != 0 {
+		t.Fatalf("expect the disabled method not run, got %d runs", runs)
+	}
+	if calls := atomic.LoadInt32(&intercepted.calls); calls != 0 {
+		t.Fatalf("expect the argument of the disabled method not intercepted, got %d", calls)
+	}
+	if e = bob.Call("/worker/name?user=bob", "x", &reply); e != nil || reply != "w: x" {
+		t.Fatalf("expect the other methods enabled, got reply=%q, err=%v", reply, e)
+	}
+
+	alice := newClient(client.Client{MaxTry: 1}, addr)
+	defer alice.Close()
+	if e = alice.Call("/flagged/experiment?user=alice", "x", &reply); e != nil || reply != "experiment: x" {
+		t.Fatalf("expect the method enabled for the others, got reply=%q, err=%v", reply, e)
+	}
+}
+
+func TestNetworkInfo(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("worker", &worker{name: "w"})
+	if srv.Addr() != nil || srv.NetworkInfo() != (server.NetworkInfo{}) {
+		t.Fatal("expect no address before serving")
+	}
+	go srv.Serve("tcp4", ":0")
+	waitFor(t, "the listener", func() bool { return srv.Addr() != nil })
+
+	tcpAddr, ok := srv.Addr().(*net.TCPAddr)
+	if !ok || tcpAddr.Port == 0 {
+		t.Fatalf("addr: %#v", srv.Addr())
+	}
+	info := srv.NetworkInfo()
+	if info.Port != tcpAddr.Port || info.Network != "tcp4" || info.Family != server.FamilyIPv4 || info.Host != "0.0.0.0" {
+		t.Fatalf("network info: %+v, bound port %d", info, tcpAddr.Port)
+	}
+	c := newClient(client.Client{}, net.JoinHostPort("127.0.0.1", strconv.Itoa(info.Port)))
+	defer c.Close()
+	var reply string
+	if e := c.Call("/worker/name", "x", &reply); e != nil || reply != "w: x" {
+		t.Fatalf("reply=%q, err=%v", reply, e)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Addr() != nil || srv.NetworkInfo() != (server.NetworkInfo{}) || srv.Address() != "" {
+		t.Fatalf("expect no address after closing, got %v", srv.Addr())
+	}
+}
@@ -31,6 +31,25 @@ type (
 		// Call calls service method.
 		Call(argv reflect.Value, ctx *Context) (replyv reflect.Value, err error)
 	}
+
+	// ArgDecoder can be implemented by a service receiver to decode the arguments itself,
+	// for the types that don't fit the codec's reflection-based decode.
+	// The request body is read as []byte, so the client sends the raw bytes as the argument.
+	ArgDecoder interface {
+		DecodeArg(path string, body []byte) (interface{}, error)
+	}
+
+	// Idempotency can be implemented by a service receiver to declare its idempotent methods,
+	// which are safe to retry or hedge. It is queried with the method name at registration.
+	Idempotency interface {
+		Idempotent(method string) bool
+	}
+
+	// IIdempotent can be implemented by an IService to report whether it is idempotent,
+	// see Server.IsIdempotent. The services not implementing it are not idempotent.
+	IIdempotent interface {
+		IsIdempotent() bool
+	}
 )
 
 type (
@@ -44,6 +63,9 @@ type (
 		method          reflect.Method
 		ArgType         reflect.Type
 		ReplyType       reflect.Type
+		withContext     bool       // the first argument is *Context
+		argDecoder      ArgDecoder // the receiver decodes the argument itself
+		idempotent      bool
 		numCalls        uint
 		sync.Mutex      // protects counters
 		pluginContainer IServerPluginContainer
@@ -60,10 +82,14 @@ func NewNormServiceBuilder(uriFormat URIFormator) *NormServiceBuilder {
 func (b *NormServiceBuilder) NewServices(rcvr interface{}, pathSegment ...string) ([]IService, error) {
 	rcvrt := reflect.TypeOf(rcvr)
 	rcvrv := reflect.ValueOf(rcvr)
+	argDecoder, _ := rcvr.(ArgDecoder)
+	idempotency, _ := rcvr.(Idempotency)
 	var services []IService
 	for k, v := range b.suitableMethods(rcvrt, true) {
 		v.typ = rcvrt
 		v.rcvr = rcvrv
+		v.argDecoder = argDecoder
+		v.idempotent = idempotency != nil && idempotency.Idempotent(k)
 		v.path = b.URIEncode(nil, append(pathSegment, k)...)
 		services = append(services, v)
 	}
@@ -85,13 +111,26 @@ func (n *NormService) GetArgType() reflect.Type {
 	return n.ArgType
 }
 
+// IsIdempotent returns whether the receiver declares the method idempotent.
+func (n *NormService) IsIdempotent() bool {
+	return n.idempotent
+}
+
+func (n *NormService) getArgDecoder() ArgDecoder {
+	return n.argDecoder
+}
+
+func (n *NormService) getReplyType() reflect.Type {
+	return n.ReplyType
+}
+
 // // GetReplyType returns the receiver type of request body.
 // func (n *NormService) GetReplyType() reflect.Type {
 // 	return n.ReplyType
 // }
 
 // Call calls service method, and returns response result.
-func (n *NormService) Call(argv reflect.Value, _ *Context) (replyv reflect.Value, err error) {
+func (n *NormService) Call(argv reflect.Value, ctx *Context) (replyv reflect.Value, err error) {
 	n.Lock()
 	n.numCalls++
 	n.Unlock()
@@ -106,11 +145,17 @@ func (n *NormService) Call(argv reflect.Value, _ *Context) (replyv reflect.Value
 	}
 	if replyIsValue {
 		replyv = replyv.Elem()
+	} else {
+		makeMap(replyv)
 	}
 
 	function := n.method.Func
 	// Invoke the method, providing a new value for the reply.
-	returnValues := function.Call([]reflect.Value{n.rcvr, argv, replyv})
+	in := []reflect.Value{n.rcvr, argv, replyv}
+	if n.withContext {
+		in = []reflect.Value{n.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := function.Call(in)
 	// The return value for the method is an error.
 	errInter := returnValues[0].Interface()
 	if errInter != nil {
@@ -119,6 +164,15 @@ func (n *NormService) Call(argv reflect.Value, _ *Context) (replyv reflect.Value
 	return replyv, nil
 }
 
+// makeMap makes the nil map v points to, so that the methods can fill the maps without defined structs,
+// e.g. the map[string]interface{} of the schema-less JSON, like net/rpc does with the replies.
+// The nil slices are left, e.g. a json.RawMessage, which is null when not set.
+func makeMap(v reflect.Value) {
+	if v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Kind() == reflect.Map && v.Elem().IsNil() {
+		v.Elem().Set(reflect.MakeMap(v.Elem().Type()))
+	}
+}
+
 // GetPath returns the name of service
 func (n *NormService) GetPath() string {
 	return n.path
@@ -144,6 +198,8 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 // because Typeof takes an empty interface value. This is annoying.
 var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
 
+var typeOfContext = reflect.TypeOf((*Context)(nil))
+
 // suitableMethods returns suitable Rpc methods of typ, it will report
 // error using log if reportErr is true.
 func (*NormServiceBuilder) suitableMethods(typ reflect.Type, reportErr bool) map[string]*NormService {
@@ -156,15 +212,20 @@ func (*NormServiceBuilder) suitableMethods(typ reflect.Type, reportErr bool) map
 		if method.PkgPath != "" {
 			continue
 		}
-		// Method needs three ins: receiver, *args, *reply.
-		if mtype.NumIn() != 3 {
+		// Method needs three ins: receiver, *args, *reply,
+		// or four ins: receiver, *Context, *args, *reply.
+		var offset int
+		if mtype.NumIn() == 4 && mtype.In(1) == typeOfContext {
+			offset = 1
+		}
+		if mtype.NumIn() != 3+offset {
 			if reportErr {
 				// log.Notice("rpc: method", mname, "has wrong number of ins:", mtype.NumIn())
 			}
 			continue
 		}
 		// First arg need not be a pointer.
-		argType := mtype.In(1)
+		argType := mtype.In(1 + offset)
 		if !isExportedOrBuiltinType(argType) {
 			if reportErr {
 				// log.Notice("rpc:", mname, "argument type not exported:", argType)
@@ -172,7 +233,7 @@ func (*NormServiceBuilder) suitableMethods(typ reflect.Type, reportErr bool) map
 			continue
 		}
 		// Second arg must be a pointer.
-		replyType := mtype.In(2)
+		replyType := mtype.In(2 + offset)
 		if replyType.Kind() != reflect.Ptr {
 			if reportErr {
 				// log.Notice("rpc: method", mname, "reply type not a pointer:", replyType)
@@ -200,7 +261,7 @@ func (*NormServiceBuilder) suitableMethods(typ reflect.Type, reportErr bool) map
 			}
 			continue
 		}
-		methods[mname] = &NormService{method: method, ArgType: argType, ReplyType: replyType}
+		methods[mname] = &NormService{method: method, ArgType: argType, ReplyType: replyType, withContext: offset == 1}
 	}
 	return methods
 }
@@ -0,0 +1,28 @@
+package server
+
+type shardKey struct{}
+
+// SetShard labels the connection with the shard of the server-side state it belongs to,
+// e.g. by a PostConnAccept plugin from the TLS identity, see Server.Shard.
+func SetShard(conn ServerCodecConn, shard string) {
+	conn.SetValue(shardKey{}, shard)
+}
+
+// GetShard returns the shard label of the connection, empty if not labeled.
+func GetShard(conn ServerCodecConn) string {
+	shard, _ := conn.GetValue(shardKey{}).(string)
+	return shard
+}
+
+// wrongShardMsg is the error of the connection of the other shard, see Server.Shard.
+func wrongShardMsg(want, got string) string {
+	if got == "" {
+		return "connection is not labeled with shard " + want
+	}
+	return "connection of shard " + got + " is not served by shard " + want
+}
+
+// Shard returns the shard label of the connection, see SetShard.
+func (ctx *Context) Shard() string {
+	return GetShard(ctx.codecConn)
+}
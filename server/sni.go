@@ -0,0 +1,58 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/henrylee2cn/myrpc/log"
+)
+
+// HandleSNI routes the TLS connections whose SNI server name is serverName to the tenant server,
+// so that one TLS listener can serve different service sets.
+// The connections that match no server name are served by the server itself.
+func (server *Server) HandleSNI(serverName string, tenant *Server) {
+	server.mu.Lock()
+	if server.sniServers == nil {
+		server.sniServers = make(map[string]*Server)
+	}
+	server.sniServers[strings.ToLower(serverName)] = tenant
+	server.mu.Unlock()
+
+	// the tenant serves connections accepted by this server.
+	tenant.mu.Lock()
+	tenant.running = true
+	tenant.mu.Unlock()
+}
+
+func (server *Server) hasSNI() bool {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return len(server.sniServers) > 0
+}
+
+// serveSNI completes the TLS handshake and serves the connection by the server matching its SNI.
+func (server *Server) serveSNI(conn ServerCodecConn) {
+	err := server.handshake(conn)
+	state, ok := conn.ConnectionState()
+	if err != nil || !ok || !state.HandshakeComplete {
+		log.Debugf("rpc: TLS handshake with %s failed", conn.RemoteAddr().String())
+		conn.Close()
+		return
+	}
+	server.mu.RLock()
+	tenant, ok := server.sniServers[strings.ToLower(state.ServerName)]
+	server.mu.RUnlock()
+	if !ok {
+		tenant = server
+	}
+	if err := server.PluginContainer.doPostConnAccept(conn, server.PluginPanicPolicy); err != nil {
+		log.Debugf("rpc: PostConnAccept: %s", err.Error())
+		return
+	}
+	if tenant != server {
+		if err := tenant.PluginContainer.doPostConnAccept(conn, tenant.PluginPanicPolicy); err != nil {
+			log.Debugf("rpc: PostConnAccept: %s", err.Error())
+			return
+		}
+	}
+	tenant.ServeConn(conn)
+}
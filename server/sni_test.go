@@ -0,0 +1,173 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// selfSignedCert creates a certificate for the dns names.
+func selfSignedCert(t *testing.T, dnsNames ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestHandleSNI(t *testing.T) {
+	tenantA := server.NewServer(server.Server{})
+	tenantA.NamedRegister("worker", &worker{name: "A"})
+	tenantB := server.NewServer(server.Server{})
+	tenantB.NamedRegister("worker", &worker{name: "B"})
+
+	srv := server.NewServer(server.Server{})
+	srv.HandleSNI("a.example.com", tenantA)
+	srv.HandleSNI("b.example.com", tenantB)
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t, "a.example.com", "b.example.com")},
+	}
+	lis := tls.NewListener(listen(t), config)
+	go srv.ServeListener(lis)
+	addr := lis.Addr().String()
+
+	for serverName, want := range map[string]string{
+		"a.example.com": "A: x",
+		"b.example.com": "B: x",
+	} {
+		c := newClient(client.Client{
+			TLSConfig: &tls.Config{ServerName: serverName, InsecureSkipVerify: true},
+		}, addr)
+		var reply string
+		if e := c.Call("/worker/name", "x", &reply); e != nil {
+			t.Fatalf("%s: %v", serverName, e.Error)
+		}
+		if reply != want {
+			t.Fatalf("%s: expect %q, got %q", serverName, want, reply)
+		}
+		c.Close()
+	}
+}
+
+type commonNameKey struct{}
+
+// commonNamePlugin stashes the common name of the client certificate in the connection.
+type commonNamePlugin struct{ namedPlugin }
+
+func (commonNamePlugin) PostConnAccept(conn server.ServerCodecConn) error {
+	netConn := conn.NetConn()
+	if _, err := netConn.Read(make([]byte, 1)); err == nil {
+		return errors.New("expect the guarded connection not readable")
+	}
+	tlsConn, ok := netConn.(interface {
+		ConnectionState() tls.ConnectionState
+	})
+	if !ok {
+		return errors.New("not a TLS connection")
+	}
+	if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		conn.SetValue(commonNameKey{}, certs[0].Subject.CommonName)
+	}
+	return nil
+}
+
+type whoami struct{}
+
+func (*whoami) Get(ctx *server.Context, arg string, reply *string) error {
+	*reply, _ = ctx.Conn().GetValue(commonNameKey{}).(string)
+	return nil
+}
+
+func TestNetConn(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.PluginContainer.Add(commonNamePlugin{"commonName"})
+	srv.NamedRegister("whoami", new(whoami))
+	config := &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t, "server.example.com")},
+		ClientAuth:   tls.RequireAnyClientCert,
+	}
+	lis := tls.NewListener(listen(t), config)
+	go srv.ServeListener(lis)
+
+	c := newClient(client.Client{
+		TLSConfig: &tls.Config{
+			Certificates:       []tls.Certificate{selfSignedCert(t, "alice.example.com")},
+			InsecureSkipVerify: true,
+		},
+	}, lis.Addr().String())
+	defer c.Close()
+	var reply string
+	if e := c.Call("/whoami/get", "", &reply); e != nil || reply != "alice.example.com" {
+		t.Fatalf("reply=%q, err=%v", reply, e)
+	}
+}
+
+func TestSlowHandshake(t *testing.T) {
+	srv := server.NewServer(server.Server{HeaderTimeout: time.Second})
+	srv.PluginContainer.Add(commonNamePlugin{"commonName"})
+	srv.NamedRegister("whoami", new(whoami))
+	config := &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t, "server.example.com")},
+		ClientAuth:   tls.RequireAnyClientCert,
+	}
+	lis := tls.NewListener(listen(t), config)
+	go srv.ServeListener(lis)
+
+	// a client that never starts the handshake doesn't hold up the others
+	slow, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	c := newClient(client.Client{
+		TLSConfig: &tls.Config{
+			Certificates:       []tls.Certificate{selfSignedCert(t, "alice.example.com")},
+			InsecureSkipVerify: true,
+		},
+		CallTimeout: 500 * time.Millisecond,
+	}, lis.Addr().String())
+	defer c.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var reply string
+		if e := c.Call("/whoami/get", "", &reply); e != nil || reply != "alice.example.com" {
+			t.Errorf("reply=%q, err=%v", reply, e)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow handshake holds up the other clients")
+	}
+
+	// and it is closed after the HeaderTimeout
+	slow.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := slow.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect the slow client closed, got: %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/rpc"
+	"reflect"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// streamChunkSize is the maximum size of the chunks of a streamed reply.
+const streamChunkSize = 32 << 10
+
+var errStreamUnsupported = errors.New("the client doesn't support streaming the reply")
+
+// ErrStreamCancelled is returned by Stream.Send after the client cancels the stream,
+// see client.CallStreamToContext.
+var ErrStreamCancelled = errors.New("stream cancelled")
+
+var readerPtrType = reflect.TypeOf((*io.Reader)(nil))
+
+type (
+	// Stream is the reply of a service streamed chunk by chunk as the service produces it,
+	// which the service sends from a goroutine of its own after it returns, e.g.
+	//
+	//	func (*Feed) Watch(ctx *server.Context, topic string, reply *io.Reader) error {
+	//		stream := server.NewStream()
+	//		done := ctx.Context().Done() // the ctx is not available after the service returns
+	//		go func() {
+	//			for {
+	//				select {
+	//				case event := <-events(topic):
+	//					if stream.Send(event) != nil {
+	//						return // e.g. ErrStreamCancelled
+	//					}
+	//				case <-done:
+	//					stream.Finish(nil)
+	//					return
+	//				}
+	//			}
+	//		}()
+	//		*reply = stream
+	//		return nil
+	//	}
+	//
+	// The ctx.Context() of the call is canceled with CancelStream when the client cancels the stream.
+	Stream struct {
+		r *io.PipeReader
+		w *io.PipeWriter
+	}
+
+	// streamCalls are the cancel funcs of the calls of a connection streaming the replies, by the seq.
+	streamCalls struct {
+		lock    sync.Mutex
+		cancels map[uint64]context.CancelCauseFunc
+	}
+)
+
+// NewStream creates a Stream.
+func NewStream() *Stream {
+	r, w := io.Pipe()
+	return &Stream{r: r, w: w}
+}
+
+// Read reads the sent chunks, it is called by the server.
+func (s *Stream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+// Send sends the chunk to the client, blocking until the server takes it.
+// It returns ErrStreamCancelled after the client cancels the stream.
+func (s *Stream) Send(chunk []byte) error {
+	_, err := s.w.Write(chunk)
+	return err
+}
+
+// Finish ends the stream, nil err means the stream is complete, otherwise the call fails with err.
+func (s *Stream) Finish(err error) {
+	s.w.CloseWithError(err)
+}
+
+// readCanceler returns the func that makes the pending and the next reads of r fail with an error,
+// nil if r can't be canceled.
+func readCanceler(r io.Reader) func(error) {
+	switch r := r.(type) {
+	case *Stream:
+		return func(err error) { r.r.CloseWithError(err) }
+	case *io.PipeReader:
+		return func(err error) { r.CloseWithError(err) }
+	}
+	return nil
+}
+
+func (s *streamCalls) add(seq uint64, cancel context.CancelCauseFunc) {
+	s.lock.Lock()
+	if s.cancels == nil {
+		s.cancels = make(map[uint64]context.CancelCauseFunc)
+	}
+	s.cancels[seq] = cancel
+	s.lock.Unlock()
+}
+
+func (s *streamCalls) remove(seq uint64) {
+	s.lock.Lock()
+	delete(s.cancels, seq)
+	s.lock.Unlock()
+}
+
+func (s *streamCalls) cancel(seq uint64) {
+	s.lock.Lock()
+	cancel := s.cancels[seq]
+	s.lock.Unlock()
+	if cancel != nil {
+		cancel(errCanceledStream)
+	}
+}
+
+// isStreamed returns whether the service streams the reply, declaring it as *io.Reader.
+func isStreamed(service IService) bool {
+	s, ok := service.(interface{ getReplyType() reflect.Type })
+	return ok && s.getReplyType() == readerPtrType
+}
+
+// trackStream makes the ctx.Context() of the call streaming the reply cancelable by the client.
+func (ctx *Context) trackStream() {
+	if ctx.streams == nil || !isStreamed(ctx.service) {
+		return
+	}
+	values, cancel := context.WithCancelCause(ctx.Context())
+	ctx.Lock()
+	ctx.values = values
+	ctx.cancelStream = cancel
+	ctx.Unlock()
+	ctx.streams.add(ctx.req.Seq, cancel)
+}
+
+// untrackStream releases the tracking of trackStream after the call completes.
+func (ctx *Context) untrackStream() {
+	if ctx.cancelStream == nil {
+		return
+	}
+	ctx.streams.remove(ctx.req.Seq)
+	ctx.cancelStream(nil)
+}
+
+// streamedReply returns the reader of the reply of the service declaring the reply as *io.Reader,
+// ok is false for the other replies. The reader is nil if the service doesn't set it.
+func streamedReply(reply interface{}) (r io.Reader, ok bool) {
+	p, ok := reply.(*io.Reader)
+	if !ok || p == nil {
+		return nil, ok
+	}
+	return *p, true
+}
+
+// streamReply sends the reader as the chunk responses of the call ahead of the final response,
+// which the client copies to its writer, see client.CallStreamTo. The reader is closed
+// if it is an io.Closer. Note the reply must not be cached or coalesced, as it is read once.
+// The reading of a Stream or an *io.PipeReader stops once the ctx.Context() is canceled,
+// e.g. by the client canceling the stream.
+func (ctx *Context) streamReply(r io.Reader) (err error) {
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	if !ctx.codecConn.Supports(common.FeatureStreamReply) {
+		return errStreamUnsupported
+	}
+	if r == nil {
+		return nil
+	}
+	if cancelRead := readCanceler(r); cancelRead != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		values := ctx.Context()
+		go func() {
+			select {
+			case <-values.Done():
+				if context.Cause(values) == errCanceledStream {
+					cancelRead(ErrStreamCancelled)
+				} else {
+					cancelRead(context.Cause(values))
+				}
+			case <-stop:
+			}
+		}()
+		defer func() {
+			if err != nil {
+				// the producer stops sending.
+				cancelRead(err)
+			}
+		}()
+	}
+	resp := &rpc.Response{
+		ServiceMethod: common.EncodeChunk(ctx.Path()),
+		Seq:           ctx.req.Seq,
+	}
+	buf := make([]byte, streamChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			ctx.sending.Lock()
+			werr := ctx.codecConn.WriteResponse(resp, buf[:n])
+			if werr != nil {
+				ctx.breakConn()
+			}
+			ctx.sending.Unlock()
+			if werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// Validate checks the configuration before serving, and returns a *common.MultiError
+// describing all the problems found, or nil. It is optional to call, e.g.
+//
+//	if err := srv.Validate(); err != nil {
+//		log.Fatal(err.Error())
+//	}
+//	srv.Serve("tcp", addr)
+func (server *Server) Validate() error {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	var errs []error
+	if server.ServerCodecFunc == nil {
+		errs = append(errs, fmt.Errorf("rpc: ServerCodecFunc is not set"))
+	}
+	if server.ServiceBuilder == nil {
+		errs = append(errs, fmt.Errorf("rpc: ServiceBuilder is not set"))
+	}
+	if server.PluginContainer == nil {
+		errs = append(errs, fmt.Errorf("rpc: PluginContainer is not set"))
+	}
+	for _, d := range []struct {
+		name  string
+		value int64
+	}{
+		{"Timeout", int64(server.Timeout)},
+		{"ReadTimeout", int64(server.ReadTimeout)},
+		{"WriteTimeout", int64(server.WriteTimeout)},
+		{"IdleTimeout", int64(server.IdleTimeout)},
+		{"HeaderTimeout", int64(server.HeaderTimeout)},
+		{"Workers", int64(server.Workers)},
+		{"MaxQueuedCalls", int64(server.MaxQueuedCalls)},
+		{"MaxPendingResponses", int64(server.MaxPendingResponses)},
+		{"MaxHops", int64(server.MaxHops)},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("rpc: %s is negative", d.name))
+		}
+	}
+
+	seen := make(map[string]bool, len(server.routers))
+	for _, path := range server.routers {
+		if seen[path] {
+			errs = append(errs, fmt.Errorf("rpc: router '%s' is registered more than once", path))
+		}
+		seen[path] = true
+	}
+
+	paths := make([]string, 0, len(server.serviceMap))
+	for path := range server.serviceMap {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	invalid := make(map[string]bool)
+	for _, path := range paths {
+		p := server.serviceMap[path].GetPluginContainer()
+		if p == nil {
+			continue
+		}
+		for _, plugin := range p.GetAll() {
+			for _, hook := range connPluginHooks(plugin) {
+				// the plugins of a group are shared by its services, report once.
+				if key := hook + "\x00" + plugin.Name(); !invalid[key] {
+					invalid[key] = true
+					errs = append(errs, fmt.Errorf("rpc: '%s()' of '%s' plugin in the group of '%s' is invalid", hook, plugin.Name(), path))
+				}
+			}
+		}
+	}
+
+	var unknown []string
+	for path := range server.timeouts {
+		if server.serviceMap[path] == nil {
+			unknown = append(unknown, path)
+		}
+	}
+	sort.Strings(unknown)
+	for _, path := range unknown {
+		errs = append(errs, fmt.Errorf("rpc: call timeout is set for unregistered service '%s'", path))
+	}
+	unknown = unknown[:0]
+	for urlPath, path := range server.httpMappings {
+		if server.serviceMap[path] == nil {
+			unknown = append(unknown, urlPath)
+		}
+	}
+	sort.Strings(unknown)
+	for _, urlPath := range unknown {
+		errs = append(errs, fmt.Errorf("rpc: gateway '%s' is mapped to unregistered service '%s'", urlPath, server.httpMappings[urlPath]))
+	}
+
+	if len(errs) > 0 {
+		return common.NewMultiError(errs)
+	}
+	return nil
+}
+
+// connPluginHooks returns the hooks of the plugin that are not called for the services of a group.
+func connPluginHooks(plugin IPlugin) []string {
+	var hooks []string
+	if _, ok := plugin.(IPostConnAcceptPlugin); ok {
+		hooks = append(hooks, "PostConnAccept")
+	}
+	if _, ok := plugin.(IPreReadRequestHeaderPlugin); ok {
+		hooks = append(hooks, "PreReadRequestHeader")
+	}
+	if _, ok := plugin.(IPostReadRequestHeaderPlugin); ok {
+		hooks = append(hooks, "PostReadRequestHeader")
+	}
+	return hooks
+}
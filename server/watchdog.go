@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/log"
+)
+
+// SetWatchdog flags the service path as interruptible by the watchdog: the stack of its call
+// running longer than the limit is captured and logged, e.g. of a handler stuck in a tight loop
+// ignoring the cancellation of ctx.Context(), which the call timeout can't stop.
+// The call keeps running, for Go can't preempt it; see WatchdogTeardown to isolate its connection.
+// Zero removes the flag.
+func (server *Server) SetWatchdog(servicePath string, limit time.Duration) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.updateWatchdogs(func(limits map[string]time.Duration) {
+		if limit > 0 {
+			limits[servicePath] = limit
+		} else {
+			delete(limits, servicePath)
+		}
+	})
+}
+
+// updateWatchdogs replaces the limits with a copy updated by fn, with server.mu held,
+// so that the calls read the limits without locking.
+func (server *Server) updateWatchdogs(fn func(limits map[string]time.Duration)) {
+	old := server.watchdogLimits()
+	limits := make(map[string]time.Duration, len(old)+1)
+	for path, limit := range old {
+		limits[path] = limit
+	}
+	fn(limits)
+	server.watchdogs.Store(limits)
+}
+
+// watchdogLimits returns the limits of the flagged service paths, which must not be modified.
+func (server *Server) watchdogLimits() map[string]time.Duration {
+	limits, _ := server.watchdogs.Load().(map[string]time.Duration)
+	return limits
+}
+
+// watch starts the watchdog of the call running on the current goroutine, and returns
+// the function stopping it when the call returns, nil if the path isn't flagged.
+// The goroutine is labeled for the watchdog to find its stack in the goroutine profile.
+func (server *Server) watch(ctx *Context) (stop func()) {
+	path := ctx.service.GetPath()
+	limit := server.watchdogLimits()[path]
+	if limit <= 0 {
+		return nil
+	}
+	id := strconv.FormatUint(atomic.AddUint64(&watchSeq, 1), 10)
+	pprof.SetGoroutineLabels(pprof.WithLabels(ctx.Context(), pprof.Labels(watchdogLabel, id)))
+	conn := ctx.codecConn
+	remote := ctx.RemoteAddr()
+	timer := time.AfterFunc(limit, func() {
+		log.Criticalf("rpc: (%s) from %s: runaway call exceeds %s\n[RUNAWAY]\n%s\n", path, remote, limit, labeledStack(id))
+		if server.WatchdogTeardown {
+			conn.Close()
+		}
+	})
+	return func() {
+		timer.Stop()
+		pprof.SetGoroutineLabels(ctx.Context())
+	}
+}
+
+// watchdogLabel is the pprof label of the goroutines of the watched calls, see watch.
+const watchdogLabel = "myrpc_watchdog"
+
+var watchSeq uint64 // the ids of the watched calls
+
+// labeledStack returns the stack of the goroutines of the watched call of the id, and of the
+// goroutines they started, from the goroutine profile grouping the goroutines by stack and labels.
+func labeledStack(id string) []byte {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return []byte("no goroutine profile: " + err.Error())
+	}
+	label := []byte("\"" + watchdogLabel + "\":\"" + id + "\"")
+	var stacks [][]byte
+	for _, group := range bytes.Split(buf.Bytes(), []byte("\n\n")) {
+		if bytes.Contains(group, label) {
+			stacks = append(stacks, group)
+		}
+	}
+	if len(stacks) == 0 {
+		return []byte("the goroutine of the call not found")
+	}
+	return bytes.Join(stacks, []byte("\n\n"))
+}
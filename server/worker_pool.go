@@ -0,0 +1,161 @@
+package server
+
+import (
+	"container/heap"
+	"strconv"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/common"
+)
+
+// DefaultQueuedCallsPerWorker is the MaxQueuedCalls per worker when it is 0.
+const DefaultQueuedCallsPerWorker = 64
+
+type (
+	// workerPool runs the calls on a number of goroutines, the pending calls
+	// of higher priority first, then in arrival order. The workers are started
+	// on the first use, and exit when the size shrinks or is 0, the latter after
+	// draining the queue.
+	workerPool struct {
+		lock    sync.Mutex
+		cond    sync.Cond
+		queue   taskQueue
+		seq     uint64
+		queued  int // the admitted calls not taken by a worker yet
+		size    int // the wanted workers
+		running int // the running workers
+	}
+
+	poolTask struct {
+		priority common.Priority
+		seq      uint64
+		fn       func()
+	}
+
+	taskQueue []*poolTask
+)
+
+// admit reserves the room of a call in the queue, false if max calls are queued already.
+func (pool *workerPool) admit(max int) bool {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	if pool.queued >= max {
+		return false
+	}
+	pool.queued++
+	return true
+}
+
+// submit queues the admitted fn with the priority.
+func (pool *workerPool) submit(priority common.Priority, fn func()) {
+	pool.lock.Lock()
+	pool.seq++
+	heap.Push(&pool.queue, &poolTask{priority: priority, seq: pool.seq, fn: fn})
+	pool.lock.Unlock()
+	pool.cond.Signal()
+}
+
+// resize starts or stops the workers to the size, 0 lets them exit once the queue is drained.
+func (pool *workerPool) resize(size int) {
+	pool.lock.Lock()
+	if pool.cond.L == nil {
+		pool.cond.L = &pool.lock
+	}
+	pool.size = size
+	for pool.running < pool.size {
+		pool.running++
+		go pool.work()
+	}
+	pool.lock.Unlock()
+	pool.cond.Broadcast()
+}
+
+// start starts the workers to the size if the pool is stopped, e.g. not started yet.
+func (pool *workerPool) start(size int) {
+	pool.lock.Lock()
+	stopped := pool.size == 0
+	pool.lock.Unlock()
+	if stopped {
+		pool.resize(size)
+	}
+}
+
+func (pool *workerPool) work() {
+	pool.lock.Lock()
+	for {
+		for pool.queue.Len() == 0 && pool.running <= pool.size {
+			pool.cond.Wait()
+		}
+		// the surplus workers exit, the last ones of a stopped pool drain the queue first.
+		if pool.running > pool.size && (pool.size > 0 || pool.queue.Len() == 0) {
+			pool.running--
+			pool.lock.Unlock()
+			return
+		}
+		task := heap.Pop(&pool.queue).(*poolTask)
+		pool.queued--
+		pool.lock.Unlock()
+		task.fn()
+		pool.lock.Lock()
+	}
+}
+
+// SetWorkers changes the Workers while serving, see Server.Workers.
+func (server *Server) SetWorkers(workers int) {
+	server.mu.Lock()
+	server.Workers = workers
+	server.mu.Unlock()
+	server.workerPool.resize(workers)
+}
+
+// workers returns the Workers, and starts them on the first use.
+func (server *Server) workers() int {
+	server.mu.RLock()
+	workers := server.Workers
+	server.mu.RUnlock()
+	if workers > 0 {
+		server.workerPool.start(workers)
+	}
+	return workers
+}
+
+// QueuedCalls returns the number of the calls waiting for the Workers.
+func (server *Server) QueuedCalls() int {
+	server.workerPool.lock.Lock()
+	defer server.workerPool.lock.Unlock()
+	return server.workerPool.queued
+}
+
+// maxQueuedCalls returns the limit of the calls waiting for the workers.
+func (server *Server) maxQueuedCalls(workers int) int {
+	if server.MaxQueuedCalls > 0 {
+		return server.MaxQueuedCalls
+	}
+	return workers * DefaultQueuedCallsPerWorker
+}
+
+func workersBusy(max int) error {
+	return common.NewError("server busy: " + strconv.Itoa(max) + " calls waiting for the Workers (MaxQueuedCalls)")
+}
+
+func (q taskQueue) Len() int { return len(q) }
+
+func (q taskQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q taskQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *taskQueue) Push(x interface{}) { *q = append(*q, x.(*poolTask)) }
+
+func (q *taskQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	task := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return task
+}
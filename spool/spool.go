@@ -0,0 +1,379 @@
+// Package spool delivers the notifications at least once, across the restarts of the client.
+//
+// The client writes every notification to a directory first, and a background worker
+// calls the server with them in order, removing each only after the server acknowledges it,
+// i.e. the call returns the acknowledgment without error. The failed deliveries are retried
+// until they succeed.
+// The server side Service skips the notifications it has handled, e.g. redelivered
+// after a lost acknowledgment, so that each is handled once within its TTL.
+//
+// Server side:
+//
+//	srv.NamedRegister("notify", spool.NewService(func(msg spool.Message) error {
+//		// handle msg.Data
+//		return nil
+//	}))
+//
+// Client side:
+//
+//	s, err := spool.Open(c, "/notify", "/var/spool/notify")
+//	s.Start()
+//	defer s.Stop()
+//	id, err := s.Enqueue(data)
+package spool
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+)
+
+const (
+	fileExt = ".msg"
+	// idFile keeps the identity of the spool of the directory, prefixing the IDs.
+	idFile = ".spool-id"
+	// seqFile keeps the sequence number of the last enqueued notification.
+	seqFile = ".spool-seq"
+)
+
+// DefaultTTL is the default TTL of a Service.
+const DefaultTTL = 24 * time.Hour
+
+var (
+	// errInProgress rejects a redelivery while the notification is being handled.
+	errInProgress = errors.New("spool: the notification is being handled")
+	// errNotAcknowledged fails a delivery the server returned without acknowledging.
+	errNotAcknowledged = errors.New("spool: the notification is not acknowledged")
+)
+
+type (
+	// Message is a spooled notification.
+	Message struct {
+		// ID identifies the notification across the redeliveries: the identity of the spool
+		// and the sequence number of the notification, in the order of the enqueues.
+		ID   string
+		Data []byte
+	}
+
+	// Service handles the notifications of a Spool, skipping the redelivered ones.
+	// Its method is served as "<path>/deliver".
+	Service struct {
+		// TTL is how long a handled notification is remembered, DefaultTTL if zero.
+		// It should exceed the time a notification can be redelivered, e.g. the downtime
+		// of the client.
+		TTL       time.Duration
+		lock      sync.Mutex
+		seen      map[string]time.Time // ID -> handled time, zero while being handled
+		nextSweep time.Time
+		handle    func(msg Message) error
+	}
+
+	// Spool writes the notifications to a directory and delivers them to a Service.
+	Spool struct {
+		Client *client.Client
+		// Path is the registered path of the Service, e.g. "/notify".
+		Path string
+		// Dir is the directory of the undelivered notifications.
+		Dir string
+		// RetryInterval is the wait after a failed delivery, 1s if zero.
+		RetryInterval time.Duration
+
+		lock    sync.Mutex // protects the worker
+		stop    chan struct{}
+		done    chan struct{}
+		wake    chan struct{}
+		id      string
+		seqLock sync.Mutex // protects seq and its file
+		seq     uint64
+	}
+)
+
+// NewService creates a Service, handle is called with every notification not handled yet.
+// A notification whose handle returns an error is not acknowledged and will be redelivered.
+func NewService(handle func(msg Message) error) *Service {
+	return &Service{
+		seen:   make(map[string]time.Time),
+		handle: handle,
+	}
+}
+
+// Deliver handles the notification unless it is handled already, and acknowledges it.
+// The notifications are handled concurrently, but a redelivery is rejected while
+// the same notification is being handled.
+func (s *Service) Deliver(msg Message, ack *bool) error {
+	now := time.Now()
+	s.lock.Lock()
+	s.sweep(now)
+	handled, ok := s.seen[msg.ID]
+	if ok && handled.IsZero() {
+		s.lock.Unlock()
+		return errInProgress
+	}
+	if !ok {
+		s.seen[msg.ID] = time.Time{}
+	}
+	s.lock.Unlock()
+	if !ok {
+		err := s.handle(msg)
+		s.lock.Lock()
+		if err != nil {
+			delete(s.seen, msg.ID)
+		} else {
+			s.seen[msg.ID] = time.Now()
+		}
+		s.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	*ack = true
+	return nil
+}
+
+// sweep forgets the notifications handled longer than the TTL ago, at most every tenth of the TTL.
+func (s *Service) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	s.nextSweep = now.Add(ttl / 10)
+	for id, handled := range s.seen {
+		if !handled.IsZero() && now.Sub(handled) > ttl {
+			delete(s.seen, id)
+		}
+	}
+}
+
+// Forget forgets the handled notification, e.g. after the ID can't be redelivered anymore.
+func (s *Service) Forget(id string) {
+	s.lock.Lock()
+	delete(s.seen, id)
+	s.lock.Unlock()
+}
+
+// Open opens the spool of the directory, creating it if not exists.
+// The notifications left by a previous spool of the directory are delivered after Start.
+func Open(c *client.Client, path, dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		Client: c,
+		Path:   path,
+		Dir:    dir,
+		wake:   make(chan struct{}, 1),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the identity and the sequence number of the spool of the directory,
+// creating the identity at random if not exists.
+func (s *Spool) load() error {
+	b, err := ioutil.ReadFile(filepath.Join(s.Dir, idFile))
+	switch {
+	case err == nil:
+		s.id = strings.TrimSpace(string(b))
+	case os.IsNotExist(err):
+		var r [8]byte
+		if _, err = rand.Read(r[:]); err != nil {
+			return err
+		}
+		s.id = hex.EncodeToString(r[:])
+		if err = writeFile(s.Dir, idFile, []byte(s.id)); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+	b, err = ioutil.ReadFile(filepath.Join(s.Dir, seqFile))
+	if err == nil {
+		s.seq, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeFile writes the file of the directory atomically and durably.
+func writeFile(dir, name string, data []byte) error {
+	tmp, err := ioutil.TempFile(dir, ".write-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// the renamed file is complete, the readers never see a partial one.
+		err = os.Rename(tmp.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// nextID returns the ID of the next notification, saving its sequence number first
+// so that the IDs keep increasing across the restarts.
+func (s *Spool) nextID() (string, error) {
+	s.seqLock.Lock()
+	defer s.seqLock.Unlock()
+	seq := s.seq + 1
+	if err := writeFile(s.Dir, seqFile, []byte(strconv.FormatUint(seq, 10))); err != nil {
+		return "", err
+	}
+	s.seq = seq
+	return fmt.Sprintf("%s-%016x", s.id, seq), nil
+}
+
+// Enqueue writes the notification to the directory and returns its ID.
+// It is delivered by the worker, see Start.
+func (s *Spool) Enqueue(data []byte) (string, error) {
+	id, err := s.nextID()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = gob.NewEncoder(&buf).Encode(&Message{ID: id, Data: data}); err != nil {
+		return "", err
+	}
+	if err = writeFile(s.Dir, id+fileExt, buf.Bytes()); err != nil {
+		return "", err
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+// Pending returns the IDs of the undelivered notifications in order.
+func (s *Spool) Pending() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, info := range infos {
+		if name := info.Name(); strings.HasSuffix(name, fileExt) && !info.IsDir() {
+			ids = append(ids, strings.TrimSuffix(name, fileExt))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Start starts the worker delivering the notifications, if not started.
+func (s *Spool) Start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.work(s.stop, s.done)
+}
+
+// Stop stops the worker after the delivery in progress. The undelivered notifications stay
+// in the directory.
+func (s *Spool) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop, s.done = nil, nil
+}
+
+func (s *Spool) work(stop, done chan struct{}) {
+	defer close(done)
+	retryInterval := s.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = time.Second
+	}
+	for {
+		wait := time.Duration(-1)
+		if err := s.deliverAll(stop); err != nil {
+			wait = retryInterval
+		}
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timeout = time.After(wait)
+		}
+		select {
+		case <-stop:
+			return
+		case <-s.wake:
+		case <-timeout:
+		}
+	}
+}
+
+// deliverAll delivers the pending notifications in order until one fails.
+func (s *Spool) deliverAll(stop chan struct{}) error {
+	ids, err := s.Pending()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if err = s.deliver(filepath.Join(s.Dir, id+fileExt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver delivers the notification of the file and removes it after acknowledged.
+func (s *Spool) deliver(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	var msg Message
+	err = gob.NewDecoder(f).Decode(&msg)
+	f.Close()
+	if err != nil {
+		// set aside, not to block the notifications behind it.
+		return os.Rename(name, name+".corrupted")
+	}
+	var ack bool
+	if e := s.Client.Call(s.Path+"/deliver", msg, &ack); e != nil {
+		return errors.New(e.Error)
+	}
+	if !ack {
+		return errNotAcknowledged
+	}
+	return os.Remove(name)
+}
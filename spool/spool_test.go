@@ -0,0 +1,232 @@
+package spool
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+func newClient(addr string) *client.Client {
+	return client.NewClient(client.Client{CallTimeout: time.Second}, &selector.DirectSelector{
+		Network: "tcp",
+		Address: addr,
+	})
+}
+
+// refuser receives the deliveries without acknowledging them.
+type refuser struct {
+	attempts chan string
+}
+
+func (r *refuser) Deliver(msg Message, ack *bool) error {
+	r.attempts <- msg.ID
+	return nil
+}
+
+// serve serves the service as "/notify" on a random local port and returns the address.
+func serve(t *testing.T, service interface{}) string {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("notify", service)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	return lis.Addr().String()
+}
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the server doesn't acknowledge
+	r := &refuser{attempts: make(chan string, 10)}
+	c := newClient(serve(t, r))
+	s, err := Open(c, "/notify", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RetryInterval = 20 * time.Millisecond
+	s.Start()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		id, err := s.Enqueue([]byte("n" + strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	// the first notification is kept and retried
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-r.attempts:
+			if id != ids[0] {
+				t.Fatalf("attempt %d: delivered %s, want the unacknowledged %s again", i, id, ids[0])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %d: no delivery", i)
+		}
+	}
+	s.Stop()
+	c.Close()
+	if pending, err := s.Pending(); err != nil || len(pending) != 5 || pending[0] != ids[0] || pending[4] != ids[4] {
+		t.Fatalf("pending = %v, err = %v", pending, err)
+	}
+
+	// the server acknowledges, and the client restarts
+	received := make(chan string, 10)
+	c = newClient(serve(t, NewService(func(msg Message) error {
+		received <- string(msg.Data)
+		return nil
+	})))
+	defer c.Close()
+	s, err = Open(c, "/notify", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RetryInterval = 20 * time.Millisecond
+	s.Start()
+	defer s.Stop()
+
+	for i := 0; i < 5; i++ {
+		select {
+		case data := <-received:
+			if data != "n"+strconv.Itoa(i) {
+				t.Fatalf("received %q, want %q in order", data, "n"+strconv.Itoa(i))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d notifications, want 5", i)
+		}
+	}
+	// removed after acknowledged
+	deadline := time.Now().Add(time.Second)
+	for {
+		pending, _ := s.Pending()
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pending = %v", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServiceDedup(t *testing.T) {
+	var handled int
+	s := NewService(func(msg Message) error {
+		handled++
+		return nil
+	})
+	var ack bool
+	for i := 0; i < 3; i++ {
+		// e.g. redelivered after a lost acknowledgment
+		if err := s.Deliver(Message{ID: "1", Data: []byte("x")}, &ack); err != nil || !ack {
+			t.Fatalf("deliver: ack=%v, err=%v", ack, err)
+		}
+	}
+	if handled != 1 {
+		t.Fatalf("handled = %d, want 1", handled)
+	}
+}
+
+func TestServiceConcurrent(t *testing.T) {
+	release := make(chan struct{})
+	s := NewService(func(msg Message) error {
+		if msg.ID == "slow" {
+			<-release
+		}
+		return nil
+	})
+	slow := make(chan error, 1)
+	go func() {
+		var ack bool
+		slow <- s.Deliver(Message{ID: "slow"}, &ack)
+	}()
+	// wait for the slow notification being handled.
+	for {
+		s.lock.Lock()
+		_, ok := s.seen["slow"]
+		s.lock.Unlock()
+		if ok {
+			break
+		}
+		runtime.Gosched()
+	}
+	var ack bool
+	if err := s.Deliver(Message{ID: "fast"}, &ack); err != nil || !ack {
+		t.Fatalf("expect the delivery not blocked by the slow one, ack=%v, err=%v", ack, err)
+	}
+	if err := s.Deliver(Message{ID: "slow"}, &ack); err != errInProgress {
+		t.Fatalf("expect the redelivery in progress rejected, got %v", err)
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServiceTTL(t *testing.T) {
+	s := NewService(func(msg Message) error { return nil })
+	s.TTL = time.Minute
+	s.seen["old"] = time.Now().Add(-2 * time.Minute)
+	s.seen["recent"] = time.Now().Add(-time.Second)
+	var ack bool
+	if err := s.Deliver(Message{ID: "new"}, &ack); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.seen["old"]; ok || len(s.seen) != 2 {
+		t.Fatalf("expect the expired notification forgotten, seen = %v", s.seen)
+	}
+}
+
+func TestSpoolIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var ids []string
+	for restart := 0; restart < 2; restart++ {
+		s, err := Open(nil, "/notify", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			id, err := s.Enqueue(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		// delivered, nothing left in the directory.
+		pending, _ := s.Pending()
+		for _, id := range pending {
+			os.Remove(filepath.Join(dir, id+fileExt))
+		}
+	}
+	if !sort.StringsAreSorted(ids) || ids[0] == ids[len(ids)-1] {
+		t.Fatalf("expect the IDs increasing across the restarts, got %v", ids)
+	}
+	prefix := ids[0][:strings.Index(ids[0], "-")]
+	for _, id := range ids {
+		if !strings.HasPrefix(id, prefix+"-") {
+			t.Fatalf("expect the IDs of the directory sharing the identity, got %v", ids)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Package codes defines the status codes of the status package, the same as gRPC's.
+package codes
+
+import "strconv"
+
+// Code is a status code.
+type Code uint32
+
+const (
+	// OK means success.
+	OK Code = iota
+	// Canceled means the operation was canceled, typically by the caller.
+	Canceled
+	// Unknown means an unknown error, e.g. an error without a code.
+	Unknown
+	// InvalidArgument means the argument is invalid regardless of the state of the system.
+	InvalidArgument
+	// DeadlineExceeded means the operation expired before completion.
+	DeadlineExceeded
+	// NotFound means the requested entity was not found.
+	NotFound
+	// AlreadyExists means the entity to create already exists.
+	AlreadyExists
+	// PermissionDenied means the caller is not permitted to do the operation.
+	PermissionDenied
+	// ResourceExhausted means some resource has been exhausted, e.g. a quota.
+	ResourceExhausted
+	// FailedPrecondition means the system is not in the state required by the operation.
+	FailedPrecondition
+	// Aborted means the operation was aborted, e.g. by a concurrency conflict.
+	Aborted
+	// OutOfRange means the operation was attempted past the valid range.
+	OutOfRange
+	// Unimplemented means the operation is not implemented or supported.
+	Unimplemented
+	// Internal means an internal error of the system.
+	Internal
+	// Unavailable means the service is currently unavailable, retrying may succeed.
+	Unavailable
+	// DataLoss means unrecoverable data loss or corruption.
+	DataLoss
+	// Unauthenticated means the caller has no valid authentication credentials.
+	Unauthenticated
+)
+
+var names = [...]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+}
+
+// String returns the name of the code.
+func (c Code) String() string {
+	if int(c) < len(names) {
+		return names[c]
+	}
+	return "Code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+// Parse returns the code of the name returned by String.
+func Parse(name string) (Code, bool) {
+	for c, n := range names {
+		if n == name {
+			return Code(c), true
+		}
+	}
+	if len(name) > 6 && name[:5] == "Code(" && name[len(name)-1] == ')' {
+		if c, err := strconv.ParseUint(name[5:len(name)-1], 10, 32); err == nil {
+			return Code(c), true
+		}
+	}
+	return Unknown, false
+}
@@ -0,0 +1,215 @@
+// Package status carries the status codes of package codes in the errors of the services,
+// e.g. the service returns
+//
+//	return status.Errorf(codes.NotFound, "no user %q", name)
+//
+// and the client reads the code from the error of the call by
+//
+//	s, ok := status.FromRPCError(rpcErr)
+//
+// The status is sent as the error message "rpc error: code = <name> desc = <message>",
+// which is compatible with the servers and clients unaware of the status.
+//
+// A status may carry the structured details, e.g. the field failing the validation,
+// whose type is registered on both sides by
+//
+//	status.RegisterDetails("myapp.FieldViolation", FieldViolation{})
+//
+// The details are appended to the message as " details = <name> <JSON>",
+// and decoded into the registered type by FromRPCError and FromError.
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/common"
+	"github.com/henrylee2cn/myrpc/status/codes"
+)
+
+const (
+	prefix     = "rpc error: code = "
+	descSep    = " desc = "
+	detailsSep = " details = "
+)
+
+// Status is an error with a code.
+type Status struct {
+	Code    codes.Code
+	Message string
+	// Details are the structured details of a registered type, nil if none.
+	Details interface{}
+}
+
+var (
+	detailsLock  sync.RWMutex
+	detailsTypes = make(map[string]reflect.Type)
+	detailsNames = make(map[reflect.Type]string)
+)
+
+// RegisterDetails registers the type of details under name, which must be the same
+// on the server and the client. It panics if the name or the type is already registered.
+// The details are encoded as JSON.
+func RegisterDetails(name string, details interface{}) {
+	if name == "" || strings.ContainsAny(name, " ") {
+		panic("status: invalid details name " + strconv.Quote(name))
+	}
+	t := reflect.TypeOf(details)
+	if t == nil {
+		panic("status: nil details")
+	}
+	detailsLock.Lock()
+	defer detailsLock.Unlock()
+	if _, ok := detailsTypes[name]; ok {
+		panic("status: details name " + strconv.Quote(name) + " is already registered")
+	}
+	if _, ok := detailsNames[t]; ok {
+		panic("status: details type " + t.String() + " is already registered")
+	}
+	detailsTypes[name] = t
+	detailsNames[t] = name
+}
+
+// New returns a Status with the code and message.
+func New(c codes.Code, msg string) *Status {
+	return &Status{Code: c, Message: msg}
+}
+
+// Newf returns a Status with the code and formatted message.
+func Newf(c codes.Code, format string, a ...interface{}) *Status {
+	return New(c, fmt.Sprintf(format, a...))
+}
+
+// Error returns an error with the code and message, nil if the code is OK.
+func Error(c codes.Code, msg string) error {
+	return New(c, msg).Err()
+}
+
+// Errorf returns an error with the code and formatted message, nil if the code is OK.
+func Errorf(c codes.Code, format string, a ...interface{}) error {
+	return Newf(c, format, a...).Err()
+}
+
+// WithDetails returns a copy of the status with the details, whose type must be registered
+// by RegisterDetails, or the details are not sent.
+func (s *Status) WithDetails(details interface{}) *Status {
+	c := *s
+	c.Details = details
+	return &c
+}
+
+// Err returns the status as an error, nil if the code is OK.
+func (s *Status) Err() error {
+	if s.Code == codes.OK {
+		return nil
+	}
+	return s
+}
+
+// Error returns the message sent to the client.
+func (s *Status) Error() string {
+	msg := prefix + s.Code.String() + descSep + s.Message
+	if s.Details != nil {
+		if details, ok := encodeDetails(s.Details); ok {
+			msg += detailsSep + details
+		}
+	}
+	return msg
+}
+
+// FromError returns the status of err, which is a *Status or an error with its message.
+// It returns the OK status for nil, and false with the Unknown status for the other errors.
+func FromError(err error) (*Status, bool) {
+	if err == nil {
+		return New(codes.OK, ""), true
+	}
+	if s, ok := err.(*Status); ok {
+		return s, true
+	}
+	if s, ok := parse(err.Error()); ok {
+		return s, true
+	}
+	return New(codes.Unknown, err.Error()), false
+}
+
+// FromRPCError returns the status of the error of a call returned by the service.
+// It returns the OK status for nil, and false with the Unknown status for the other errors.
+func FromRPCError(e *common.RPCError) (*Status, bool) {
+	if e == nil {
+		return New(codes.OK, ""), true
+	}
+	if e.Type == common.ErrorTypeServerService {
+		if s, ok := parse(e.Error); ok {
+			return s, true
+		}
+	}
+	return New(codes.Unknown, e.Error), false
+}
+
+// Code returns the code of err, see FromError.
+func Code(err error) codes.Code {
+	s, _ := FromError(err)
+	return s.Code
+}
+
+func parse(msg string) (*Status, bool) {
+	if !strings.HasPrefix(msg, prefix) {
+		return nil, false
+	}
+	msg = msg[len(prefix):]
+	i := strings.Index(msg, descSep)
+	if i < 0 {
+		return nil, false
+	}
+	c, ok := codes.Parse(msg[:i])
+	if !ok {
+		return nil, false
+	}
+	s := New(c, msg[i+len(descSep):])
+	// the message ending with undecodable details is kept as it is.
+	if i := strings.LastIndex(s.Message, detailsSep); i >= 0 {
+		if details, ok := decodeDetails(s.Message[i+len(detailsSep):]); ok {
+			s.Message = s.Message[:i]
+			s.Details = details
+		}
+	}
+	return s, true
+}
+
+// encodeDetails encodes the details as "<name> <JSON>".
+func encodeDetails(details interface{}) (string, bool) {
+	detailsLock.RLock()
+	name, ok := detailsNames[reflect.TypeOf(details)]
+	detailsLock.RUnlock()
+	if !ok {
+		return "", false
+	}
+	b, err := json.Marshal(details)
+	if err != nil {
+		return "", false
+	}
+	return name + " " + string(b), true
+}
+
+// decodeDetails decodes the details encoded by encodeDetails into the registered type.
+func decodeDetails(s string) (interface{}, bool) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return nil, false
+	}
+	detailsLock.RLock()
+	t, ok := detailsTypes[s[:i]]
+	detailsLock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal([]byte(s[i+1:]), v.Interface()); err != nil {
+		return nil, false
+	}
+	return v.Elem().Interface(), true
+}
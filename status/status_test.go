@@ -0,0 +1,114 @@
+package status
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/server"
+	"github.com/henrylee2cn/myrpc/status/codes"
+)
+
+type users struct{}
+
+func (*users) Get(name string, reply *string) error {
+	if name != "alice" {
+		return Errorf(codes.NotFound, "no user %q", name)
+	}
+	*reply = name
+	return nil
+}
+
+type FieldViolation struct {
+	Field  string
+	Reason string
+}
+
+func init() {
+	RegisterDetails("status.FieldViolation", FieldViolation{})
+}
+
+func (*users) Create(name string, reply *string) error {
+	if name == "" {
+		return New(codes.InvalidArgument, "invalid user").WithDetails(FieldViolation{
+			Field:  "name",
+			Reason: "must not be empty",
+		}).Err()
+	}
+	*reply = name
+	return nil
+}
+
+func TestStatus(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("users", new(users))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	c := client.NewClient(client.Client{}, &selector.DirectSelector{Network: "tcp", Address: lis.Addr().String()})
+	defer c.Close()
+
+	var reply string
+	s, ok := FromRPCError(c.Call("/users/get", "alice", &reply))
+	if !ok || s.Code != codes.OK {
+		t.Fatalf("expect OK, got %v", s)
+	}
+	s, ok = FromRPCError(c.Call("/users/get", "bob", &reply))
+	if !ok || s.Code != codes.NotFound || s.Message != `no user "bob"` {
+		t.Fatalf("expect the NotFound status, got %+v", s)
+	}
+	if Code(s) != codes.NotFound {
+		t.Fatalf("expect the code of the status NotFound, got %v", Code(s))
+	}
+}
+
+func TestFromError(t *testing.T) {
+	for _, c := range []codes.Code{codes.PermissionDenied, codes.Unauthenticated, codes.Code(42)} {
+		s, ok := FromError(errors.New(New(c, "x desc = y").Error()))
+		if !ok || s.Code != c || s.Message != "x desc = y" {
+			t.Fatalf("%v: got %+v", c, s)
+		}
+	}
+	if s, ok := FromError(errors.New("plain")); ok || s.Code != codes.Unknown || s.Message != "plain" {
+		t.Fatalf("expect Unknown, got %+v", s)
+	}
+	if Errorf(codes.OK, "fine") != nil {
+		t.Fatal("expect nil error of OK")
+	}
+}
+
+func TestDetails(t *testing.T) {
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("users", new(users))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+	c := client.NewClient(client.Client{}, &selector.DirectSelector{Network: "tcp", Address: lis.Addr().String()})
+	defer c.Close()
+
+	var reply string
+	s, ok := FromRPCError(c.Call("/users/create", "", &reply))
+	if !ok || s.Code != codes.InvalidArgument || s.Message != "invalid user" {
+		t.Fatalf("expect the InvalidArgument status, got %+v", s)
+	}
+	v, ok := s.Details.(FieldViolation)
+	if !ok || v.Field != "name" || v.Reason != "must not be empty" {
+		t.Fatalf("expect the field violation, got %#v", s.Details)
+	}
+
+	// the unregistered details are not sent, and the unknown ones are kept in the message.
+	if msg := New(codes.Internal, "x").WithDetails(struct{}{}).Error(); strings.Contains(msg, detailsSep) {
+		t.Fatalf("unregistered details are sent: %s", msg)
+	}
+	msg := New(codes.Internal, "x"+detailsSep+"unknown.Type {}").Error()
+	if s, ok := FromError(errors.New(msg)); !ok || s.Message != "x"+detailsSep+"unknown.Type {}" || s.Details != nil {
+		t.Fatalf("expect the message kept, got %+v", s)
+	}
+}
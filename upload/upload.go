@@ -0,0 +1,196 @@
+// Package upload implements resumable uploads of large payloads over unary calls.
+//
+// The client sends the payload in chunks tagged with the upload ID and offset,
+// and the server replies with the committed offset of the upload. When the connection breaks,
+// the client asks the server for the committed offset and resumes from it.
+//
+// The server holds no payload: every chunk is handed to the handler as it is committed,
+// so an upload costs the server the memory of one chunk at a time.
+//
+// Server side:
+//
+//	srv.NamedRegister("upload", upload.NewService(func(id string, offset int64, data []byte, last bool) error {
+//		_, err := files[id].WriteAt(data, offset)
+//		return err
+//	}))
+//
+// Client side:
+//
+//	u := &upload.Uploader{Client: c, Path: "/upload"}
+//	err := u.Upload(id, bytes.NewReader(data), int64(len(data)))
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/henrylee2cn/myrpc/client"
+)
+
+type (
+	// Chunk is a part of an upload.
+	Chunk struct {
+		ID     string
+		Offset int64
+		Data   []byte
+		// Last is whether the chunk ends the upload.
+		Last bool
+	}
+
+	// Status is the state of an upload replied by the server.
+	Status struct {
+		// Offset is the committed size of the upload.
+		Offset int64
+		// Done is whether the upload is complete.
+		Done bool
+	}
+
+	// Handler receives the chunks of the uploads as they are committed, in the order of
+	// their offsets and one at a time per upload; last is whether the chunk ends the upload.
+	// If it returns an error, the chunk is not committed and the client sends it again.
+	Handler func(id string, offset int64, data []byte, last bool) error
+
+	// Service tracks the offsets of the uploads in memory, and streams their chunks to the Handler.
+	// Its methods are served as "<path>/write" and "<path>/offset".
+	Service struct {
+		lock    sync.Mutex
+		uploads map[string]*entry
+		handle  Handler
+	}
+
+	entry struct {
+		lock   sync.Mutex // serializes the chunks of the upload
+		offset int64
+		done   bool
+	}
+)
+
+// NewService creates a Service streaming the chunks of every upload to handle.
+func NewService(handle Handler) *Service {
+	return &Service{
+		uploads: make(map[string]*entry),
+		handle:  handle,
+	}
+}
+
+// entry returns the entry of the upload, creating it if create is true, otherwise nil if unknown.
+func (s *Service) entry(id string, create bool) *entry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e := s.uploads[id]
+	if e == nil && create {
+		e = new(entry)
+		s.uploads[id] = e
+	}
+	return e
+}
+
+// Write commits the chunk to the upload. The part of the chunk that is already committed,
+// e.g. resent after a lost reply, is skipped; a chunk beyond the committed offset is rejected.
+func (s *Service) Write(chunk Chunk, reply *Status) error {
+	e := s.entry(chunk.ID, true)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.done {
+		*reply = Status{Offset: e.offset, Done: true}
+		return nil
+	}
+	if chunk.Offset > e.offset {
+		return fmt.Errorf("upload: chunk at %d is beyond the committed offset %d of '%s'", chunk.Offset, e.offset, chunk.ID)
+	}
+	var data []byte
+	if end := chunk.Offset + int64(len(chunk.Data)); end > e.offset {
+		data = chunk.Data[e.offset-chunk.Offset:]
+	}
+	if len(data) > 0 || chunk.Last {
+		if err := s.handle(chunk.ID, e.offset, data, chunk.Last); err != nil {
+			return err
+		}
+	}
+	e.offset += int64(len(data))
+	e.done = chunk.Last
+	*reply = Status{Offset: e.offset, Done: e.done}
+	return nil
+}
+
+// Offset replies the status of the upload, zero for an unknown upload.
+func (s *Service) Offset(id string, reply *Status) error {
+	if e := s.entry(id, false); e != nil {
+		e.lock.Lock()
+		*reply = Status{Offset: e.offset, Done: e.done}
+		e.lock.Unlock()
+	}
+	return nil
+}
+
+// Remove forgets the upload, e.g. after its payload is consumed.
+func (s *Service) Remove(id string) {
+	s.lock.Lock()
+	delete(s.uploads, id)
+	s.lock.Unlock()
+}
+
+// Uploader uploads the payloads to a Service.
+type Uploader struct {
+	Client *client.Client
+	// Path is the registered path of the Service, e.g. "/upload".
+	Path string
+	// ChunkSize is the size of every chunk, 64KB if zero.
+	ChunkSize int
+	// MaxResumes is the number of times to resume the upload after a failed chunk, 3 if zero.
+	MaxResumes int
+}
+
+// Upload uploads the payload of size read from r, resuming from the committed offset
+// of the server, both at the beginning and after a failed chunk.
+func (u *Uploader) Upload(id string, r io.ReaderAt, size int64) error {
+	chunkSize := u.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 64 << 10
+	}
+	maxResumes := u.MaxResumes
+	if maxResumes <= 0 {
+		maxResumes = 3
+	}
+	var status Status
+	if e := u.Client.Call(u.Path+"/offset", id, &status); e != nil {
+		return errors.New(e.Error)
+	}
+	buf := make([]byte, chunkSize)
+	for resumes := 0; !status.Done; {
+		if status.Offset > size {
+			return fmt.Errorf("upload: committed offset %d of '%s' is beyond the size %d", status.Offset, id, size)
+		}
+		n := int64(chunkSize)
+		if rest := size - status.Offset; rest < n {
+			n = rest
+		}
+		if _, err := r.ReadAt(buf[:n], status.Offset); err != nil && err != io.EOF {
+			return err
+		}
+		chunk := Chunk{
+			ID:     id,
+			Offset: status.Offset,
+			Data:   buf[:n],
+			Last:   status.Offset+n == size,
+		}
+		var next Status
+		e := u.Client.Call(u.Path+"/write", chunk, &next)
+		if e == nil {
+			status = next
+			continue
+		}
+		if resumes++; resumes > maxResumes {
+			return errors.New(e.Error)
+		}
+		if e = u.Client.Call(u.Path+"/offset", id, &status); e != nil {
+			return errors.New(e.Error)
+		}
+	}
+	if status.Offset != size {
+		return fmt.Errorf("upload: '%s' is done at %d, not the size %d", id, status.Offset, size)
+	}
+	return nil
+}
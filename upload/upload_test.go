@@ -0,0 +1,115 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/henrylee2cn/myrpc/client"
+	"github.com/henrylee2cn/myrpc/client/selector"
+	"github.com/henrylee2cn/myrpc/server"
+)
+
+// breakingConn closes itself after writing the given bytes.
+type breakingConn struct {
+	net.Conn
+	left int64
+}
+
+func (c *breakingConn) Write(b []byte) (int, error) {
+	if atomic.AddInt64(&c.left, -int64(len(b))) < 0 {
+		c.Conn.Close()
+		return 0, net.ErrWriteToConnected
+	}
+	return c.Conn.Write(b)
+}
+
+func TestResume(t *testing.T) {
+	completed := make(chan []byte, 1)
+	var received bytes.Buffer
+	srv := server.NewServer(server.Server{})
+	srv.NamedRegister("upload", NewService(func(id string, offset int64, data []byte, last bool) error {
+		if id != "dataset" || offset != int64(received.Len()) {
+			t.Errorf("unexpected chunk of '%s' at %d, received %d", id, offset, received.Len())
+		}
+		if len(data) > 1024 {
+			t.Errorf("expect the chunks streamed one by one, got %d bytes", len(data))
+		}
+		received.Write(data)
+		if last {
+			completed <- received.Bytes()
+		}
+		return nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(lis)
+
+	var dials int32
+	c := client.NewClient(client.Client{
+		FailMode: client.Failtry,
+		MaxTry:   1,
+		Dial: func(network, address string, timeout time.Duration) (net.Conn, error) {
+			conn, err := net.DialTimeout(network, address, timeout)
+			if err != nil || atomic.AddInt32(&dials, 1) > 1 {
+				return conn, err
+			}
+			// the first connection breaks in the middle of the upload.
+			return &breakingConn{Conn: conn, left: 5000}, nil
+		},
+	}, &selector.DirectSelector{Network: "tcp", Address: lis.Addr().String()})
+	defer c.Close()
+
+	payload := make([]byte, 20000)
+	rand.Read(payload)
+	u := &Uploader{Client: c, Path: "/upload", ChunkSize: 1024}
+	if err := u.Upload("dataset", bytes.NewReader(payload), int64(len(payload))); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Fatalf("expect the upload resumed on a new connection, got %d dials", n)
+	}
+	select {
+	case data := <-completed:
+		if !bytes.Equal(data, payload) {
+			t.Fatalf("the uploaded payload is corrupt: %d bytes", len(data))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the upload is not complete")
+	}
+}
+
+func TestHandlerError(t *testing.T) {
+	fail := true
+	var received []byte
+	s := NewService(func(id string, offset int64, data []byte, last bool) error {
+		if fail {
+			return errors.New("disk full")
+		}
+		received = append(received, data...)
+		return nil
+	})
+	var status Status
+	if err := s.Write(Chunk{ID: "a", Data: []byte("abc")}, &status); err == nil {
+		t.Fatal("expect the error of the handler")
+	}
+	if s.Offset("a", &status); status.Offset != 0 {
+		t.Fatalf("expect the failed chunk not committed, got offset %d", status.Offset)
+	}
+	fail = false
+	if err := s.Write(Chunk{ID: "a", Data: []byte("abc")}, &status); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(Chunk{ID: "a", Offset: 1, Data: []byte("bcd"), Last: true}, &status); err != nil {
+		t.Fatal(err)
+	}
+	if string(received) != "abcd" || status != (Status{Offset: 4, Done: true}) {
+		t.Fatalf("received %q, status %+v", received, status)
+	}
+}